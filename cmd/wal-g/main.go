@@ -18,7 +18,15 @@ var helpMsg = "  backup-fetch\tfetch a backup from S3\n" +
 	"  backup-push\tstarts and uploads a finished backup to S3\n" +
 	"  backup-list\tprints available backups\n" +
 	"  wal-fetch\tfetch a WAL file from S3\n" +
+	"  wal-prefetch-service\tserve wal-fetch requests from WAL cache filled ahead of replay\n" +
 	"  wal-push\tupload a WAL file to S3\n" +
+	"  daemon\tkeep uploading WAL files and serve wal-push requests through a socket\n" +
+	"  wal-verify\tcheck that WAL archive has all segments needed by backups\n" +
+	"  wal-show\tprint records of archived WAL files\n" +
+	"  wal-diff\treport relations changed between two backups or LSNs\n" +
+	"  wal-find\tfind WAL file and LSN of the last commit before the time\n" +
+	"  wal-dict-train\ttrain zstd dictionary on archived WAL files for wal-push\n" +
+	"  page-repair\treconstruct a page from backup and full page images of WAL\n" +
 	"  delete\tclear old backups and WALs\n"
 
 func init() {
@@ -67,6 +75,10 @@ func main() {
 		l.Fatalf("Please choose a command:\n%s", helpMsg)
 	}
 	command := all[0]
+	owner := ""
+	if command == "backup-fetch" {
+		all, owner = extractOwnerFlag(all)
+	}
 	firstArgument := ""
 	if len(all) > 1 {
 		firstArgument = all[1]
@@ -77,11 +89,29 @@ func main() {
 	if firstArgument == "-h" || firstArgument == "--help" || (firstArgument == "" && !argumentlessCommand(command)) {
 		switch command {
 		case "backup-fetch":
-			fmt.Printf("usage:\twal-g backup-fetch output_directory backup_name\n\twal-g backup-fetch output_directory LATEST\n\n")
+			fmt.Printf("usage:\twal-g backup-fetch output_directory backup_name\n\twal-g backup-fetch output_directory LATEST\n\twal-g backup-fetch --catchup existing_directory backup_name\n\twal-g backup-fetch --owner user output_directory backup_name\n\n")
 			os.Exit(1)
 		case "backup-push":
 			fmt.Printf("usage:\twal-g backup-push backup_directory\n\n")
 			os.Exit(1)
+		case "wal-verify":
+			fmt.Printf("usage:\twal-g wal-verify [--json]\n\n")
+			os.Exit(1)
+		case "wal-diff":
+			fmt.Printf("usage:\twal-g wal-diff from_backup|from_lsn to_backup|to_lsn [--timeline timeline] [--json]\n\n")
+			os.Exit(1)
+		case "wal-find":
+			fmt.Printf("usage:\twal-g wal-find --time time [--timeline timeline] [--json]\n\n")
+			os.Exit(1)
+		case "wal-dict-train":
+			fmt.Printf("usage:\twal-g wal-dict-train [--segments count] [--size bytes]\n\n")
+			os.Exit(1)
+		case "page-repair":
+			fmt.Printf("usage:\twal-g page-repair --relfilenode spc/db/rel --block N --target-lsn lsn [--backup backup_name] [--output path] [--json]\n\n")
+			os.Exit(1)
+		case "wal-show":
+			fmt.Printf("usage:\twal-g wal-show wal_name [--stats] [--json]\n\twal-g wal-show first_wal_name-last_wal_name [--stats] [--json]\n\n")
+			os.Exit(1)
 		case "backup-list":
 			fmt.Printf("usage:\twal-g backup-list\n\n")
 			os.Exit(1)
@@ -91,6 +121,12 @@ func main() {
 		case "wal-push":
 			fmt.Printf("usage:\twal-g wal-push archive_path\n\n")
 			os.Exit(1)
+		case "daemon":
+			fmt.Printf("usage:\twal-g daemon pg_wal_directory\n\n")
+			os.Exit(1)
+		case "wal-prefetch-service":
+			fmt.Printf("usage:\twal-g wal-prefetch-service pg_wal_directory\n\n")
+			os.Exit(1)
 		case "delete":
 			fmt.Println(internal.DeleteUsageText)
 			os.Exit(1)
@@ -114,6 +150,20 @@ func main() {
 		defer pprof.StopCPUProfile()
 	}
 
+	// Hand WAL file to the daemon, if there is one, it has storage session ready
+	if command == "wal-push" {
+		if socketPath, ok := internal.LookupConfigValue(internal.DaemonSocketSetting); ok && socketPath != "" {
+			err := internal.PushWalThroughDaemon(socketPath, firstArgument)
+			if err == nil {
+				return
+			}
+			if _, ok := err.(internal.DaemonPushError); ok {
+				tracelog.ErrorLogger.FatalError(err)
+			}
+			tracelog.WarningLogger.Printf("Daemon is unavailable, uploading WAL file by itself: %v\n", err)
+		}
+	}
+
 	// Configure and start S3 session with bucket, region, and path names.
 	// Checks that environment variables are properly set.
 	uploader, folder, err := internal.Configure()
@@ -129,19 +179,62 @@ func main() {
 		internal.HandleWALFetch(folder, firstArgument, backupName, true)
 	} else if command == "wal-prefetch" {
 		internal.HandleWALPrefetch(folder, firstArgument, backupName, uploader)
+	} else if command == "wal-prefetch-service" {
+		internal.HandleWalPrefetchService(folder, uploader, firstArgument)
 	} else if command == "wal-push" {
 		// Upload a WAL file to S3.
 		internal.HandleWALPush(uploader, firstArgument)
+	} else if command == "daemon" {
+		internal.HandleDaemon(uploader, firstArgument)
 	} else if command == "backup-push" {
 		internal.HandleBackupPush(firstArgument, uploader)
 	} else if command == "backup-fetch" {
-		internal.HandleBackupFetch(backupName, folder, firstArgument, mem)
+		if firstArgument == internal.CatchupFlag {
+			if len(all) != 4 {
+				l.Fatalf("usage:\twal-g backup-fetch --catchup existing_directory backup_name\n")
+			}
+			internal.HandleCatchupFetch(folder, all[2], all[3], owner)
+		} else {
+			internal.HandleBackupFetch(backupName, folder, firstArgument, mem, owner)
+		}
 	} else if command == "mysql-cron" {
 		internal.HandleMySQLCron(uploader, firstArgument)
 	} else if command == "stream-push" {
 		internal.HandleStreamPush(uploader, firstArgument)
 	} else if command == "stream-fetch" {
 		internal.HandleStreamFetch(firstArgument, folder)
+	} else if command == "wal-verify" {
+		internal.HandleWalVerify(folder, firstArgument == internal.WalVerifyJsonFlag)
+	} else if command == "wal-show" {
+		arguments, err := internal.ParseWalShowArguments(all[1:])
+		if err != nil {
+			l.Fatalf("%v\nusage:\twal-g wal-show wal_name|first_wal_name-last_wal_name [--stats] [--json]\n", err)
+		}
+		internal.HandleWalShow(folder, arguments)
+	} else if command == "wal-diff" {
+		arguments, err := internal.ParseWalDiffArguments(all[1:])
+		if err != nil {
+			l.Fatalf("%v\nusage:\twal-g wal-diff from_backup|from_lsn to_backup|to_lsn [--timeline timeline] [--json]\n", err)
+		}
+		internal.HandleWalDiff(folder, arguments)
+	} else if command == "wal-find" {
+		arguments, err := internal.ParseWalFindArguments(all[1:])
+		if err != nil {
+			l.Fatalf("%v\nusage:\twal-g wal-find --time time [--timeline timeline] [--json]\n", err)
+		}
+		internal.HandleWalFind(folder, arguments)
+	} else if command == "wal-dict-train" {
+		arguments, err := internal.ParseWalDictTrainArguments(all[1:])
+		if err != nil {
+			l.Fatalf("%v\nusage:\twal-g wal-dict-train [--segments count] [--size bytes]\n", err)
+		}
+		internal.HandleWalDictTrain(folder, arguments)
+	} else if command == "page-repair" {
+		arguments, err := internal.ParsePageRepairArguments(all[1:])
+		if err != nil {
+			l.Fatalf("%v\nusage:\twal-g page-repair --relfilenode spc/db/rel --block N --target-lsn lsn [--backup backup_name] [--output path] [--json]\n", err)
+		}
+		internal.HandlePageRepair(folder, arguments)
 	} else if command == "backup-list" {
 		internal.HandleBackupList(folder)
 	} else if command == "delete" {
@@ -151,5 +244,21 @@ func main() {
 	}
 }
 func argumentlessCommand(command string) bool {
-	return command == "backup-list" || command == "stream-push" || command == "stream-fetch"
+	return command == "backup-list" || command == "stream-push" || command == "stream-fetch" || command == "wal-verify" ||
+		command == "wal-dict-train"
+}
+
+// extractOwnerFlag removes "--owner user" pair from arguments
+func extractOwnerFlag(args []string) ([]string, string) {
+	owner := ""
+	result := make([]string, 0, len(args))
+	for i := 0; i < len(args); i++ {
+		if args[i] == internal.OwnerFlag && i+1 < len(args) {
+			owner = args[i+1]
+			i++
+			continue
+		}
+		result = append(result, args[i])
+	}
+	return result, owner
 }
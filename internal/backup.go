@@ -9,6 +9,7 @@ import (
 	"os"
 	"path/filepath"
 	"regexp"
+	"sort"
 )
 
 const TarPartitionFolderName = "/tar_partitions/"
@@ -25,6 +26,18 @@ func (err NoBackupsFoundError) Error() string {
 	return fmt.Sprintf(tracelog.GetErrorFormatter(), err.error)
 }
 
+type TarPartsMismatchError struct {
+	error
+}
+
+func NewTarPartsMismatchError(backupName string, missingParts, unexpectedParts []string) TarPartsMismatchError {
+	return TarPartsMismatchError{errors.Errorf("Tar parts of backup '%s' do not match sentinel: missing %v, unlisted %v", backupName, missingParts, unexpectedParts)}
+}
+
+func (err TarPartsMismatchError) Error() string {
+	return fmt.Sprintf(tracelog.GetErrorFormatter(), err.error)
+}
+
 // Backup contains information about a valid backup
 // generated and uploaded by WAL-G.
 type Backup struct {
@@ -62,6 +75,39 @@ func (backup *Backup) GetTarNames() ([]string, error) {
 	return result, nil
 }
 
+// GetCheckedTarNames lists tar parts of the backup and checks them against
+// tar parts manifest of the sentinel. Backups without manifest are listed as is.
+func (backup *Backup) GetCheckedTarNames(sentinelDto BackupSentinelDto) ([]string, error) {
+	tarNames, err := backup.GetTarNames()
+	if err != nil {
+		return nil, err
+	}
+	if sentinelDto.TarParts == nil {
+		return tarNames, nil
+	}
+
+	missingParts := make([]string, 0)
+	unexpectedParts := make([]string, 0)
+	listedNames := make(map[string]bool, len(tarNames))
+	for _, tarName := range tarNames {
+		listedNames[tarName] = true
+		if _, ok := sentinelDto.TarParts[tarName]; !ok {
+			unexpectedParts = append(unexpectedParts, tarName)
+		}
+	}
+	for tarName := range sentinelDto.TarParts {
+		if !listedNames[tarName] {
+			missingParts = append(missingParts, tarName)
+		}
+	}
+	if len(missingParts) > 0 || len(unexpectedParts) > 0 {
+		sort.Strings(missingParts)
+		sort.Strings(unexpectedParts)
+		return nil, NewTarPartsMismatchError(backup.Name, missingParts, unexpectedParts)
+	}
+	return tarNames, nil
+}
+
 // TODO : unit tests
 func (backup *Backup) fetchSentinel() (BackupSentinelDto, error) {
 	sentinelDto := BackupSentinelDto{}
@@ -109,32 +155,45 @@ func checkDbDirectoryForUnwrap(dbDataDirectory string, sentinelDto BackupSentine
 
 // TODO : unit tests
 // Do the job of unpacking Backup object
-func (backup *Backup) unwrap(dbDataDirectory string, sentinelDto BackupSentinelDto, filesToUnwrap map[string]bool) error {
+func (backup *Backup) unwrap(dbDataDirectory string, sentinelDto BackupSentinelDto, filesToUnwrap map[string]bool, owner *FileOwner) error {
 	err := checkDbDirectoryForUnwrap(dbDataDirectory, sentinelDto)
 	if err != nil {
 		return err
 	}
 
-	tarInterpreter := NewFileTarInterpreter(dbDataDirectory, sentinelDto, filesToUnwrap)
-	tarsToExtract, pgControlKey, err := backup.getTarsToExtract()
+	tarInterpreter := NewFileTarInterpreter(dbDataDirectory, sentinelDto, filesToUnwrap, owner)
+	pgControlKey, err := backup.extractTars(tarInterpreter, sentinelDto)
 	if err != nil {
 		return err
 	}
-	err = ExtractAll(tarInterpreter, tarsToExtract)
+	err = backup.extractPgControl(tarInterpreter, sentinelDto, pgControlKey)
 	if err != nil {
 		return err
 	}
+
+	tracelog.InfoLogger.Print("\nBackup extraction complete.\n")
+	return nil
+}
+
+// extractTars extracts all tars of the backup except pg_control one, which name is returned
+func (backup *Backup) extractTars(tarInterpreter TarInterpreter, sentinelDto BackupSentinelDto) (pgControlKey string, err error) {
+	tarsToExtract, pgControlKey, err := backup.getTarsToExtract(sentinelDto)
+	if err != nil {
+		return "", err
+	}
+	return pgControlKey, ExtractAll(tarInterpreter, tarsToExtract)
+}
+
+func (backup *Backup) extractPgControl(tarInterpreter TarInterpreter, sentinelDto BackupSentinelDto, pgControlKey string) error {
 	// Check name for backwards compatibility. Will check for `pg_control` if WALG version of backup.
 	re := regexp.MustCompile(`^([^_]+._{1}[^_]+._{1})`)
 	match := re.FindString(backup.Name)
 	if match == "" || sentinelDto.isIncremental() {
-		err = ExtractAll(tarInterpreter, []ReaderMaker{NewStorageReaderMaker(backup.getTarPartitionFolder(), pgControlKey)})
+		err := ExtractAll(tarInterpreter, []ReaderMaker{backup.newTarPartReaderMaker(sentinelDto, pgControlKey)})
 		if err != nil {
 			return errors.Wrap(err, "failed to extract pg_control")
 		}
 	}
-
-	tracelog.InfoLogger.Print("\nBackup extraction complete.\n")
 	return nil
 }
 
@@ -153,8 +212,8 @@ func IsDirectoryEmpty(directoryPath string) (bool, error) {
 }
 
 // TODO : init tests
-func (backup *Backup) getTarsToExtract() (tarsToExtract []ReaderMaker, pgControlKey string, err error) {
-	tarNames, err := backup.GetTarNames()
+func (backup *Backup) getTarsToExtract(sentinelDto BackupSentinelDto) (tarsToExtract []ReaderMaker, pgControlKey string, err error) {
+	tarNames, err := backup.GetCheckedTarNames(sentinelDto)
 	if err != nil {
 		return nil, "", err
 	}
@@ -175,11 +234,19 @@ func (backup *Backup) getTarsToExtract() (tarsToExtract []ReaderMaker, pgControl
 			pgControlKey = tarName
 			continue
 		}
-		tarToExtract := NewStorageReaderMaker(backup.getTarPartitionFolder(), tarName)
+		tarToExtract := backup.newTarPartReaderMaker(sentinelDto, tarName)
 		tarsToExtract = append(tarsToExtract, tarToExtract)
 	}
 	if pgControlKey == "" {
 		return nil, "", NewPgControlNotFoundError()
 	}
 	return
-}
\ No newline at end of file
+}
+
+func (backup *Backup) newTarPartReaderMaker(sentinelDto BackupSentinelDto, tarName string) ReaderMaker {
+	readerMaker := NewStorageReaderMaker(backup.getTarPartitionFolder(), tarName)
+	if description, ok := sentinelDto.TarParts[tarName]; ok {
+		return NewTarPartCheckingReaderMaker(readerMaker, description)
+	}
+	return readerMaker
+}
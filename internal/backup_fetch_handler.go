@@ -57,10 +57,22 @@ func (err PgControlNotFoundError) Error() string {
 
 // TODO : unit tests
 // HandleBackupFetch is invoked to perform wal-g backup-fetch
-func HandleBackupFetch(backupName string, folder StorageFolder, dbDataDirectory string, mem bool) {
-	tracelog.DebugLogger.Printf("HandleBackupFetch(%s, folder, %s, %v)\n", backupName, dbDataDirectory, mem)
+func HandleBackupFetch(backupName string, folder StorageFolder, dbDataDirectory string, mem bool, owner string) {
+	tracelog.DebugLogger.Printf("HandleBackupFetch(%s, folder, %s, %v, %s)\n", backupName, dbDataDirectory, mem, owner)
 	dbDataDirectory = ResolveSymlink(dbDataDirectory)
-	err := deltaFetchRecursion(backupName, folder, dbDataDirectory, nil)
+	fileOwner, err := LookupFileOwner(owner)
+	if err != nil {
+		tracelog.ErrorLogger.FatalError(err)
+	}
+	reverseUnpack, err := useReverseUnpack()
+	if err != nil {
+		tracelog.ErrorLogger.FatalError(err)
+	}
+	if reverseUnpack {
+		err = deltaFetchReverse(backupName, folder, dbDataDirectory, fileOwner)
+	} else {
+		err = deltaFetchRecursion(backupName, folder, dbDataDirectory, nil, fileOwner)
+	}
 	if err != nil {
 		tracelog.ErrorLogger.Fatalf("Failed to fetch backup: %v\n", err)
 	}
@@ -106,7 +118,7 @@ func GetBackupByName(backupName string, folder StorageFolder) (*Backup, error) {
 
 // TODO : unit tests
 // deltaFetchRecursion function composes Backup object and recursively searches for necessary base backup
-func deltaFetchRecursion(backupName string, folder StorageFolder, dbDataDirectory string, filesToUnwrap map[string]bool) error {
+func deltaFetchRecursion(backupName string, folder StorageFolder, dbDataDirectory string, filesToUnwrap map[string]bool, owner *FileOwner) error {
 	backup, err := GetBackupByName(backupName, folder)
 	if err != nil {
 		return err
@@ -118,6 +130,10 @@ func deltaFetchRecursion(backupName string, folder StorageFolder, dbDataDirector
 
 	if filesToUnwrap == nil { // it is the exact backup we want to fetch, so we want to include all files here
 		filesToUnwrap = GetRestoredBackupFilesToUnwrap(sentinelDto)
+		err = sentinelDto.applyPostgresSizes()
+		if err != nil {
+			return err
+		}
 	}
 
 	if sentinelDto.isIncremental() {
@@ -126,14 +142,14 @@ func deltaFetchRecursion(backupName string, folder StorageFolder, dbDataDirector
 		if err != nil {
 			return err
 		}
-		err = deltaFetchRecursion(*sentinelDto.IncrementFrom, folder, dbDataDirectory, baseFilesToUnwrap)
+		err = deltaFetchRecursion(*sentinelDto.IncrementFrom, folder, dbDataDirectory, baseFilesToUnwrap, owner)
 		if err != nil {
 			return err
 		}
 		tracelog.InfoLogger.Printf("%v fetched. Upgrading from LSN %x to LSN %x \n", *(sentinelDto.IncrementFrom), *(sentinelDto.IncrementFromLSN), *(sentinelDto.BackupStartLSN))
 	}
 
-	return backup.unwrap(dbDataDirectory, sentinelDto, filesToUnwrap)
+	return backup.unwrap(dbDataDirectory, sentinelDto, filesToUnwrap, owner)
 }
 
 func GetRestoredBackupFilesToUnwrap(sentinelDto BackupSentinelDto) map[string]bool {
@@ -87,6 +87,13 @@ func HandleBackupPush(archiveDirectory string, uploader *Uploader) {
 	uploader.uploadingFolder = basebackupFolder // TODO: AB: this subfolder switch look ugly. I think typed storage folders could be better (i.e. interface BasebackupStorageFolder, WalStorageFolder etc)
 
 	bundle := NewBundle(archiveDirectory, previousBackupSentinelDto.BackupStartLSN, previousBackupSentinelDto.Files)
+	bundle.StoreXattrs, err = storeXattrs()
+	if err != nil {
+		tracelog.ErrorLogger.FatalError(err)
+	}
+
+	// Sizes read from the server on backup start take precedence
+	detectOfflinePostgresSizes(archiveDirectory, filepath.Join(archiveDirectory, "pg_wal"))
 
 	// Connect to postgres and start/finish a nonexclusive backup.
 	conn, err := Connect()
@@ -133,10 +140,13 @@ func HandleBackupPush(archiveDirectory string, uploader *Uploader) {
 		tracelog.ErrorLogger.FatalError(err)
 	}
 
-	timelineChanged := bundle.checkTimelineChanged(conn)
+	finishTimeline, timelineSwitches, err := bundle.checkTimelineSwitch(conn, folder.GetSubFolder(WalPath), backupStartLSN)
+	if err != nil {
+		tracelog.ErrorLogger.Printf("%v\nSentinel for the backup will not be uploaded.\n", err)
+	}
 	var currentBackupSentinelDto *BackupSentinelDto
 
-	if !timelineChanged {
+	if err == nil {
 		currentBackupSentinelDto = &BackupSentinelDto{
 			BackupStartLSN:   &backupStartLSN,
 			IncrementFromLSN: previousBackupSentinelDto.BackupStartLSN,
@@ -153,7 +163,12 @@ func HandleBackupPush(archiveDirectory string, uploader *Uploader) {
 		}
 
 		currentBackupSentinelDto.setFiles(bundle.GetFiles())
+		currentBackupSentinelDto.setPostgresSizes(CurrentPostgresSizes())
+		currentBackupSentinelDto.Relations = bundle.RelationNames
 		currentBackupSentinelDto.BackupFinishLSN = &finishLsn
+		currentBackupSentinelDto.StartTimeline = bundle.Timeline
+		currentBackupSentinelDto.FinishTimeline = finishTimeline
+		currentBackupSentinelDto.TimelineSwitches = timelineSwitches
 	}
 
 	// Wait for all uploads to finish.
@@ -1,6 +1,9 @@
 package internal
 
-import "sync"
+import (
+	"github.com/x4m/wal-g/internal/walparser"
+	"sync"
+)
 
 // BackupSentinelDto describes file structure of json sentinel
 type BackupSentinelDto struct {
@@ -15,7 +18,20 @@ type BackupSentinelDto struct {
 	PgVersion       int     `json:"PgVersion"`
 	BackupFinishLSN *uint64 `json:"FinishLSN"`
 
+	WalSegmentSize uint64 `json:"WalSegmentSize,omitempty"`
+	WalBlockSize   uint32 `json:"WalBlockSize,omitempty"`
+	BlockSize      uint32 `json:"BlockSize,omitempty"`
+
 	UserData interface{} `json:"UserData,omitempty"`
+
+	TarParts TarPartList `json:"TarParts,omitempty"`
+
+	Relations []RelationName `json:"Relations,omitempty"`
+
+	// Replica can be promoted during backup, then backup is finished on the next timeline
+	StartTimeline    uint32          `json:"StartTimeline,omitempty"`
+	FinishTimeline   uint32          `json:"FinishTimeline,omitempty"`
+	TimelineSwitches TimelineHistory `json:"TimelineSwitches,omitempty"`
 }
 
 func (dto *BackupSentinelDto) setFiles(p *sync.Map) {
@@ -40,3 +56,25 @@ func (dto *BackupSentinelDto) isIncremental() bool {
 	}
 	return dto.IncrementFrom != nil
 }
+
+func (dto *BackupSentinelDto) setPostgresSizes(sizes PostgresSizes) {
+	dto.WalSegmentSize = sizes.WalSegmentSize
+	dto.WalBlockSize = sizes.WalPageSize
+	dto.BlockSize = sizes.BlockSize
+}
+
+// applyPostgresSizes makes WAL-G use sizes of the backed up cluster, backups made
+// before sizes were recorded have default sizes
+func (dto *BackupSentinelDto) applyPostgresSizes() error {
+	sizes := PostgresSizes{dto.WalSegmentSize, dto.WalBlockSize, dto.BlockSize}
+	if sizes.WalSegmentSize == 0 {
+		sizes.WalSegmentSize = DefaultWalSegmentSize
+	}
+	if sizes.WalPageSize == 0 {
+		sizes.WalPageSize = walparser.DefaultWalPageSize
+	}
+	if sizes.BlockSize == 0 {
+		sizes.BlockSize = uint32(DefaultDatabasePageSize)
+	}
+	return SetPostgresSizes(sizes)
+}
@@ -7,21 +7,27 @@ import (
 )
 
 type LimitedReader struct {
-	r       io.ReadCloser
-	limiter *rate.Limiter
+	r        io.ReadCloser
+	limiter  *rate.Limiter
+	schedule *RateLimitSchedule
 }
 
 var DiskLimiter *rate.Limiter
 var NetworkLimiter *rate.Limiter
 
+// DiskLimitSchedule and NetworkLimitSchedule change limits depending on time of day if set
+var DiskLimitSchedule *RateLimitSchedule
+var NetworkLimitSchedule *RateLimitSchedule
+
 // NewNetworkLimitReader returns a reader that is rate limited by network limiter
 func NewNetworkLimitReader(r io.ReadCloser) io.ReadCloser {
 	if NetworkLimiter == nil {
 		return r
 	}
 	return &LimitedReader{
-		r:       r,
-		limiter: NetworkLimiter,
+		r:        r,
+		limiter:  NetworkLimiter,
+		schedule: NetworkLimitSchedule,
 	}
 }
 
@@ -31,8 +37,9 @@ func NewDiskLimitReader(r io.ReadCloser) io.ReadCloser {
 		return r
 	}
 	return &LimitedReader{
-		r:       r,
-		limiter: DiskLimiter,
+		r:        r,
+		limiter:  DiskLimiter,
+		schedule: DiskLimitSchedule,
 	}
 }
 
@@ -42,7 +49,7 @@ func (r *LimitedReader) Read(buf []byte) (int, error) {
 		return n, err
 	}
 
-	err = r.limiter.WaitN(limitReaderCtx, n)
+	err = waitLimiter(r.limiter, r.schedule, n)
 	return n, err
 }
 
@@ -50,6 +57,61 @@ func (r *LimitedReader) Close() error {
 	return r.r.Close()
 }
 
+type LimitedWriter struct {
+	w        io.Writer
+	limiter  *rate.Limiter
+	schedule *RateLimitSchedule
+}
+
+// NewDiskLimitWriter returns a writer that is rate limited by disk limiter
+func NewDiskLimitWriter(w io.Writer) io.Writer {
+	if DiskLimiter == nil {
+		return w
+	}
+	return &LimitedWriter{
+		w:        w,
+		limiter:  DiskLimiter,
+		schedule: DiskLimitSchedule,
+	}
+}
+
+func (w *LimitedWriter) Write(buf []byte) (int, error) {
+	err := waitLimiter(w.limiter, w.schedule, len(buf))
+	if err != nil {
+		return 0, err
+	}
+	return w.w.Write(buf)
+}
+
+// limitDiskIO waits until n bytes can be read from or written to disk
+func limitDiskIO(n int) error {
+	if DiskLimiter == nil {
+		return nil
+	}
+	return waitLimiter(DiskLimiter, DiskLimitSchedule, n)
+}
+
+// waitLimiter waits for n bytes in chunks not exceeding limiter burst,
+// limiter of the active window is used, when limits are scheduled
+func waitLimiter(limiter *rate.Limiter, schedule *RateLimitSchedule, n int) error {
+	if schedule != nil {
+		limiter = schedule.LimiterAt(time.Now())
+	}
+	burst := limiter.Burst()
+	for n > 0 {
+		chunk := n
+		if limiter.Limit() != rate.Inf && burst > 0 && chunk > burst {
+			chunk = burst
+		}
+		err := limiter.WaitN(limitReaderCtx, chunk)
+		if err != nil {
+			return err
+		}
+		n -= chunk
+	}
+	return nil
+}
+
 type emptyContext int
 
 func (*emptyContext) Deadline() (deadline time.Time, ok bool) {
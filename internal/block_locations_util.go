@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"github.com/x4m/wal-g/internal/walparser"
 	"io"
+	"math"
 )
 
 func ExtractBlockLocations(records []walparser.XLogRecord) []walparser.BlockLocation {
@@ -21,6 +22,13 @@ func ExtractBlockLocations(records []walparser.XLogRecord) []walparser.BlockLoca
 
 // TODO : unit tests
 func extractLocationsFromWalFile(parser *walparser.WalParser, walFile io.ReadCloser) ([]walparser.BlockLocation, error) {
+	return extractLocationsFromWalFileRange(parser, walFile, 0, math.MaxUint64)
+}
+
+// TODO : unit tests
+// extractLocationsFromWalFileRange extracts locations of records, which start in [fromLsn, toLsn).
+// Start of the record continued from the loaded parser is unknown, such record is not filtered.
+func extractLocationsFromWalFileRange(parser *walparser.WalParser, walFile io.ReadCloser, fromLsn uint64, toLsn uint64) ([]walparser.BlockLocation, error) {
 	pageReader := walparser.NewWalPageReader(walFile)
 	locations := make([]walparser.BlockLocation, 0)
 	for {
@@ -39,6 +47,11 @@ func extractLocationsFromWalFile(parser *walparser.WalParser, walFile io.ReadClo
 		default:
 			return nil, err
 		}
-		locations = append(locations, ExtractBlockLocations(records)...)
+		for _, record := range records {
+			if record.Lsn != 0 && (uint64(record.Lsn) < fromLsn || uint64(record.Lsn) >= toLsn) {
+				continue
+			}
+			locations = append(locations, ExtractBlockLocations([]walparser.XLogRecord{record})...)
+		}
 	}
 }
@@ -7,7 +7,6 @@ import (
 	"github.com/jackc/pgx"
 	"github.com/pkg/errors"
 	"github.com/x4m/wal-g/internal/tracelog"
-	"github.com/x4m/wal-g/internal/walparser"
 	"io"
 	"os"
 	"path/filepath"
@@ -42,6 +41,7 @@ func init() {
 	filesToExclude := []string{
 		"pg_log", "pg_xlog", "pg_wal", // Directories
 		"pgsql_tmp", "postgresql.auto.conf.tmp", "postmaster.pid", "postmaster.opts", "recovery.conf", // Files
+		"recovery.signal", "standby.signal", // Recovery configuration files of PostgreSQL 12+
 		"pg_dynshmem", "pg_notify", "pg_replslot", "pg_serial", "pg_stat_tmp", "pg_snapshots", "pg_subtrans", // Directories
 	}
 
@@ -68,6 +68,8 @@ type Bundle struct {
 	IncrementFromLsn   *uint64
 	IncrementFromFiles BackupFileList
 	DeltaMap           PagedFileDeltaMap
+	StoreXattrs        bool
+	RelationNames      []RelationName
 
 	tarballQueue     chan TarBall
 	uploadQueue      chan TarBall
@@ -191,24 +193,47 @@ func (bundle *Bundle) GetIncrementBaseLsn() *uint64 { return bundle.IncrementFro
 func (bundle *Bundle) GetIncrementBaseFiles() BackupFileList { return bundle.IncrementFromFiles }
 
 // TODO : unit tests
-// checkTimelineChanged compares timelines of pg_backup_start() and pg_backup_stop()
-func (bundle *Bundle) checkTimelineChanged(conn *pgx.Conn) bool {
-	if bundle.Replica {
-		timeline, err := readTimeline(conn)
-		if err != nil {
-			tracelog.ErrorLogger.Printf("Unable to check timeline change. Sentinel for the backup will not be uploaded.")
-			return true
-		}
+// checkTimelineSwitch reads timeline of pg_backup_stop(). Replica can be promoted during backup, such backup is
+// consistent when history of the new timeline leads through the backup start. Switches of timeline made during
+// the backup are returned to be recorded in sentinel.
+func (bundle *Bundle) checkTimelineSwitch(conn *pgx.Conn, walFolder StorageFolder, backupStartLSN uint64) (uint32, TimelineHistory, error) {
+	if !bundle.Replica {
+		return bundle.Timeline, TimelineHistory{}, nil
+	}
+	timeline, err := readTimeline(conn)
+	if err != nil {
+		return 0, nil, errors.Wrap(err, "unable to check timeline change")
+	}
+	if timeline == bundle.Timeline {
+		return timeline, TimelineHistory{}, nil
+	}
+	// Per discussion in
+	// https://www.postgresql.org/message-id/flat/BF2AD4A8-E7F5-486F-92C8-A6959040DEB6%40yandex-team.ru#BF2AD4A8-E7F5-486F-92C8-A6959040DEB6@yandex-team.ru
+	// backup is consistent, if the new timeline branched off after the backup start
+	history, err := bundle.fetchTimelineHistory(walFolder, timeline)
+	if err != nil {
+		return 0, nil, errors.Wrapf(err, "unable to fetch history of timeline %d", timeline)
+	}
+	switches, err := ValidateTimelineSwitch(history, bundle.Timeline, backupStartLSN, timeline)
+	if err != nil {
+		return 0, nil, err
+	}
+	tracelog.WarningLogger.Printf("Timeline has changed from %d to %d during backup, the switch is recorded in sentinel.\n",
+		bundle.Timeline, timeline)
+	return timeline, switches, nil
+}
 
-		// Per discussion in
-		// https://www.postgresql.org/message-id/flat/BF2AD4A8-E7F5-486F-92C8-A6959040DEB6%40yandex-team.ru#BF2AD4A8-E7F5-486F-92C8-A6959040DEB6@yandex-team.ru
-		// Following check is the very pessimistic approach on replica backup invalidation
-		if timeline != bundle.Timeline {
-			tracelog.ErrorLogger.Printf("Timeline has changed since backup start. Sentinel for the backup will not be uploaded.")
-			return true
+// fetchTimelineHistory reads history of the timeline from WAL directory, since just promoted server
+// may have not archived it yet, and from storage otherwise
+func (bundle *Bundle) fetchTimelineHistory(walFolder StorageFolder, timeline uint32) (TimelineHistory, error) {
+	for _, walDirectory := range []string{"pg_wal", "pg_xlog"} {
+		historyFile, err := os.Open(filepath.Join(bundle.ArchiveDirectory, walDirectory, formatHistoryFileName(timeline)))
+		if err == nil {
+			defer historyFile.Close()
+			return ParseTimelineHistory(historyFile)
 		}
 	}
-	return false
+	return fetchTimelineHistory(walFolder, timeline)
 }
 
 // TODO : unit tests
@@ -229,6 +254,9 @@ func (bundle *Bundle) StartBackup(conn *pgx.Conn, backup string) (backupName str
 	}
 	lsn, err = pgx.ParseLSN(lsnStr)
 
+	if sizesErr := readServerPostgresSizes(conn); sizesErr != nil {
+		tracelog.WarningLogger.Printf("Couldn't get sizes of the server because of error: '%v'\n", sizesErr)
+	}
 	if bundle.Replica {
 		name, bundle.Timeline, err = getWalFilename(lsn, conn)
 		if err != nil {
@@ -240,6 +268,11 @@ func (bundle *Bundle) StartBackup(conn *pgx.Conn, backup string) (backupName str
 			tracelog.WarningLogger.Printf("Couldn't get current timeline because of error: '%v'\n", err)
 		}
 	}
+	var relationNamesErr error
+	bundle.RelationNames, relationNamesErr = queryRunner.GetRelationNames()
+	if relationNamesErr != nil {
+		tracelog.WarningLogger.Printf("Couldn't get relation names because of error: '%v'\n", relationNamesErr)
+	}
 	return "base_" + name, lsn, queryRunner.Version, nil
 
 }
@@ -288,7 +321,16 @@ func (bundle *Bundle) handleTar(path string, info os.FileInfo) error {
 		return nil
 	}
 
-	fileInfoHeader, err := tar.FileInfoHeader(info, fileName)
+	link := ""
+	if info.Mode()&os.ModeSymlink != 0 {
+		var err error
+		link, err = os.Readlink(path)
+		if err != nil {
+			return errors.Wrap(err, "handleTar: could not read symlink")
+		}
+	}
+
+	fileInfoHeader, err := tar.FileInfoHeader(info, link)
 	if err != nil {
 		return errors.Wrap(err, "handleTar: could not grab header info")
 	}
@@ -296,6 +338,13 @@ func (bundle *Bundle) handleTar(path string, info os.FileInfo) error {
 	fileInfoHeader.Name = bundle.GetFileRelPath(path)
 	tracelog.DebugLogger.Println(fileInfoHeader.Name)
 
+	if bundle.StoreXattrs && !excluded {
+		err = addXattrsToHeader(fileInfoHeader, path)
+		if err != nil {
+			tracelog.WarningLogger.Printf("Failed to read extended attributes of '%s': %v\n", path, err)
+		}
+	}
+
 	if !excluded && info.Mode().IsRegular() {
 		baseFiles := bundle.GetIncrementBaseFiles()
 		baseFile, wasInBase := baseFiles[fileInfoHeader.Name]
@@ -309,7 +358,7 @@ func (bundle *Bundle) handleTar(path string, info os.FileInfo) error {
 		if wasInBase && (time.Equal(baseFile.MTime)) {
 			// File was not changed since previous backup
 			tracelog.DebugLogger.Println("Skiped due to unchanged modification time")
-			bundle.GetFiles().Store(fileInfoHeader.Name, BackupFileDescription{IsSkipped: true, IsIncremented: false, MTime: time})
+			bundle.GetFiles().Store(fileInfoHeader.Name, BackupFileDescription{IsSkipped: true, IsIncremented: false, MTime: time, Size: info.Size()})
 			return nil
 		}
 
@@ -460,44 +509,23 @@ func (bundle *Bundle) getDeltaBitmapFor(filePath string) (*roaring.Bitmap, error
 	return bundle.DeltaMap.GetDeltaBitmapFor(filePath)
 }
 
+// DownloadDeltaMap collects blocks changed since increment base LSN up to the WAL file before backupStartLSN.
+// Segments of ancestor timelines are read as history of the bundle timeline tells.
 func (bundle *Bundle) DownloadDeltaMap(folder StorageFolder, backupStartLSN uint64) error {
-	deltaMap := NewPagedFileDeltaMap()
 	logSegNo := logSegNoFromLsn(*bundle.IncrementFromLsn + 1)
 	logSegNo -= logSegNo % WalFileInDelta
 	lastLogSegNo := logSegNoFromLsn(backupStartLSN) - 1
-	walParser := walparser.NewWalParser()
-	for ; logSegNo+(WalFileInDelta-1) <= lastLogSegNo; logSegNo += WalFileInDelta {
-		deltaFilename := toDeltaFilename(formatWALFileName(bundle.Timeline, logSegNo))
-		reader, err := downloadAndDecompressWALFile(folder, deltaFilename)
-		if err != nil {
-			return errors.Wrapf(err, "Error during delta file '%s' downloading.", deltaFilename)
-		}
-		deltaFile, err := LoadDeltaFile(reader)
-		if err != nil {
-			return errors.Wrapf(err, "Error during reading delta file '%s'", deltaFilename)
-		}
-		walParser = deltaFile.WalParser
-		reader.Close()
-		for _, location := range deltaFile.Locations {
-			deltaMap.AddToDelta(location)
-		}
+	history, err := fetchTimelineHistory(folder, bundle.Timeline)
+	if _, ok := err.(ArchiveNonExistenceError); ok {
+		tracelog.WarningLogger.Printf("History of timeline %d is not found, delta map is collected from this timeline only\n", bundle.Timeline)
+		history, err = TimelineHistory{}, nil
 	}
-	// We don't consider the case when there is no delta files from previous backup,
-	// because in such a case postgres do a WAL-Switch and first WAL file appears to be whole.
-	for ; logSegNo <= lastLogSegNo; logSegNo++ {
-		walFilename := formatWALFileName(bundle.Timeline, logSegNo)
-		reader, err := downloadAndDecompressWALFile(folder, walFilename)
-		if err != nil {
-			return errors.Wrapf(err, "Error during wal file '%s' downloading", walFilename)
-		}
-		locations, err := extractLocationsFromWalFile(walParser, reader)
-		if err != nil {
-			return errors.Wrapf(err, "Error during extracting locations from wal file: '%s'", walFilename)
-		}
-		reader.Close()
-		for _, location := range locations {
-			deltaMap.AddToDelta(location)
-		}
+	if err != nil {
+		return err
+	}
+	deltaMap, err := downloadWholeWalFilesDeltaMap(folder, bundle.Timeline, history, logSegNo, lastLogSegNo)
+	if err != nil {
+		return err
 	}
 	bundle.DeltaMap = deltaMap
 	return nil
@@ -511,7 +539,7 @@ func (bundle *Bundle) packFileIntoTar(path string, info os.FileInfo, fileInfoHea
 	if isIncremented {
 		bitmap, err := bundle.getDeltaBitmapFor(path)
 		if _, ok := err.(NoBitmapFoundError); ok { // this file has changed after the start of backup, so just skip it
-			bundle.GetFiles().Store(fileInfoHeader.Name, BackupFileDescription{IsSkipped: true, IsIncremented: false, MTime: info.ModTime()})
+			bundle.GetFiles().Store(fileInfoHeader.Name, BackupFileDescription{IsSkipped: true, IsIncremented: false, MTime: info.ModTime(), Size: info.Size()})
 			return nil
 		} else if err != nil {
 			return errors.Wrapf(err, "packFileIntoTar: failed to find corresponding bitmap '%s'\n", path)
@@ -542,7 +570,8 @@ func (bundle *Bundle) packFileIntoTar(path string, info os.FileInfo, fileInfoHea
 	}
 	defer fileReader.Close()
 
-	bundle.GetFiles().Store(fileInfoHeader.Name, BackupFileDescription{IsSkipped: false, IsIncremented: isIncremented, MTime: info.ModTime()})
+	bundle.GetFiles().Store(fileInfoHeader.Name, BackupFileDescription{IsSkipped: false, IsIncremented: isIncremented, MTime: info.ModTime(),
+		Size: info.Size(), TarPart: tarBall.Name()})
 
 	packedFileSize, err := PackFileTo(tarBall, fileInfoHeader, fileReader)
 	if err != nil {
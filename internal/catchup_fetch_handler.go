@@ -0,0 +1,203 @@
+package internal
+
+import (
+	"fmt"
+	"github.com/pkg/errors"
+	"github.com/x4m/wal-g/internal/tracelog"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+const CatchupFlag = "--catchup"
+
+type CatchupServerRunningError struct {
+	error
+}
+
+func NewCatchupServerRunningError(dbDataDirectory string) CatchupServerRunningError {
+	return CatchupServerRunningError{errors.Errorf("Found postmaster.pid in %v, stop the server before catchup", dbDataDirectory)}
+}
+
+func (err CatchupServerRunningError) Error() string {
+	return fmt.Sprintf(tracelog.GetErrorFormatter(), err.error)
+}
+
+// CatchupComparison lists differences between data directory and the backup
+type CatchupComparison struct {
+	// FilesToRestore are names of backup files, which are missing or changed in data directory
+	FilesToRestore map[string]bool
+	// FilesToDelete are paths relative to data directory of files, which are not present in the backup
+	FilesToDelete []string
+}
+
+// TODO : unit tests
+// HandleCatchupFetch is invoked to perform wal-g backup-fetch --catchup
+func HandleCatchupFetch(folder StorageFolder, dbDataDirectory string, backupName string, owner string) {
+	tracelog.DebugLogger.Printf("HandleCatchupFetch(folder, %s, %s, %s)\n", dbDataDirectory, backupName, owner)
+	dbDataDirectory = ResolveSymlink(dbDataDirectory)
+	fileOwner, err := LookupFileOwner(owner)
+	if err != nil {
+		tracelog.ErrorLogger.FatalError(err)
+	}
+	err = catchupFetch(folder, dbDataDirectory, backupName, fileOwner)
+	if err != nil {
+		tracelog.ErrorLogger.Fatalf("Failed to catch up with backup: %v\n", err)
+	}
+}
+
+// TODO : unit tests
+func catchupFetch(folder StorageFolder, dbDataDirectory string, backupName string, owner *FileOwner) error {
+	if _, err := os.Stat(filepath.Join(dbDataDirectory, "postmaster.pid")); err == nil {
+		return NewCatchupServerRunningError(dbDataDirectory)
+	}
+
+	backup, err := GetBackupByName(backupName, folder)
+	if err != nil {
+		return err
+	}
+	sentinelDto, err := backup.fetchSentinel()
+	if err != nil {
+		return err
+	}
+	err = sentinelDto.applyPostgresSizes()
+	if err != nil {
+		return err
+	}
+
+	comparison, err := CompareDataDirectoryWithBackup(dbDataDirectory, sentinelDto.Files)
+	if err != nil {
+		return err
+	}
+	tracelog.InfoLogger.Printf("Catchup: %d files to restore, %d files to delete\n",
+		len(comparison.FilesToRestore), len(comparison.FilesToDelete))
+
+	for _, fileName := range comparison.FilesToDelete {
+		tracelog.DebugLogger.Printf("Deleting '%s'\n", fileName)
+		err = os.Remove(filepath.Join(dbDataDirectory, fileName))
+		if err != nil && !os.IsNotExist(err) {
+			return errors.Wrapf(err, "failed to delete '%s'", fileName)
+		}
+	}
+
+	filesToUnwrap := make(map[string]bool, len(comparison.FilesToRestore)+len(UtilityFilePaths))
+	for fileName := range comparison.FilesToRestore {
+		filesToUnwrap[fileName] = true
+	}
+	for utilityFilePath := range UtilityFilePaths {
+		filesToUnwrap[utilityFilePath] = true
+	}
+	err = unwrapReverse(folder, backup, sentinelDto, dbDataDirectory, filesToUnwrap, true, owner)
+	if err != nil {
+		return err
+	}
+
+	// Modification times of restored files are set as in the backup, so next catchup will not fetch them again
+	for fileName := range comparison.FilesToRestore {
+		mTime := sentinelDto.Files[fileName].MTime
+		err = os.Chtimes(path.Join(dbDataDirectory, fileName), mTime, mTime)
+		if err != nil {
+			return errors.Wrapf(err, "failed to set modification time of '%s'", fileName)
+		}
+	}
+	return nil
+}
+
+// CompareDataDirectoryWithBackup finds files which differ from the backup by size or modification time
+// and files which are not present in the backup. Excluded files and directories are not touched.
+func CompareDataDirectoryWithBackup(dbDataDirectory string, backupFiles BackupFileList) (*CatchupComparison, error) {
+	// File names in sentinel may or may not start with a slash
+	backupFileNames := make(map[string]string, len(backupFiles))
+	for fileName := range backupFiles {
+		backupFileNames[strings.TrimPrefix(fileName, "/")] = fileName
+	}
+	localFiles := make(map[string]bool)
+	comparison := &CatchupComparison{make(map[string]bool), make([]string, 0)}
+
+	err := filepath.Walk(dbDataDirectory, func(filePath string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if _, excluded := ExcludedFilenames[info.Name()]; excluded {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		relativePath := strings.TrimPrefix(GetFileRelativePath(filePath, dbDataDirectory), "/")
+		fileName, inBackup := backupFileNames[relativePath]
+		if !inBackup {
+			if _, isUtilityFile := UtilityFilePaths["/"+relativePath]; !isUtilityFile {
+				if _, isUtilityFile = UtilityFilePaths[relativePath]; !isUtilityFile {
+					comparison.FilesToDelete = append(comparison.FilesToDelete, relativePath)
+				}
+			}
+			return nil
+		}
+		localFiles[fileName] = true
+		description := backupFiles[fileName]
+		if !info.ModTime().Equal(description.MTime) || info.Size() != description.Size {
+			comparison.FilesToRestore[fileName] = true
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to compare '%s' with backup", dbDataDirectory)
+	}
+
+	for fileName := range backupFiles {
+		if !localFiles[fileName] {
+			comparison.FilesToRestore[fileName] = true
+		}
+	}
+	sort.Strings(comparison.FilesToDelete)
+	return comparison, nil
+}
+
+// TODO : unit tests
+// extractChangedTars extracts only tars containing files to unwrap. Tars not mentioned
+// in file descriptions (directories, label files) and tars of old backups without this information are always extracted.
+func (backup *Backup) extractChangedTars(tarInterpreter TarInterpreter, sentinelDto BackupSentinelDto,
+	filesToUnwrap map[string]bool) (pgControlKey string, err error) {
+	tarsToExtract, pgControlKey, err := backup.getTarsToExtract(sentinelDto)
+	if err != nil {
+		return "", err
+	}
+	tarsToExtract = FilterTarsToExtract(tarsToExtract, sentinelDto.Files, filesToUnwrap)
+	if len(tarsToExtract) == 0 {
+		tracelog.InfoLogger.Printf("Nothing to extract from %s\n", backup.Name)
+		return pgControlKey, nil
+	}
+	return pgControlKey, ExtractAll(tarInterpreter, tarsToExtract)
+}
+
+// FilterTarsToExtract leaves tars, which contain files to unwrap or whose content is unknown
+func FilterTarsToExtract(tarsToExtract []ReaderMaker, backupFiles BackupFileList, filesToUnwrap map[string]bool) []ReaderMaker {
+	knownTars := make(map[string]bool)
+	neededTars := make(map[string]bool)
+	for fileName, description := range backupFiles {
+		if description.TarPart == "" {
+			continue
+		}
+		knownTars[description.TarPart] = true
+		if filesToUnwrap[fileName] {
+			neededTars[description.TarPart] = true
+		}
+	}
+
+	result := make([]ReaderMaker, 0, len(tarsToExtract))
+	for _, tarToExtract := range tarsToExtract {
+		tarName := tarToExtract.Path()
+		if knownTars[tarName] && !neededTars[tarName] {
+			tracelog.DebugLogger.Printf("Skipping unchanged tar '%s'\n", tarName)
+			continue
+		}
+		result = append(result, tarToExtract)
+	}
+	return result
+}
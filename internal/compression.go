@@ -8,17 +8,17 @@ import (
 )
 
 const (
-	Lz4AlgorithmName    = "lz4"
-	LzmaAlgorithmName   = "lzma"
-	ZstdAlgorithmName   = "zstd"
-
-	Lz4FileExtension    = "lz4"
-	LzmaFileExtension   = "lzma"
-	ZstdFileExtension   = "zst"
-	LzoFileExtension    = "lzo"
+	Lz4AlgorithmName  = "lz4"
+	LzmaAlgorithmName = "lzma"
+	ZstdAlgorithmName = "zstd"
+
+	Lz4FileExtension  = "lz4"
+	LzmaFileExtension = "lzma"
+	ZstdFileExtension = "zst"
+	LzoFileExtension  = "lzo"
 )
 
-var CompressingAlgorithms = []string{Lz4AlgorithmName, LzmaAlgorithmName}
+var CompressingAlgorithms = []string{Lz4AlgorithmName, LzmaAlgorithmName, ZstdAlgorithmName}
 
 type UnknownCompressionMethodError struct {
 	error
@@ -32,6 +32,20 @@ func (err UnknownCompressionMethodError) Error() string {
 	return fmt.Sprintf(tracelog.GetErrorFormatter(), err.error)
 }
 
+type InvalidCompressionLevelError struct {
+	error
+}
+
+func NewInvalidCompressionLevelError(method string, level int) InvalidCompressionLevelError {
+	levels := compressionLevels[method]
+	return InvalidCompressionLevelError{errors.Errorf("Invalid compression level %d of %s, supported levels are from %d to %d",
+		level, method, levels[0], levels[1])}
+}
+
+func (err InvalidCompressionLevelError) Error() string {
+	return fmt.Sprintf(tracelog.GetErrorFormatter(), err.error)
+}
+
 type Compressor interface {
 	NewWriter(writer io.Writer) ReaderFromWriteCloser
 	FileExtension() string
@@ -42,15 +56,44 @@ type Decompressor interface {
 	FileExtension() string
 }
 
+// Compressors have default compression levels
 var Compressors = map[string]Compressor{
-	Lz4AlgorithmName:    Lz4Compressor{},
-	LzmaAlgorithmName:   LzmaCompressor{},
+	Lz4AlgorithmName:  Lz4Compressor{DefaultLz4CompressionLevel},
+	LzmaAlgorithmName: LzmaCompressor{DefaultLzmaCompressionLevel},
+	ZstdAlgorithmName: ZstdCompressor{Level: DefaultZstdCompressionLevel},
+}
+
+// compressionLevels are the lowest and the highest compression levels of methods
+var compressionLevels = map[string][2]int{
+	Lz4AlgorithmName:  {DefaultLz4CompressionLevel, MaxLz4CompressionLevel},
+	LzmaAlgorithmName: {0, MaxLzmaCompressionLevel},
+	ZstdAlgorithmName: {MinZstdCompressionLevel, MaxZstdCompressionLevel},
+}
+
+// NewCompressor makes compressor of the method, which compresses with the level
+func NewCompressor(method string, level int) (Compressor, error) {
+	levels, ok := compressionLevels[method]
+	if !ok {
+		return nil, NewUnknownCompressionMethodError()
+	}
+	if level < levels[0] || level > levels[1] {
+		return nil, NewInvalidCompressionLevelError(method, level)
+	}
+	switch method {
+	case Lz4AlgorithmName:
+		return Lz4Compressor{level}, nil
+	case LzmaAlgorithmName:
+		return LzmaCompressor{level}, nil
+	default:
+		return ZstdCompressor{Level: level}, nil
+	}
 }
 
 var Decompressors = []Decompressor{
 	Lz4Decompressor{},
 	LzmaDecompressor{},
 	ZstdDecompressor{},
+	LzoDecompressor{},
 }
 
 func getDecompressorByCompressor(compressor Compressor) Decompressor {
@@ -5,6 +5,7 @@ import (
 	"net/url"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/defaults"
@@ -22,7 +23,7 @@ import (
 
 const (
 	DefaultStreamingPartSizeFor10Concurrency = 20 << 20
-	DefaultDataBurstRateLimit                = 8 * int64(DatabasePageSize)
+	DefaultDataBurstRateLimit                = 8 * int64(DefaultDatabasePageSize)
 	DefaultDataFolderPath                    = "/tmp"
 )
 
@@ -87,22 +88,42 @@ func getPathFromPrefix(prefix string) (bucket, server string, err error) {
 
 // TODO : unit tests
 func configureLimiters() error {
-	if diskLimitStr := getSettingValue("WALG_DISK_RATE_LIMIT"); diskLimitStr != "" {
-		diskLimit, err := strconv.ParseInt(diskLimitStr, 10, 64)
+	var err error
+	DiskLimiter, DiskLimitSchedule, err = configureLimiter("WALG_DISK_RATE_LIMIT", "WALG_DISK_RATE_LIMIT_SCHEDULE")
+	if err != nil {
+		return err
+	}
+	NetworkLimiter, NetworkLimitSchedule, err = configureLimiter("WALG_NETWORK_RATE_LIMIT", "WALG_NETWORK_RATE_LIMIT_SCHEDULE")
+	return err
+}
+
+// TODO : unit tests
+// configureLimiter creates limiter with constant limit or with time of day schedule.
+// Limit setting is used outside of schedule windows, without it rate is not limited there.
+func configureLimiter(limitSetting string, scheduleSetting string) (*rate.Limiter, *RateLimitSchedule, error) {
+	limit := rate.Inf
+	if limitStr := getSettingValue(limitSetting); limitStr != "" {
+		parsedLimit, err := strconv.ParseInt(limitStr, 10, 64)
 		if err != nil {
-			return errors.Wrap(err, "failed to parse WALG_DISK_RATE_LIMIT")
+			return nil, nil, errors.Wrapf(err, "failed to parse %s", limitSetting)
 		}
-		DiskLimiter = rate.NewLimiter(rate.Limit(diskLimit), int(diskLimit+DefaultDataBurstRateLimit)) // Add 8 pages to possible bursts
+		limit = rate.Limit(parsedLimit)
 	}
 
-	if netLimitStr := getSettingValue("WALG_NETWORK_RATE_LIMIT"); netLimitStr != "" {
-		netLimit, err := strconv.ParseInt(netLimitStr, 10, 64)
-		if err != nil {
-			return errors.Wrap(err, "failed to parse WALG_NETWORK_RATE_LIMIT")
+	scheduleStr := getSettingValue(scheduleSetting)
+	if scheduleStr == "" {
+		if limit == rate.Inf {
+			return nil, nil, nil
 		}
-		NetworkLimiter = rate.NewLimiter(rate.Limit(netLimit), int(netLimit+DefaultDataBurstRateLimit)) // Add 8 pages to possible bursts
+		return rate.NewLimiter(limit, limitBurst(limit)), nil, nil
 	}
-	return nil
+
+	windows, err := ParseRateLimitSchedule(scheduleStr)
+	if err != nil {
+		return nil, nil, err
+	}
+	schedule := &RateLimitSchedule{DefaultLimit: limit, Windows: windows}
+	return schedule.LimiterAt(time.Now()), schedule, nil
 }
 
 // TODO : unit tests
@@ -231,7 +252,7 @@ func getDataFolderPath() string {
 }
 
 // TODO : unit tests
-func configureWalDeltaUsage() (useWalDelta bool, deltaDataFolder DataFolder, err error) {
+func configureWalDeltaUsage(folder StorageFolder) (useWalDelta bool, deltaDataFolder DataFolder, err error) {
 	if useWalDeltaStr, ok := LookupConfigValue("WALG_USE_WAL_DELTA"); ok {
 		useWalDelta, err = strconv.ParseBool(useWalDeltaStr)
 		if err != nil {
@@ -241,6 +262,15 @@ func configureWalDeltaUsage() (useWalDelta bool, deltaDataFolder DataFolder, err
 	if !useWalDelta {
 		return
 	}
+	if deltaDataInStorageStr, ok := LookupConfigValue("WALG_DELTA_DATA_IN_STORAGE"); ok {
+		deltaDataInStorage, err := strconv.ParseBool(deltaDataInStorageStr)
+		if err != nil {
+			return false, nil, errors.Wrapf(err, "failed to parse WALG_DELTA_DATA_IN_STORAGE")
+		}
+		if deltaDataInStorage {
+			return true, NewStorageDataFolder(folder.GetSubFolder(DeltaDataPath), GetDataFolderHolder(getDataFolderPath())), nil
+		}
+	}
 	dataFolderPath := getDataFolderPath()
 	deltaDataFolder, err = NewDiskDataFolder(dataFolderPath)
 	if err != nil {
@@ -261,7 +291,15 @@ func configureCompressor() (Compressor, error) {
 	if _, ok := Compressors[compressionMethod]; !ok {
 		return nil, NewUnknownCompressionMethodError()
 	}
-	return Compressors[compressionMethod], nil
+	compressionLevelStr := getSettingValue("WALG_COMPRESSION_LEVEL")
+	if compressionLevelStr == "" {
+		return Compressors[compressionMethod], nil
+	}
+	compressionLevel, err := strconv.Atoi(compressionLevelStr)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to parse WALG_COMPRESSION_LEVEL")
+	}
+	return NewCompressor(compressionMethod, compressionLevel)
 }
 
 // TODO : unit tests
@@ -314,7 +352,7 @@ func Configure() (uploader *Uploader, destinationFolder StorageFolder, err error
 		return nil, nil, errors.Wrap(err, "failed to configure compression")
 	}
 
-	useWalDelta, deltaDataFolder, err := configureWalDeltaUsage()
+	useWalDelta, deltaDataFolder, err := configureWalDeltaUsage(folder)
 	if err != nil {
 		return nil, nil, errors.Wrap(err, "failed to configure WAL Delta usage")
 	}
@@ -327,7 +365,16 @@ func Configure() (uploader *Uploader, destinationFolder StorageFolder, err error
 		}
 	}
 
-	uploader = NewUploader(compressor, folder, deltaDataFolder, useWalDelta, preventWalOverwrite)
+	useWalTimeIndex := false
+	if useWalTimeIndexStr := getSettingValue("WALG_USE_WAL_TIME_INDEX"); useWalTimeIndexStr != "" {
+		useWalTimeIndex, err = strconv.ParseBool(useWalTimeIndexStr)
+		if err != nil {
+			return nil, nil, errors.Wrap(err, "failed to parse WALG_USE_WAL_TIME_INDEX")
+		}
+	}
+
+	uploader = NewUploader(compressor, folder, deltaDataFolder, useWalDelta, preventWalOverwrite, useWalTimeIndex)
+	uploader.TarCompressionConcurrency = getCompressionConcurrency()
 
 	return uploader, folder, err
 }
@@ -1,6 +1,7 @@
 package internal
 
 import (
+	"github.com/pkg/errors"
 	"github.com/x4m/wal-g/internal/tracelog"
 	"log"
 	"sort"
@@ -243,7 +244,7 @@ func deleteBackupsBefore(backups []BackupTime, skipline int, folder StorageFolde
 func dropBackup(folder StorageFolder, backupName string) {
 	basebackupFolder := folder.GetSubFolder(BaseBackupPath)
 	backup := NewBackup(basebackupFolder, backupName)
-	tarNames, err := backup.GetTarNames()
+	tarNames, err := getTarNamesToDelete(backup)
 	if err != nil {
 		tracelog.ErrorLogger.FatalError(err)
 	}
@@ -269,6 +270,19 @@ func dropBackup(folder StorageFolder, backupName string) {
 	}
 }
 
+// getTarNamesToDelete checks tar parts against sentinel manifest.
+// Backups without sentinel are garbage, their parts are deleted as listed.
+func getTarNamesToDelete(backup *Backup) ([]string, error) {
+	sentinelDto, err := backup.fetchSentinel()
+	if err != nil {
+		if _, ok := errors.Cause(err).(ObjectNotFoundError); ok {
+			return backup.GetTarNames()
+		}
+		return nil, err
+	}
+	return backup.GetCheckedTarNames(sentinelDto)
+}
+
 // TODO : unit tests
 func deleteWALBefore(walSkipFileName string, walFolder StorageFolder) {
 	wals, err := getWals(walSkipFileName, walFolder)
@@ -6,6 +6,7 @@ import (
 	"github.com/pkg/errors"
 	"github.com/x4m/wal-g/internal/tracelog"
 	"github.com/x4m/wal-g/internal/walparser"
+	"sort"
 	"sync"
 )
 
@@ -25,6 +26,7 @@ type DeltaFileManager struct {
 	dataFolder            DataFolder
 	PartFiles             *LazyCache
 	DeltaFileWriters      *LazyCache
+	DeltaSummaries        *LazyCache
 	deltaFileWriterWaiter sync.WaitGroup
 	canceledWalRecordings chan string
 	CanceledDeltaFiles    map[string]bool
@@ -36,6 +38,7 @@ func NewDeltaFileManager(dataFolder DataFolder) *DeltaFileManager {
 		dataFolder,
 		nil,
 		nil,
+		nil,
 		sync.WaitGroup{},
 		make(chan string),
 		make(map[string]bool),
@@ -55,6 +58,13 @@ func NewDeltaFileManager(dataFolder DataFolder) *DeltaFileManager {
 		}
 		return manager.LoadDeltaFileWriter(deltaFilename)
 	})
+	manager.DeltaSummaries = NewLazyCache(func(summaryFilenameInterface interface{}) (summary interface{}, err error) {
+		summaryFilename, ok := summaryFilenameInterface.(string)
+		if !ok {
+			return nil, NewWrongTypeError("string")
+		}
+		return manager.LoadDeltaSummary(summaryFilename)
+	})
 	manager.canceledWaiter.Add(1)
 	go manager.collectCanceledDeltaFiles()
 	return manager
@@ -90,9 +100,30 @@ func (manager *DeltaFileManager) LoadDeltaFileWriter(deltaFilename string) (delt
 	deltaFileWriter = NewDeltaFileChanWriter(deltaFile)
 	manager.deltaFileWriterWaiter.Add(1)
 	go deltaFileWriter.Consume(&manager.deltaFileWriterWaiter)
+	// Summary is loaded before data folder is cleaned, so that it survives until its delta files are uploaded
+	summaryFilename, _, err := GetDeltaSummaryFilenameFor(deltaFilename)
+	if err == nil {
+		_, _, err = manager.DeltaSummaries.Load(summaryFilename)
+	}
+	if err != nil {
+		tracelog.WarningLogger.Printf("Failed to load delta summary for '%s': %v\n", deltaFilename, err)
+	}
 	return deltaFileWriter, nil
 }
 
+// TODO : unit tests
+func (manager *DeltaFileManager) LoadDeltaSummary(summaryFilename string) (*DeltaMapSummary, error) {
+	physicalSummaryFile, err := manager.dataFolder.OpenReadonlyFile(ToPartFilename(summaryFilename))
+	if err != nil {
+		if _, ok := err.(NoSuchFileError); !ok {
+			return nil, err
+		}
+		return NewDeltaMapSummary(), nil
+	}
+	defer physicalSummaryFile.Close()
+	return LoadDeltaMapSummary(physicalSummaryFile)
+}
+
 func (manager *DeltaFileManager) GetPartFile(deltaFilename string) (*WalPartFile, error) {
 	partFilename := ToPartFilename(deltaFilename)
 	partFile, _, err := manager.PartFiles.Load(partFilename)
@@ -125,12 +156,25 @@ func (manager *DeltaFileManager) FlushPartFiles() (completedPartFiles map[string
 	close(manager.canceledWalRecordings)
 	manager.canceledWaiter.Wait()
 	completedPartFiles = make(map[string]bool)
+	partFilenames := make([]string, 0)
 	manager.PartFiles.Range(func(key, value interface{}) bool {
-		partFilename := key.(string)
+		partFilenames = append(partFilenames, key.(string))
+		return true
+	})
+	// Part files are flushed in WAL order, because combining of one part file can complete the next one
+	sort.Strings(partFilenames)
+	flushedPartFiles := make(map[string]bool)
+	for i := 0; i < len(partFilenames); i++ {
+		partFilename := partFilenames[i]
+		if flushedPartFiles[partFilename] {
+			continue
+		}
+		flushedPartFiles[partFilename] = true
+		value, _ := manager.PartFiles.LoadExisting(partFilename)
 		partFile := value.(*WalPartFile)
 		deltaFilename := partFilenameToDelta(partFilename)
 		if _, ok := manager.CanceledDeltaFiles[deltaFilename]; ok {
-			return true
+			continue
 		}
 		if partFile.IsComplete() {
 			completedPartFiles[partFilename] = true
@@ -138,6 +182,9 @@ func (manager *DeltaFileManager) FlushPartFiles() (completedPartFiles map[string
 			if err != nil {
 				manager.CanceledDeltaFiles[deltaFilename] = true
 				tracelog.WarningLogger.Printf("Canceled delta file writing because of error: "+tracelog.GetErrorFormatter()+"\n", err)
+			} else if partFile.ContinuedWals[WalFileInDelta-1] {
+				nextDeltaFilename, _ := getNextDeltaFilename(deltaFilename)
+				partFilenames = append(partFilenames, ToPartFilename(nextDeltaFilename))
 			}
 		} else {
 			err := saveToDataFolder(partFile, partFilename, manager.dataFolder)
@@ -146,8 +193,7 @@ func (manager *DeltaFileManager) FlushPartFiles() (completedPartFiles map[string
 				tracelog.WarningLogger.Printf("Failed to save part file: '%s' because of error: '%v'\n", partFilename, err)
 			}
 		}
-		return true
-	})
+	}
 	return
 }
 
@@ -158,6 +204,7 @@ func (manager *DeltaFileManager) FlushDeltaFiles(uploader *Uploader, completedPa
 		return true
 	})
 	manager.deltaFileWriterWaiter.Wait()
+	uploadedDeltaFiles := make(map[string]*DeltaFile)
 	manager.DeltaFileWriters.Range(func(key, value interface{}) bool {
 		deltaFilename := key.(string)
 		deltaFileWriter := value.(*DeltaFileChanWriter)
@@ -174,6 +221,8 @@ func (manager *DeltaFileManager) FlushDeltaFiles(uploader *Uploader, completedPa
 				err = uploader.UploadFile(&NamedReaderImpl{&deltaFileData, deltaFilename})
 				if err != nil {
 					tracelog.WarningLogger.Printf("Failed to upload delta file: '%s' because of uploading error: '%v'\n", deltaFilename, err)
+				} else {
+					uploadedDeltaFiles[deltaFilename] = deltaFileWriter.DeltaFile
 				}
 			}
 		} else {
@@ -184,6 +233,75 @@ func (manager *DeltaFileManager) FlushDeltaFiles(uploader *Uploader, completedPa
 		}
 		return true
 	})
+	manager.FlushDeltaSummaries(uploader, uploadedDeltaFiles)
+}
+
+// FlushDeltaSummaries merges uploaded delta files into their summaries. Summaries of all delta files
+// are uploaded, the rest are saved to data folder to be completed by next uploads.
+// Summaries, which can not be completed anymore, are dropped: those with canceled delta file
+// and those older than the newest complete summary of their timeline.
+func (manager *DeltaFileManager) FlushDeltaSummaries(uploader *Uploader, uploadedDeltaFiles map[string]*DeltaFile) {
+	for deltaFilename, deltaFile := range uploadedDeltaFiles {
+		summaryFilename, position, err := GetDeltaSummaryFilenameFor(deltaFilename)
+		if err != nil {
+			continue
+		}
+		// Summary is loaded with delta file writer, since data folder is already cleaned
+		summary, exists := manager.DeltaSummaries.LoadExisting(summaryFilename)
+		if exists {
+			summary.(*DeltaMapSummary).AddDeltaFile(position, deltaFile)
+		}
+	}
+	canceledSummaries := make(map[string]bool)
+	for deltaFilename := range manager.CanceledDeltaFiles {
+		summaryFilename, _, err := GetDeltaSummaryFilenameFor(deltaFilename)
+		if err == nil {
+			canceledSummaries[summaryFilename] = true
+		}
+	}
+	newestCompleteSummaries := make(map[uint32]uint64)
+	manager.DeltaSummaries.Range(func(key, value interface{}) bool {
+		timeline, logSegNo, err := parseDeltaSummaryFilename(key.(string))
+		if err == nil && value.(*DeltaMapSummary).IsComplete() && logSegNo >= newestCompleteSummaries[timeline] {
+			newestCompleteSummaries[timeline] = logSegNo
+		}
+		return true
+	})
+	manager.DeltaSummaries.Range(func(key, value interface{}) bool {
+		summaryFilename := key.(string)
+		summary := value.(*DeltaMapSummary)
+		if summary.DeltaFiles == 0 {
+			return true
+		}
+		if canceledSummaries[summaryFilename] {
+			tracelog.WarningLogger.Printf("Dropped delta summary: '%s' because its delta file was canceled\n", summaryFilename)
+			return true
+		}
+		if !summary.IsComplete() {
+			timeline, logSegNo, err := parseDeltaSummaryFilename(summaryFilename)
+			newestLogSegNo, exists := newestCompleteSummaries[timeline]
+			if err == nil && exists && logSegNo < newestLogSegNo {
+				tracelog.WarningLogger.Printf("Dropped incomplete delta summary: '%s' older than complete one\n", summaryFilename)
+				return true
+			}
+		}
+		if summary.IsComplete() {
+			var summaryData bytes.Buffer
+			err := summary.Save(&summaryData)
+			if err == nil {
+				err = uploader.UploadFile(&NamedReaderImpl{&summaryData, summaryFilename})
+			}
+			if err == nil {
+				return true
+			}
+			tracelog.WarningLogger.Printf("Failed to upload delta summary: '%s' because of error: '%v'\n", summaryFilename, err)
+		}
+		err := saveToDataFolder(summary, ToPartFilename(summaryFilename), manager.dataFolder)
+		if err != nil {
+			tracelog.WarningLogger.Printf("Failed to save delta summary: '%s' because of error: '%v'\n", summaryFilename, err)
+		}
+		return true
+	})
 }
 
 func (manager *DeltaFileManager) FlushFiles(uploader *Uploader) {
@@ -195,6 +313,18 @@ func (manager *DeltaFileManager) FlushFiles(uploader *Uploader) {
 	manager.FlushDeltaFiles(uploader, completedPartFiles)
 }
 
+// unlockDataFolder releases data folder shared by several hosts, it is called when wal-push fails
+// or daemon stops, so that another host can continue without waiting for the lease to expire.
+// Successful flush keeps the lease, the next wal-push of the host renews it without settle delay.
+func (manager *DeltaFileManager) unlockDataFolder() {
+	if lockingDataFolder, ok := manager.dataFolder.(LockingDataFolder); ok {
+		err := lockingDataFolder.Unlock()
+		if err != nil {
+			tracelog.WarningLogger.Printf("Failed to unlock delta folder because of error: '%v'\n", err)
+		}
+	}
+}
+
 func (manager *DeltaFileManager) CancelRecording(walFilename string) {
 	manager.canceledWalRecordings <- walFilename
 }
@@ -220,11 +350,24 @@ func (manager *DeltaFileManager) CombinePartFile(deltaFilename string, partFile
 		return NewDeltaFileWriterNotFoundError(deltaFilename)
 	}
 	deltaFileWriter := deltaFileWriterInterface.(*DeltaFileChanWriter)
-	deltaFileWriter.DeltaFile.WalParser = walparser.LoadWalParserFromCurrentRecordHead(partFile.WalHeads[WalFileInDelta-1])
+	nextRecordHead := partFile.GetNextDeltaFileRecordHead()
+	deltaFileWriter.DeltaFile.WalParser = walparser.LoadWalParserFromCurrentRecordHead(nextRecordHead)
 	records, err := partFile.CombineRecords()
 	if err != nil {
 		return err
 	}
+	if partFile.ContinuedWals[WalFileInDelta-1] {
+		// Recorder of the last WAL file did not know the head of the record, which ends in the next delta
+		nextDeltaFilename, err := getNextDeltaFilename(deltaFilename)
+		if err != nil {
+			return err
+		}
+		nextPartFile, err := manager.GetPartFile(nextDeltaFilename)
+		if err != nil {
+			return err
+		}
+		nextPartFile.PreviousWalHead = nextRecordHead
+	}
 	locations := ExtractBlockLocations(records)
 	for _, location := range locations {
 		deltaFileWriter.BlockLocationConsumer <- location
@@ -0,0 +1,196 @@
+package internal
+
+import (
+	"fmt"
+	"github.com/pkg/errors"
+	"github.com/x4m/wal-g/internal/tracelog"
+	"github.com/x4m/wal-g/internal/walparser"
+	"math"
+)
+
+type InvalidLsnRangeError struct {
+	error
+}
+
+func NewInvalidLsnRangeError(fromLsn uint64, toLsn uint64) InvalidLsnRangeError {
+	return InvalidLsnRangeError{errors.Errorf("LSN %s does not precede LSN %s", formatLsn(fromLsn), formatLsn(toLsn))}
+}
+
+func (err InvalidLsnRangeError) Error() string {
+	return fmt.Sprintf(tracelog.GetErrorFormatter(), err.error)
+}
+
+// deltaMapDownloader collects changed blocks from archived delta files and WAL files of one timeline.
+// Files must be added in order, since WAL parser state is carried from one file to the next.
+type deltaMapDownloader struct {
+	folder    StorageFolder
+	timeline  uint32
+	walParser *walparser.WalParser
+	deltaMap  PagedFileDeltaMap
+}
+
+func newDeltaMapDownloader(folder StorageFolder, timeline uint32) *deltaMapDownloader {
+	return &deltaMapDownloader{folder, timeline, walparser.NewWalParser(), NewPagedFileDeltaMap()}
+}
+
+// addDeltaFile adds changes of WalFileInDelta WAL files starting from firstLogSegNo
+func (downloader *deltaMapDownloader) addDeltaFile(firstLogSegNo uint64) error {
+	deltaFilename := toDeltaFilename(formatWALFileName(downloader.timeline, firstLogSegNo))
+	reader, err := downloadAndDecompressWALFile(downloader.folder, deltaFilename)
+	if _, ok := err.(ArchiveNonExistenceError); ok {
+		return err
+	}
+	if err != nil {
+		return errors.Wrapf(err, "Error during delta file '%s' downloading.", deltaFilename)
+	}
+	defer reader.Close()
+	deltaFile, err := LoadDeltaFile(reader)
+	if err != nil {
+		return errors.Wrapf(err, "Error during reading delta file '%s'", deltaFilename)
+	}
+	downloader.walParser = deltaFile.WalParser
+	for _, location := range deltaFile.Locations {
+		downloader.deltaMap.AddToDelta(location)
+	}
+	return nil
+}
+
+// addDeltaSummary adds changes of WalFileInDeltaSummary WAL files starting from firstLogSegNo
+func (downloader *deltaMapDownloader) addDeltaSummary(firstLogSegNo uint64) error {
+	summaryFilename := toDeltaSummaryFilename(formatWALFileName(downloader.timeline, firstLogSegNo))
+	reader, err := downloadAndDecompressWALFile(downloader.folder, summaryFilename)
+	if _, ok := err.(ArchiveNonExistenceError); ok {
+		return err
+	}
+	if err != nil {
+		return errors.Wrapf(err, "Error during delta summary '%s' downloading.", summaryFilename)
+	}
+	defer reader.Close()
+	summary, err := LoadDeltaMapSummary(reader)
+	if err != nil {
+		return errors.Wrapf(err, "Error during reading delta summary '%s'", summaryFilename)
+	}
+	downloader.walParser = summary.WalParser
+	for relFileNode, bitmap := range summary.DeltaMap {
+		if existing, ok := downloader.deltaMap[relFileNode]; ok {
+			existing.Or(bitmap)
+		} else {
+			downloader.deltaMap[relFileNode] = bitmap
+		}
+	}
+	return nil
+}
+
+// addDeltaFiles adds changes of WAL files from logSegNo up to lastLogSegNo by whole groups of delta files.
+// Summaries are used for whole intervals, missing summaries are replaced by delta files. Number of the first
+// WAL file, which is not added, is returned.
+func (downloader *deltaMapDownloader) addDeltaFiles(logSegNo uint64, lastLogSegNo uint64,
+	addMissingDeltaFile func(logSegNo uint64) error) (uint64, error) {
+	for logSegNo+(WalFileInDelta-1) <= lastLogSegNo {
+		if logSegNo%WalFileInDeltaSummary == 0 && logSegNo+(WalFileInDeltaSummary-1) <= lastLogSegNo {
+			err := downloader.addDeltaSummary(logSegNo)
+			if err == nil {
+				logSegNo += WalFileInDeltaSummary
+				continue
+			}
+			if _, ok := err.(ArchiveNonExistenceError); !ok {
+				return logSegNo, err
+			}
+		}
+		err := downloader.addDeltaFile(logSegNo)
+		if _, ok := err.(ArchiveNonExistenceError); ok && addMissingDeltaFile != nil {
+			err = addMissingDeltaFile(logSegNo)
+		}
+		if err != nil {
+			return logSegNo, err
+		}
+		logSegNo += WalFileInDelta
+	}
+	return logSegNo, nil
+}
+
+// addWalFile adds changes of records of WAL file, which start in [fromLsn, toLsn)
+func (downloader *deltaMapDownloader) addWalFile(logSegNo uint64, fromLsn uint64, toLsn uint64) error {
+	walFilename := formatWALFileName(downloader.timeline, logSegNo)
+	reader, err := downloadAndDecompressWALFile(downloader.folder, walFilename)
+	if err != nil {
+		return errors.Wrapf(err, "Error during wal file '%s' downloading", walFilename)
+	}
+	defer reader.Close()
+	locations, err := extractLocationsFromWalFileRange(downloader.walParser, reader, fromLsn, toLsn)
+	if err != nil {
+		return errors.Wrapf(err, "Error during extracting locations from wal file: '%s'", walFilename)
+	}
+	for _, location := range locations {
+		downloader.deltaMap.AddToDelta(location)
+	}
+	return nil
+}
+
+// TODO : unit tests
+// DownloadDeltaMapBetween collects blocks changed by records, which start in [fromLsn, toLsn).
+// Delta summaries and delta files are used for whole groups of WAL files inside the range, WAL files
+// are read at the range edges and for groups, which have no delta file.
+func DownloadDeltaMapBetween(folder StorageFolder, timeline uint32, fromLsn uint64, toLsn uint64) (PagedFileDeltaMap, error) {
+	if fromLsn >= toLsn {
+		return nil, NewInvalidLsnRangeError(fromLsn, toLsn)
+	}
+	downloader := newDeltaMapDownloader(folder, timeline)
+	logSegNo := fromLsn / WalSegmentSize
+	lastLogSegNo := (toLsn - 1) / WalSegmentSize
+	for ; logSegNo <= lastLogSegNo && (logSegNo%WalFileInDelta != 0 || logSegNo*WalSegmentSize < fromLsn); logSegNo++ {
+		if err := downloader.addWalFile(logSegNo, fromLsn, toLsn); err != nil {
+			return nil, err
+		}
+	}
+	if logSegNo%WalFileInDelta == 0 && (logSegNo+WalFileInDelta)*WalSegmentSize <= toLsn {
+		var err error
+		logSegNo, err = downloader.addDeltaFiles(logSegNo, toLsn/WalSegmentSize-1, func(logSegNo uint64) error {
+			var err error
+			for i := uint64(0); i < WalFileInDelta && err == nil; i++ {
+				err = downloader.addWalFile(logSegNo+i, fromLsn, toLsn)
+			}
+			return err
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	for ; logSegNo <= lastLogSegNo; logSegNo++ {
+		if err := downloader.addWalFile(logSegNo, fromLsn, toLsn); err != nil {
+			return nil, err
+		}
+	}
+	return downloader.deltaMap, nil
+}
+
+// downloadWholeWalFilesDeltaMap collects changes of WAL files from logSegNo up to lastLogSegNo. Each segment is
+// read from the timeline, which the history leads through. Delta files are used for groups of WAL files on one
+// timeline, the group with the switch of timeline is read from WAL files: segments before the switch point from
+// the ancestor timeline, the partial segment with the switch point and the following ones from the next timeline.
+func downloadWholeWalFilesDeltaMap(folder StorageFolder, timeline uint32, history TimelineHistory,
+	logSegNo uint64, lastLogSegNo uint64) (PagedFileDeltaMap, error) {
+	downloader := newDeltaMapDownloader(folder, timeline)
+	for _, segments := range history.splitSegments(timeline, logSegNo, lastLogSegNo) {
+		downloader.timeline = segments.timeline
+		logSegNo = segments.logSegNo
+		for ; logSegNo <= segments.lastLogSegNo && logSegNo%WalFileInDelta != 0; logSegNo++ {
+			if err := downloader.addWalFile(logSegNo, 0, math.MaxUint64); err != nil {
+				return nil, err
+			}
+		}
+		var err error
+		logSegNo, err = downloader.addDeltaFiles(logSegNo, segments.lastLogSegNo, nil)
+		if err != nil {
+			return nil, err
+		}
+		// We don't consider the case when there is no delta files from previous backup,
+		// because in such a case postgres do a WAL-Switch and first WAL file appears to be whole.
+		for ; logSegNo <= segments.lastLogSegNo; logSegNo++ {
+			if err := downloader.addWalFile(logSegNo, 0, math.MaxUint64); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return downloader.deltaMap, nil
+}
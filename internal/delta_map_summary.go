@@ -0,0 +1,155 @@
+package internal
+
+import (
+	"bytes"
+	"encoding/binary"
+	"github.com/RoaringBitmap/roaring"
+	"github.com/pkg/errors"
+	"github.com/x4m/wal-g/internal/walparser"
+	"github.com/x4m/wal-g/internal/walparser/parsingutil"
+	"io"
+	"math"
+	"sort"
+	"strings"
+)
+
+const (
+	// WalFileInDeltaSummary WAL files are rolled up into one delta map summary
+	WalFileInDeltaSummary      uint64 = 1024
+	DeltaSummaryFilenameSuffix        = "_delta_summary"
+
+	deltaFileInDeltaSummary = WalFileInDeltaSummary / WalFileInDelta
+	// allDeltaFilesMask has bits of all delta files of summary, its constant expression overflows
+	// and does not compile, when delta files do not fit into 64 bits of DeltaMapSummary.DeltaFiles
+	allDeltaFilesMask uint64 = math.MaxUint64 >> (64 - deltaFileInDeltaSummary)
+)
+
+// DeltaMapSummary is the delta map of all delta files of WalFileInDeltaSummary WAL files.
+// It is accumulated in data folder as delta files are uploaded, and uploaded when all delta files are merged.
+type DeltaMapSummary struct {
+	DeltaMap PagedFileDeltaMap
+	// DeltaFiles has bit of each delta file merged into DeltaMap
+	DeltaFiles uint64
+	// WalParser is the state of the last delta file of summary, it is needed to continue with next files
+	WalParser *walparser.WalParser
+}
+
+func NewDeltaMapSummary() *DeltaMapSummary {
+	return &DeltaMapSummary{NewPagedFileDeltaMap(), 0, walparser.NewWalParser()}
+}
+
+func (summary *DeltaMapSummary) IsComplete() bool {
+	return summary.DeltaFiles == allDeltaFilesMask
+}
+
+// AddDeltaFile merges delta file, which is at position of delta files of summary
+func (summary *DeltaMapSummary) AddDeltaFile(position int, deltaFile *DeltaFile) {
+	for _, location := range deltaFile.Locations {
+		summary.DeltaMap.AddToDelta(location)
+	}
+	summary.DeltaFiles |= 1 << uint(position)
+	if uint64(position) == deltaFileInDeltaSummary-1 {
+		summary.WalParser = deltaFile.WalParser
+	}
+}
+
+// Save writes merged delta files mask, then number of relations and bitmap of each relation, then WAL parser
+func (summary *DeltaMapSummary) Save(writer io.Writer) error {
+	relFileNodes := make([]walparser.RelFileNode, 0, len(summary.DeltaMap))
+	for relFileNode := range summary.DeltaMap {
+		relFileNodes = append(relFileNodes, relFileNode)
+	}
+	sort.Slice(relFileNodes, func(i, j int) bool {
+		return lessRelFileNode(relFileNodes[i], relFileNodes[j])
+	})
+	err := binary.Write(writer, binary.LittleEndian, []uint64{summary.DeltaFiles, uint64(len(relFileNodes))})
+	if err != nil {
+		return err
+	}
+	for _, relFileNode := range relFileNodes {
+		bitmapData, err := summary.DeltaMap[relFileNode].ToBytes()
+		if err != nil {
+			return err
+		}
+		header := []uint32{uint32(relFileNode.SpcNode), uint32(relFileNode.DBNode), uint32(relFileNode.RelNode), uint32(len(bitmapData))}
+		err = binary.Write(writer, binary.LittleEndian, header)
+		if err != nil {
+			return err
+		}
+		_, err = writer.Write(bitmapData)
+		if err != nil {
+			return err
+		}
+	}
+	return summary.WalParser.Save(writer)
+}
+
+func LoadDeltaMapSummary(reader io.Reader) (*DeltaMapSummary, error) {
+	summary := NewDeltaMapSummary()
+	var relationCount uint64
+	err := parsingutil.ParseMultipleFieldsFromReader([]parsingutil.FieldToParse{
+		{Field: &summary.DeltaFiles, Name: "deltaFiles"},
+		{Field: &relationCount, Name: "relationCount"},
+	}, reader)
+	if err != nil {
+		return nil, err
+	}
+	for i := uint64(0); i < relationCount; i++ {
+		var relFileNode walparser.RelFileNode
+		var bitmapSize uint32
+		err = parsingutil.ParseMultipleFieldsFromReader([]parsingutil.FieldToParse{
+			{Field: &relFileNode.SpcNode, Name: "SpcNode"},
+			{Field: &relFileNode.DBNode, Name: "DBNode"},
+			{Field: &relFileNode.RelNode, Name: "RelNode"},
+			{Field: &bitmapSize, Name: "bitmapSize"},
+		}, reader)
+		if err != nil {
+			return nil, err
+		}
+		bitmapData := make([]byte, bitmapSize)
+		_, err = io.ReadFull(reader, bitmapData)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		bitmap := roaring.New()
+		_, err = bitmap.ReadFrom(bytes.NewReader(bitmapData))
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to read bitmap of %s", formatRelFileNode(relFileNode))
+		}
+		summary.DeltaMap[relFileNode] = bitmap
+	}
+	summary.WalParser, err = walparser.LoadWalParser(reader)
+	if err != nil {
+		return nil, err
+	}
+	return summary, nil
+}
+
+func lessRelFileNode(a walparser.RelFileNode, b walparser.RelFileNode) bool {
+	if a.SpcNode != b.SpcNode {
+		return a.SpcNode < b.SpcNode
+	}
+	if a.DBNode != b.DBNode {
+		return a.DBNode < b.DBNode
+	}
+	return a.RelNode < b.RelNode
+}
+
+func toDeltaSummaryFilename(walFilename string) string {
+	return walFilename + DeltaSummaryFilenameSuffix
+}
+
+func parseDeltaSummaryFilename(summaryFilename string) (timeline uint32, logSegNo uint64, err error) {
+	return ParseWALFilename(strings.TrimSuffix(summaryFilename, DeltaSummaryFilenameSuffix))
+}
+
+// GetDeltaSummaryFilenameFor returns name of summary, which delta file belongs to, and position of delta file in it
+func GetDeltaSummaryFilenameFor(deltaFilename string) (string, int, error) {
+	timeline, logSegNo, err := ParseWALFilename(strings.TrimSuffix(deltaFilename, DeltaFilenameSuffix))
+	if err != nil {
+		return "", 0, err
+	}
+	summarySegNo := logSegNo - logSegNo%WalFileInDeltaSummary
+	position := int((logSegNo - summarySegNo) / WalFileInDelta)
+	return toDeltaSummaryFilename(formatWALFileName(timeline, summarySegNo)), position, nil
+}
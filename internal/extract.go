@@ -72,8 +72,19 @@ func DecryptAndDecompressTar(writer io.Writer, readerMaker ReaderMaker, crypter
 	if err != nil {
 		return errors.Wrap(err, "DecryptAndDecompressTar: failed to create new reader")
 	}
-	defer readCloser.Close()
+	readCloser = NewNetworkLimitReader(readCloser)
 
+	err = decryptAndDecompressTar(writer, readCloser, readerMaker.Path(), crypter)
+	if err != nil {
+		readCloser.Close()
+		return err
+	}
+	// Closing the reader may verify the part against sentinel
+	return errors.Wrap(readCloser.Close(), "DecryptAndDecompressTar: failed to close reader")
+}
+
+func decryptAndDecompressTar(writer io.Writer, readCloser io.ReadCloser, path string, crypter Crypter) error {
+	var err error
 	if crypter.IsUsed() {
 		var reader io.Reader
 		reader, err = crypter.Decrypt(readCloser)
@@ -83,7 +94,7 @@ func DecryptAndDecompressTar(writer io.Writer, readerMaker ReaderMaker, crypter
 		readCloser = ReadCascadeCloser{reader, readCloser}
 	}
 
-	fileExtension := GetFileExtension(readerMaker.Path())
+	fileExtension := GetFileExtension(path)
 	for _, decompressor := range Decompressors {
 		if fileExtension != decompressor.FileExtension() {
 			continue
@@ -96,10 +107,8 @@ func DecryptAndDecompressTar(writer io.Writer, readerMaker ReaderMaker, crypter
 		_, err = io.Copy(writer, readCloser)
 		return errors.Wrap(err, "DecryptAndDecompressTar: tar extract failed")
 	case "nop":
-	case "lzo":
-		return NewUnsupportedFileTypeError(readerMaker.Path(), fileExtension)
 	default:
-		return NewUnsupportedFileTypeError(readerMaker.Path(), fileExtension)
+		return NewUnsupportedFileTypeError(path, fileExtension)
 	}
 	return nil
 }
@@ -0,0 +1,118 @@
+package internal
+
+import (
+	"archive/tar"
+	"fmt"
+	"github.com/pkg/errors"
+	"github.com/x4m/wal-g/internal/tracelog"
+	"os"
+	"os/user"
+	"strconv"
+	"strings"
+)
+
+const OwnerFlag = "--owner"
+
+// xattrPAXPrefix is used by tar to store extended attributes in PAX records
+const xattrPAXPrefix = "SCHILY.xattr."
+
+type UnknownOwnerError struct {
+	error
+}
+
+func NewUnknownOwnerError(owner string, err error) UnknownOwnerError {
+	return UnknownOwnerError{errors.Wrapf(err, "Unable to find user '%s' to own restored files", owner)}
+}
+
+func (err UnknownOwnerError) Error() string {
+	return fmt.Sprintf(tracelog.GetErrorFormatter(), err.error)
+}
+
+// FileOwner overrides ownership of restored files
+type FileOwner struct {
+	Uid int
+	Gid int
+}
+
+func storeXattrs() (bool, error) {
+	storeXattrsStr, ok := LookupConfigValue("WALG_STORE_XATTRS")
+	if !ok {
+		return false, nil
+	}
+	storeXattrs, err := strconv.ParseBool(storeXattrsStr)
+	return storeXattrs, errors.Wrap(err, "failed to parse WALG_STORE_XATTRS")
+}
+
+// LookupFileOwner finds user by name or uid. Empty name means no override.
+func LookupFileOwner(owner string) (*FileOwner, error) {
+	if owner == "" {
+		return nil, nil
+	}
+	ownerUser, err := user.Lookup(owner)
+	if err != nil {
+		ownerUser, err = user.LookupId(owner)
+		if err != nil {
+			return nil, NewUnknownOwnerError(owner, err)
+		}
+	}
+	uid, err := strconv.Atoi(ownerUser.Uid)
+	if err != nil {
+		return nil, NewUnknownOwnerError(owner, err)
+	}
+	gid, err := strconv.Atoi(ownerUser.Gid)
+	if err != nil {
+		return nil, NewUnknownOwnerError(owner, err)
+	}
+	return &FileOwner{uid, gid}, nil
+}
+
+// addXattrsToHeader stores extended attributes of the file in tar header
+func addXattrsToHeader(header *tar.Header, path string) error {
+	xattrs, err := readXattrs(path)
+	if err != nil {
+		return err
+	}
+	for name, value := range xattrs {
+		if header.PAXRecords == nil {
+			header.PAXRecords = make(map[string]string)
+		}
+		header.PAXRecords[xattrPAXPrefix+name] = value
+	}
+	return nil
+}
+
+// applyFileAttributes restores ownership, extended attributes and modification time of restored file.
+// Ownership from the backup is restored only when running as root, owner overrides it.
+func applyFileAttributes(targetPath string, header *tar.Header, owner *FileOwner) error {
+	isSymlink := header.Typeflag == tar.TypeSymlink
+	if owner != nil {
+		if err := os.Lchown(targetPath, owner.Uid, owner.Gid); err != nil {
+			return errors.Wrapf(err, "failed to chown '%s'", targetPath)
+		}
+	} else if os.Geteuid() == 0 {
+		if err := os.Lchown(targetPath, header.Uid, header.Gid); err != nil {
+			return errors.Wrapf(err, "failed to chown '%s'", targetPath)
+		}
+	}
+
+	if !isSymlink {
+		for key, value := range header.PAXRecords {
+			if !strings.HasPrefix(key, xattrPAXPrefix) {
+				continue
+			}
+			name := strings.TrimPrefix(key, xattrPAXPrefix)
+			if err := writeXattr(targetPath, name, value); err != nil {
+				tracelog.WarningLogger.Printf("Failed to restore extended attribute '%s' of '%s': %v\n", name, targetPath, err)
+			}
+		}
+	}
+
+	// Directory modification times are changed by files restored later, so they are not restored
+	isRegular := header.Typeflag == tar.TypeReg || header.Typeflag == tar.TypeRegA
+	if isRegular && !header.ModTime.IsZero() {
+		if err := os.Chtimes(targetPath, header.ModTime, header.ModTime); err != nil {
+			return errors.Wrapf(err, "failed to set modification time of '%s'", targetPath)
+		}
+	}
+	return nil
+}
@@ -4,10 +4,18 @@ import (
 	"io"
 )
 
-type Lz4Compressor struct{}
+const (
+	// DefaultLz4CompressionLevel is the fast mode, higher levels are depth of match search of high compression mode
+	DefaultLz4CompressionLevel = 0
+	MaxLz4CompressionLevel     = 16
+)
+
+type Lz4Compressor struct {
+	Level int
+}
 
 func (compressor Lz4Compressor) NewWriter(writer io.Writer) ReaderFromWriteCloser {
-	return NewLz4ReaderFromWriter(writer)
+	return NewLz4ReaderFromWriter(writer, compressor.Level)
 }
 
 func (compressor Lz4Compressor) FileExtension() string {
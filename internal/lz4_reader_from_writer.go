@@ -9,8 +9,9 @@ type Lz4ReaderFromWriter struct {
 	lz4.Writer
 }
 
-func NewLz4ReaderFromWriter(dst io.Writer) *Lz4ReaderFromWriter {
+func NewLz4ReaderFromWriter(dst io.Writer, level int) *Lz4ReaderFromWriter {
 	lzWriter := lz4.NewWriter(dst)
+	lzWriter.Header.CompressionLevel = level
 	return &Lz4ReaderFromWriter{*lzWriter}
 }
 
@@ -2,10 +2,17 @@ package internal
 
 import "io"
 
-type LzmaCompressor struct{}
+const (
+	DefaultLzmaCompressionLevel = 6
+	MaxLzmaCompressionLevel     = 9
+)
+
+type LzmaCompressor struct {
+	Level int
+}
 
 func (compressor LzmaCompressor) NewWriter(writer io.Writer) ReaderFromWriteCloser {
-	lzmaWriter, err := NewLzmaReaderFromWriter(writer)
+	lzmaWriter, err := NewLzmaReaderFromWriter(writer, compressor.Level)
 	if err != nil {
 		panic(err.Error())
 	}
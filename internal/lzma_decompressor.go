@@ -1,6 +1,7 @@
 package internal
 
 import (
+	"bufio"
 	"github.com/pkg/errors"
 	"github.com/ulikunitz/xz/lzma"
 	"io"
@@ -8,13 +9,27 @@ import (
 
 type LzmaDecompressor struct{}
 
+// Decompress reads concatenated lzma streams, which are written by ParallelCompressor
 func (decompressor LzmaDecompressor) Decompress(dst io.Writer, src io.Reader) error {
-	lzReader, err := lzma.NewReader(NewUntilEofReader(src))
-	if err != nil {
-		return errors.Wrap(err, "DecompressLzma: lzma reader creation failed")
+	// bufio.Reader is io.ByteReader, so lzma reader does not read beyond the end of its stream
+	source := bufio.NewReader(NewUntilEofReader(src))
+	for {
+		lzReader, err := lzma.NewReader(source)
+		if err != nil {
+			return errors.Wrap(err, "DecompressLzma: lzma reader creation failed")
+		}
+		_, err = FastCopy(dst, lzReader)
+		if err != nil {
+			return errors.Wrap(err, "DecompressLzma: lzma write failed")
+		}
+		_, err = source.Peek(1)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return errors.Wrap(err, "DecompressLzma: lzma read failed")
+		}
 	}
-	_, err = FastCopy(dst, lzReader)
-	return errors.Wrap(err, "DecompressLzma: lzma write failed")
 }
 
 func (decompressor LzmaDecompressor) FileExtension() string {
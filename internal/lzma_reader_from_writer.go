@@ -9,8 +9,13 @@ type LzmaReaderFromWriter struct {
 	lzma.Writer
 }
 
-func NewLzmaReaderFromWriter(dst io.Writer) (*LzmaReaderFromWriter, error) {
-	lzmaWriter, err := lzma.NewWriter(dst)
+// lzmaDictionaryCapacities are dictionary sizes of compression levels, as in presets of xz
+var lzmaDictionaryCapacities = [MaxLzmaCompressionLevel + 1]int{
+	256 << 10, 1 << 20, 2 << 20, 4 << 20, 4 << 20, 8 << 20, 8 << 20, 16 << 20, 32 << 20, 64 << 20,
+}
+
+func NewLzmaReaderFromWriter(dst io.Writer, level int) (*LzmaReaderFromWriter, error) {
+	lzmaWriter, err := lzma.WriterConfig{DictCap: lzmaDictionaryCapacities[level]}.NewWriter(dst)
 	if err != nil {
 		return nil, err
 	}
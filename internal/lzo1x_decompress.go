@@ -0,0 +1,171 @@
+package internal
+
+import "github.com/pkg/errors"
+
+var lzo1xCorruptedError = errors.New("lzo1x: compressed data is corrupted")
+
+// lzo1xState is position of compressed data and decompressed output
+type lzo1xState struct {
+	input  []byte
+	output []byte
+}
+
+func (state *lzo1xState) readByte() (int, error) {
+	if len(state.input) == 0 {
+		return 0, lzo1xCorruptedError
+	}
+	value := state.input[0]
+	state.input = state.input[1:]
+	return int(value), nil
+}
+
+// readLength reads length, which is continued in zero bytes, each adding 255
+func (state *lzo1xState) readLength(base int) (int, error) {
+	length := 0
+	for {
+		value, err := state.readByte()
+		if err != nil {
+			return 0, err
+		}
+		if value != 0 {
+			return length + base + value, nil
+		}
+		length += 255
+	}
+}
+
+func (state *lzo1xState) copyLiterals(count int) error {
+	if count > len(state.input) || len(state.output)+count > cap(state.output) {
+		return lzo1xCorruptedError
+	}
+	state.output = append(state.output, state.input[:count]...)
+	state.input = state.input[count:]
+	return nil
+}
+
+// copyMatch copies bytes one by one, because match may overlap with its own output
+func (state *lzo1xState) copyMatch(distance, count int) error {
+	position := len(state.output) - distance
+	if position < 0 || len(state.output)+count > cap(state.output) {
+		return lzo1xCorruptedError
+	}
+	for i := 0; i < count; i++ {
+		state.output = append(state.output, state.output[position+i])
+	}
+	return nil
+}
+
+// readDistance reads little endian distance of long match, its low bits are the number of literals after match
+func (state *lzo1xState) readDistance() (distance int, trailingLiterals int, err error) {
+	if len(state.input) < 2 {
+		return 0, 0, lzo1xCorruptedError
+	}
+	value := int(state.input[0]) | int(state.input[1])<<8
+	state.input = state.input[2:]
+	return value >> 2, value & 3, nil
+}
+
+// DecompressLzo1x decompresses block of LZO1X algorithm, which is used by lzop.
+// It follows lzo1x_decompress_safe of liblzo2.
+func DecompressLzo1x(input []byte, decompressedSize int) ([]byte, error) {
+	state := &lzo1xState{input: input, output: make([]byte, 0, decompressedSize)}
+	// literalState is the number of literals after the last match, 4 means long literal run
+	literalState := 0
+	if len(input) > 0 && input[0] > 17 {
+		state.input = input[1:]
+		count := int(input[0]) - 17
+		err := state.copyLiterals(count)
+		if err != nil {
+			return nil, err
+		}
+		literalState = 4
+		if count < 4 {
+			literalState = count
+		}
+	}
+	for {
+		instruction, err := state.readByte()
+		if err != nil {
+			return nil, err
+		}
+		var distance, count, trailingLiterals int
+		switch {
+		case instruction < 16 && literalState == 0:
+			count = instruction + 3
+			if instruction == 0 {
+				count, err = state.readLength(18)
+				if err != nil {
+					return nil, err
+				}
+			}
+			err = state.copyLiterals(count)
+			if err != nil {
+				return nil, err
+			}
+			literalState = 4
+			continue
+		case instruction < 16:
+			next, err := state.readByte()
+			if err != nil {
+				return nil, err
+			}
+			distance = 1 + instruction>>2 + next<<2
+			trailingLiterals = instruction & 3
+			count = 2
+			if literalState == 4 {
+				distance += 0x800
+				count = 3
+			}
+		case instruction >= 64:
+			next, err := state.readByte()
+			if err != nil {
+				return nil, err
+			}
+			distance = 1 + (instruction>>2)&7 + next<<3
+			trailingLiterals = instruction & 3
+			count = instruction>>5 + 1
+		case instruction >= 32:
+			count = instruction&31 + 2
+			if count == 2 {
+				count, err = state.readLength(33)
+				if err != nil {
+					return nil, err
+				}
+			}
+			distance, trailingLiterals, err = state.readDistance()
+			if err != nil {
+				return nil, err
+			}
+			distance++
+		default:
+			count = instruction&7 + 2
+			if count == 2 {
+				count, err = state.readLength(9)
+				if err != nil {
+					return nil, err
+				}
+			}
+			farDistance := (instruction & 8) << 11
+			distance, trailingLiterals, err = state.readDistance()
+			if err != nil {
+				return nil, err
+			}
+			if farDistance == 0 && distance == 0 {
+				if count != 3 || len(state.input) != 0 || len(state.output) != decompressedSize {
+					return nil, lzo1xCorruptedError
+				}
+				return state.output, nil
+			}
+			distance += farDistance + 0x4000
+		}
+		err = state.copyMatch(distance, count)
+		if err != nil {
+			return nil, err
+		}
+		err = state.copyLiterals(trailingLiterals)
+		if err != nil {
+			return nil, err
+		}
+		literalState = trailingLiterals
+	}
+}
@@ -6,10 +6,11 @@ import (
 
 const LzopBlockSize = 256 * 1024
 
+// LzoDecompressor decompresses lzop files of WAL-E
 type LzoDecompressor struct{}
 
 func (decompressor LzoDecompressor) Decompress(dst io.Writer, src io.Reader) error {
-	lzor, err := NewLzoReader(src)
+	lzor, err := NewLzopReader(src)
 	if err != nil {
 		return err
 	}
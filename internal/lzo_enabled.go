@@ -7,14 +7,6 @@ import (
 	"io"
 )
 
-func NewLzoReader(r io.Reader) (io.ReadCloser, error) {
-	return lzo.NewReader(r)
-}
-
 func NewLzoWriter(w io.Writer) io.WriteCloser {
 	return lzo.NewWriter(w)
 }
-
-func init() {
-	Decompressors = append(Decompressors, LzoDecompressor{})
-}
@@ -0,0 +1,250 @@
+package internal
+
+import (
+	"bytes"
+	"encoding/binary"
+	"github.com/pkg/errors"
+	"hash"
+	"hash/adler32"
+	"hash/crc32"
+	"io"
+)
+
+// lzop file format constants, see lzop sources
+var lzopMagic = []byte{0x89, 'L', 'Z', 'O', 0x00, 0x0D, 0x0A, 0x1A, 0x0A}
+
+const (
+	lzopAdler32D     = 0x00000001
+	lzopAdler32C     = 0x00000002
+	lzopExtraField   = 0x00000040
+	lzopCrc32D       = 0x00000100
+	lzopCrc32C       = 0x00000200
+	lzopFilter       = 0x00000800
+	lzopHeaderCrc32  = 0x00001000
+	lzopNewVersion   = 0x0940
+	lzopMaxBlockSize = 64 << 20
+)
+
+// LzopReader decompresses lzop format, which is used by WAL-E, without cgo
+type LzopReader struct {
+	source       io.Reader
+	flags        uint32
+	block        []byte
+	decompressed []byte
+	eof          bool
+}
+
+// NewLzopReader reads lzop header of source
+func NewLzopReader(source io.Reader) (*LzopReader, error) {
+	reader := &LzopReader{source: source}
+	err := reader.readHeader()
+	if err != nil {
+		return nil, err
+	}
+	return reader, nil
+}
+
+func (reader *LzopReader) Read(p []byte) (n int, err error) {
+	for len(reader.decompressed) == 0 {
+		if reader.eof {
+			return 0, io.EOF
+		}
+		err = reader.readBlock()
+		if err != nil {
+			return 0, err
+		}
+	}
+	n = copy(p, reader.decompressed)
+	reader.decompressed = reader.decompressed[n:]
+	return n, nil
+}
+
+func (reader *LzopReader) Close() error {
+	return nil
+}
+
+func (reader *LzopReader) readHeader() error {
+	magic := make([]byte, len(lzopMagic))
+	_, err := io.ReadFull(reader.source, magic)
+	if err != nil {
+		return errors.Wrap(err, "lzop: failed to read magic")
+	}
+	if !bytes.Equal(magic, lzopMagic) {
+		return errors.New("lzop: invalid magic")
+	}
+
+	// header is read through buffer, because its checksum is checked
+	var header bytes.Buffer
+	headerReader := io.TeeReader(reader.source, &header)
+	var version, libraryVersion, versionNeeded uint16
+	var method, level uint8
+	err = readBigEndian(headerReader, &version, &libraryVersion)
+	if err != nil {
+		return err
+	}
+	if version >= lzopNewVersion {
+		err = readBigEndian(headerReader, &versionNeeded)
+		if err != nil {
+			return err
+		}
+	}
+	err = readBigEndian(headerReader, &method)
+	if err != nil {
+		return err
+	}
+	if version >= lzopNewVersion {
+		err = readBigEndian(headerReader, &level)
+		if err != nil {
+			return err
+		}
+	}
+	err = readBigEndian(headerReader, &reader.flags)
+	if err != nil {
+		return err
+	}
+	if reader.flags&lzopFilter != 0 {
+		return errors.New("lzop: filters are not supported")
+	}
+	var mode, mtimeLow, mtimeHigh uint32
+	err = readBigEndian(headerReader, &mode, &mtimeLow)
+	if err != nil {
+		return err
+	}
+	if version >= lzopNewVersion {
+		err = readBigEndian(headerReader, &mtimeHigh)
+		if err != nil {
+			return err
+		}
+	}
+	var nameLength uint8
+	err = readBigEndian(headerReader, &nameLength)
+	if err != nil {
+		return err
+	}
+	_, err = io.ReadFull(headerReader, make([]byte, nameLength))
+	if err != nil {
+		return errors.Wrap(err, "lzop: failed to read header")
+	}
+
+	headerChecksum := reader.newChecksum(lzopHeaderCrc32)
+	headerChecksum.Write(header.Bytes())
+	err = reader.checkChecksum(headerChecksum, "header")
+	if err != nil {
+		return err
+	}
+
+	if reader.flags&lzopExtraField != 0 {
+		var extraFieldLength uint32
+		err = readBigEndian(reader.source, &extraFieldLength)
+		if err != nil {
+			return err
+		}
+		_, err = io.CopyN(&bytes.Buffer{}, reader.source, int64(extraFieldLength)+4)
+		if err != nil {
+			return errors.Wrap(err, "lzop: failed to read extra field")
+		}
+	}
+	return nil
+}
+
+// readBlock decompresses the next block, blocks are compressed independently
+func (reader *LzopReader) readBlock() error {
+	var decompressedSize, compressedSize uint32
+	err := readBigEndian(reader.source, &decompressedSize)
+	if err != nil {
+		return err
+	}
+	if decompressedSize == 0 {
+		reader.eof = true
+		return nil
+	}
+	err = readBigEndian(reader.source, &compressedSize)
+	if err != nil {
+		return err
+	}
+	if decompressedSize > lzopMaxBlockSize || compressedSize > decompressedSize {
+		return errors.Errorf("lzop: invalid block of %d bytes compressed to %d bytes", decompressedSize, compressedSize)
+	}
+	var decompressedAdler32, decompressedCrc32, compressedAdler32, compressedCrc32 uint32
+	checksums := []struct {
+		flag     uint32
+		checksum *uint32
+	}{
+		{lzopAdler32D, &decompressedAdler32},
+		{lzopCrc32D, &decompressedCrc32},
+		{lzopAdler32C, &compressedAdler32},
+		{lzopCrc32C, &compressedCrc32},
+	}
+	for i, checksum := range checksums {
+		// checksums of compressed data are not stored for blocks, which are not compressed
+		if reader.flags&checksum.flag != 0 && (i < 2 || compressedSize < decompressedSize) {
+			err = readBigEndian(reader.source, checksum.checksum)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	if cap(reader.block) < int(compressedSize) {
+		reader.block = make([]byte, compressedSize)
+	}
+	block := reader.block[:compressedSize]
+	_, err = io.ReadFull(reader.source, block)
+	if err != nil {
+		return errors.Wrap(err, "lzop: failed to read block")
+	}
+	if compressedSize < decompressedSize {
+		err = checkBlockChecksums(block, compressedAdler32, compressedCrc32, reader.flags&lzopAdler32C != 0,
+			reader.flags&lzopCrc32C != 0, "compressed block")
+		if err != nil {
+			return err
+		}
+		reader.decompressed, err = DecompressLzo1x(block, int(decompressedSize))
+		if err != nil {
+			return err
+		}
+	} else {
+		reader.decompressed = block
+	}
+	return checkBlockChecksums(reader.decompressed, decompressedAdler32, decompressedCrc32,
+		reader.flags&lzopAdler32D != 0, reader.flags&lzopCrc32D != 0, "block")
+}
+
+func (reader *LzopReader) newChecksum(crc32Flag uint32) hash.Hash32 {
+	if reader.flags&crc32Flag != 0 {
+		return crc32.NewIEEE()
+	}
+	return adler32.New()
+}
+
+func (reader *LzopReader) checkChecksum(checksum hash.Hash32, name string) error {
+	var expected uint32
+	err := readBigEndian(reader.source, &expected)
+	if err != nil {
+		return err
+	}
+	if checksum.Sum32() != expected {
+		return errors.Errorf("lzop: %s checksum mismatch", name)
+	}
+	return nil
+}
+
+func checkBlockChecksums(data []byte, expectedAdler32, expectedCrc32 uint32, useAdler32, useCrc32 bool, name string) error {
+	if useAdler32 && adler32.Checksum(data) != expectedAdler32 {
+		return errors.Errorf("lzop: %s adler32 checksum mismatch", name)
+	}
+	if useCrc32 && crc32.ChecksumIEEE(data) != expectedCrc32 {
+		return errors.Errorf("lzop: %s crc32 checksum mismatch", name)
+	}
+	return nil
+}
+
+func readBigEndian(source io.Reader, values ...interface{}) error {
+	for _, value := range values {
+		err := binary.Read(source, binary.BigEndian, value)
+		if err != nil {
+			return errors.Wrap(err, "lzop: failed to read")
+		}
+	}
+	return nil
+}
@@ -10,6 +10,7 @@ import (
 type MD5Reader struct {
 	internal io.Reader
 	md5      hash.Hash
+	size     int64
 }
 
 func newMd5Reader(reader io.Reader) *MD5Reader {
@@ -18,10 +19,11 @@ func newMd5Reader(reader io.Reader) *MD5Reader {
 
 func (reader *MD5Reader) Read(p []byte) (n int, err error) {
 	n, err = reader.internal.Read(p)
-	if err != nil {
-		return
+	reader.size += int64(n)
+	_, hashErr := reader.md5.Write(p[:n])
+	if err == nil {
+		err = hashErr
 	}
-	_, err = reader.md5.Write(p[:n])
 	return
 }
 
@@ -29,3 +31,8 @@ func (reader *MD5Reader) Sum() string {
 	bytes := reader.md5.Sum(nil)
 	return hex.EncodeToString(bytes)
 }
+
+// Size returns count of bytes read so far
+func (reader *MD5Reader) Size() int64 {
+	return reader.size
+}
@@ -23,6 +23,7 @@ func (tarBall *NOPTarBall) Size() int64            { return tarBall.size }
 func (tarBall *NOPTarBall) AddSize(i int64)        { tarBall.size += i }
 func (tarBall *NOPTarBall) TarWriter() *tar.Writer { return tarBall.tarWriter }
 func (tarBall *NOPTarBall) AwaitUploads()          {}
+func (tarBall *NOPTarBall) Name() string           { return "" }
 
 // NOPTarBallMaker creates a new NOPTarBall. Used
 // for testing purposes.
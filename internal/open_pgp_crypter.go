@@ -7,6 +7,7 @@ import (
 	"github.com/x4m/wal-g/internal/tracelog"
 	"golang.org/x/crypto/openpgp"
 	"io"
+	"sync"
 )
 
 // CrypterUseMischiefError happens when crypter is used before initialization
@@ -32,6 +33,10 @@ type OpenPGPCrypter struct {
 
 	PubKey    openpgp.EntityList
 	SecretKey openpgp.EntityList
+
+	// crypter is shared by concurrent uploads, so it is configured once and keys are loaded under mutex
+	configureOnce sync.Once
+	keyMutex      sync.Mutex
 }
 
 func (crypter *OpenPGPCrypter) IsArmed() bool {
@@ -41,9 +46,11 @@ func (crypter *OpenPGPCrypter) IsArmed() bool {
 // IsUsed is to check necessity of Crypter use
 // Must be called prior to any other crypter call
 func (crypter *OpenPGPCrypter) IsUsed() bool {
-	if !crypter.Configured {
-		crypter.ConfigureGPGCrypter()
-	}
+	crypter.configureOnce.Do(func() {
+		if !crypter.Configured {
+			crypter.ConfigureGPGCrypter()
+		}
+	})
 	return crypter.IsArmed()
 }
 
@@ -58,27 +65,38 @@ func (crypter *OpenPGPCrypter) Encrypt(writer io.WriteCloser) (io.WriteCloser, e
 	if !crypter.Configured {
 		return nil, NewCrypterUseMischiefError()
 	}
-	if crypter.PubKey == nil {
-		armour, err := getPubRingArmour(crypter.KeyRingId)
-		if err != nil {
-			return nil, err
-		}
-
-		entitylist, err := openpgp.ReadArmoredKeyRing(bytes.NewReader(armour))
-		if err != nil {
-			return nil, err
-		}
-		crypter.PubKey = entitylist
+	err := crypter.loadPubKey()
+	if err != nil {
+		return nil, err
 	}
 
 	return &DelayWriteCloser{writer, crypter.PubKey, nil}, nil
 }
 
-// Decrypt creates decrypted reader from ordinary reader
-func (crypter *OpenPGPCrypter) Decrypt(reader io.ReadCloser) (io.Reader, error) {
-	if !crypter.Configured {
-		return nil, NewCrypterUseMischiefError()
+// loadPubKey reads public key once, so it can be loaded before concurrent use of crypter
+func (crypter *OpenPGPCrypter) loadPubKey() error {
+	crypter.keyMutex.Lock()
+	defer crypter.keyMutex.Unlock()
+	if crypter.PubKey != nil {
+		return nil
+	}
+	armour, err := getPubRingArmour(crypter.KeyRingId)
+	if err != nil {
+		return err
+	}
+
+	entitylist, err := openpgp.ReadArmoredKeyRing(bytes.NewReader(armour))
+	if err != nil {
+		return err
 	}
+	crypter.PubKey = entitylist
+	return nil
+}
+
+// loadSecretKey reads secret key once
+func (crypter *OpenPGPCrypter) loadSecretKey() (openpgp.EntityList, error) {
+	crypter.keyMutex.Lock()
+	defer crypter.keyMutex.Unlock()
 	if crypter.SecretKey == nil {
 		armour, err := getSecretRingArmour(crypter.KeyRingId)
 		if err != nil {
@@ -91,8 +109,20 @@ func (crypter *OpenPGPCrypter) Decrypt(reader io.ReadCloser) (io.Reader, error)
 		}
 		crypter.SecretKey = entitylist
 	}
+	return crypter.SecretKey, nil
+}
+
+// Decrypt creates decrypted reader from ordinary reader
+func (crypter *OpenPGPCrypter) Decrypt(reader io.ReadCloser) (io.Reader, error) {
+	if !crypter.Configured {
+		return nil, NewCrypterUseMischiefError()
+	}
+	secretKey, err := crypter.loadSecretKey()
+	if err != nil {
+		return nil, err
+	}
 
-	var md, err0 = openpgp.ReadMessage(reader, crypter.SecretKey, nil, nil)
+	var md, err0 = openpgp.ReadMessage(reader, secretKey, nil, nil)
 	if err0 != nil {
 		return nil, err0
 	}
@@ -0,0 +1,482 @@
+package internal
+
+import (
+	"archive/tar"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"github.com/jackc/pgx"
+	"github.com/pkg/errors"
+	"github.com/x4m/wal-g/internal/tracelog"
+	"github.com/x4m/wal-g/internal/walparser"
+	"io"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+)
+
+const (
+	PageRepairRelFileNodeFlag = "--relfilenode"
+	PageRepairBlockFlag       = "--block"
+	PageRepairTargetLsnFlag   = "--target-lsn"
+	PageRepairBackupFlag      = "--backup"
+	PageRepairOutputFlag      = "--output"
+	PageRepairJsonFlag        = "--json"
+
+	pageRepairSourceBackup        = "backup"
+	pageRepairSourceFullPageImage = "full page image"
+)
+
+type NoBackupBeforeLsnError struct {
+	error
+}
+
+func NewNoBackupBeforeLsnError(lsn uint64) NoBackupBeforeLsnError {
+	return NoBackupBeforeLsnError{errors.Errorf("No backup is consistent at or before LSN %s", formatLsn(lsn))}
+}
+
+func (err NoBackupBeforeLsnError) Error() string {
+	return fmt.Sprintf(tracelog.GetErrorFormatter(), err.error)
+}
+
+type BlockNotInBackupError struct {
+	error
+}
+
+func NewBlockNotInBackupError(location walparser.BlockLocation, backupName string) BlockNotInBackupError {
+	return BlockNotInBackupError{errors.Errorf("Block %d of %s is not found in backup '%s'",
+		location.BlockNo, formatRelFileNode(location.RelationFileNode), backupName)}
+}
+
+func (err BlockNotInBackupError) Error() string {
+	return fmt.Sprintf(tracelog.GetErrorFormatter(), err.error)
+}
+
+// PageRepairCommandArguments are arguments of page-repair, empty backup name means the latest backup
+// consistent at target LSN, empty output means the default file name
+type PageRepairCommandArguments struct {
+	Location   walparser.BlockLocation
+	TargetLsn  uint64
+	BackupName string
+	Output     string
+	Json       bool
+}
+
+func parseRelFileNode(value string) (walparser.RelFileNode, error) {
+	parts := strings.Split(value, "/")
+	oids := make([]walparser.Oid, len(parts))
+	for i, part := range parts {
+		oid, err := strconv.ParseUint(part, 10, 32)
+		if err != nil {
+			return walparser.RelFileNode{}, errors.Errorf("invalid relfilenode '%s', expected spc/db/rel", value)
+		}
+		oids[i] = walparser.Oid(oid)
+	}
+	if len(oids) != 3 {
+		return walparser.RelFileNode{}, errors.Errorf("invalid relfilenode '%s', expected spc/db/rel", value)
+	}
+	return walparser.RelFileNode{SpcNode: oids[0], DBNode: oids[1], RelNode: oids[2]}, nil
+}
+
+// ParsePageRepairArguments parses "--relfilenode spc/db/rel --block N --target-lsn X [--backup name] [--output path] [--json]"
+func ParsePageRepairArguments(args []string) (PageRepairCommandArguments, error) {
+	result := PageRepairCommandArguments{}
+	specified := make(map[string]bool)
+	for i := 0; i < len(args); i++ {
+		flag := args[i]
+		if flag == PageRepairJsonFlag {
+			result.Json = true
+			continue
+		}
+		if i+1 == len(args) {
+			return result, errors.Errorf("%s value is not specified", flag)
+		}
+		i++
+		value := args[i]
+		var err error
+		switch flag {
+		case PageRepairRelFileNodeFlag:
+			result.Location.RelationFileNode, err = parseRelFileNode(value)
+		case PageRepairBlockFlag:
+			var blockNo uint64
+			blockNo, err = strconv.ParseUint(value, 10, 32)
+			result.Location.BlockNo = uint32(blockNo)
+		case PageRepairTargetLsnFlag:
+			result.TargetLsn, err = pgx.ParseLSN(value)
+		case PageRepairBackupFlag:
+			result.BackupName = value
+		case PageRepairOutputFlag:
+			result.Output = value
+		default:
+			return result, errors.Errorf("unexpected argument '%s'", flag)
+		}
+		if err != nil {
+			return result, errors.Wrapf(err, "invalid %s value '%s'", flag, value)
+		}
+		specified[flag] = true
+	}
+	for _, flag := range []string{PageRepairRelFileNodeFlag, PageRepairBlockFlag, PageRepairTargetLsnFlag} {
+		if !specified[flag] {
+			return result, errors.Errorf("%s is not specified", flag)
+		}
+	}
+	if result.Output == "" {
+		relFileNode := result.Location.RelationFileNode
+		result.Output = fmt.Sprintf("%d_%d_%d_%d.page", relFileNode.SpcNode, relFileNode.DBNode, relFileNode.RelNode, result.Location.BlockNo)
+	}
+	return result, nil
+}
+
+// PageRepairResult tells where the page came from. If the block was changed after its last full page image
+// or after the backup, the page is not up to date and redo of the block is needed from RedoFromLsn.
+type PageRepairResult struct {
+	BackupName  string
+	Timeline    uint32
+	TargetLsn   string
+	Source      string
+	SourceLsn   string
+	PageLsn     string
+	RedoFromLsn string `json:",omitempty"`
+	RedoRecords int    `json:",omitempty"`
+	Output      string
+	Page        []byte `json:"-"`
+}
+
+func (result *PageRepairResult) WriteText(writer io.Writer) error {
+	_, err := fmt.Fprintf(writer, "Page is taken from %s at %s of backup '%s' on timeline %d, page LSN is %s\n",
+		result.Source, result.SourceLsn, result.BackupName, result.Timeline, result.PageLsn)
+	if err != nil {
+		return err
+	}
+	if result.RedoRecords == 0 {
+		_, err = fmt.Fprintf(writer, "Page is up to date at %s and written to %s\n", result.TargetLsn, result.Output)
+		return err
+	}
+	_, err = fmt.Fprintf(writer, "Page is written to %s, but %d records from %s up to %s should still be redone for the block\n",
+		result.Output, result.RedoRecords, result.RedoFromLsn, result.TargetLsn)
+	return err
+}
+
+// relFilePath returns name of the file of relation segment in backup, tablespace directory of
+// non default tablespace depends on postgres version, so it is looked up among backup files
+func relFilePath(files BackupFileList, relFileNode walparser.RelFileNode, relFileId uint32) (string, bool) {
+	name := strconv.FormatUint(uint64(relFileNode.RelNode), 10)
+	if relFileId > 0 {
+		name += "." + strconv.FormatUint(uint64(relFileId), 10)
+	}
+	switch relFileNode.SpcNode {
+	case DefaultSpcNode:
+		path := fmt.Sprintf("/%s/%d/%s", DefaultTablespace, relFileNode.DBNode, name)
+		_, ok := files[path]
+		return path, ok
+	case GlobalSpcNode:
+		path := fmt.Sprintf("/%s/%s", GlobalTablespace, name)
+		_, ok := files[path]
+		return path, ok
+	}
+	prefix := fmt.Sprintf("/%s/%d/", NonDefaultTablespace, relFileNode.SpcNode)
+	suffix := fmt.Sprintf("/%d/%s", relFileNode.DBNode, name)
+	for path := range files {
+		if strings.HasPrefix(path, prefix) && strings.HasSuffix(path, suffix) &&
+			strings.Count(strings.TrimPrefix(path, prefix), "/") == 2 {
+			return path, true
+		}
+	}
+	return "", false
+}
+
+// readTarPartFile calls readFile for fileName in tar part, the rest of the part is read too,
+// so that the part is checked against sentinel
+func readTarPartFile(backup *Backup, sentinel BackupSentinelDto, tarName string, fileName string,
+	readFile func(io.Reader) error) (found bool, err error) {
+	reader, writer := io.Pipe()
+	defer reader.Close()
+	go func() {
+		var crypter OpenPGPCrypter
+		err := DecryptAndDecompressTar(&EmptyWriteIgnorer{writer}, backup.newTarPartReaderMaker(sentinel, tarName), &crypter)
+		writer.CloseWithError(err)
+	}()
+	tarReader := tar.NewReader(reader)
+	for {
+		header, err := tarReader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return false, errors.Wrapf(err, "failed to read tar part '%s'", tarName)
+		}
+		if header.Name == fileName {
+			found = true
+			if err = readFile(tarReader); err != nil {
+				return true, errors.Wrapf(err, "failed to read '%s' from tar part '%s'", fileName, tarName)
+			}
+		}
+	}
+	_, err = io.Copy(ioutil.Discard, reader)
+	return found, errors.Wrapf(err, "failed to read tar part '%s'", tarName)
+}
+
+// readPageFromFile reads page of the block from regular file or increment,
+// nil page is returned if increment does not contain the block
+func readPageFromFile(reader io.Reader, blockNo uint32, isIncremented bool) (page []byte, beyondEnd bool, err error) {
+	page = make([]byte, DatabasePageSize)
+	if !isIncremented {
+		_, err = io.CopyN(ioutil.Discard, reader, int64(blockNo)*int64(DatabasePageSize))
+		if err == nil {
+			_, err = io.ReadFull(reader, page)
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return nil, true, nil
+		}
+		return page, false, err
+	}
+	fileSize, diffBlockCount, diffMap, err := readIncrementDiffMap(reader)
+	if err != nil {
+		return nil, false, err
+	}
+	if uint64(blockNo+1)*uint64(DatabasePageSize) > fileSize {
+		return nil, true, nil
+	}
+	for i := uint32(0); i < diffBlockCount; i++ {
+		_, err = io.ReadFull(reader, page)
+		if err != nil {
+			return nil, false, err
+		}
+		if binary.LittleEndian.Uint32(diffMap[i*sizeofInt32:(i+1)*sizeofInt32]) == blockNo {
+			return page, false, nil
+		}
+	}
+	return nil, false, nil
+}
+
+// fetchBackupPage reads page of the block from backup. Only the tar part containing the block
+// is downloaded, base backups are read if the file is skipped or the block is not in increment.
+// BlockNotInBackupError is returned for blocks of files, which were missing or shorter at backup time.
+func fetchBackupPage(folder StorageFolder, backupName string, location walparser.BlockLocation) ([]byte, error) {
+	relFileId := location.BlockNo / uint32(BlocksInRelFile)
+	blockNo := location.BlockNo % uint32(BlocksInRelFile)
+	for name := backupName; ; {
+		backup, err := GetBackupByName(name, folder)
+		if err != nil {
+			return nil, err
+		}
+		sentinel, err := backup.fetchSentinel()
+		if err != nil {
+			return nil, err
+		}
+		path, ok := relFilePath(sentinel.Files, location.RelationFileNode, relFileId)
+		if !ok {
+			return nil, NewBlockNotInBackupError(location, backupName)
+		}
+		description := sentinel.Files[path]
+		if !description.IsSkipped {
+			tarNames := []string{description.TarPart}
+			if description.TarPart == "" {
+				// backups made before tar parts were recorded are searched through
+				tarNames, err = backup.GetCheckedTarNames(sentinel)
+				if err != nil {
+					return nil, err
+				}
+			}
+			var page []byte
+			var beyondEnd bool
+			readFile := func(reader io.Reader) error {
+				var readErr error
+				page, beyondEnd, readErr = readPageFromFile(reader, blockNo, description.IsIncremented)
+				return readErr
+			}
+			for _, tarName := range tarNames {
+				tracelog.DebugLogger.Printf("Searching %s in %s of %s\n", path, tarName, backup.Name)
+				found, err := readTarPartFile(backup, sentinel, tarName, path, readFile)
+				if err != nil {
+					return nil, err
+				}
+				if found {
+					break
+				}
+			}
+			if beyondEnd {
+				return nil, NewBlockNotInBackupError(location, backupName)
+			}
+			if page != nil {
+				return page, nil
+			}
+		}
+		if !sentinel.isIncremental() {
+			return nil, NewBlockNotInBackupError(location, backupName)
+		}
+		name = *sentinel.IncrementFrom
+	}
+}
+
+// findBackupBeforeLsn finds the latest backup, which is consistent at lsn
+func findBackupBeforeLsn(folder StorageFolder, lsn uint64) (*Backup, BackupSentinelDto, error) {
+	backups, err := getBackups(folder)
+	if err != nil {
+		return nil, BackupSentinelDto{}, err
+	}
+	for _, backupTime := range backups {
+		backup := NewBackup(folder.GetSubFolder(BaseBackupPath), backupTime.BackupName)
+		sentinel, err := backup.fetchSentinel()
+		if err != nil {
+			return nil, BackupSentinelDto{}, err
+		}
+		if sentinel.BackupFinishLSN != nil && *sentinel.BackupFinishLSN <= lsn {
+			return backup, sentinel, nil
+		}
+	}
+	return nil, BackupSentinelDto{}, NewNoBackupBeforeLsnError(lsn)
+}
+
+// applyBlockFullPageImages scans WAL records from fromLsn up to toLsn and takes the last full page image
+// of the block. Records changing the block after the image are counted, as they are still to be redone.
+func applyBlockFullPageImages(walFolder StorageFolder, timeline uint32, fromLsn uint64, toLsn uint64,
+	location walparser.BlockLocation, result *PageRepairResult) error {
+	parser := walparser.NewWalParser()
+	for logSegNo := fromLsn / WalSegmentSize; logSegNo*WalSegmentSize < toLsn; logSegNo++ {
+		walFileName := formatWALFileName(timeline, logSegNo)
+		reader, err := downloadAndDecompressWALFile(walFolder, walFileName)
+		if err != nil {
+			return errors.Wrapf(err, "failed to download '%s'", walFileName)
+		}
+		err = applyWalFileFullPageImages(parser, reader, fromLsn, toLsn, location, result)
+		reader.Close()
+		if err != nil {
+			return errors.Wrapf(err, "failed to read '%s'", walFileName)
+		}
+	}
+	return nil
+}
+
+func applyWalFileFullPageImages(parser *walparser.WalParser, walFile io.Reader, fromLsn uint64, toLsn uint64,
+	location walparser.BlockLocation, result *PageRepairResult) error {
+	pageReader := walparser.NewWalPageReader(walFile)
+	for {
+		data, err := pageReader.ReadPageData()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		_, records, err := parser.ParseRecordsFromPage(bytes.NewReader(data))
+		switch err.(type) {
+		case nil, walparser.PartialPageError, walparser.ZeroPageError:
+		default:
+			return err
+		}
+		for _, record := range records {
+			lsn := uint64(record.Lsn)
+			if lsn < fromLsn {
+				continue
+			}
+			if lsn >= toLsn {
+				return nil
+			}
+			for _, block := range record.Blocks {
+				if block.Header.BlockLocation != location || block.Header.ForkNum() != 0 {
+					continue
+				}
+				// image without BKPIMAGE_APPLY is only for wal_consistency_checking, the record is redone as usual
+				if !block.Header.HasImage() || !block.Header.ImageHeader.ApplyImage() {
+					if result.RedoRecords == 0 {
+						result.RedoFromLsn = formatLsn(lsn)
+					}
+					result.RedoRecords++
+					continue
+				}
+				page, err := block.RestorePageImage()
+				if err != nil {
+					return errors.Wrapf(err, "failed to restore image at %s", formatLsn(lsn))
+				}
+				result.Page, result.Source, result.SourceLsn = page, pageRepairSourceFullPageImage, formatLsn(lsn)
+				result.RedoFromLsn, result.RedoRecords = "", 0
+			}
+		}
+	}
+}
+
+// RepairPage reconstructs page of the block at target LSN from backup and full page images of archived WAL.
+// Page of online backup may be torn, but full_page_writes make the first change of the block after backup start
+// carry full page image, so the page is taken from the last image before target LSN, if there is one.
+func RepairPage(folder StorageFolder, arguments PageRepairCommandArguments) (*PageRepairResult, error) {
+	var backup *Backup
+	var sentinel BackupSentinelDto
+	var err error
+	if arguments.BackupName == "" {
+		backup, sentinel, err = findBackupBeforeLsn(folder, arguments.TargetLsn)
+	} else {
+		backup, err = GetBackupByName(arguments.BackupName, folder)
+		if err == nil {
+			sentinel, err = backup.fetchSentinel()
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	if sentinel.BackupStartLSN == nil {
+		return nil, errors.Errorf("backup '%s' has no start LSN", backup.Name)
+	}
+	if sentinel.BackupFinishLSN != nil && *sentinel.BackupFinishLSN > arguments.TargetLsn {
+		return nil, errors.Errorf("backup '%s' is consistent only at %s, after target LSN", backup.Name,
+			formatLsn(*sentinel.BackupFinishLSN))
+	}
+	if err = sentinel.applyPostgresSizes(); err != nil {
+		return nil, err
+	}
+	timeline, _, err := ParseWALFilename(stripWalFileName(backup.Name))
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to find timeline of backup '%s'", backup.Name)
+	}
+
+	result := &PageRepairResult{
+		BackupName: backup.Name,
+		Timeline:   timeline,
+		TargetLsn:  formatLsn(arguments.TargetLsn),
+		Source:     pageRepairSourceBackup,
+		SourceLsn:  formatLsn(*sentinel.BackupStartLSN),
+		Output:     arguments.Output,
+	}
+	// block created after backup still may be restored from full page image
+	result.Page, err = fetchBackupPage(folder, backup.Name, arguments.Location)
+	notInBackupErr, notInBackup := err.(BlockNotInBackupError)
+	if err != nil && !notInBackup {
+		return nil, err
+	}
+	err = applyBlockFullPageImages(folder.GetSubFolder(WalPath), timeline, *sentinel.BackupStartLSN,
+		arguments.TargetLsn, arguments.Location, result)
+	if err != nil {
+		return nil, err
+	}
+	if result.Page == nil {
+		return nil, notInBackupErr
+	}
+	pageHeader, err := ParsePostgresPageHeader(bytes.NewReader(result.Page))
+	if err != nil {
+		return nil, err
+	}
+	result.PageLsn = formatLsn(pageHeader.Lsn())
+	return result, nil
+}
+
+// TODO : unit tests
+// HandlePageRepair is invoked to perform wal-g page-repair
+func HandlePageRepair(folder StorageFolder, arguments PageRepairCommandArguments) {
+	result, err := RepairPage(folder, arguments)
+	if err != nil {
+		tracelog.ErrorLogger.FatalError(err)
+	}
+	err = ioutil.WriteFile(result.Output, result.Page, 0600)
+	if err != nil {
+		tracelog.ErrorLogger.FatalError(err)
+	}
+	if arguments.Json {
+		err = writeIndentedJson(os.Stdout, result)
+	} else {
+		err = result.WriteText(os.Stdout)
+	}
+	if err != nil {
+		tracelog.ErrorLogger.FatalError(err)
+	}
+}
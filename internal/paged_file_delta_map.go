@@ -14,10 +14,13 @@ import (
 
 const (
 	RelFileSizeBound               = 1 << 30
-	BlocksInRelFile                = RelFileSizeBound / int(DatabasePageSize)
 	DefaultSpcNode   walparser.Oid = 1663
+	GlobalSpcNode    walparser.Oid = 1664
 )
 
+// BlocksInRelFile depends on DatabasePageSize, see SetPostgresSizes
+var BlocksInRelFile = RelFileSizeBound / int(DatabasePageSize)
+
 type NoBitmapFoundError struct {
 	error
 }
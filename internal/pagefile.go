@@ -25,8 +25,12 @@ import (
 	"strings"
 )
 
+const DefaultDatabasePageSize = walparser.DefaultBlockSize
+
+// DatabasePageSize is BLCKSZ of the cluster, see SetPostgresSizes
+var DatabasePageSize = DefaultDatabasePageSize
+
 const (
-	DatabasePageSize            = walparser.BlockSize
 	sizeofInt32                 = 4
 	sizeofInt16                 = 2
 	sizeofInt64                 = 8
@@ -135,24 +139,7 @@ func ReadIncrementalFile(filePath string, fileSize int64, lsn uint64, deltaBitma
 // ApplyFileIncrement changes pages according to supplied change map file
 func ApplyFileIncrement(fileName string, increment io.Reader) error {
 	tracelog.DebugLogger.Printf("Incrementing %s\n", fileName)
-	err := ReadIncrementFileHeader(increment)
-	if err != nil {
-		return err
-	}
-
-	var fileSize uint64
-	var diffBlockCount uint32
-	err = parsingutil.ParseMultipleFieldsFromReader([]parsingutil.FieldToParse{
-		{Field: &fileSize, Name: "fileSize"},
-		{Field: &diffBlockCount, Name: "diffBlockCount"},
-	}, increment)
-	if err != nil {
-		return err
-	}
-
-	diffMap := make([]byte, diffBlockCount*sizeofInt32)
-
-	_, err = io.ReadFull(increment, diffMap)
+	fileSize, diffBlockCount, diffMap, err := readIncrementDiffMap(increment)
 	if err != nil {
 		return err
 	}
@@ -180,6 +167,10 @@ func ApplyFileIncrement(fileName string, increment io.Reader) error {
 			return err
 		}
 
+		err = limitDiskIO(len(page))
+		if err != nil {
+			return err
+		}
 		_, err = file.WriteAt(page, int64(blockNo)*int64(DatabasePageSize))
 		if err != nil {
 			return err
@@ -194,6 +185,26 @@ func ApplyFileIncrement(fileName string, increment io.Reader) error {
 	return nil
 }
 
+// readIncrementDiffMap reads everything from increment up to the changed page data
+func readIncrementDiffMap(increment io.Reader) (fileSize uint64, diffBlockCount uint32, diffMap []byte, err error) {
+	err = ReadIncrementFileHeader(increment)
+	if err != nil {
+		return
+	}
+
+	err = parsingutil.ParseMultipleFieldsFromReader([]parsingutil.FieldToParse{
+		{Field: &fileSize, Name: "fileSize"},
+		{Field: &diffBlockCount, Name: "diffBlockCount"},
+	}, increment)
+	if err != nil {
+		return
+	}
+
+	diffMap = make([]byte, diffBlockCount*sizeofInt32)
+	_, err = io.ReadFull(increment, diffMap)
+	return
+}
+
 func ReadIncrementFileHeader(reader io.Reader) error {
 	header := make([]byte, sizeofInt32)
 	_, err := io.ReadFull(reader, header)
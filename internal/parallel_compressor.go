@@ -0,0 +1,154 @@
+package internal
+
+import (
+	"bytes"
+	"io"
+	"sync"
+)
+
+// ParallelCompressionBlockSize is the size of input, which is compressed independently.
+// It is not smaller than lzma dictionary of default level, so that compression ratio does not suffer much.
+var ParallelCompressionBlockSize = 8 << 20
+
+// ParallelCompressor compresses one stream on several cores. Input is split into blocks, which are compressed
+// into independent lz4 or zstd frames or lzma streams. Decompressors read concatenation of them as a single stream.
+type ParallelCompressor struct {
+	Compressor
+	Concurrency int
+}
+
+func NewParallelCompressor(compressor Compressor, concurrency int) Compressor {
+	if concurrency <= 1 {
+		return compressor
+	}
+	return ParallelCompressor{compressor, concurrency}
+}
+
+func (compressor ParallelCompressor) NewWriter(writer io.Writer) ReaderFromWriteCloser {
+	parallelWriter := &parallelCompressingWriter{
+		compressor:    compressor.Compressor,
+		dst:           writer,
+		block:         make([]byte, 0, ParallelCompressionBlockSize),
+		pendingBlocks: make(chan chan compressedBlock, compressor.Concurrency-1),
+		writingDone:   make(chan struct{}),
+	}
+	go parallelWriter.writeBlocks()
+	return parallelWriter
+}
+
+type compressedBlock struct {
+	data []byte
+	err  error
+}
+
+// parallelCompressingWriter compresses each filled block on its own goroutine.
+// Blocks are written to dst in order of input by writeBlocks.
+type parallelCompressingWriter struct {
+	compressor    Compressor
+	dst           io.Writer
+	block         []byte
+	blockCount    int
+	pendingBlocks chan chan compressedBlock
+	writingDone   chan struct{}
+	errMutex      sync.Mutex
+	err           error
+	closed        bool
+}
+
+func (writer *parallelCompressingWriter) Write(p []byte) (n int, err error) {
+	for len(p) > 0 {
+		if err = writer.getError(); err != nil {
+			return
+		}
+		copied := copy(writer.block[len(writer.block):cap(writer.block)], p)
+		writer.block = writer.block[:len(writer.block)+copied]
+		p = p[copied:]
+		n += copied
+		if len(writer.block) == cap(writer.block) {
+			writer.compressBlock()
+		}
+	}
+	return n, writer.getError()
+}
+
+func (writer *parallelCompressingWriter) ReadFrom(reader io.Reader) (n int64, err error) {
+	for {
+		if err = writer.getError(); err != nil {
+			return
+		}
+		read, readErr := reader.Read(writer.block[len(writer.block):cap(writer.block)])
+		writer.block = writer.block[:len(writer.block)+read]
+		n += int64(read)
+		if len(writer.block) == cap(writer.block) {
+			writer.compressBlock()
+		}
+		if readErr == io.EOF {
+			return n, writer.getError()
+		}
+		if readErr != nil {
+			return n, readErr
+		}
+	}
+}
+
+// Close compresses the last block and waits for all blocks to be written
+func (writer *parallelCompressingWriter) Close() error {
+	if writer.closed {
+		return writer.getError()
+	}
+	writer.closed = true
+	// empty input is compressed too, so that output is decompressible
+	if len(writer.block) > 0 || writer.blockCount == 0 {
+		writer.compressBlock()
+	}
+	close(writer.pendingBlocks)
+	<-writer.writingDone
+	return writer.getError()
+}
+
+// compressBlock blocks, when Concurrency blocks are already being compressed
+func (writer *parallelCompressingWriter) compressBlock() {
+	block := writer.block
+	result := make(chan compressedBlock, 1)
+	writer.pendingBlocks <- result
+	go func() {
+		var compressed bytes.Buffer
+		compressingWriter := writer.compressor.NewWriter(&compressed)
+		_, err := compressingWriter.Write(block)
+		if err == nil {
+			err = compressingWriter.Close()
+		}
+		result <- compressedBlock{compressed.Bytes(), err}
+	}()
+	writer.block = make([]byte, 0, cap(block))
+	writer.blockCount++
+}
+
+func (writer *parallelCompressingWriter) writeBlocks() {
+	defer close(writer.writingDone)
+	for result := range writer.pendingBlocks {
+		block := <-result
+		if writer.getError() != nil {
+			continue
+		}
+		err := block.err
+		if err == nil {
+			_, err = writer.dst.Write(block.data)
+		}
+		if err != nil {
+			writer.setError(err)
+		}
+	}
+}
+
+func (writer *parallelCompressingWriter) getError() error {
+	writer.errMutex.Lock()
+	defer writer.errMutex.Unlock()
+	return writer.err
+}
+
+func (writer *parallelCompressingWriter) setError(err error) {
+	writer.errMutex.Lock()
+	defer writer.errMutex.Unlock()
+	writer.err = err
+}
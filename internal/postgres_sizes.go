@@ -0,0 +1,206 @@
+package internal
+
+import (
+	"encoding/binary"
+	"fmt"
+	"github.com/jackc/pgx"
+	"github.com/pkg/errors"
+	"github.com/x4m/wal-g/internal/tracelog"
+	"github.com/x4m/wal-g/internal/walparser"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+)
+
+const (
+	minWalSegmentSize = 1024 * 1024        // xlog_internal.h WalSegMinSize
+	maxWalSegmentSize = 1024 * 1024 * 1024 // xlog_internal.h WalSegMaxSize
+	minPageSize       = 1024
+	maxWalPageSize    = 64 * 1024
+	maxBlockSize      = 32 * 1024
+
+	// pgControlFloatFormat is FLOATFORMAT_VALUE stored in pg_control right before blcksz,
+	// relseg_size, xlog_blcksz and xlog_seg_size in all versions, see pg_control.h
+	pgControlFloatFormat = 1234567.0
+	// pgControlMaxSafeSize is PG_CONTROL_MAX_SAFE_SIZE, ControlFileData fits into it
+	pgControlMaxSafeSize = 512
+)
+
+type InvalidPostgresSizeError struct {
+	error
+}
+
+func NewInvalidPostgresSizeError(name string, size uint64) InvalidPostgresSizeError {
+	return InvalidPostgresSizeError{errors.Errorf("Unsupported %s: %d", name, size)}
+}
+
+func (err InvalidPostgresSizeError) Error() string {
+	return fmt.Sprintf(tracelog.GetErrorFormatter(), err.error)
+}
+
+type PgControlFormatError struct {
+	error
+}
+
+func NewPgControlFormatError(path string) PgControlFormatError {
+	return PgControlFormatError{errors.Errorf("Unable to find sizes in '%s'", path)}
+}
+
+func (err PgControlFormatError) Error() string {
+	return fmt.Sprintf(tracelog.GetErrorFormatter(), err.error)
+}
+
+// PostgresSizes are sizes chosen by initdb or configure, which WAL-G should know
+// to name WAL files and parse WAL and data files. Zero size is unknown.
+type PostgresSizes struct {
+	WalSegmentSize uint64
+	WalPageSize    uint32
+	BlockSize      uint32
+}
+
+func CurrentPostgresSizes() PostgresSizes {
+	return PostgresSizes{WalSegmentSize, walparser.WalPageSize, uint32(DatabasePageSize)}
+}
+
+// SetPostgresSizes validates known sizes and makes WAL-G use them. Sizes are global variables, so
+// command sets them at its start, before goroutines using them are started. Sizes equal to current are not written.
+func SetPostgresSizes(sizes PostgresSizes) error {
+	if sizes.WalSegmentSize != 0 && !isPowerOf2InRange(sizes.WalSegmentSize, minWalSegmentSize, maxWalSegmentSize) {
+		return NewInvalidPostgresSizeError("WAL segment size", sizes.WalSegmentSize)
+	}
+	if sizes.WalPageSize != 0 && !isPowerOf2InRange(uint64(sizes.WalPageSize), minPageSize, maxWalPageSize) {
+		return NewInvalidPostgresSizeError("WAL block size", uint64(sizes.WalPageSize))
+	}
+	if sizes.BlockSize != 0 && !isPowerOf2InRange(uint64(sizes.BlockSize), minPageSize, maxBlockSize) {
+		return NewInvalidPostgresSizeError("block size", uint64(sizes.BlockSize))
+	}
+
+	current := CurrentPostgresSizes()
+	if sizes.WalSegmentSize != 0 && sizes.WalSegmentSize != current.WalSegmentSize {
+		tracelog.DebugLogger.Printf("Using WAL segment size %d\n", sizes.WalSegmentSize)
+		WalSegmentSize = sizes.WalSegmentSize
+		xLogSegmentsPerXLogId = 0x100000000 / WalSegmentSize
+	}
+	if sizes.WalPageSize == 0 {
+		sizes.WalPageSize = current.WalPageSize
+	}
+	if sizes.BlockSize == 0 {
+		sizes.BlockSize = current.BlockSize
+	}
+	if sizes.BlockSize != current.BlockSize {
+		tracelog.DebugLogger.Printf("Using block size %d\n", sizes.BlockSize)
+		DatabasePageSize = uint16(sizes.BlockSize)
+		BlocksInRelFile = RelFileSizeBound / int(DatabasePageSize)
+	}
+	walparser.SetPageSizes(sizes.WalPageSize, uint16(sizes.BlockSize))
+	return nil
+}
+
+func isPowerOf2InRange(size uint64, min uint64, max uint64) bool {
+	return size >= min && size <= max && size&(size-1) == 0
+}
+
+// TODO : unit tests
+// readServerPostgresSizes makes WAL-G use sizes of the server, WAL file names depend on them.
+// It is called at backup start, before files are uploaded.
+func readServerPostgresSizes(conn *pgx.Conn) error {
+	var sizes PostgresSizes
+	err := conn.QueryRow("select bytes_per_wal_segment, wal_block_size, database_block_size from pg_control_init()").Scan(
+		&sizes.WalSegmentSize, &sizes.WalPageSize, &sizes.BlockSize)
+	if err != nil {
+		return err
+	}
+	return SetPostgresSizes(sizes)
+}
+
+// ReadPostgresSizesFromPgControl reads sizes from global/pg_control of the data directory
+func ReadPostgresSizesFromPgControl(dataDirectory string) (PostgresSizes, error) {
+	path := filepath.Join(dataDirectory, "global", PgControl)
+	file, err := os.Open(path)
+	if err != nil {
+		return PostgresSizes{}, err
+	}
+	defer file.Close()
+	data := make([]byte, pgControlMaxSafeSize)
+	readCount, err := file.Read(data)
+	if err != nil {
+		return PostgresSizes{}, errors.Wrapf(err, "failed to read '%s'", path)
+	}
+	data = data[:readCount]
+
+	// floatFormat is double, so it is 8 byte aligned
+	for offset := 0; offset+8+16 <= len(data); offset += 8 {
+		if math.Float64frombits(binary.LittleEndian.Uint64(data[offset:])) != pgControlFloatFormat {
+			continue
+		}
+		sizes := data[offset+8:]
+		return PostgresSizes{
+			BlockSize:      binary.LittleEndian.Uint32(sizes[0:]),
+			WalPageSize:    binary.LittleEndian.Uint32(sizes[8:]),
+			WalSegmentSize: uint64(binary.LittleEndian.Uint32(sizes[12:])),
+		}, nil
+	}
+	return PostgresSizes{}, NewPgControlFormatError(path)
+}
+
+// ReadPostgresSizesFromWalFile reads WAL sizes from the long header of WAL segment, block size stays unknown
+func ReadPostgresSizesFromWalFile(walFilePath string) (PostgresSizes, error) {
+	file, err := os.Open(walFilePath)
+	if err != nil {
+		return PostgresSizes{}, err
+	}
+	defer file.Close()
+	segmentSize, walPageSize, err := walparser.ReadWalSizes(file)
+	if err != nil {
+		return PostgresSizes{}, errors.Wrapf(err, "failed to read WAL header of '%s'", walFilePath)
+	}
+	return PostgresSizes{WalSegmentSize: uint64(segmentSize), WalPageSize: walPageSize}, nil
+}
+
+// TODO : unit tests
+// detectOfflinePostgresSizes makes WAL-G use sizes of the cluster, when there is no connection to it.
+// Sizes are read from pg_control of the data directory, or from any segment of the WAL directory.
+// If both are unavailable, default sizes are used.
+func detectOfflinePostgresSizes(dataDirectory string, walDirectory string) {
+	sizes, err := ReadPostgresSizesFromPgControl(dataDirectory)
+	if err != nil {
+		tracelog.DebugLogger.Printf("Unable to read sizes from pg_control: %v\n", err)
+		sizes, err = readPostgresSizesFromWalDirectory(walDirectory)
+	}
+	if err != nil {
+		tracelog.DebugLogger.Printf("Unable to read sizes from WAL segments: %v\n", err)
+		return
+	}
+	err = SetPostgresSizes(sizes)
+	if err != nil {
+		tracelog.WarningLogger.Printf("Ignoring detected sizes: %v\n", err)
+	}
+}
+
+func readPostgresSizesFromWalDirectory(walDirectory string) (PostgresSizes, error) {
+	files, err := ioutil.ReadDir(walDirectory)
+	if err != nil {
+		return PostgresSizes{}, err
+	}
+	for _, file := range files {
+		// Names are not parsed, valid names depend on segment size
+		if !file.Mode().IsRegular() || len(file.Name()) != 24 || file.Size() < minWalSegmentSize {
+			continue
+		}
+		sizes, err := ReadPostgresSizesFromWalFile(filepath.Join(walDirectory, file.Name()))
+		if err == nil {
+			return sizes, nil
+		}
+	}
+	return PostgresSizes{}, errors.Errorf("no WAL segments in '%s'", walDirectory)
+}
+
+// walDataDirectory returns data directory of pg_wal, which contains the file
+func walDataDirectory(walFilePath string) string {
+	absolutePath, err := filepath.Abs(walFilePath)
+	if err != nil {
+		absolutePath = walFilePath
+	}
+	return filepath.Dir(filepath.Dir(absolutePath))
+}
@@ -19,15 +19,20 @@ import (
 // HandleWALPrefetch is invoked by wal-fetch command to speed up database restoration
 func HandleWALPrefetch(folder StorageFolder, walFileName string, location string, uploader *Uploader) {
 	folder = folder.GetSubFolder(WalPath)
-	var fileName = walFileName
-	var err error
+	detectOfflinePostgresSizes(walDataDirectory(location), path.Dir(location))
 	location = path.Dir(location)
+	targetTimeline, history, err := FindTargetTimeline(folder, walFileName)
+	if err != nil {
+		tracelog.ErrorLogger.Println("WAL-prefetch failed: ", err, " file: ", walFileName)
+		return
+	}
+	fileNames, err := GetNextWalFilenames(walFileName, targetTimeline, history, getMaxDownloadConcurrency(8))
+	if err != nil {
+		tracelog.ErrorLogger.Println("WAL-prefetch failed: ", err, " file: ", walFileName)
+		return
+	}
 	waitGroup := &sync.WaitGroup{}
-	for i := 0; i < getMaxDownloadConcurrency(8); i++ {
-		fileName, err = GetNextWalFilename(fileName)
-		if err != nil {
-			tracelog.ErrorLogger.Println("WAL-prefetch failed: ", err, " file: ", fileName)
-		}
+	for _, fileName := range fileNames {
 		waitGroup.Add(1)
 		go prefetchFile(location, folder, fileName, waitGroup)
 
@@ -61,7 +66,10 @@ func prefaultData(prefaultStartLsn uint64, timelineId uint32, waitGroup *sync.Wa
 		return
 	}
 
-	archiveDirectory := uploader.deltaFileManager.dataFolder.(*DiskDataFolder).path
+	archiveDirectory := getDataFolderPath()
+	if diskDataFolder, ok := uploader.deltaFileManager.dataFolder.(*DiskDataFolder); ok {
+		archiveDirectory = diskDataFolder.path
+	}
 	archiveDirectory = filepath.Dir(archiveDirectory)
 	archiveDirectory = filepath.Dir(archiveDirectory)
 	bundle := NewBundle(archiveDirectory, &prefaultStartLsn, nil)
@@ -5,6 +5,7 @@ import (
 	"github.com/jackc/pgx"
 	"github.com/pkg/errors"
 	"github.com/x4m/wal-g/internal/tracelog"
+	"path"
 )
 
 type NoPostgresVersionError struct {
@@ -40,7 +41,9 @@ type QueryRunner interface {
 	StopBackup() (string, string, string, error)
 }
 
-// PgQueryRunner is implementation for controlling PostgreSQL 9.0+
+// PgQueryRunner is implementation for controlling PostgreSQL 9.0+.
+// Since 9.6 backups are non-exclusive, so the backup is aborted by the server
+// when the session which started it ends. Start and stop must use the same connection.
 type PgQueryRunner struct {
 	connection *pgx.Conn
 	Version    int
@@ -56,6 +59,8 @@ func (queryRunner *PgQueryRunner) BuildStartBackup() (string, error) {
 	// TODO: rewrite queries for older versions to remove pg_is_in_recovery()
 	// where pg_start_backup() will fail on standby anyway
 	switch {
+	case queryRunner.Version >= 150000:
+		return "SELECT case when pg_is_in_recovery() then '' else (pg_walfile_name_offset(lsn)).file_name end, lsn::text, pg_is_in_recovery() FROM pg_backup_start($1, true) lsn", nil
 	case queryRunner.Version >= 100000:
 		return "SELECT case when pg_is_in_recovery() then '' else (pg_walfile_name_offset(lsn)).file_name end, lsn::text, pg_is_in_recovery() FROM pg_start_backup($1, true, false) lsn", nil
 	case queryRunner.Version >= 90600:
@@ -72,6 +77,8 @@ func (queryRunner *PgQueryRunner) BuildStartBackup() (string, error) {
 // BuildStopBackup formats a query that stops backup according to server features and version
 func (queryRunner *PgQueryRunner) BuildStopBackup() (string, error) {
 	switch {
+	case queryRunner.Version >= 150000:
+		return "SELECT labelfile, spcmapfile, lsn FROM pg_backup_stop()", nil
 	case queryRunner.Version >= 90600:
 		return "SELECT labelfile, spcmapfile, lsn FROM pg_stop_backup(false)", nil
 	case queryRunner.Version >= 90000:
@@ -83,6 +90,20 @@ func (queryRunner *PgQueryRunner) BuildStopBackup() (string, error) {
 	}
 }
 
+// BuildDisableSessionTimeouts formats a query that prevents the server from closing the idle session,
+// which runs non-exclusive backup, while files are uploaded. Returns empty string if no query is needed.
+func (queryRunner *PgQueryRunner) BuildDisableSessionTimeouts() string {
+	if queryRunner.Version >= 140000 {
+		return "SET idle_session_timeout = 0"
+	}
+	return ""
+}
+
+// BuildGetRelationNames formats a query to retrieve data file paths and qualified names of relations of the connected database
+func (queryRunner *PgQueryRunner) BuildGetRelationNames() string {
+	return "SELECT pg_relation_filepath(c.oid), n.nspname || '.' || c.relname FROM pg_class c JOIN pg_namespace n ON n.oid = c.relnamespace WHERE pg_relation_filepath(c.oid) IS NOT NULL"
+}
+
 // NewPgQueryRunner builds QueryRunner from available connection
 func NewPgQueryRunner(conn *pgx.Conn) (*PgQueryRunner, error) {
 	r := &PgQueryRunner{connection: conn}
@@ -109,8 +130,14 @@ func (queryRunner *PgQueryRunner) StartBackup(backup string) (backupName string,
 		return "", "", false, errors.Wrap(err, "QueryRunner StartBackup: Building start backup query failed")
 	}
 
+	if disableTimeoutsQuery := queryRunner.BuildDisableSessionTimeouts(); disableTimeoutsQuery != "" {
+		if _, err = conn.Exec(disableTimeoutsQuery); err != nil {
+			return "", "", false, errors.Wrap(err, "QueryRunner StartBackup: failed to disable session timeouts")
+		}
+	}
+
 	if err = conn.QueryRow(startBackupQuery, backup).Scan(&backupName, &lsnString, &inRecovery); err != nil {
-		return "", "", false, errors.Wrap(err, "QueryRunner StartBackup: pg_start_backup() failed")
+		return "", "", false, errors.Wrap(err, "QueryRunner StartBackup: start backup failed")
 	}
 
 	return backupName, lsnString, inRecovery, nil
@@ -148,3 +175,29 @@ func (queryRunner *PgQueryRunner) StopBackup() (label string, offsetMap string,
 
 	return label, offsetMap, lsnStr, nil
 }
+
+// GetRelationNames reads names of relations of the connected database, shared and temporary relations are skipped
+func (queryRunner *PgQueryRunner) GetRelationNames() ([]RelationName, error) {
+	rows, err := queryRunner.connection.Query(queryRunner.BuildGetRelationNames())
+	if err != nil {
+		return nil, errors.Wrap(err, "QueryRunner GetRelationNames: query failed")
+	}
+	defer rows.Close()
+
+	relationNames := make([]RelationName, 0)
+	for rows.Next() {
+		var filePath, name string
+		if err = rows.Scan(&filePath, &name); err != nil {
+			return nil, errors.Wrap(err, "QueryRunner GetRelationNames: failed to read relation")
+		}
+		if !pagedFilenameRegexp.MatchString(path.Base(filePath)) {
+			continue
+		}
+		relFileNode, err := GetRelFileNodeFrom(filePath)
+		if err != nil {
+			continue
+		}
+		relationNames = append(relationNames, NewRelationName(*relFileNode, name))
+	}
+	return relationNames, errors.Wrap(rows.Err(), "QueryRunner GetRelationNames: failed to read relations")
+}
@@ -0,0 +1,128 @@
+package internal
+
+import (
+	"fmt"
+	"github.com/pkg/errors"
+	"github.com/x4m/wal-g/internal/tracelog"
+	"golang.org/x/time/rate"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+type InvalidRateLimitScheduleError struct {
+	error
+}
+
+func NewInvalidRateLimitScheduleError(schedule string, reason string) InvalidRateLimitScheduleError {
+	return InvalidRateLimitScheduleError{errors.Errorf("Invalid rate limit schedule '%s': %s", schedule, reason)}
+}
+
+func (err InvalidRateLimitScheduleError) Error() string {
+	return fmt.Sprintf(tracelog.GetErrorFormatter(), err.error)
+}
+
+// RateLimitWindow is a time of day interval with its own rate limit.
+// Window with From after To passes midnight.
+type RateLimitWindow struct {
+	From  time.Duration
+	To    time.Duration
+	Limit int64
+}
+
+func (window RateLimitWindow) contains(timeOfDay time.Duration) bool {
+	if window.From <= window.To {
+		return window.From <= timeOfDay && timeOfDay < window.To
+	}
+	return timeOfDay >= window.From || timeOfDay < window.To
+}
+
+// RateLimitSchedule changes rate limit depending on local time of day.
+// Outside of windows DefaultLimit is used.
+type RateLimitSchedule struct {
+	DefaultLimit rate.Limit
+	Windows      []RateLimitWindow
+
+	// limiter of the active window, it is replaced when the window changes, see LimiterAt
+	limiter *rate.Limiter
+	mutex   sync.Mutex
+}
+
+// ParseRateLimitSchedule parses comma separated windows like "09:00-18:00=1048576,22:00-06:00=10485760",
+// where limit is in bytes per second
+func ParseRateLimitSchedule(schedule string) ([]RateLimitWindow, error) {
+	var windows []RateLimitWindow
+	for _, windowStr := range strings.Split(schedule, ",") {
+		windowStr = strings.TrimSpace(windowStr)
+		if windowStr == "" {
+			continue
+		}
+		interval := strings.SplitN(windowStr, "=", 2)
+		if len(interval) != 2 {
+			return nil, NewInvalidRateLimitScheduleError(schedule, "expected 'HH:MM-HH:MM=limit'")
+		}
+		bounds := strings.SplitN(interval[0], "-", 2)
+		if len(bounds) != 2 {
+			return nil, NewInvalidRateLimitScheduleError(schedule, "expected 'HH:MM-HH:MM=limit'")
+		}
+		from, err := parseTimeOfDay(bounds[0])
+		if err != nil {
+			return nil, NewInvalidRateLimitScheduleError(schedule, err.Error())
+		}
+		to, err := parseTimeOfDay(bounds[1])
+		if err != nil {
+			return nil, NewInvalidRateLimitScheduleError(schedule, err.Error())
+		}
+		limit, err := strconv.ParseInt(strings.TrimSpace(interval[1]), 10, 64)
+		if err != nil || limit <= 0 {
+			return nil, NewInvalidRateLimitScheduleError(schedule, fmt.Sprintf("limit '%s' is not a positive number", interval[1]))
+		}
+		windows = append(windows, RateLimitWindow{from, to, limit})
+	}
+	if len(windows) == 0 {
+		return nil, NewInvalidRateLimitScheduleError(schedule, "no windows")
+	}
+	return windows, nil
+}
+
+func parseTimeOfDay(timeOfDay string) (time.Duration, error) {
+	parsed, err := time.Parse("15:04", strings.TrimSpace(timeOfDay))
+	if err != nil {
+		return 0, errors.Errorf("time '%s' is not in HH:MM format", timeOfDay)
+	}
+	return time.Duration(parsed.Hour())*time.Hour + time.Duration(parsed.Minute())*time.Minute, nil
+}
+
+// LimitAt returns rate limit for the moment, the first matching window wins
+func (schedule *RateLimitSchedule) LimitAt(moment time.Time) rate.Limit {
+	hour, minute, second := moment.Clock()
+	timeOfDay := time.Duration(hour)*time.Hour + time.Duration(minute)*time.Minute + time.Duration(second)*time.Second
+	for _, window := range schedule.Windows {
+		if window.contains(timeOfDay) {
+			return rate.Limit(window.Limit)
+		}
+	}
+	return schedule.DefaultLimit
+}
+
+// LimiterAt returns limiter of the window active at the moment. Burst of the limiter is sized to the limit
+// of the window, so the limiter is replaced when the window changes: rate.Limiter of pinned
+// golang.org/x/time has no SetBurst.
+func (schedule *RateLimitSchedule) LimiterAt(moment time.Time) *rate.Limiter {
+	limit := schedule.LimitAt(moment)
+	schedule.mutex.Lock()
+	defer schedule.mutex.Unlock()
+	if schedule.limiter == nil || schedule.limiter.Limit() != limit {
+		schedule.limiter = rate.NewLimiter(limit, limitBurst(limit))
+	}
+	return schedule.limiter
+}
+
+// limitBurst allows bursts of 8 pages above the limit
+func limitBurst(limit rate.Limit) int {
+	if limit == rate.Inf {
+		return 0
+	}
+	return int(int64(limit) + DefaultDataBurstRateLimit)
+}
@@ -0,0 +1,30 @@
+package internal
+
+import (
+	"fmt"
+	"github.com/x4m/wal-g/internal/walparser"
+)
+
+// RelationName maps data files of relation to its qualified name at backup time
+type RelationName struct {
+	SpcNode uint32 `json:"SpcNode"`
+	DBNode  uint32 `json:"DBNode"`
+	RelNode uint32 `json:"RelNode"`
+	Name    string `json:"Name"`
+}
+
+func NewRelationName(relFileNode walparser.RelFileNode, name string) RelationName {
+	return RelationName{uint32(relFileNode.SpcNode), uint32(relFileNode.DBNode), uint32(relFileNode.RelNode), name}
+}
+
+func (relationName *RelationName) relFileNode() walparser.RelFileNode {
+	return walparser.RelFileNode{
+		SpcNode: walparser.Oid(relationName.SpcNode),
+		DBNode:  walparser.Oid(relationName.DBNode),
+		RelNode: walparser.Oid(relationName.RelNode),
+	}
+}
+
+func formatRelFileNode(relFileNode walparser.RelFileNode) string {
+	return fmt.Sprintf("%d/%d/%d", relFileNode.SpcNode, relFileNode.DBNode, relFileNode.RelNode)
+}
@@ -0,0 +1,382 @@
+package internal
+
+import (
+	"archive/tar"
+	"bytes"
+	"encoding/binary"
+	"github.com/RoaringBitmap/roaring"
+	"github.com/pkg/errors"
+	"github.com/x4m/wal-g/internal/tracelog"
+	"io"
+	"os"
+	"path"
+	"strconv"
+	"sync"
+)
+
+// restoredPagedFile remembers which blocks of the file are already written
+// and the size and attributes of the newest file version.
+type restoredPagedFile struct {
+	blocks   *roaring.Bitmap
+	fileSize uint64
+	header   *tar.Header
+}
+
+func (file *restoredPagedFile) shouldWrite(blockNo uint32) bool {
+	return uint64(blockNo)*uint64(DatabasePageSize) < file.fileSize && !file.blocks.Contains(blockNo)
+}
+
+// RestoredPagedFiles tracks blocks of paged files, materialized during reverse delta unpack.
+// When delta chain is restored from the newest backup to the oldest one,
+// older versions of these blocks are skipped, so each block is written exactly once.
+type RestoredPagedFiles struct {
+	mutex sync.Mutex
+	files map[string]*restoredPagedFile
+}
+
+func NewRestoredPagedFiles() *RestoredPagedFiles {
+	return &RestoredPagedFiles{files: make(map[string]*restoredPagedFile)}
+}
+
+func (restoredFiles *RestoredPagedFiles) get(fileName string) *restoredPagedFile {
+	restoredFiles.mutex.Lock()
+	defer restoredFiles.mutex.Unlock()
+	return restoredFiles.files[fileName]
+}
+
+// getOrCreate returns tracked file and true if it was not tracked before
+func (restoredFiles *RestoredPagedFiles) getOrCreate(fileName string, fileSize uint64, header *tar.Header) (*restoredPagedFile, bool) {
+	restoredFiles.mutex.Lock()
+	defer restoredFiles.mutex.Unlock()
+	if file, ok := restoredFiles.files[fileName]; ok {
+		return file, false
+	}
+	file := &restoredPagedFile{roaring.New(), fileSize, header}
+	restoredFiles.files[fileName] = file
+	return file, true
+}
+
+// RestoredBlockCount returns count of blocks written to the file
+func (restoredFiles *RestoredPagedFiles) RestoredBlockCount(fileName string) uint64 {
+	file := restoredFiles.get(fileName)
+	if file == nil {
+		return 0
+	}
+	return file.blocks.GetCardinality()
+}
+
+// applyAttributes restores attributes of the newest versions of tracked files,
+// when all their blocks are written
+func (restoredFiles *RestoredPagedFiles) applyAttributes(dbDataDirectory string, owner *FileOwner) error {
+	restoredFiles.mutex.Lock()
+	defer restoredFiles.mutex.Unlock()
+	for fileName, file := range restoredFiles.files {
+		targetPath := path.Join(dbDataDirectory, fileName)
+		if err := os.Chmod(targetPath, os.FileMode(file.header.Mode)); err != nil {
+			return errors.Wrapf(err, "chmod failed for '%s'", targetPath)
+		}
+		if err := applyFileAttributes(targetPath, file.header, owner); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// pageWriter writes pages to the file. If compare is set,
+// pages equal to those already on disk are not rewritten.
+type pageWriter struct {
+	file      *os.File
+	compare   bool
+	localPage []byte
+}
+
+func newPageWriter(file *os.File, compare bool) *pageWriter {
+	return &pageWriter{file, compare, make([]byte, DatabasePageSize)}
+}
+
+func (writer *pageWriter) writePage(page []byte, blockNo uint32) error {
+	offset := int64(blockNo) * int64(DatabasePageSize)
+	if writer.compare && writer.isOnDisk(page, offset) {
+		return nil
+	}
+	err := limitDiskIO(len(page))
+	if err != nil {
+		return err
+	}
+	_, err = writer.file.WriteAt(page, offset)
+	return err
+}
+
+func (writer *pageWriter) isOnDisk(page []byte, offset int64) bool {
+	localPage := writer.localPage[:len(page)]
+	if limitDiskIO(len(page)) != nil {
+		return false
+	}
+	n, _ := writer.file.ReadAt(localPage, offset)
+	if n != len(page) {
+		return false
+	}
+	// Page LSN is the first field of page header, differing LSN is the cheapest way to spot a changed page
+	if len(page) >= sizeofInt64 && !bytes.Equal(localPage[:sizeofInt64], page[:sizeofInt64]) {
+		return false
+	}
+	return bytes.Equal(localPage, page)
+}
+
+// ReverseDeltaTarInterpreter extracts tars of delta chain from the newest backup to the oldest.
+type ReverseDeltaTarInterpreter struct {
+	FileTarInterpreter
+	RestoredFiles *RestoredPagedFiles
+	// skipUnchangedPages is used by catchup fetch, when files already exist in data directory
+	skipUnchangedPages bool
+}
+
+func NewReverseDeltaTarInterpreter(dbDataDirectory string, sentinel BackupSentinelDto, filesToUnwrap map[string]bool,
+	restoredFiles *RestoredPagedFiles, owner *FileOwner) *ReverseDeltaTarInterpreter {
+	return &ReverseDeltaTarInterpreter{FileTarInterpreter{dbDataDirectory, sentinel, filesToUnwrap, owner}, restoredFiles, false}
+}
+
+// NewCatchupTarInterpreter makes interpreter which updates files existing in data directory
+func NewCatchupTarInterpreter(dbDataDirectory string, sentinel BackupSentinelDto, filesToUnwrap map[string]bool,
+	restoredFiles *RestoredPagedFiles, owner *FileOwner) *ReverseDeltaTarInterpreter {
+	return &ReverseDeltaTarInterpreter{FileTarInterpreter{dbDataDirectory, sentinel, filesToUnwrap, owner}, restoredFiles, true}
+}
+
+// Interpret extracts a tar file to disk skipping blocks already written from newer backups
+func (tarInterpreter *ReverseDeltaTarInterpreter) Interpret(fileReader io.Reader, fileInfo *tar.Header) error {
+	if fileInfo.Typeflag != tar.TypeReg && fileInfo.Typeflag != tar.TypeRegA {
+		if tarInterpreter.skipUnchangedPages && (fileInfo.Typeflag == tar.TypeLink || fileInfo.Typeflag == tar.TypeSymlink) {
+			err := removeIfExists(path.Join(tarInterpreter.DBDataDirectory, fileInfo.Name))
+			if err != nil {
+				return errors.Wrapf(err, "Interpret: failed to replace link '%s'", fileInfo.Name)
+			}
+		}
+		return tarInterpreter.FileTarInterpreter.Interpret(fileReader, fileInfo)
+	}
+	tracelog.DebugLogger.Println("Interpreting: ", fileInfo.Name)
+	if _, ok := tarInterpreter.FilesToUnwrap[fileInfo.Name]; !ok {
+		// restored from newer backup or not needed at all
+		tracelog.DebugLogger.Printf("Don't have to unwrap '%s' this time\n", fileInfo.Name)
+		return nil
+	}
+	targetPath := path.Join(tarInterpreter.DBDataDirectory, fileInfo.Name)
+
+	fileDescription, haveFileDescription := tarInterpreter.Sentinel.Files[fileInfo.Name]
+	if haveFileDescription && tarInterpreter.Sentinel.isIncremental() && fileDescription.IsIncremented {
+		err := tarInterpreter.applyFileIncrement(fileInfo, targetPath, fileReader)
+		return errors.Wrapf(err, "Interpret: failed to apply increment for '%s'", targetPath)
+	}
+	if restoredFile := tarInterpreter.RestoredFiles.get(fileInfo.Name); restoredFile != nil {
+		err := writeNotRestoredPages(restoredFile, targetPath, fileReader, tarInterpreter.skipUnchangedPages)
+		return errors.Wrapf(err, "Interpret: failed to write base pages for '%s'", targetPath)
+	}
+	if tarInterpreter.skipUnchangedPages {
+		if _, err := os.Stat(targetPath); err == nil {
+			err = tarInterpreter.overwriteChangedPages(fileInfo, targetPath, fileReader)
+			return errors.Wrapf(err, "Interpret: failed to overwrite changed pages of '%s'", targetPath)
+		}
+	}
+	return tarInterpreter.unwrapRegularFile(fileReader, fileInfo, targetPath)
+}
+
+// overwriteChangedPages updates existing file with its version from the backup, leaving equal pages intact
+func (tarInterpreter *ReverseDeltaTarInterpreter) overwriteChangedPages(fileInfo *tar.Header, targetPath string, fileReader io.Reader) error {
+	restoredFile, _ := tarInterpreter.RestoredFiles.getOrCreate(fileInfo.Name, uint64(fileInfo.Size), fileInfo)
+	err := os.Truncate(targetPath, fileInfo.Size)
+	if err != nil {
+		return err
+	}
+	return writeNotRestoredPages(restoredFile, targetPath, fileReader, true)
+}
+
+func removeIfExists(targetPath string) error {
+	if _, err := os.Lstat(targetPath); err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	return os.Remove(targetPath)
+}
+
+// applyFileIncrement writes pages from the increment, which were not written by newer increments.
+// The first (newest) increment of the file determines its size and attributes.
+func (tarInterpreter *ReverseDeltaTarInterpreter) applyFileIncrement(fileInfo *tar.Header, targetPath string, increment io.Reader) error {
+	tracelog.DebugLogger.Printf("Incrementing %s\n", targetPath)
+	fileSize, diffBlockCount, diffMap, err := readIncrementDiffMap(increment)
+	if err != nil {
+		return err
+	}
+
+	err = prepareDirs(fileInfo.Name, targetPath)
+	if err != nil {
+		return errors.Wrap(err, "failed to create all directories")
+	}
+	file, err := os.OpenFile(targetPath, os.O_RDWR|os.O_CREATE, 0666)
+	if err != nil {
+		return errors.Wrap(err, "can't open file to increment")
+	}
+	defer file.Close()
+
+	restoredFile, isNewest := tarInterpreter.RestoredFiles.getOrCreate(fileInfo.Name, fileSize, fileInfo)
+	if isNewest {
+		err = file.Truncate(int64(fileSize))
+		if err != nil {
+			return err
+		}
+	}
+
+	writer := newPageWriter(file, tarInterpreter.skipUnchangedPages)
+	page := make([]byte, DatabasePageSize)
+	for i := uint32(0); i < diffBlockCount; i++ {
+		blockNo := binary.LittleEndian.Uint32(diffMap[i*sizeofInt32 : (i+1)*sizeofInt32])
+		_, err = io.ReadFull(increment, page)
+		if err != nil {
+			return err
+		}
+		if !restoredFile.shouldWrite(blockNo) {
+			continue
+		}
+
+		err = writer.writePage(page, blockNo)
+		if err != nil {
+			return err
+		}
+		restoredFile.blocks.Add(blockNo)
+	}
+
+	all, _ := increment.Read(make([]byte, 1))
+	if all > 0 {
+		return NewUnexpectedTarDataError()
+	}
+
+	return file.Sync()
+}
+
+// writeNotRestoredPages writes full file version from base backup, skipping pages restored from increments
+func writeNotRestoredPages(restoredFile *restoredPagedFile, targetPath string, fileReader io.Reader, compare bool) error {
+	file, err := os.OpenFile(targetPath, os.O_RDWR, 0666)
+	if err != nil {
+		return errors.Wrap(err, "incremented file should always exist")
+	}
+	defer file.Close()
+
+	writer := newPageWriter(file, compare)
+	page := make([]byte, DatabasePageSize)
+	for blockNo := uint32(0); ; blockNo++ {
+		n, err := io.ReadFull(fileReader, page)
+		if err == io.EOF {
+			break
+		}
+		if err != nil && err != io.ErrUnexpectedEOF {
+			return err
+		}
+		if restoredFile.shouldWrite(blockNo) {
+			writeErr := writer.writePage(page[:n], blockNo)
+			if writeErr != nil {
+				return writeErr
+			}
+			restoredFile.blocks.Add(blockNo)
+		}
+		if err == io.ErrUnexpectedEOF {
+			break
+		}
+	}
+	return file.Sync()
+}
+
+// TODO : unit tests
+// deltaFetchReverse restores delta chain starting from the newest backup, so each page is written once
+func deltaFetchReverse(backupName string, folder StorageFolder, dbDataDirectory string, owner *FileOwner) error {
+	isEmpty, err := IsDirectoryEmpty(dbDataDirectory)
+	if err != nil {
+		return err
+	}
+	if !isEmpty {
+		return NewNonEmptyDbDataDirectoryError(dbDataDirectory)
+	}
+
+	backup, err := GetBackupByName(backupName, folder)
+	if err != nil {
+		return err
+	}
+	sentinelDto, err := backup.fetchSentinel()
+	if err != nil {
+		return err
+	}
+	err = sentinelDto.applyPostgresSizes()
+	if err != nil {
+		return err
+	}
+	// it is the exact backup we want to fetch, so we want to include all files here
+	return unwrapReverse(folder, backup, sentinelDto, dbDataDirectory, GetRestoredBackupFilesToUnwrap(sentinelDto), false, owner)
+}
+
+// TODO : unit tests
+// unwrapReverse extracts the backup and then its delta bases down to the full backup.
+// In catchup mode only tars with files to unwrap are downloaded and unchanged pages are not rewritten.
+func unwrapReverse(folder StorageFolder, backup *Backup, sentinelDto BackupSentinelDto, dbDataDirectory string,
+	filesToUnwrap map[string]bool, catchup bool, owner *FileOwner) error {
+	restoredFiles := NewRestoredPagedFiles()
+	newestBackup, newestSentinelDto := backup, sentinelDto
+	var newestTarInterpreter TarInterpreter
+	var pgControlKey string
+	for {
+		var tarInterpreter *ReverseDeltaTarInterpreter
+		var currentPgControlKey string
+		var err error
+		if catchup {
+			tarInterpreter = NewCatchupTarInterpreter(dbDataDirectory, sentinelDto, filesToUnwrap, restoredFiles, owner)
+			currentPgControlKey, err = backup.extractChangedTars(tarInterpreter, sentinelDto, filesToUnwrap)
+		} else {
+			tarInterpreter = NewReverseDeltaTarInterpreter(dbDataDirectory, sentinelDto, filesToUnwrap, restoredFiles, owner)
+			currentPgControlKey, err = backup.extractTars(tarInterpreter, sentinelDto)
+		}
+		if err != nil {
+			return err
+		}
+		if newestTarInterpreter == nil {
+			newestTarInterpreter, pgControlKey = tarInterpreter, currentPgControlKey
+		}
+
+		if !sentinelDto.isIncremental() {
+			break
+		}
+		tracelog.InfoLogger.Printf("%v fetched. Fetching its base %v at LSN %x \n", backup.Name, *(sentinelDto.IncrementFrom), *(sentinelDto.IncrementFromLSN))
+		filesToUnwrap, err = GetBaseFilesToUnwrap(sentinelDto.Files, filesToUnwrap)
+		if err != nil {
+			return err
+		}
+		backup, err = GetBackupByName(*sentinelDto.IncrementFrom, folder)
+		if err != nil {
+			return err
+		}
+		sentinelDto, err = backup.fetchSentinel()
+		if err != nil {
+			return err
+		}
+	}
+
+	err := restoredFiles.applyAttributes(dbDataDirectory, owner)
+	if err != nil {
+		return err
+	}
+
+	// pg_control of the newest backup goes last to prevent server startup with incomplete restoration
+	err = newestBackup.extractPgControl(newestTarInterpreter, newestSentinelDto, pgControlKey)
+	if err != nil {
+		return err
+	}
+	tracelog.InfoLogger.Print("\nBackup extraction complete.\n")
+	return nil
+}
+
+// TODO : unit tests
+func useReverseUnpack() (bool, error) {
+	useReverseUnpackStr, ok := LookupConfigValue("WALG_USE_REVERSE_UNPACK")
+	if !ok {
+		return false, nil
+	}
+	useReverseUnpack, err := strconv.ParseBool(useReverseUnpackStr)
+	return useReverseUnpack, errors.Wrap(err, "failed to parse WALG_USE_REVERSE_UNPACK")
+}
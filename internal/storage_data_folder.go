@@ -0,0 +1,215 @@
+package internal
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/pkg/errors"
+	"github.com/x4m/wal-g/internal/tracelog"
+	"io"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	// DeltaDataPath is the storage folder of WAL delta recording state, when it is kept in storage
+	DeltaDataPath          = "walg_data/"
+	DataFolderLockFilename = "lock"
+)
+
+var (
+	// DataFolderLockTimeout is the time, after which lock of host, which stopped pushing WAL, can be taken over
+	DataFolderLockTimeout = 10 * time.Minute
+	// DataFolderLockSettleDelay is waited after lock is taken, so that concurrent writer of lock is noticed
+	DataFolderLockSettleDelay = time.Second
+)
+
+type DataFolderLockedError struct {
+	error
+}
+
+func NewDataFolderLockedError(holder string, expiresAt time.Time) DataFolderLockedError {
+	return DataFolderLockedError{errors.Errorf("delta data folder is locked by '%s' until %s",
+		holder, expiresAt.Format(time.RFC3339))}
+}
+
+func (err DataFolderLockedError) Error() string {
+	return fmt.Sprintf(tracelog.GetErrorFormatter(), err.error)
+}
+
+// LockingDataFolder is the data folder shared by several hosts.
+// It is locked at the first access, the lock is kept between flushes and should be unlocked,
+// when the host stops archiving.
+type LockingDataFolder interface {
+	DataFolder
+	Unlock() error
+}
+
+type dataFolderLock struct {
+	Holder    string    `json:"holder"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// StorageDataFolder keeps WAL delta recording state in storage, so that it survives move of archiving to another host.
+// Storage has no atomic operations, so the lock is a lease: lock object is written, read back after
+// DataFolderLockSettleDelay, and renewed while the folder is used. Valid lease of the same holder is renewed
+// without delay, so the next wal-push of the host continues with the lease of the previous one.
+type StorageDataFolder struct {
+	folder        StorageFolder
+	holder        string
+	lockExpiresAt time.Time
+	lockMutex     sync.Mutex
+}
+
+func NewStorageDataFolder(folder StorageFolder, holder string) *StorageDataFolder {
+	return &StorageDataFolder{folder: folder, holder: holder}
+}
+
+// GetDataFolderHolder identifies lock holder of data folder by host and local data folder path,
+// it is the same for all wal-push processes of the cluster
+func GetDataFolderHolder(dataFolderPath string) string {
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = "unknown"
+	}
+	return fmt.Sprintf("%s:%s", hostname, dataFolderPath)
+}
+
+func (folder *StorageDataFolder) OpenReadonlyFile(filename string) (io.ReadCloser, error) {
+	err := folder.ensureLocked()
+	if err != nil {
+		return nil, err
+	}
+	file, err := folder.folder.ReadObject(filename)
+	if _, ok := errors.Cause(err).(ObjectNotFoundError); ok {
+		return nil, NewNoSuchFileError(filename)
+	}
+	return file, err
+}
+
+func (folder *StorageDataFolder) OpenWriteOnlyFile(filename string) (io.WriteCloser, error) {
+	err := folder.ensureLocked()
+	if err != nil {
+		return nil, err
+	}
+	return &storageDataFile{folder: folder, name: filename}, nil
+}
+
+func (folder *StorageDataFolder) CleanFolder() error {
+	err := folder.ensureLocked()
+	if err != nil {
+		return err
+	}
+	objects, _, err := folder.folder.ListFolder()
+	if err != nil {
+		return err
+	}
+	names := make([]string, 0, len(objects))
+	for _, object := range objects {
+		if object.GetName() != DataFolderLockFilename {
+			names = append(names, object.GetName())
+		}
+	}
+	if len(names) == 0 {
+		return nil
+	}
+	return folder.folder.DeleteObjects(names)
+}
+
+// Unlock removes lock object, if it is still held by this folder
+func (folder *StorageDataFolder) Unlock() error {
+	folder.lockMutex.Lock()
+	defer folder.lockMutex.Unlock()
+	if folder.lockExpiresAt.IsZero() {
+		return nil
+	}
+	folder.lockExpiresAt = time.Time{}
+	lock, err := folder.readLock()
+	if err != nil || lock == nil || lock.Holder != folder.holder {
+		return err
+	}
+	return folder.folder.DeleteObjects([]string{DataFolderLockFilename})
+}
+
+// ensureLocked takes the lock at the first access and renews it, when half of its timeout is passed
+func (folder *StorageDataFolder) ensureLocked() error {
+	folder.lockMutex.Lock()
+	defer folder.lockMutex.Unlock()
+	if time.Until(folder.lockExpiresAt) > DataFolderLockTimeout/2 {
+		return nil
+	}
+	lock, err := folder.readLock()
+	if err != nil {
+		return err
+	}
+	isLeased := lock != nil && time.Now().Before(lock.ExpiresAt)
+	if isLeased && lock.Holder != folder.holder {
+		return NewDataFolderLockedError(lock.Holder, lock.ExpiresAt)
+	}
+	expiresAt := time.Now().Add(DataFolderLockTimeout)
+	lockData, err := json.Marshal(dataFolderLock{folder.holder, expiresAt})
+	if err != nil {
+		return err
+	}
+	err = folder.folder.PutObject(DataFolderLockFilename, bytes.NewReader(lockData))
+	if err != nil {
+		return err
+	}
+	if isLeased {
+		// nobody else takes valid lease, so it is renewed without waiting
+		folder.lockExpiresAt = expiresAt
+		return nil
+	}
+	time.Sleep(DataFolderLockSettleDelay)
+	lock, err = folder.readLock()
+	if err != nil {
+		return err
+	}
+	if lock == nil {
+		return errors.New("lock of delta data folder is removed while it is taken")
+	}
+	if lock.Holder != folder.holder {
+		return NewDataFolderLockedError(lock.Holder, lock.ExpiresAt)
+	}
+	folder.lockExpiresAt = expiresAt
+	return nil
+}
+
+// readLock returns nil, if the folder is not locked
+func (folder *StorageDataFolder) readLock() (*dataFolderLock, error) {
+	reader, err := folder.folder.ReadObject(DataFolderLockFilename)
+	if _, ok := errors.Cause(err).(ObjectNotFoundError); ok {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+	lockData, err := ioutil.ReadAll(reader)
+	if err != nil {
+		return nil, err
+	}
+	lock := &dataFolderLock{}
+	err = json.Unmarshal(lockData, lock)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to parse lock of delta data folder")
+	}
+	return lock, nil
+}
+
+// storageDataFile is uploaded to storage on close
+type storageDataFile struct {
+	bytes.Buffer
+	folder *StorageDataFolder
+	name   string
+}
+
+func (file *storageDataFile) Close() error {
+	err := file.folder.ensureLocked()
+	if err != nil {
+		return err
+	}
+	return file.folder.folder.PutObject(file.name, &file.Buffer)
+}
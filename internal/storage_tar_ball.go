@@ -22,15 +22,29 @@ func (err NoSentinelUploadError) Error() string {
 	return fmt.Sprintf(tracelog.GetErrorFormatter(), err.error)
 }
 
+type TarPartsNotUploadedError struct {
+	error
+}
+
+func NewTarPartsNotUploadedError(failedParts []string) TarPartsNotUploadedError {
+	return TarPartsNotUploadedError{errors.Errorf("Sentinel was not uploaded because tar parts failed to upload: %v", failedParts)}
+}
+
+func (err TarPartsNotUploadedError) Error() string {
+	return fmt.Sprintf(tracelog.GetErrorFormatter(), err.error)
+}
+
 // StorageTarBall represents a tar file that is
 // going to be uploaded to storage.
 type StorageTarBall struct {
 	backupName  string
+	name        string
 	partNumber  int
 	size        int64
 	writeCloser io.Closer
 	tarWriter   *tar.Writer
 	uploader    *Uploader
+	parts       *TarPartRegistry
 }
 
 // SetUp creates a new tar writer and starts upload to storage.
@@ -45,6 +59,7 @@ func (tarBall *StorageTarBall) SetUp(crypter Crypter, names ...string) {
 		} else {
 			name = fmt.Sprintf("part_%0.3d.tar.%v", tarBall.partNumber, tarBall.uploader.compressor.FileExtension())
 		}
+		tarBall.name = name
 		writeCloser := tarBall.startUpload(name, crypter)
 
 		tarBall.writeCloser = writeCloser
@@ -90,7 +105,15 @@ func (tarBall *StorageTarBall) startUpload(name string, crypter Crypter) io.Writ
 	go func() {
 		defer uploader.waitGroup.Done()
 
-		err := uploader.upload(path, NewNetworkLimitReader(pipeReader))
+		md5Reader := newMd5Reader(pipeReader)
+		err := uploader.upload(path, NewNetworkLimitReader(ReadCascadeCloser{md5Reader, pipeReader}))
+		if tarBall.parts != nil {
+			if err == nil {
+				tarBall.parts.register(name, TarPartDescription{Size: md5Reader.Size(), MD5: md5Reader.Sum()})
+			} else {
+				tarBall.parts.registerFailure(name)
+			}
+		}
 		if compressingError, ok := err.(CompressingPipeWriterError); ok {
 			tracelog.ErrorLogger.Printf("could not upload '%s' due to compression error\n%+v\n", path, compressingError)
 		}
@@ -100,6 +123,7 @@ func (tarBall *StorageTarBall) startUpload(name string, crypter Crypter) io.Writ
 		}
 	}()
 
+	compressor := NewParallelCompressor(uploader.compressor, uploader.TarCompressionConcurrency)
 	if crypter.IsUsed() {
 		encryptedWriter, err := crypter.Encrypt(pipeWriter)
 
@@ -107,10 +131,10 @@ func (tarBall *StorageTarBall) startUpload(name string, crypter Crypter) io.Writ
 			tracelog.ErrorLogger.Fatal("upload: encryption error ", err)
 		}
 
-		return &CascadeWriteCloser{uploader.compressor.NewWriter(encryptedWriter), &CascadeWriteCloser{encryptedWriter, pipeWriter}}
+		return &CascadeWriteCloser{compressor.NewWriter(encryptedWriter), &CascadeWriteCloser{encryptedWriter, pipeWriter}}
 	}
 
-	return &CascadeWriteCloser{uploader.compressor.NewWriter(pipeWriter), pipeWriter}
+	return &CascadeWriteCloser{compressor.NewWriter(pipeWriter), pipeWriter}
 }
 
 // Size accumulated in this tarball
@@ -121,17 +145,32 @@ func (tarBall *StorageTarBall) AddSize(i int64) { tarBall.size += i }
 
 func (tarBall *StorageTarBall) TarWriter() *tar.Writer { return tarBall.tarWriter }
 
+// Name of the tar part in tar_partitions folder, known after SetUp
+func (tarBall *StorageTarBall) Name() string { return tarBall.name }
+
 // Finish writes a .json file description and uploads it with the
 // the backup name. Finish will wait until all tar file parts
 // have been uploaded. The json file will only be uploaded
 // if all other parts of the backup are present in storage.
 // an alert is given with the corresponding error.
+// Sentinel lists all uploaded tar parts with their sizes and checksums.
 func (tarBall *StorageTarBall) Finish(sentinelDto *BackupSentinelDto) error {
 	name := tarBall.backupName + SentinelSuffix
 	uploader := tarBall.uploader
 
 	uploader.finish()
 
+	if tarBall.parts != nil {
+		failedParts := tarBall.parts.FailedParts()
+		if len(failedParts) > 0 {
+			tracelog.ErrorLogger.Printf("Sentinel was not uploaded %v", name)
+			return NewTarPartsNotUploadedError(failedParts)
+		}
+		if sentinelDto != nil {
+			sentinelDto.TarParts = tarBall.parts.Parts()
+		}
+	}
+
 	var err error
 	// If other parts are successful in uploading, upload json file.
 	if uploader.Success && sentinelDto != nil {
@@ -5,10 +5,11 @@ type StorageTarBallMaker struct {
 	partCount  int
 	backupName string
 	uploader   *Uploader
+	parts      *TarPartRegistry
 }
 
 func NewStorageTarBallMaker(backupName string, uploader *Uploader) *StorageTarBallMaker {
-	return &StorageTarBallMaker{0, backupName, uploader}
+	return &StorageTarBallMaker{0, backupName, uploader, NewTarPartRegistry()}
 }
 
 // Make returns a tarball with required storage fields.
@@ -22,5 +23,6 @@ func (tarBallMaker *StorageTarBallMaker) Make(dedicatedUploader bool) TarBall {
 		partNumber: tarBallMaker.partCount,
 		backupName: tarBallMaker.backupName,
 		uploader:   uploader,
+		parts:      tarBallMaker.parts,
 	}
 }
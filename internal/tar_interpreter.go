@@ -22,10 +22,12 @@ type FileTarInterpreter struct {
 	DBDataDirectory string
 	Sentinel        BackupSentinelDto
 	FilesToUnwrap   map[string]bool
+	// Owner overrides ownership of restored files if set
+	Owner *FileOwner
 }
 
-func NewFileTarInterpreter(dbDataDirectory string, sentinel BackupSentinelDto, filesToUnwrap map[string]bool) *FileTarInterpreter {
-	return &FileTarInterpreter{dbDataDirectory, sentinel, filesToUnwrap}
+func NewFileTarInterpreter(dbDataDirectory string, sentinel BackupSentinelDto, filesToUnwrap map[string]bool, owner *FileOwner) *FileTarInterpreter {
+	return &FileTarInterpreter{dbDataDirectory, sentinel, filesToUnwrap, owner}
 }
 
 // TODO : unit tests
@@ -35,7 +37,10 @@ func (tarInterpreter *FileTarInterpreter) unwrapRegularFile(fileReader io.Reader
 	// If this file is incremental we use it's base version from incremental path
 	if haveFileDescription && tarInterpreter.Sentinel.isIncremental() && fileDescription.IsIncremented {
 		err := ApplyFileIncrement(targetPath, fileReader)
-		return errors.Wrapf(err, "Interpret: failed to apply increment for '%s'", targetPath)
+		if err != nil {
+			return errors.Wrapf(err, "Interpret: failed to apply increment for '%s'", targetPath)
+		}
+		return applyFileAttributes(targetPath, fileInfo, tarInterpreter.Owner)
 	}
 	if _, ok := tarInterpreter.FilesToUnwrap[fileInfo.Name]; !ok {
 		// don't have to unwrap it this time
@@ -51,7 +56,7 @@ func (tarInterpreter *FileTarInterpreter) unwrapRegularFile(fileReader io.Reader
 		return errors.Wrapf(err, "failed to create new file: '%s'", targetPath)
 	}
 
-	_, err = io.Copy(file, fileReader)
+	_, err = io.Copy(NewDiskLimitWriter(file), fileReader)
 	if err != nil {
 		err1 := file.Close()
 		if err1 != nil {
@@ -69,6 +74,9 @@ func (tarInterpreter *FileTarInterpreter) unwrapRegularFile(fileReader io.Reader
 	if err = os.Chmod(file.Name(), mode); err != nil {
 		return errors.Wrap(err, "Interpret: chmod failed")
 	}
+	if err = applyFileAttributes(targetPath, fileInfo, tarInterpreter.Owner); err != nil {
+		return errors.Wrap(err, "Interpret: failed to restore file attributes")
+	}
 
 	err = file.Sync()
 	return errors.Wrap(err, "Interpret: fsync failed")
@@ -92,14 +100,25 @@ func (tarInterpreter *FileTarInterpreter) Interpret(fileReader io.Reader, fileIn
 		if err = os.Chmod(targetPath, os.FileMode(fileInfo.Mode)); err != nil {
 			return errors.Wrap(err, "Interpret: chmod failed")
 		}
+		return applyFileAttributes(targetPath, fileInfo, tarInterpreter.Owner)
 	case tar.TypeLink:
-		if err := os.Link(fileInfo.Name, targetPath); err != nil {
+		err := prepareDirs(fileInfo.Name, targetPath)
+		if err != nil {
+			return errors.Wrap(err, "Interpret: failed to create all directories")
+		}
+		// Hardlink name is relative to archive root, same as file names
+		if err = os.Link(path.Join(tarInterpreter.DBDataDirectory, fileInfo.Linkname), targetPath); err != nil {
 			return errors.Wrapf(err, "Interpret: failed to create hardlink %s", targetPath)
 		}
 	case tar.TypeSymlink:
-		if err := os.Symlink(fileInfo.Name, targetPath); err != nil {
+		err := prepareDirs(fileInfo.Name, targetPath)
+		if err != nil {
+			return errors.Wrap(err, "Interpret: failed to create all directories")
+		}
+		if err = os.Symlink(fileInfo.Linkname, targetPath); err != nil {
 			return errors.Wrapf(err, "Interpret: failed to create symlink %s", targetPath)
 		}
+		return applyFileAttributes(targetPath, fileInfo, tarInterpreter.Owner)
 	}
 	return nil
 }
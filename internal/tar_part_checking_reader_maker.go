@@ -0,0 +1,81 @@
+package internal
+
+import (
+	"fmt"
+	"github.com/pkg/errors"
+	"github.com/x4m/wal-g/internal/tracelog"
+	"io"
+	"io/ioutil"
+)
+
+type TarPartCorruptedError struct {
+	error
+}
+
+func NewTarPartCorruptedError(path string, expected TarPartDescription, actualSize int64, actualMD5 string) TarPartCorruptedError {
+	return TarPartCorruptedError{errors.Errorf("Tar part '%s' does not match sentinel: expected size %d md5 '%s', got size %d md5 '%s'",
+		path, expected.Size, expected.MD5, actualSize, actualMD5)}
+}
+
+func (err TarPartCorruptedError) Error() string {
+	return fmt.Sprintf(tracelog.GetErrorFormatter(), err.error)
+}
+
+// TarPartCheckingReaderMaker wraps readers of tar part so that its size
+// and checksum are verified against the sentinel once the part is read completely.
+type TarPartCheckingReaderMaker struct {
+	underlying  ReaderMaker
+	description TarPartDescription
+}
+
+func NewTarPartCheckingReaderMaker(underlying ReaderMaker, description TarPartDescription) *TarPartCheckingReaderMaker {
+	return &TarPartCheckingReaderMaker{underlying, description}
+}
+
+func (readerMaker *TarPartCheckingReaderMaker) Path() string { return readerMaker.underlying.Path() }
+
+func (readerMaker *TarPartCheckingReaderMaker) Reader() (io.ReadCloser, error) {
+	reader, err := readerMaker.underlying.Reader()
+	if err != nil {
+		return nil, err
+	}
+	return &tarPartCheckingReader{reader, newMd5Reader(reader), readerMaker.Path(), readerMaker.description, false}, nil
+}
+
+type tarPartCheckingReader struct {
+	underlying  io.Closer
+	md5Reader   *MD5Reader
+	path        string
+	description TarPartDescription
+	finished    bool
+}
+
+func (reader *tarPartCheckingReader) Read(p []byte) (n int, err error) {
+	n, err = reader.md5Reader.Read(p)
+	if reader.md5Reader.Size() > reader.description.Size {
+		return n, reader.corruptedError()
+	}
+	if err == io.EOF {
+		reader.finished = true
+		if reader.md5Reader.Size() != reader.description.Size ||
+			(reader.description.MD5 != "" && reader.md5Reader.Sum() != reader.description.MD5) {
+			return n, reader.corruptedError()
+		}
+	}
+	return
+}
+
+// Close reads the rest of the part if decompressor has not done so,
+// so that the part is verified in any case.
+func (reader *tarPartCheckingReader) Close() error {
+	defer reader.underlying.Close()
+	if reader.finished {
+		return nil
+	}
+	_, err := io.Copy(ioutil.Discard, reader)
+	return err
+}
+
+func (reader *tarPartCheckingReader) corruptedError() error {
+	return NewTarPartCorruptedError(reader.path, reader.description, reader.md5Reader.Size(), reader.md5Reader.Sum())
+}
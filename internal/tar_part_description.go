@@ -0,0 +1,11 @@
+package internal
+
+// TarPartDescription describes one tar part of a backup
+// as it was uploaded to storage.
+type TarPartDescription struct {
+	Size int64
+	MD5  string `json:",omitempty"`
+}
+
+// TarPartList maps tar part names from tar_partitions folder to their descriptions
+type TarPartList map[string]TarPartDescription
@@ -0,0 +1,51 @@
+package internal
+
+import (
+	"sort"
+	"sync"
+)
+
+// TarPartRegistry collects descriptions of tar parts uploaded by
+// all tarballs of a single backup. It is shared between tarballs
+// produced by one StorageTarBallMaker.
+type TarPartRegistry struct {
+	mutex       sync.Mutex
+	parts       TarPartList
+	failedParts []string
+}
+
+func NewTarPartRegistry() *TarPartRegistry {
+	return &TarPartRegistry{parts: make(TarPartList)}
+}
+
+func (registry *TarPartRegistry) register(name string, description TarPartDescription) {
+	registry.mutex.Lock()
+	defer registry.mutex.Unlock()
+	registry.parts[name] = description
+}
+
+func (registry *TarPartRegistry) registerFailure(name string) {
+	registry.mutex.Lock()
+	defer registry.mutex.Unlock()
+	registry.failedParts = append(registry.failedParts, name)
+}
+
+// FailedParts returns sorted names of parts which were not uploaded
+func (registry *TarPartRegistry) FailedParts() []string {
+	registry.mutex.Lock()
+	defer registry.mutex.Unlock()
+	result := append([]string(nil), registry.failedParts...)
+	sort.Strings(result)
+	return result
+}
+
+// Parts returns a copy of uploaded parts list
+func (registry *TarPartRegistry) Parts() TarPartList {
+	registry.mutex.Lock()
+	defer registry.mutex.Unlock()
+	result := make(TarPartList, len(registry.parts))
+	for name, description := range registry.parts {
+		result[name] = description
+	}
+	return result
+}
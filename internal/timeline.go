@@ -6,19 +6,11 @@ import (
 	"github.com/pkg/errors"
 	"github.com/x4m/wal-g/internal/tracelog"
 	"strconv"
+	"strings"
 )
 
-type BytesPerWalSegmentError struct {
-	error
-}
-
-func NewBytesPerWalSegmentError() BytesPerWalSegmentError {
-	return BytesPerWalSegmentError{errors.New("bytes_per_wal_segment of the server does not match expected value")}
-}
-
-func (err BytesPerWalSegmentError) Error() string {
-	return fmt.Sprintf(tracelog.GetErrorFormatter(), err.error)
-}
+// partialSuffix marks the last segment of old timeline, which is archived on promotion
+const partialSuffix = ".partial"
 
 type IncorrectLogSegNoError struct {
 	error
@@ -32,14 +24,10 @@ func (err IncorrectLogSegNoError) Error() string {
 	return fmt.Sprintf(tracelog.GetErrorFormatter(), err.error)
 }
 
+// readTimeline reads current timeline of the server
 func readTimeline(conn *pgx.Conn) (timeline uint32, err error) {
-	var bytesPerWalSegment uint32
-
 	// TODO: Check if this logic can be moved to queryRunner or abstracted away somehow
-	err = conn.QueryRow("select timeline_id, bytes_per_wal_segment from pg_control_checkpoint(), pg_control_init()").Scan(&timeline, &bytesPerWalSegment)
-	if err == nil && uint64(bytesPerWalSegment) != WalSegmentSize {
-		return 0, NewBytesPerWalSegmentError()
-	}
+	err = conn.QueryRow("select timeline_id from pg_control_checkpoint()").Scan(&timeline)
 	return
 }
 
@@ -48,10 +36,15 @@ const (
 )
 
 const (
-	// WalSegmentSize is the size of one WAL file
-	WalSegmentSize = uint64(16 * 1024 * 1024) // xlog.c line 113ß
+	// DefaultWalSegmentSize is the size of one WAL file, unless cluster is initialized with --wal-segsize
+	DefaultWalSegmentSize = uint64(16 * 1024 * 1024) // xlog.c line 113ß
 
-	walFileFormat         = "%08X%08X%08X"               // xlog_internal.h line 155
+	walFileFormat = "%08X%08X%08X" // xlog_internal.h line 155
+)
+
+// WalSegmentSize is the size of one WAL file of the cluster, see SetPostgresSizes
+var (
+	WalSegmentSize        = DefaultWalSegmentSize
 	xLogSegmentsPerXLogId = 0x100000000 / WalSegmentSize // xlog_internal.h line 101
 )
 
@@ -112,6 +105,24 @@ func isWalFilename(filename string) bool {
 	return err == nil
 }
 
+// isArchiveFilename tells whether postgres archives files of such name from pg_wal:
+// WAL segments, timeline histories, partial segments and backup history files
+func isArchiveFilename(filename string) bool {
+	if _, ok := parseHistoryFilename(filename); ok || isWalFilename(filename) {
+		return true
+	}
+	if strings.HasSuffix(filename, partialSuffix) {
+		return isWalFilename(strings.TrimSuffix(filename, partialSuffix))
+	}
+	// backup history file is named like 000000010000000000000002.00000028.backup
+	parts := strings.Split(filename, ".")
+	if len(parts) != 3 || parts[2] != "backup" || len(parts[1]) != 8 || !isWalFilename(parts[0]) {
+		return false
+	}
+	_, err := strconv.ParseUint(parts[1], 0x10, sizeofInt32bits)
+	return err == nil
+}
+
 // GetNextWalFilename computes name of next WAL segment
 func GetNextWalFilename(name string) (string, error) {
 	timelineId, logSegNo, err := ParseWALFilename(name)
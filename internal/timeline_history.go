@@ -0,0 +1,264 @@
+package internal
+
+import (
+	"bufio"
+	"fmt"
+	"github.com/jackc/pgx"
+	"github.com/pkg/errors"
+	"github.com/x4m/wal-g/internal/tracelog"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	historyFileSuffix = ".history"
+	historyFileFormat = "%08X" + historyFileSuffix // xlog_internal.h line 215
+)
+
+type InvalidTimelineHistoryError struct {
+	error
+}
+
+func NewInvalidTimelineHistoryError(line string) InvalidTimelineHistoryError {
+	return InvalidTimelineHistoryError{errors.Errorf("Invalid timeline history line: '%s'", line)}
+}
+
+func (err InvalidTimelineHistoryError) Error() string {
+	return fmt.Sprintf(tracelog.GetErrorFormatter(), err.error)
+}
+
+type UnreachableBackupStartError struct {
+	error
+}
+
+func NewUnreachableBackupStartError(startTimeline uint32, startLsn uint64, finishTimeline uint32) UnreachableBackupStartError {
+	return UnreachableBackupStartError{errors.Errorf("backup start %s on timeline %d is not in history of timeline %d, where backup is finished",
+		formatLsn(startLsn), startTimeline, finishTimeline)}
+}
+
+func (err UnreachableBackupStartError) Error() string {
+	return fmt.Sprintf(tracelog.GetErrorFormatter(), err.error)
+}
+
+// TimelineHistoryRecord tells that timeline ended at SwitchLSN, and next timeline started from there
+type TimelineHistoryRecord struct {
+	Timeline  uint32 `json:"Timeline"`
+	SwitchLSN uint64 `json:"SwitchLSN"`
+	Reason    string `json:"Reason,omitempty"`
+}
+
+// TimelineHistory is a list of ancestors of the timeline ordered from the oldest one
+type TimelineHistory []TimelineHistoryRecord
+
+// ParseTimelineHistory parses content of NNNNNNNN.history file, see timeline.c readTimeLineHistory()
+func ParseTimelineHistory(reader io.Reader) (TimelineHistory, error) {
+	history := make(TimelineHistory, 0)
+	scanner := bufio.NewScanner(reader)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		// Comments and empty lines are allowed in history files
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.SplitN(line, "\t", 3)
+		if len(fields) < 2 {
+			return nil, NewInvalidTimelineHistoryError(line)
+		}
+		timeline, err := strconv.ParseUint(strings.TrimSpace(fields[0]), 10, sizeofInt32bits)
+		if err != nil {
+			return nil, NewInvalidTimelineHistoryError(line)
+		}
+		switchLSN, err := pgx.ParseLSN(strings.TrimSpace(fields[1]))
+		if err != nil {
+			return nil, NewInvalidTimelineHistoryError(line)
+		}
+		record := TimelineHistoryRecord{Timeline: uint32(timeline), SwitchLSN: switchLSN}
+		if len(fields) == 3 {
+			record.Reason = strings.TrimSpace(fields[2])
+		}
+		history = append(history, record)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, errors.Wrap(err, "failed to read timeline history")
+	}
+	return history, nil
+}
+
+func formatHistoryFileName(timeline uint32) string {
+	return fmt.Sprintf(historyFileFormat, timeline)
+}
+
+// parseHistoryFilename extracts timeline from history file name like 00000002.history
+func parseHistoryFilename(name string) (timeline uint32, ok bool) {
+	if len(name) != 8+len(historyFileSuffix) || !strings.HasSuffix(name, historyFileSuffix) {
+		return 0, false
+	}
+	timeline64, err := strconv.ParseUint(name[0:8], 0x10, sizeofInt32bits)
+	if err != nil {
+		return 0, false
+	}
+	return uint32(timeline64), true
+}
+
+// TODO : unit tests
+// fetchTimelineHistory downloads history of the timeline from WAL folder.
+// The first timeline has no history file and no ancestors.
+func fetchTimelineHistory(walFolder StorageFolder, timeline uint32) (TimelineHistory, error) {
+	if timeline <= 1 {
+		return TimelineHistory{}, nil
+	}
+	reader, err := downloadAndDecompressWALFile(walFolder, formatHistoryFileName(timeline))
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+	return ParseTimelineHistory(reader)
+}
+
+// TimelineForSegment finds which timeline of the history contains the segment,
+// when the history leads to the timeline. Segment with the switch point
+// is read from the new timeline, see xlog.c XLogFileReadAnyTLI()
+func (history TimelineHistory) TimelineForSegment(timeline uint32, logSegNo uint64) uint32 {
+	for i := len(history) - 1; i >= 0; i-- {
+		if logSegNo >= history[i].SwitchLSN/WalSegmentSize {
+			if i == len(history)-1 {
+				return timeline
+			}
+			return history[i+1].Timeline
+		}
+	}
+	if len(history) > 0 {
+		return history[0].Timeline
+	}
+	return timeline
+}
+
+// timelineSegments is the range of WAL segments, which are read from one timeline
+type timelineSegments struct {
+	timeline     uint32
+	logSegNo     uint64
+	lastLogSegNo uint64
+}
+
+// splitSegments splits segments from logSegNo to lastLogSegNo by timelines, which the history leads through.
+// Segments are assigned to timelines as TimelineForSegment does.
+func (history TimelineHistory) splitSegments(timeline uint32, logSegNo uint64, lastLogSegNo uint64) []timelineSegments {
+	ranges := make([]timelineSegments, 0)
+	for i := 0; i <= len(history) && logSegNo <= lastLogSegNo; i++ {
+		rangeTimeline, rangeLastLogSegNo := timeline, lastLogSegNo
+		if i < len(history) {
+			switchLogSegNo := history[i].SwitchLSN / WalSegmentSize
+			if switchLogSegNo <= logSegNo {
+				continue
+			}
+			rangeTimeline = history[i].Timeline
+			if switchLogSegNo-1 < rangeLastLogSegNo {
+				rangeLastLogSegNo = switchLogSegNo - 1
+			}
+		}
+		ranges = append(ranges, timelineSegments{rangeTimeline, logSegNo, rangeLastLogSegNo})
+		logSegNo = rangeLastLogSegNo + 1
+	}
+	return ranges
+}
+
+// SwitchLSN returns LSN where history leaves the ancestor timeline and false if it is not an ancestor
+func (history TimelineHistory) SwitchLSN(ancestor uint32) (uint64, bool) {
+	for _, record := range history {
+		if record.Timeline == ancestor {
+			return record.SwitchLSN, true
+		}
+	}
+	return 0, false
+}
+
+// ValidateTimelineSwitch checks that backup started at startLsn on startTimeline is finished on the timeline,
+// which history leads through the start point, and returns switches of timeline made during the backup
+func ValidateTimelineSwitch(history TimelineHistory, startTimeline uint32, startLsn uint64, finishTimeline uint32) (TimelineHistory, error) {
+	if startTimeline == finishTimeline {
+		return TimelineHistory{}, nil
+	}
+	for i, record := range history {
+		if record.Timeline == startTimeline {
+			if startLsn > record.SwitchLSN {
+				break
+			}
+			return history[i:], nil
+		}
+	}
+	return nil, NewUnreachableBackupStartError(startTimeline, startLsn, finishTimeline)
+}
+
+// sortedTimelines returns timelines of the map in ascending order
+func sortedTimelines(histories map[uint32]TimelineHistory) []uint32 {
+	timelines := make([]uint32, 0, len(histories))
+	for timeline := range histories {
+		timelines = append(timelines, timeline)
+	}
+	sort.Slice(timelines, func(i, j int) bool { return timelines[i] < timelines[j] })
+	return timelines
+}
+
+// TODO : unit tests
+// FindTargetTimeline finds the newest timeline, which continues through the segment,
+// probing history files of next timelines like PostgreSQL findNewestTimeLine() does.
+// If there is no such timeline, timeline of the segment is returned with empty history.
+func FindTargetTimeline(walFolder StorageFolder, walFileName string) (uint32, TimelineHistory, error) {
+	timeline, logSegNo, err := ParseWALFilename(walFileName)
+	if err != nil {
+		return 0, nil, err
+	}
+	targetTimeline, targetHistory := timeline, TimelineHistory{}
+	for nextTimeline := timeline + 1; ; nextTimeline++ {
+		history, err := fetchTimelineHistory(walFolder, nextTimeline)
+		if _, ok := err.(ArchiveNonExistenceError); ok {
+			break
+		}
+		if err != nil {
+			return 0, nil, err
+		}
+		if history.TimelineForSegment(nextTimeline, logSegNo) == timeline {
+			targetTimeline, targetHistory = nextTimeline, history
+		}
+	}
+	return targetTimeline, targetHistory, nil
+}
+
+// GetNextWalFilenames computes names of count segments following the segment,
+// switching timelines as the history of target timeline does
+func GetNextWalFilenames(walFileName string, targetTimeline uint32, history TimelineHistory, count int) ([]string, error) {
+	_, logSegNo, err := ParseWALFilename(walFileName)
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, 0, count)
+	for i := 1; i <= count; i++ {
+		nextLogSegNo := logSegNo + uint64(i)
+		names = append(names, formatWALFileName(history.TimelineForSegment(targetTimeline, nextLogSegNo), nextLogSegNo))
+	}
+	return names, nil
+}
+
+// TODO : unit tests
+// getAncestorWalFilename returns name of the same segment on ancestor timeline,
+// if the segment lies before the switch to the timeline of walFileName
+func getAncestorWalFilename(walFolder StorageFolder, walFileName string) (string, bool, error) {
+	timeline, logSegNo, err := ParseWALFilename(walFileName)
+	if err != nil {
+		return "", false, nil
+	}
+	history, err := fetchTimelineHistory(walFolder, timeline)
+	if _, ok := err.(ArchiveNonExistenceError); ok {
+		return "", false, nil
+	}
+	if err != nil {
+		return "", false, err
+	}
+	ancestor := history.TimelineForSegment(timeline, logSegNo)
+	if ancestor == timeline {
+		return "", false, nil
+	}
+	return formatWALFileName(ancestor, logSegNo), true, nil
+}
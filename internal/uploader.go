@@ -11,20 +11,27 @@ import (
 // Uploader contains fields associated with uploading tarballs.
 // Multiple tarballs can share one uploader.
 type Uploader struct {
-	uploadingFolder     StorageFolder
-	compressor          Compressor
+	uploadingFolder StorageFolder
+	compressor      Compressor
+	// walCompressor compresses WAL segments, when it differs from compressor, see useCurrentZstdDictionary
+	walCompressor       Compressor
 	waitGroup           *sync.WaitGroup
 	deltaFileManager    *DeltaFileManager
+	crypter             *OpenPGPCrypter
 	Success             bool
 	useWalDelta         bool
 	preventWalOverwrite bool
+	// useWalTimeIndex makes uploader store time index of each WAL file, see WalTimeIndex
+	useWalTimeIndex bool
+	// TarCompressionConcurrency is the number of goroutines compressing each tar part, see ParallelCompressor
+	TarCompressionConcurrency int
 }
 
 func NewUploader(
 	compressor Compressor,
 	uploadingLocation StorageFolder,
 	deltaDataFolder DataFolder,
-	useWalDelta, preventWalOverwrite bool,
+	useWalDelta, preventWalOverwrite, useWalTimeIndex bool,
 ) *Uploader {
 	var deltaFileManager *DeltaFileManager = nil
 	if useWalDelta {
@@ -36,7 +43,9 @@ func NewUploader(
 		useWalDelta:         useWalDelta,
 		waitGroup:           &sync.WaitGroup{},
 		deltaFileManager:    deltaFileManager,
+		crypter:             &OpenPGPCrypter{},
 		preventWalOverwrite: preventWalOverwrite,
+		useWalTimeIndex:     useWalTimeIndex,
 	}
 }
 
@@ -54,14 +63,43 @@ func (uploader *Uploader) Clone() *Uploader {
 	return &Uploader{
 		uploader.uploadingFolder,
 		uploader.compressor,
+		uploader.walCompressor,
 		&sync.WaitGroup{},
 		uploader.deltaFileManager,
+		uploader.crypter,
 		uploader.Success,
 		uploader.useWalDelta,
 		uploader.preventWalOverwrite,
+		uploader.useWalTimeIndex,
+		uploader.TarCompressionConcurrency,
 	}
 }
 
+// useCurrentZstdDictionary makes WAL segments compressed with dictionary trained by wal-dict-train.
+// WAL is pushed without dictionary, if it can not be loaded.
+func (uploader *Uploader) useCurrentZstdDictionary() {
+	compressor, ok := uploader.compressor.(ZstdCompressor)
+	if !ok {
+		return
+	}
+	dictionary, err := LoadCurrentZstdDictionary(uploader.uploadingFolder)
+	if err != nil {
+		tracelog.WarningLogger.Printf("Failed to load zstd dictionary, WAL is compressed without it: %v\n", err)
+		return
+	}
+	if dictionary != nil {
+		uploader.walCompressor = ZstdCompressor{Level: compressor.Level, Dictionary: dictionary}
+	}
+}
+
+// prepareCrypter loads encryption keys once, so crypter can be shared by concurrent uploads
+func (uploader *Uploader) prepareCrypter() error {
+	if uploader.crypter.IsUsed() {
+		return uploader.crypter.loadPubKey()
+	}
+	return nil
+}
+
 // TODO : unit tests
 func (uploader *Uploader) UploadWalFile(file NamedReader) error {
 	var walFileReader io.Reader
@@ -79,20 +117,42 @@ func (uploader *Uploader) UploadWalFile(file NamedReader) error {
 		walFileReader = file
 	}
 
-	return uploader.UploadFile(&NamedReaderImpl{walFileReader, file.Name()})
+	compressor := uploader.compressor
+	if uploader.walCompressor != nil && isWalFilename(filename) {
+		compressor = uploader.walCompressor
+	}
+	if !uploader.useWalTimeIndex || !isWalFilename(filename) {
+		return uploader.uploadFileWith(&NamedReaderImpl{walFileReader, file.Name()}, compressor)
+	}
+	indexingReader := NewWalTimeIndexingReader(walFileReader)
+	err := uploader.uploadFileWith(&NamedReaderImpl{indexingReader, file.Name()}, compressor)
+	if err != nil {
+		return err
+	}
+	if indexingReader.Err == nil {
+		indexingReader.Err = uploadWalTimeIndex(uploader.uploadingFolder, filename, &indexingReader.Index)
+	}
+	if indexingReader.Err != nil {
+		logWalTimeIndexingError(filename, indexingReader.Err)
+	}
+	return nil
 }
 
 // TODO : unit tests
 // UploadFile compresses a file and uploads it.
 func (uploader *Uploader) UploadFile(file NamedReader) error {
+	return uploader.uploadFileWith(file, uploader.compressor)
+}
+
+func (uploader *Uploader) uploadFileWith(file NamedReader, compressor Compressor) error {
 	pipeWriter := &CompressingPipeWriter{
 		Input:                file,
-		NewCompressingWriter: uploader.compressor.NewWriter,
+		NewCompressingWriter: compressor.NewWriter,
 	}
 
-	pipeWriter.Compress(&OpenPGPCrypter{})
+	pipeWriter.Compress(uploader.crypter)
 
-	dstPath := sanitizePath(filepath.Base(file.Name()) + "." + uploader.compressor.FileExtension())
+	dstPath := sanitizePath(filepath.Base(file.Name()) + "." + compressor.FileExtension())
 	reader := pipeWriter.Output
 
 	err := uploader.upload(dstPath, reader)
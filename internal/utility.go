@@ -49,6 +49,13 @@ func ToBytes(x interface{}) []byte {
 	return buf.Bytes()
 }
 
+func concatByteSlices(a []byte, b []byte) []byte {
+	result := make([]byte, len(a)+len(b))
+	copy(result, a)
+	copy(result[len(a):], b)
+	return result
+}
+
 func allZero(s []byte) bool {
 	for _, v := range s {
 		if v != 0 {
@@ -120,6 +127,10 @@ func getMaxUploadDiskConcurrency() int {
 	return getMaxConcurrency("WALG_UPLOAD_DISK_CONCURRENCY", 1)
 }
 
+func getCompressionConcurrency() int {
+	return getMaxConcurrency("WALG_COMPRESSION_CONCURRENCY", 1)
+}
+
 // TODO : unit tests
 func getMaxConcurrency(key string, defaultValue int) int {
 	var con int
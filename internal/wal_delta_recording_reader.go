@@ -52,7 +52,17 @@ func NewWalDeltaRecordingReader(walFileReader io.Reader, walFilename string, man
 }
 
 func (reader *WalDeltaRecordingReader) Close() error {
-	err := reader.partRecorder.SaveNextWalHead(reader.WalParser.GetCurrentRecordData())
+	err := reader.partRecorder.SavePageMagic(reader.WalParser.PageMagic())
+	if err != nil {
+		tracelog.WarningLogger.Printf("Failed to save WAL page magic after end of recording because of: %v", err)
+	}
+	recordData := reader.WalParser.GetCurrentRecordData()
+	if len(recordData) > 0 && !reader.WalParser.HasCurrentRecordBeginning() {
+		// no record starts in this WAL file
+		err = reader.partRecorder.SaveContinuedWal(recordData)
+	} else {
+		err = reader.partRecorder.SaveNextWalHead(recordData)
+	}
 	if err != nil {
 		tracelog.WarningLogger.Printf("Failed to save next wal file prefix after end of recording because of: %v", err)
 	}
@@ -31,6 +31,14 @@ func GetDeltaFilenameFor(walFilename string) (string, error) {
 	return toDeltaFilename(formatWALFileName(timeline, deltaSegNo)), nil
 }
 
+func getNextDeltaFilename(deltaFilename string) (string, error) {
+	timeline, logSegNo, err := ParseWALFilename(strings.TrimSuffix(deltaFilename, DeltaFilenameSuffix))
+	if err != nil {
+		return "", err
+	}
+	return toDeltaFilename(formatWALFileName(timeline, logSegNo+WalFileInDelta)), nil
+}
+
 func GetPositionInDelta(walFilename string) int {
 	_, logSegNo, _ := ParseWALFilename(walFilename)
 	return int(logSegNo % uint64(WalFileInDelta))
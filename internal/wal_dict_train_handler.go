@@ -0,0 +1,138 @@
+package internal
+
+import (
+	"github.com/pkg/errors"
+	"github.com/x4m/wal-g/internal/tracelog"
+	"github.com/x4m/wal-g/internal/walparser"
+	"io/ioutil"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	WalDictTrainSegmentsFlag = "--segments"
+	WalDictTrainSizeFlag     = "--size"
+
+	DefaultWalDictTrainSegments = 16
+	// DefaultZstdDictionarySize is the default dictionary size of zstd command line tool
+	DefaultZstdDictionarySize = 110 << 10
+)
+
+// ZstdDictionarySampleSize limits size of WAL pages, which dictionary is trained on,
+// training takes about ten times more memory
+var ZstdDictionarySampleSize = 32 << 20
+
+// WalDictTrainCommandArguments are arguments of wal-dict-train
+type WalDictTrainCommandArguments struct {
+	Segments int
+	Size     int
+}
+
+// ParseWalDictTrainArguments parses "[--segments count] [--size bytes]"
+func ParseWalDictTrainArguments(args []string) (WalDictTrainCommandArguments, error) {
+	result := WalDictTrainCommandArguments{DefaultWalDictTrainSegments, DefaultZstdDictionarySize}
+	for i := 0; i < len(args); i++ {
+		switch args[i] {
+		case WalDictTrainSegmentsFlag, WalDictTrainSizeFlag:
+			if i+1 == len(args) {
+				return result, errors.Errorf("%s value is not specified", args[i])
+			}
+			value, err := strconv.Atoi(args[i+1])
+			if err != nil {
+				return result, err
+			}
+			if value <= 0 {
+				return result, errors.Errorf("%s value should be positive", args[i])
+			}
+			if args[i] == WalDictTrainSegmentsFlag {
+				result.Segments = value
+			} else {
+				result.Size = value
+			}
+			i++
+		default:
+			return result, errors.Errorf("unexpected argument '%s'", args[i])
+		}
+	}
+	return result, nil
+}
+
+// getLatestArchivedWalSegments returns names of the latest WAL segments in storage, the latest goes first
+func getLatestArchivedWalSegments(walFolder StorageFolder, count int) ([]string, error) {
+	objects, _, err := walFolder.ListFolder()
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, 0, len(objects))
+	for _, object := range objects {
+		name := object.GetName()
+		for _, decompressor := range Decompressors {
+			walFileName := strings.TrimSuffix(name, "."+decompressor.FileExtension())
+			if walFileName != name && isWalFilename(walFileName) {
+				names = append(names, walFileName)
+			}
+		}
+	}
+	sort.Sort(sort.Reverse(sort.StringSlice(names)))
+	if len(names) > count {
+		names = names[:count]
+	}
+	return names, nil
+}
+
+// sampleWalPages takes evenly spaced pages of WAL segment up to sampleSize bytes, zero pages are skipped
+func sampleWalPages(segment []byte, sampleSize int) [][]byte {
+	pageSize := int(walparser.WalPageSize)
+	pageCount := len(segment) / pageSize
+	step := (pageCount*pageSize + sampleSize - 1) / sampleSize
+	if step < 1 {
+		step = 1
+	}
+	samples := make([][]byte, 0, pageCount/step+1)
+	for i := 0; i < pageCount; i += step {
+		page := segment[i*pageSize : (i+1)*pageSize]
+		if !allZero(page) {
+			samples = append(samples, page)
+		}
+	}
+	return samples
+}
+
+// TrainWalDictionary trains zstd dictionary on pages of the latest archived WAL segments and makes it current
+func TrainWalDictionary(walFolder StorageFolder, arguments WalDictTrainCommandArguments) (uint32, error) {
+	walFileNames, err := getLatestArchivedWalSegments(walFolder, arguments.Segments)
+	if err != nil {
+		return 0, err
+	}
+	if len(walFileNames) == 0 {
+		return 0, errors.New("no WAL segments are archived to train zstd dictionary")
+	}
+	samples := make([][]byte, 0)
+	for _, walFileName := range walFileNames {
+		reader, err := downloadAndDecompressWALFile(walFolder, walFileName)
+		if err != nil {
+			return 0, err
+		}
+		segment, err := ioutil.ReadAll(reader)
+		reader.Close()
+		if err != nil {
+			return 0, errors.Wrapf(err, "failed to read WAL file '%s'", walFileName)
+		}
+		samples = append(samples, sampleWalPages(segment, ZstdDictionarySampleSize/len(walFileNames))...)
+	}
+	dictionary, err := TrainZstdDictionary(samples, arguments.Size)
+	if err != nil {
+		return 0, err
+	}
+	return UploadZstdDictionary(walFolder, dictionary)
+}
+
+// TODO : unit tests
+func HandleWalDictTrain(folder StorageFolder, arguments WalDictTrainCommandArguments) {
+	id, err := TrainWalDictionary(folder.GetSubFolder(WalPath), arguments)
+	if err != nil {
+		tracelog.ErrorLogger.FatalError(err)
+	}
+	tracelog.InfoLogger.Printf("zstd dictionary %d is used by wal-push from now on\n", id)
+}
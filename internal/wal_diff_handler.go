@@ -0,0 +1,247 @@
+package internal
+
+import (
+	"fmt"
+	"github.com/jackc/pgx"
+	"github.com/pkg/errors"
+	"github.com/x4m/wal-g/internal/tracelog"
+	"github.com/x4m/wal-g/internal/walparser"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	WalDiffJsonFlag     = "--json"
+	WalDiffTimelineFlag = "--timeline"
+)
+
+type WalDiffTimelineError struct {
+	error
+}
+
+func NewWalDiffTimelineError(fromTimeline uint32, toTimeline uint32) WalDiffTimelineError {
+	return WalDiffTimelineError{errors.Errorf("Range ends are on timelines %d and %d, "+
+		"changes are reported only within one timeline", fromTimeline, toTimeline)}
+}
+
+func (err WalDiffTimelineError) Error() string {
+	return fmt.Sprintf(tracelog.GetErrorFormatter(), err.error)
+}
+
+// WalDiffCommandArguments are arguments of wal-diff, each end of the range is either LSN or backup name
+type WalDiffCommandArguments struct {
+	From     string
+	To       string
+	Timeline uint32
+	Json     bool
+}
+
+// ParseWalDiffArguments parses "from to [--timeline timeline] [--json]"
+func ParseWalDiffArguments(args []string) (WalDiffCommandArguments, error) {
+	result := WalDiffCommandArguments{}
+	positional := make([]string, 0, 2)
+	for i := 0; i < len(args); i++ {
+		switch args[i] {
+		case WalDiffJsonFlag:
+			result.Json = true
+		case WalDiffTimelineFlag:
+			if i+1 == len(args) {
+				return result, errors.New("timeline is not specified")
+			}
+			i++
+			timeline, err := strconv.ParseUint(args[i], 10, 32)
+			if err != nil || timeline == 0 {
+				return result, errors.Errorf("invalid timeline '%s'", args[i])
+			}
+			result.Timeline = uint32(timeline)
+		default:
+			positional = append(positional, args[i])
+		}
+	}
+	if len(positional) != 2 {
+		return result, errors.New("expected beginning and end of the range")
+	}
+	result.From, result.To = positional[0], positional[1]
+	return result, nil
+}
+
+func isLsnArgument(argument string) bool {
+	return strings.Contains(argument, "/")
+}
+
+// walDiffEnd is an end of wal-diff range, timeline is zero if it is unknown
+type walDiffEnd struct {
+	lsn           uint64
+	timeline      uint32
+	relationNames []RelationName
+}
+
+// TODO : unit tests
+func resolveWalDiffEnd(folder StorageFolder, argument string) (walDiffEnd, error) {
+	if isLsnArgument(argument) {
+		lsn, err := pgx.ParseLSN(argument)
+		return walDiffEnd{lsn: lsn}, errors.Wrapf(err, "failed to parse LSN '%s'", argument)
+	}
+	backup, err := GetBackupByName(argument, folder)
+	if err != nil {
+		return walDiffEnd{}, err
+	}
+	sentinel, err := backup.fetchSentinel()
+	if err != nil {
+		return walDiffEnd{}, err
+	}
+	if sentinel.BackupStartLSN == nil {
+		return walDiffEnd{}, errors.Errorf("backup '%s' has no start LSN", backup.Name)
+	}
+	if err = sentinel.applyPostgresSizes(); err != nil {
+		return walDiffEnd{}, err
+	}
+	timeline, _, err := ParseWALFilename(stripWalFileName(backup.Name))
+	if err != nil {
+		return walDiffEnd{}, errors.Wrapf(err, "failed to find timeline of backup '%s'", backup.Name)
+	}
+	return walDiffEnd{*sentinel.BackupStartLSN, timeline, sentinel.Relations}, nil
+}
+
+// RelationChanges tells how many blocks of relation were changed
+type RelationChanges struct {
+	SpcNode       uint32
+	DBNode        uint32
+	RelNode       uint32
+	Name          string `json:",omitempty"`
+	ChangedBlocks uint64
+	ChangedBytes  uint64
+}
+
+func (changes *RelationChanges) displayName() string {
+	relFileNode := formatRelFileNode(walparser.RelFileNode{
+		SpcNode: walparser.Oid(changes.SpcNode),
+		DBNode:  walparser.Oid(changes.DBNode),
+		RelNode: walparser.Oid(changes.RelNode),
+	})
+	if changes.Name == "" {
+		return relFileNode
+	}
+	return fmt.Sprintf("%s (%s)", changes.Name, relFileNode)
+}
+
+// WalDiffReport lists changed relations, the most changed go first
+type WalDiffReport struct {
+	Timeline      uint32
+	FromLsn       string
+	ToLsn         string
+	Relations     []RelationChanges
+	ChangedBlocks uint64
+	ChangedBytes  uint64
+}
+
+// NewWalDiffReport summarizes delta map. Names are looked up in snapshots in order,
+// the first snapshot containing the relation wins.
+func NewWalDiffReport(deltaMap PagedFileDeltaMap, timeline uint32, fromLsn uint64, toLsn uint64,
+	relationNameSnapshots ...[]RelationName) *WalDiffReport {
+	names := make(map[walparser.RelFileNode]string)
+	for i := len(relationNameSnapshots) - 1; i >= 0; i-- {
+		for _, relationName := range relationNameSnapshots[i] {
+			names[relationName.relFileNode()] = relationName.Name
+		}
+	}
+
+	report := &WalDiffReport{
+		Timeline:  timeline,
+		FromLsn:   formatLsn(fromLsn),
+		ToLsn:     formatLsn(toLsn),
+		Relations: make([]RelationChanges, 0, len(deltaMap)),
+	}
+	for relFileNode, bitmap := range deltaMap {
+		changes := RelationChanges{
+			SpcNode:       uint32(relFileNode.SpcNode),
+			DBNode:        uint32(relFileNode.DBNode),
+			RelNode:       uint32(relFileNode.RelNode),
+			Name:          names[relFileNode],
+			ChangedBlocks: bitmap.GetCardinality(),
+		}
+		changes.ChangedBytes = changes.ChangedBlocks * uint64(DatabasePageSize)
+		report.ChangedBlocks += changes.ChangedBlocks
+		report.ChangedBytes += changes.ChangedBytes
+		report.Relations = append(report.Relations, changes)
+	}
+	sort.Slice(report.Relations, func(i, j int) bool {
+		left, right := report.Relations[i], report.Relations[j]
+		if left.ChangedBlocks != right.ChangedBlocks {
+			return left.ChangedBlocks > right.ChangedBlocks
+		}
+		if left.SpcNode != right.SpcNode {
+			return left.SpcNode < right.SpcNode
+		}
+		if left.DBNode != right.DBNode {
+			return left.DBNode < right.DBNode
+		}
+		return left.RelNode < right.RelNode
+	})
+	return report
+}
+
+func (report *WalDiffReport) WriteJson(writer io.Writer) error {
+	return writeIndentedJson(writer, report)
+}
+
+func (report *WalDiffReport) WriteText(writer io.Writer) error {
+	_, err := fmt.Fprintf(writer, "Changes on timeline %d from %s to %s:\n%-60s %14s %16s\n",
+		report.Timeline, report.FromLsn, report.ToLsn, "Relation", "Blocks", "Bytes")
+	if err != nil {
+		return err
+	}
+	for i := range report.Relations {
+		changes := &report.Relations[i]
+		_, err = fmt.Fprintf(writer, "%-60s %14d %16d\n", changes.displayName(), changes.ChangedBlocks, changes.ChangedBytes)
+		if err != nil {
+			return err
+		}
+	}
+	_, err = fmt.Fprintf(writer, "%-60s %14d %16d\n", "Total", report.ChangedBlocks, report.ChangedBytes)
+	return err
+}
+
+// TODO : unit tests
+// HandleWalDiff is invoked to perform wal-g wal-diff
+func HandleWalDiff(folder StorageFolder, arguments WalDiffCommandArguments) {
+	from, err := resolveWalDiffEnd(folder, arguments.From)
+	if err != nil {
+		tracelog.ErrorLogger.FatalError(err)
+	}
+	to, err := resolveWalDiffEnd(folder, arguments.To)
+	if err != nil {
+		tracelog.ErrorLogger.FatalError(err)
+	}
+
+	timeline := arguments.Timeline
+	for _, end := range []walDiffEnd{from, to} {
+		if end.timeline == 0 {
+			continue
+		}
+		if timeline != 0 && timeline != end.timeline {
+			tracelog.ErrorLogger.FatalError(NewWalDiffTimelineError(timeline, end.timeline))
+		}
+		timeline = end.timeline
+	}
+	if timeline == 0 {
+		tracelog.ErrorLogger.Fatalf("Timeline of the range is unknown, use %s\n", WalDiffTimelineFlag)
+	}
+
+	deltaMap, err := DownloadDeltaMapBetween(folder.GetSubFolder(WalPath), timeline, from.lsn, to.lsn)
+	if err != nil {
+		tracelog.ErrorLogger.FatalError(err)
+	}
+	report := NewWalDiffReport(deltaMap, timeline, from.lsn, to.lsn, to.relationNames, from.relationNames)
+	if arguments.Json {
+		err = report.WriteJson(os.Stdout)
+	} else {
+		err = report.WriteText(os.Stdout)
+	}
+	if err != nil {
+		tracelog.ErrorLogger.FatalError(err)
+	}
+}
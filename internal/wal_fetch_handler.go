@@ -40,7 +40,19 @@ func (err ArchiveNonExistenceError) Error() string {
 func HandleWALFetch(folder StorageFolder, walFileName string, location string, triggerPrefetch bool) {
 	tracelog.DebugLogger.Printf("HandleWALFetch(folder, %s, %s, %v)\n", walFileName, location, triggerPrefetch)
 	folder = folder.GetSubFolder(WalPath)
+	detectOfflinePostgresSizes(walDataDirectory(location), path.Dir(location))
 	location = ResolveSymlink(location)
+	if socketPath, ok := LookupConfigValue(PrefetchSocketSetting); ok && socketPath != "" {
+		err := FetchWalThroughPrefetchService(socketPath, walFileName, location)
+		if err == nil {
+			return
+		}
+		switch err.(type) {
+		case ArchiveNonExistenceError, PrefetchServiceError:
+			tracelog.ErrorLogger.FatalError(err)
+		}
+		tracelog.WarningLogger.Printf("Prefetch service is unavailable, fetching WAL file by itself: %v\n", err)
+	}
 	if triggerPrefetch {
 		defer forkPrefetch(walFileName, location)
 	}
@@ -93,11 +105,29 @@ func HandleWALFetch(folder StorageFolder, walFileName string, location string, t
 	}
 
 	err := downloadWALFileTo(folder, walFileName, location)
+	if _, ok := err.(ArchiveNonExistenceError); ok {
+		err = downloadAncestorWALFileTo(folder, walFileName, location, err)
+	}
 	if err != nil {
 		tracelog.ErrorLogger.FatalError(err)
 	}
 }
 
+// TODO : unit tests
+// downloadAncestorWALFileTo downloads the segment from ancestor timeline, if the segment is before
+// the switch to requested timeline. Otherwise notFoundErr is returned.
+func downloadAncestorWALFileTo(folder StorageFolder, walFileName string, dstPath string, notFoundErr error) error {
+	ancestorWalFileName, found, err := getAncestorWalFilename(folder, walFileName)
+	if err != nil {
+		return err
+	}
+	if !found {
+		return notFoundErr
+	}
+	tracelog.InfoLogger.Printf("WAL file %s is before timeline switch, fetching %s instead\n", walFileName, ancestorWalFileName)
+	return downloadWALFileTo(folder, ancestorWalFileName, dstPath)
+}
+
 // TODO : unit tests
 func checkWALFileMagic(prefetched string) error {
 	file, err := os.Open(prefetched)
@@ -117,6 +147,7 @@ func checkWALFileMagic(prefetched string) error {
 func TryDownloadWALFile(folder StorageFolder, walPath string) (walFileReader io.ReadCloser, exists bool, err error) {
 	walFileReader, err = folder.ReadObject(walPath)
 	if err == nil {
+		walFileReader = NewNetworkLimitReader(walFileReader)
 		exists = true
 		return
 	}
@@ -151,6 +182,10 @@ func downloadAndDecompressWALFile(folder StorageFolder, walFileName string) (io.
 		if !exists {
 			continue
 		}
+		if _, ok := decompressor.(ZstdDecompressor); ok {
+			// WAL files may be compressed with dictionaries, which are kept in WAL folder
+			decompressor = ZstdDecompressor{DictionaryFolder: folder}
+		}
 		reader, writer := io.Pipe()
 		go func() {
 			err = decompressWALFile(&EmptyWriteIgnorer{writer}, archiveReader, decompressor)
@@ -0,0 +1,277 @@
+package internal
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/pkg/errors"
+	"github.com/x4m/wal-g/internal/tracelog"
+	"github.com/x4m/wal-g/internal/walparser"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	WalFindTimeFlag     = "--time"
+	WalFindTimelineFlag = "--timeline"
+	WalFindJsonFlag     = "--json"
+)
+
+// walFindTimeLayouts are accepted formats of --time, time without zone is UTC
+var walFindTimeLayouts = []string{
+	time.RFC3339Nano,
+	"2006-01-02 15:04:05.999999999Z07:00",
+	"2006-01-02 15:04:05.999999999Z07",
+	"2006-01-02 15:04:05.999999999",
+}
+
+type NoCommitBeforeTimeError struct {
+	error
+}
+
+func NewNoCommitBeforeTimeError(target time.Time) NoCommitBeforeTimeError {
+	return NoCommitBeforeTimeError{errors.Errorf("No indexed commit found at or before %v", target)}
+}
+
+func (err NoCommitBeforeTimeError) Error() string {
+	return fmt.Sprintf(tracelog.GetErrorFormatter(), err.error)
+}
+
+// WalFindCommandArguments are arguments of wal-find, zero timeline is the latest indexed timeline
+type WalFindCommandArguments struct {
+	Time     time.Time
+	Timeline uint32
+	Json     bool
+}
+
+func parseWalFindTime(value string) (time.Time, error) {
+	for _, layout := range walFindTimeLayouts {
+		if result, err := time.Parse(layout, value); err == nil {
+			return result, nil
+		}
+	}
+	return time.Time{}, errors.Errorf("invalid time '%s', expected format is '2006-01-02 15:04:05+07' or RFC 3339", value)
+}
+
+// ParseWalFindArguments parses "--time time [--timeline timeline] [--json]"
+func ParseWalFindArguments(args []string) (WalFindCommandArguments, error) {
+	result := WalFindCommandArguments{}
+	hasTime := false
+	for i := 0; i < len(args); i++ {
+		switch args[i] {
+		case WalFindJsonFlag:
+			result.Json = true
+		case WalFindTimeFlag, WalFindTimelineFlag:
+			if i+1 == len(args) {
+				return result, errors.Errorf("%s value is not specified", args[i])
+			}
+			var err error
+			if args[i] == WalFindTimeFlag {
+				result.Time, err = parseWalFindTime(args[i+1])
+				hasTime = true
+			} else {
+				var timeline uint64
+				timeline, err = strconv.ParseUint(args[i+1], 10, 32)
+				if err == nil && timeline == 0 {
+					err = errors.New("timeline 0 does not exist")
+				}
+				result.Timeline = uint32(timeline)
+			}
+			if err != nil {
+				return result, err
+			}
+			i++
+		default:
+			return result, errors.Errorf("unexpected argument '%s'", args[i])
+		}
+	}
+	if !hasTime {
+		return result, errors.Errorf("%s is not specified", WalFindTimeFlag)
+	}
+	return result, nil
+}
+
+// WalFindResult is the commit record found by wal-find
+type WalFindResult struct {
+	WalFileName string
+	Lsn         string
+	CommitTime  time.Time
+}
+
+func (result *WalFindResult) WriteText(writer io.Writer) error {
+	_, err := fmt.Fprintf(writer, "%s %s %s\n", result.WalFileName, result.Lsn, result.CommitTime.Format(time.RFC3339Nano))
+	return err
+}
+
+// indexedWalFiles lists WAL files of timeline which have time index, ordered by name.
+// Zero timeline is replaced by the latest indexed timeline.
+func indexedWalFiles(walFolder StorageFolder, timeline uint32) ([]string, error) {
+	objects, _, err := walFolder.ListFolder()
+	if err != nil {
+		return nil, err
+	}
+	walFileNames := make(map[uint32][]string)
+	for _, object := range objects {
+		if !strings.HasSuffix(object.GetName(), WalTimeIndexSuffix) {
+			continue
+		}
+		walFileName := strings.TrimSuffix(object.GetName(), WalTimeIndexSuffix)
+		walTimeline, _, err := ParseWALFilename(walFileName)
+		if err != nil {
+			continue
+		}
+		walFileNames[walTimeline] = append(walFileNames[walTimeline], walFileName)
+	}
+	if timeline == 0 {
+		for walTimeline := range walFileNames {
+			if walTimeline > timeline {
+				timeline = walTimeline
+			}
+		}
+	}
+	result := walFileNames[timeline]
+	sort.Strings(result)
+	return result, nil
+}
+
+// parseWalFileRecords parses pages of WAL file with parser and passes their records to handleRecords,
+// parsing stops when handleRecords returns false
+func parseWalFileRecords(walFolder StorageFolder, walFileName string, parser *walparser.WalParser,
+	handleRecords func(records []walparser.XLogRecord) bool) error {
+	reader, err := downloadAndDecompressWALFile(walFolder, walFileName)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+	pageReader := walparser.NewWalPageReader(reader)
+	for {
+		data, err := pageReader.ReadPageData()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return errors.Wrapf(err, "failed to read '%s'", walFileName)
+		}
+		_, records, err := parser.ParseRecordsFromPage(bytes.NewReader(data))
+		switch err.(type) {
+		case nil, walparser.PartialPageError, walparser.ZeroPageError:
+		default:
+			return errors.Wrapf(err, "failed to parse '%s'", walFileName)
+		}
+		if !handleRecords(records) {
+			return nil
+		}
+	}
+}
+
+// findLastCommitInWalFile parses WAL file and returns its last commit at or before target.
+// The last record of WAL file may end in the next WAL file, so parsing continues there up to the end of the record.
+func findLastCommitInWalFile(walFolder StorageFolder, walFileName string, target time.Time) (*WalFindResult, error) {
+	var result *WalFindResult
+	checkCommit := func(record *walparser.XLogRecord) {
+		commitTime, ok := record.GetXactTime()
+		if ok && record.IsXactCommit() && !commitTime.After(target) {
+			result = &WalFindResult{walFileName, formatLsn(uint64(record.Lsn)), commitTime}
+		}
+	}
+	parser := walparser.NewWalParser()
+	err := parseWalFileRecords(walFolder, walFileName, parser, func(records []walparser.XLogRecord) bool {
+		for i := range records {
+			checkCommit(&records[i])
+		}
+		return true
+	})
+	if err != nil || len(parser.GetCurrentRecordData()) == 0 || !parser.HasCurrentRecordBeginning() {
+		return result, err
+	}
+	nextWalFileName, err := GetNextWalFilename(walFileName)
+	if err != nil {
+		return nil, err
+	}
+	err = parseWalFileRecords(walFolder, nextWalFileName, parser, func(records []walparser.XLogRecord) bool {
+		if len(records) == 0 {
+			return true
+		}
+		checkCommit(&records[0])
+		return false
+	})
+	if _, ok := err.(ArchiveNonExistenceError); ok {
+		tracelog.WarningLogger.Printf("The last record of '%s' is not parsed, because '%s' is not archived yet\n",
+			walFileName, nextWalFileName)
+		return result, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// FindLastCommitBefore finds the last commit at or before target among indexed WAL files of timeline.
+// Commit times are expected to grow along WAL, so index objects are binary searched
+// and only WAL files which may contain the commit are downloaded.
+func FindLastCommitBefore(walFolder StorageFolder, timeline uint32, target time.Time) (*WalFindResult, error) {
+	walFileNames, err := indexedWalFiles(walFolder, timeline)
+	if err != nil {
+		return nil, err
+	}
+	indexes := make([]*WalTimeIndex, len(walFileNames))
+	getIndex := func(i int) (*WalTimeIndex, error) {
+		if indexes[i] != nil {
+			return indexes[i], nil
+		}
+		index, err := fetchWalTimeIndex(walFolder, walFileNames[i])
+		if err == nil {
+			indexes[i] = index
+		}
+		return index, err
+	}
+
+	// the first WAL file, which starts after target
+	var searchErr error
+	after := sort.Search(len(walFileNames), func(i int) bool {
+		index, err := getIndex(i)
+		if err != nil {
+			searchErr = err
+			return true
+		}
+		return index.FirstTime.After(target)
+	})
+	if searchErr != nil {
+		return nil, searchErr
+	}
+	for i := after - 1; i >= 0; i-- {
+		index, err := getIndex(i)
+		if err != nil {
+			return nil, err
+		}
+		if index.Commits == 0 && !index.EndsWithPartialRecord {
+			continue
+		}
+		tracelog.DebugLogger.Printf("Searching commit in %s\n", walFileNames[i])
+		result, err := findLastCommitInWalFile(walFolder, walFileNames[i], target)
+		if err != nil || result != nil {
+			return result, err
+		}
+	}
+	return nil, NewNoCommitBeforeTimeError(target)
+}
+
+// TODO : unit tests
+// HandleWalFind is invoked to perform wal-g wal-find
+func HandleWalFind(folder StorageFolder, arguments WalFindCommandArguments) {
+	result, err := FindLastCommitBefore(folder.GetSubFolder(WalPath), arguments.Timeline, arguments.Time)
+	if err != nil {
+		tracelog.ErrorLogger.FatalError(err)
+	}
+	if arguments.Json {
+		err = writeIndentedJson(os.Stdout, result)
+	} else {
+		err = result.WriteText(os.Stdout)
+	}
+	if err != nil {
+		tracelog.ErrorLogger.FatalError(err)
+	}
+}
@@ -11,6 +11,9 @@ const (
 	PreviousWalHeadType WalPartDataType = 0
 	WalTailType         WalPartDataType = 1
 	WalHeadType         WalPartDataType = 2
+	ContinuedWalType    WalPartDataType = 3
+	// PageMagicType part keeps magic of WAL pages, which defines layout of records, see walparser.XLogPageMagicPg15
+	PageMagicType WalPartDataType = 4
 )
 
 type WalPart struct {
@@ -1,6 +1,7 @@
 package internal
 
 import (
+	"encoding/binary"
 	"github.com/pkg/errors"
 	"github.com/x4m/wal-g/internal/walparser"
 	"io"
@@ -10,6 +11,11 @@ type WalPartFile struct {
 	WalTails        [][]byte
 	PreviousWalHead []byte
 	WalHeads        [][]byte
+	// ContinuedWals marks WAL files, which are entirely a part of the record started before them.
+	// Tail of such file is all of its record data, head is empty.
+	ContinuedWals []bool
+	// PageMagic is the magic of recorded WAL pages, zero when it is unknown
+	PageMagic uint16
 }
 
 func NewWalPartFile() *WalPartFile {
@@ -17,6 +23,8 @@ func NewWalPartFile() *WalPartFile {
 		make([][]byte, WalFileInDelta),
 		nil,
 		make([][]byte, WalFileInDelta),
+		make([]bool, WalFileInDelta),
+		0,
 	}
 }
 
@@ -52,29 +60,50 @@ func (partFile *WalPartFile) Save(writer io.Writer) error {
 			walParts = append(walParts, *NewWalPart(WalHeadType, uint8(id), data))
 		}
 	}
+	for id, continued := range partFile.ContinuedWals {
+		if continued {
+			walParts = append(walParts, *NewWalPart(ContinuedWalType, uint8(id), make([]byte, 0)))
+		}
+	}
+	if partFile.PageMagic != 0 {
+		walParts = append(walParts, *NewWalPart(PageMagicType, 0, ToBytes(&partFile.PageMagic)))
+	}
 	return saveWalParts(walParts, writer)
 }
 
+// getCurrentDeltaFileRecordHeads returns heads of records continued in each WAL file of the delta,
+// the last one is continued in the next delta. Head of the record continued through the whole
+// WAL file is extended by the data of that file.
 func (partFile *WalPartFile) getCurrentDeltaFileRecordHeads() [][]byte {
-	recordHeads := make([][]byte, WalFileInDelta)
+	recordHeads := make([][]byte, WalFileInDelta+1)
 	recordHeads[0] = partFile.PreviousWalHead
-	for id := 1; id < int(WalFileInDelta); id++ {
-		recordHeads[id] = partFile.WalHeads[id-1]
+	for id := 0; id < int(WalFileInDelta); id++ {
+		if partFile.ContinuedWals[id] {
+			recordHeads[id+1] = concatByteSlices(recordHeads[id], partFile.WalTails[id])
+		} else {
+			recordHeads[id+1] = partFile.WalHeads[id]
+		}
 	}
 	return recordHeads
 }
 
+// GetNextDeltaFileRecordHead returns head of the record, which is continued in the next delta
+func (partFile *WalPartFile) GetNextDeltaFileRecordHead() []byte {
+	return partFile.getCurrentDeltaFileRecordHeads()[WalFileInDelta]
+}
+
 func (partFile *WalPartFile) CombineRecords() ([]walparser.XLogRecord, error) {
 	recordHeads := partFile.getCurrentDeltaFileRecordHeads()
 	records := make([]walparser.XLogRecord, 0)
 	for id := 0; id < int(WalFileInDelta); id++ {
-		recordData := make([]byte, 0)
-		recordData = append(recordData, recordHeads[id]...)
-		recordData = append(recordData, partFile.WalTails[id]...)
+		if partFile.ContinuedWals[id] {
+			continue
+		}
+		recordData := concatByteSlices(recordHeads[id], partFile.WalTails[id])
 		if len(recordData) == 0 {
 			continue
 		}
-		record, err := walparser.ParseXLogRecordFromBytes(recordData)
+		record, err := walparser.ParseXLogRecordFromBytes(recordData, partFile.PageMagic)
 		if err != nil {
 			return nil, err
 		}
@@ -91,6 +120,12 @@ func (partFile *WalPartFile) setPart(part WalPart) {
 		partFile.WalTails[part.id] = part.data
 	case WalHeadType:
 		partFile.WalHeads[part.id] = part.data
+	case ContinuedWalType:
+		partFile.ContinuedWals[part.id] = true
+	case PageMagicType:
+		if len(part.data) == 2 {
+			partFile.PageMagic = binary.LittleEndian.Uint16(part.data)
+		}
 	}
 }
 
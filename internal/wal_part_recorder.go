@@ -69,6 +69,41 @@ func (recorder *WalPartRecorder) SaveNextWalHead(head []byte) error {
 	return nil
 }
 
+// SavePageMagic saves magic of WAL pages, records of the delta are parsed according to it
+func (recorder *WalPartRecorder) SavePageMagic(pageMagic uint16) error {
+	if pageMagic == 0 {
+		return nil
+	}
+	deltaFilename, err := GetDeltaFilenameFor(recorder.walFilename)
+	if err != nil {
+		return err
+	}
+	partFile, err := recorder.manager.GetPartFile(deltaFilename)
+	if err != nil {
+		return err
+	}
+	partFile.PageMagic = pageMagic
+	return nil
+}
+
+// SaveContinuedWal saves data of WAL file, which is entirely a part of the record started in previous files.
+// Head of the record continued in the next delta is known only when whole delta is recorded, see DeltaFileManager.CombinePartFile
+func (recorder *WalPartRecorder) SaveContinuedWal(recordData []byte) error {
+	deltaFilename, err := GetDeltaFilenameFor(recorder.walFilename)
+	if err != nil {
+		return err
+	}
+	partFile, err := recorder.manager.GetPartFile(deltaFilename)
+	if err != nil {
+		return err
+	}
+	positionInDelta := GetPositionInDelta(recorder.walFilename)
+	partFile.WalTails[positionInDelta] = recordData
+	partFile.WalHeads[positionInDelta] = make([]byte, 0)
+	partFile.ContinuedWals[positionInDelta] = true
+	return nil
+}
+
 func (recorder *WalPartRecorder) cancelRecordingWithErr(err error) {
 	tracelog.WarningLogger.Printf("Stopped wal file: '%s' recording because of error: '%v'\n", recorder.walFilename, err)
 	recorder.manager.CancelRecording(recorder.walFilename)
@@ -0,0 +1,243 @@
+package internal
+
+import (
+	"github.com/pkg/errors"
+	"github.com/x4m/wal-g/internal/tracelog"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+)
+
+const (
+	prefetchCacheRunningDirectory = "running"
+	// prefetchCacheOriginFilename keeps path of WAL folder in storage, which cached files are downloaded from
+	prefetchCacheOriginFilename = "origin"
+)
+
+type walCacheEntry struct {
+	size int64
+	// pins counts readers of the file, pinned files are not evicted
+	pins int
+}
+
+// WalPrefetchCache is a size bounded directory of downloaded WAL files.
+// Files are downloaded into running subdirectory and moved to the cache when complete,
+// so the cache survives restarts of the prefetch service.
+type WalPrefetchCache struct {
+	directory string
+	maxSize   int64
+
+	mutex     sync.Mutex
+	entries   map[string]*walCacheEntry
+	totalSize int64
+	// lastRequested is a segment number replayed by postgres, segments before it are evicted first
+	lastRequested uint64
+}
+
+// NewWalPrefetchCache indexes files left in directory by previous runs and removes incomplete downloads.
+// Files are named like WAL files of any cluster, so files downloaded from other origin are removed too.
+func NewWalPrefetchCache(directory string, maxSize int64, origin string) (*WalPrefetchCache, error) {
+	cache := &WalPrefetchCache{
+		directory: directory,
+		maxSize:   maxSize,
+		entries:   make(map[string]*walCacheEntry),
+	}
+	err := os.RemoveAll(cache.runningDirectory())
+	if err != nil {
+		return nil, err
+	}
+	err = os.MkdirAll(cache.runningDirectory(), 0700)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to create prefetch cache directory '%s'", directory)
+	}
+	err = cache.checkOrigin(origin)
+	if err != nil {
+		return nil, err
+	}
+	files, err := ioutil.ReadDir(directory)
+	if err != nil {
+		return nil, err
+	}
+	for _, file := range files {
+		if file.Mode().IsRegular() && file.Name() != prefetchCacheOriginFilename {
+			cache.entries[file.Name()] = &walCacheEntry{size: file.Size()}
+			cache.totalSize += file.Size()
+		}
+	}
+	cache.mutex.Lock()
+	cache.evict()
+	cache.mutex.Unlock()
+	return cache, nil
+}
+
+// checkOrigin removes cached files, unless they are downloaded from origin, and records origin
+func (cache *WalPrefetchCache) checkOrigin(origin string) error {
+	originPath := filepath.Join(cache.directory, prefetchCacheOriginFilename)
+	cachedOrigin, err := ioutil.ReadFile(originPath)
+	if err == nil && string(cachedOrigin) == origin {
+		return nil
+	}
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	files, err := ioutil.ReadDir(cache.directory)
+	if err != nil {
+		return err
+	}
+	removedCount := 0
+	for _, file := range files {
+		if file.Mode().IsRegular() {
+			err = os.Remove(filepath.Join(cache.directory, file.Name()))
+			if err != nil {
+				return err
+			}
+			removedCount++
+		}
+	}
+	if removedCount > 0 {
+		tracelog.WarningLogger.Printf("Prefetch cache '%s' is cleared, because it is not downloaded from '%s'\n",
+			cache.directory, origin)
+	}
+	return ioutil.WriteFile(originPath, []byte(origin), 0600)
+}
+
+func (cache *WalPrefetchCache) runningDirectory() string {
+	return filepath.Join(cache.directory, prefetchCacheRunningDirectory)
+}
+
+// DownloadPath returns path where download of the file should be written before it is added
+func (cache *WalPrefetchCache) DownloadPath(walFileName string) string {
+	return filepath.Join(cache.runningDirectory(), walFileName)
+}
+
+func (cache *WalPrefetchCache) Contains(walFileName string) bool {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+	_, ok := cache.entries[walFileName]
+	return ok
+}
+
+// Size returns total size of cached files
+func (cache *WalPrefetchCache) Size() int64 {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+	return cache.totalSize
+}
+
+// Add moves downloaded file from its download path into the cache
+func (cache *WalPrefetchCache) Add(walFileName string) error {
+	downloadPath := cache.DownloadPath(walFileName)
+	stat, err := os.Stat(downloadPath)
+	if err != nil {
+		return err
+	}
+	err = os.Rename(downloadPath, filepath.Join(cache.directory, walFileName))
+	if err != nil {
+		return err
+	}
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+	if old, ok := cache.entries[walFileName]; ok {
+		cache.totalSize -= old.size
+	}
+	cache.entries[walFileName] = &walCacheEntry{size: stat.Size()}
+	cache.totalSize += stat.Size()
+	cache.evict()
+	return nil
+}
+
+// MarkRequested tells the cache, that postgres has reached the segment
+func (cache *WalPrefetchCache) MarkRequested(walFileName string) {
+	_, logSegNo, err := ParseWALFilename(walFileName)
+	if err != nil {
+		return
+	}
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+	cache.lastRequested = logSegNo
+}
+
+// CopyTo writes cached file to dstPath, it returns false if the file is not cached
+func (cache *WalPrefetchCache) CopyTo(walFileName string, dstPath string) (bool, error) {
+	cache.mutex.Lock()
+	entry, ok := cache.entries[walFileName]
+	if ok {
+		entry.pins++
+	}
+	cache.mutex.Unlock()
+	if !ok {
+		return false, nil
+	}
+	defer func() {
+		cache.mutex.Lock()
+		entry.pins--
+		cache.evict()
+		cache.mutex.Unlock()
+	}()
+
+	// Postgres recycles restored segments in place, so cache shares no links with them
+	src, err := os.Open(filepath.Join(cache.directory, walFileName))
+	if err != nil {
+		return false, err
+	}
+	defer src.Close()
+	dst, err := os.OpenFile(dstPath, os.O_RDWR|os.O_CREATE|os.O_TRUNC|os.O_EXCL, 0666)
+	if err != nil {
+		return false, err
+	}
+	_, err = io.Copy(dst, src)
+	if err != nil {
+		dst.Close()
+		return false, err
+	}
+	return true, dst.Close()
+}
+
+// evict removes files until the cache fits its size, it should be called under cache mutex.
+// Segments already replayed go first, then segments farthest from the replay position.
+func (cache *WalPrefetchCache) evict() {
+	if cache.totalSize <= cache.maxSize {
+		return
+	}
+	names := make([]string, 0, len(cache.entries))
+	for name, entry := range cache.entries {
+		if entry.pins == 0 {
+			names = append(names, name)
+		}
+	}
+	sort.Slice(names, func(i, j int) bool {
+		return cache.evictsBefore(names[i], names[j])
+	})
+	for _, name := range names {
+		if cache.totalSize <= cache.maxSize {
+			return
+		}
+		err := os.Remove(filepath.Join(cache.directory, name))
+		if err != nil && !os.IsNotExist(err) {
+			tracelog.WarningLogger.Printf("Failed to evict '%s' from prefetch cache: %v\n", name, err)
+			continue
+		}
+		cache.totalSize -= cache.entries[name].size
+		delete(cache.entries, name)
+	}
+}
+
+func (cache *WalPrefetchCache) evictsBefore(name1, name2 string) bool {
+	_, logSegNo1, err1 := ParseWALFilename(name1)
+	_, logSegNo2, err2 := ParseWALFilename(name2)
+	if err1 != nil || err2 != nil {
+		// Files which are not segments, like history files, are small and go last
+		return err1 == nil && err2 != nil || (err1 != nil && err2 != nil && name1 < name2)
+	}
+	replayed1, replayed2 := logSegNo1 < cache.lastRequested, logSegNo2 < cache.lastRequested
+	if replayed1 != replayed2 {
+		return replayed1
+	}
+	if replayed1 {
+		return logSegNo1 < logSegNo2
+	}
+	return logSegNo1 > logSegNo2
+}
@@ -0,0 +1,459 @@
+package internal
+
+import (
+	"bufio"
+	"fmt"
+	"github.com/pkg/errors"
+	"github.com/x4m/wal-g/internal/tracelog"
+	"io"
+	"math"
+	"net"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// Prefetch service protocol is line based: client sends "FETCH <WAL file name> <absolute destination path>",
+// destination should be in WAL directory of the service. Service answers "OK" when the file is written,
+// "MISSING" when there is no such file in storage or "ERROR <message>".
+const (
+	PrefetchSocketSetting    = "WALG_PREFETCH_SOCKET"
+	PrefetchDirectorySetting = "WALG_PREFETCH_DIR"
+	PrefetchCacheSizeSetting = "WALG_PREFETCH_CACHE_SIZE"
+
+	DefaultPrefetchCacheSize = int64(64 * DefaultWalSegmentSize)
+
+	prefetchFetchCommand    = "FETCH"
+	prefetchOkResponse      = "OK"
+	prefetchMissingResponse = "MISSING"
+	prefetchErrorResponse   = "ERROR"
+
+	// replayRateWindow is a number of recent requests used to estimate replay rate
+	replayRateWindow = 16
+	// maxPrefetchLookahead bounds lookahead, when cache is large
+	maxPrefetchLookahead = 256
+)
+
+type PrefetchServiceNotConfiguredError struct {
+	error
+}
+
+func NewPrefetchServiceNotConfiguredError(setting string) PrefetchServiceNotConfiguredError {
+	return PrefetchServiceNotConfiguredError{errors.Errorf("%s is required to run prefetch service", setting)}
+}
+
+func (err PrefetchServiceNotConfiguredError) Error() string {
+	return fmt.Sprintf(tracelog.GetErrorFormatter(), err.error)
+}
+
+type PrefetchServiceError struct {
+	error
+}
+
+func NewPrefetchServiceError(walFileName string, reason string) PrefetchServiceError {
+	return PrefetchServiceError{errors.Errorf("Prefetch service failed to fetch WAL file '%s': %s", walFileName, reason)}
+}
+
+func (err PrefetchServiceError) Error() string {
+	return fmt.Sprintf(tracelog.GetErrorFormatter(), err.error)
+}
+
+// AdaptiveLookahead decides how many segments to prefetch, so that downloads
+// started now complete before replay reaches them
+type AdaptiveLookahead struct {
+	initial int
+	max     int
+
+	mutex            sync.Mutex
+	requests         []time.Time
+	downloadDuration time.Duration
+}
+
+func NewAdaptiveLookahead(initial int, max int) *AdaptiveLookahead {
+	if initial > max {
+		initial = max
+	}
+	return &AdaptiveLookahead{initial: initial, max: max}
+}
+
+// ObserveRequest records the moment, when postgres requested next segment
+func (lookahead *AdaptiveLookahead) ObserveRequest(moment time.Time) {
+	lookahead.mutex.Lock()
+	defer lookahead.mutex.Unlock()
+	lookahead.requests = append(lookahead.requests, moment)
+	if len(lookahead.requests) > replayRateWindow {
+		lookahead.requests = lookahead.requests[1:]
+	}
+}
+
+// ObserveDownload records duration of segment download, recent downloads weigh more
+func (lookahead *AdaptiveLookahead) ObserveDownload(duration time.Duration) {
+	lookahead.mutex.Lock()
+	defer lookahead.mutex.Unlock()
+	if lookahead.downloadDuration == 0 {
+		lookahead.downloadDuration = duration
+		return
+	}
+	lookahead.downloadDuration = (3*lookahead.downloadDuration + duration) / 4
+}
+
+// Lookahead returns number of segments to keep ahead of replay: twice as many segments,
+// as replay consumes during one download, but at least one
+func (lookahead *AdaptiveLookahead) Lookahead() int {
+	lookahead.mutex.Lock()
+	defer lookahead.mutex.Unlock()
+	if len(lookahead.requests) < 2 || lookahead.downloadDuration == 0 {
+		return lookahead.initial
+	}
+	elapsed := lookahead.requests[len(lookahead.requests)-1].Sub(lookahead.requests[0])
+	if elapsed <= 0 {
+		return lookahead.max
+	}
+	segmentsPerSecond := float64(len(lookahead.requests)-1) / elapsed.Seconds()
+	count := int(math.Ceil(2 * segmentsPerSecond * lookahead.downloadDuration.Seconds()))
+	if count < 1 {
+		return 1
+	}
+	if count > lookahead.max {
+		return lookahead.max
+	}
+	return count
+}
+
+type walDownload struct {
+	done chan struct{}
+	err  error
+}
+
+// WalPrefetchService serves wal-fetch requests from the cache and downloads segments ahead of replay
+type WalPrefetchService struct {
+	folder       StorageFolder
+	uploader     *Uploader
+	cache        *WalPrefetchCache
+	lookahead    *AdaptiveLookahead
+	walDirectory string
+
+	mutex    sync.Mutex
+	inFlight map[string]*walDownload
+	workers  chan struct{}
+}
+
+// NewWalPrefetchService creates service, which fetches WAL files from folder into walDirectory.
+// Uploader is used to prefault data files, it may be nil.
+func NewWalPrefetchService(folder StorageFolder, uploader *Uploader, cache *WalPrefetchCache, walDirectory string) *WalPrefetchService {
+	maxLookahead := int(cache.maxSize/int64(WalSegmentSize)) - 1
+	if maxLookahead > maxPrefetchLookahead {
+		maxLookahead = maxPrefetchLookahead
+	}
+	if maxLookahead < 1 {
+		maxLookahead = 1
+	}
+	return &WalPrefetchService{
+		folder:       folder,
+		uploader:     uploader,
+		cache:        cache,
+		lookahead:    NewAdaptiveLookahead(getMaxDownloadConcurrency(8), maxLookahead),
+		walDirectory: filepath.Clean(walDirectory),
+		inFlight:     make(map[string]*walDownload),
+		workers:      make(chan struct{}, getMaxDownloadConcurrency(8)),
+	}
+}
+
+// TODO : unit tests
+// HandleWalPrefetchService is invoked to perform wal-g wal-prefetch-service
+func HandleWalPrefetchService(folder StorageFolder, uploader *Uploader, walDirectory string) {
+	socketPath, ok := LookupConfigValue(PrefetchSocketSetting)
+	if !ok || socketPath == "" {
+		tracelog.ErrorLogger.FatalError(NewPrefetchServiceNotConfiguredError(PrefetchSocketSetting))
+	}
+	directory, ok := LookupConfigValue(PrefetchDirectorySetting)
+	if !ok || directory == "" {
+		tracelog.ErrorLogger.FatalError(NewPrefetchServiceNotConfiguredError(PrefetchDirectorySetting))
+	}
+	cacheSize, err := getPrefetchCacheSize()
+	if err != nil {
+		tracelog.ErrorLogger.FatalError(err)
+	}
+	walFolder := folder.GetSubFolder(WalPath)
+	cache, err := NewWalPrefetchCache(directory, cacheSize, walFolder.GetPath())
+	if err != nil {
+		tracelog.ErrorLogger.FatalError(err)
+	}
+	walDirectory = ResolveSymlink(walDirectory)
+	detectOfflinePostgresSizes(filepath.Dir(filepath.Clean(walDirectory)), walDirectory)
+	service := NewWalPrefetchService(walFolder, uploader, cache, walDirectory)
+
+	// Socket left by previous service prevents listening
+	err = os.Remove(socketPath)
+	if err != nil && !os.IsNotExist(err) {
+		tracelog.ErrorLogger.FatalError(err)
+	}
+	listener, err := net.Listen("unix", socketPath)
+	if err != nil {
+		tracelog.ErrorLogger.FatalError(err)
+	}
+	// Only the owner of the service may ask it to write files
+	err = os.Chmod(socketPath, 0600)
+	if err != nil {
+		tracelog.ErrorLogger.FatalError(err)
+	}
+	tracelog.InfoLogger.Printf("WAL prefetch service is listening on %s, cache is %s\n", socketPath, directory)
+
+	stopping := make(chan struct{})
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		sig := <-signals
+		tracelog.InfoLogger.Printf("Received %v, stopping WAL prefetch service\n", sig)
+		close(stopping)
+		listener.Close()
+	}()
+
+	err = service.Serve(listener)
+	select {
+	case <-stopping:
+	default:
+		tracelog.ErrorLogger.FatalError(err)
+	}
+}
+
+func getPrefetchCacheSize() (int64, error) {
+	sizeStr, ok := LookupConfigValue(PrefetchCacheSizeSetting)
+	if !ok {
+		return DefaultPrefetchCacheSize, nil
+	}
+	size, err := strconv.ParseInt(sizeStr, 10, 64)
+	if err != nil {
+		return 0, errors.Wrap(err, "failed to parse "+PrefetchCacheSizeSetting)
+	}
+	return size, nil
+}
+
+// Serve answers client requests until listener is closed
+func (service *WalPrefetchService) Serve(listener net.Listener) error {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return err
+		}
+		go service.handleConnection(conn)
+	}
+}
+
+func (service *WalPrefetchService) handleConnection(conn net.Conn) {
+	defer conn.Close()
+	request, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil {
+		tracelog.ErrorLogger.Println("Failed to read prefetch request: ", err)
+		return
+	}
+	fields := strings.SplitN(strings.TrimSpace(request), " ", 3)
+	var response string
+	if len(fields) != 3 || fields[0] != prefetchFetchCommand {
+		response = fmt.Sprintf("%s unknown request '%s'", prefetchErrorResponse, strings.TrimSpace(request))
+	} else if err = service.Fetch(fields[1], fields[2]); err != nil {
+		if _, ok := err.(ArchiveNonExistenceError); ok {
+			response = prefetchMissingResponse
+		} else {
+			response = fmt.Sprintf("%s %v", prefetchErrorResponse, strings.Replace(err.Error(), "\n", " ", -1))
+		}
+	} else {
+		response = prefetchOkResponse
+	}
+	_, err = fmt.Fprintln(conn, response)
+	if err != nil {
+		tracelog.ErrorLogger.Println("Failed to answer prefetch request: ", err)
+	}
+}
+
+// Fetch writes WAL file to dstPath, downloading it unless it is cached,
+// and starts prefetch of following segments. Only WAL segments and history files are written
+// and only into WAL directory of the service.
+func (service *WalPrefetchService) Fetch(walFileName string, dstPath string) error {
+	if _, isHistory := parseHistoryFilename(walFileName); !isHistory && !isWalFilename(walFileName) {
+		return errors.Errorf("'%s' is not a name of WAL file", walFileName)
+	}
+	if ResolveSymlink(filepath.Dir(filepath.Clean(dstPath))) != service.walDirectory {
+		return errors.Errorf("'%s' is not in WAL directory '%s'", dstPath, service.walDirectory)
+	}
+	service.cache.MarkRequested(walFileName)
+	service.lookahead.ObserveRequest(time.Now())
+	if isWalFilename(walFileName) {
+		go service.prefetchAhead(walFileName)
+	}
+
+	copied, err := service.cache.CopyTo(walFileName, dstPath)
+	if copied || err != nil {
+		return err
+	}
+	err = service.download(walFileName)
+	if err != nil {
+		return err
+	}
+	copied, err = service.cache.CopyTo(walFileName, dstPath)
+	if copied || err != nil {
+		return err
+	}
+	// Cache is too small even for the requested file
+	return downloadWALFileTo(service.folder, walFileName, dstPath)
+}
+
+// TODO : unit tests
+func (service *WalPrefetchService) prefetchAhead(walFileName string) {
+	targetTimeline, history, err := FindTargetTimeline(service.folder, walFileName)
+	if err != nil {
+		tracelog.ErrorLogger.Println("WAL-prefetch failed: ", err, " file: ", walFileName)
+		return
+	}
+	fileNames, err := GetNextWalFilenames(walFileName, targetTimeline, history, service.lookahead.Lookahead())
+	if err != nil {
+		tracelog.ErrorLogger.Println("WAL-prefetch failed: ", err, " file: ", walFileName)
+		return
+	}
+	for _, fileName := range fileNames {
+		if service.cache.Contains(fileName) || service.isDownloading(fileName) {
+			continue
+		}
+		go service.prefetchFile(fileName)
+	}
+}
+
+func (service *WalPrefetchService) prefetchFile(walFileName string) {
+	service.workers <- struct{}{}
+	defer func() { <-service.workers }()
+	err := service.download(walFileName)
+	if _, ok := err.(ArchiveNonExistenceError); ok {
+		return // Segment is not archived yet
+	}
+	if err != nil {
+		tracelog.ErrorLogger.Println("WAL-prefetch failed: ", err, " file: ", walFileName)
+		return
+	}
+	if service.uploader == nil {
+		return
+	}
+	prefaultStartLsn, shouldPrefault, timelineId, err := ShouldPrefault(walFileName)
+	if err != nil {
+		tracelog.ErrorLogger.Println("ShouldPrefault failed: ", err, " file: ", walFileName)
+	}
+	if shouldPrefault {
+		waitGroup := &sync.WaitGroup{}
+		waitGroup.Add(1)
+		prefaultData(prefaultStartLsn, timelineId, waitGroup, service.uploader)
+	}
+}
+
+func (service *WalPrefetchService) isDownloading(walFileName string) bool {
+	service.mutex.Lock()
+	defer service.mutex.Unlock()
+	_, ok := service.inFlight[walFileName]
+	return ok
+}
+
+// download puts WAL file into cache, concurrent downloads of the same file wait for the first one
+func (service *WalPrefetchService) download(walFileName string) error {
+	service.mutex.Lock()
+	if service.cache.Contains(walFileName) {
+		service.mutex.Unlock()
+		return nil
+	}
+	download, isRunning := service.inFlight[walFileName]
+	if !isRunning {
+		download = &walDownload{done: make(chan struct{})}
+		service.inFlight[walFileName] = download
+	}
+	service.mutex.Unlock()
+
+	if isRunning {
+		<-download.done
+		return download.err
+	}
+
+	startTime := time.Now()
+	download.err = service.downloadToCache(walFileName)
+	if download.err == nil {
+		service.lookahead.ObserveDownload(time.Since(startTime))
+	}
+
+	service.mutex.Lock()
+	delete(service.inFlight, walFileName)
+	service.mutex.Unlock()
+	close(download.done)
+	return download.err
+}
+
+// downloadToCache downloads WAL file, or the same segment of ancestor timeline, into the cache
+func (service *WalPrefetchService) downloadToCache(walFileName string) error {
+	reader, err := downloadAndDecompressWALFile(service.folder, walFileName)
+	if _, ok := err.(ArchiveNonExistenceError); ok {
+		ancestorWalFileName, found, ancestorErr := getAncestorWalFilename(service.folder, walFileName)
+		if ancestorErr != nil {
+			return ancestorErr
+		}
+		if found {
+			reader, err = downloadAndDecompressWALFile(service.folder, ancestorWalFileName)
+		}
+	}
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+
+	downloadPath := service.cache.DownloadPath(walFileName)
+	err = writeFileFrom(downloadPath, reader)
+	if err != nil {
+		os.Remove(downloadPath)
+		return err
+	}
+	return service.cache.Add(walFileName)
+}
+
+func writeFileFrom(filePath string, content io.Reader) error {
+	file, err := os.OpenFile(filePath, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	_, err = FastCopy(file, content)
+	if err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// TODO : unit tests
+// FetchWalThroughPrefetchService asks prefetch service listening on the socket to write WAL file to dstPath.
+// Missing file is reported as ArchiveNonExistenceError, failure of the service as PrefetchServiceError,
+// errors of connection to the service are returned as is.
+func FetchWalThroughPrefetchService(socketPath string, walFileName string, dstPath string) error {
+	absolutePath, err := filepath.Abs(dstPath)
+	if err != nil {
+		return err
+	}
+	conn, err := net.Dial("unix", socketPath)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	_, err = fmt.Fprintf(conn, "%s %s %s\n", prefetchFetchCommand, walFileName, absolutePath)
+	if err != nil {
+		return err
+	}
+	response, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil {
+		return NewPrefetchServiceError(walFileName, fmt.Sprintf("no answer from service: %v", err))
+	}
+	response = strings.TrimSpace(response)
+	switch response {
+	case prefetchOkResponse:
+		return nil
+	case prefetchMissingResponse:
+		return NewArchiveNonExistenceError(walFileName)
+	}
+	return NewPrefetchServiceError(walFileName, strings.TrimSpace(strings.TrimPrefix(response, prefetchErrorResponse)))
+}
@@ -0,0 +1,378 @@
+package internal
+
+import (
+	"bufio"
+	"fmt"
+	"github.com/pkg/errors"
+	"github.com/x4m/wal-g/internal/tracelog"
+	"io/ioutil"
+	"net"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// Daemon protocol is line based: client sends "PUSH <absolute WAL file path>",
+// daemon answers "OK" after the file is uploaded or "ERROR <message>".
+const (
+	DaemonSocketSetting = "WALG_DAEMON_SOCKET"
+
+	daemonPushCommand   = "PUSH"
+	daemonOkResponse    = "OK"
+	daemonErrorResponse = "ERROR"
+
+	daemonScanInterval  = time.Second
+	daemonFlushInterval = time.Minute
+	// daemonRememberedUploads limits number of uploaded WAL names remembered to answer repeated requests
+	daemonRememberedUploads = TotalBgUploadedLimit
+)
+
+type DaemonSocketNotConfiguredError struct {
+	error
+}
+
+func NewDaemonSocketNotConfiguredError() DaemonSocketNotConfiguredError {
+	return DaemonSocketNotConfiguredError{errors.Errorf("%s is not set, daemon has nowhere to listen", DaemonSocketSetting)}
+}
+
+func (err DaemonSocketNotConfiguredError) Error() string {
+	return fmt.Sprintf(tracelog.GetErrorFormatter(), err.error)
+}
+
+type DaemonPushError struct {
+	error
+}
+
+func NewDaemonPushError(walFilePath string, reason string) DaemonPushError {
+	return DaemonPushError{errors.Errorf("Daemon failed to upload WAL file '%s': %s", walFilePath, reason)}
+}
+
+func (err DaemonPushError) Error() string {
+	return fmt.Sprintf(tracelog.GetErrorFormatter(), err.error)
+}
+
+type walUpload struct {
+	done chan struct{}
+	err  error
+}
+
+// WalPushDaemon keeps storage sessions, crypter and delta files between WAL uploads.
+// It uploads files requested by clients and files marked ready in archive_status.
+type WalPushDaemon struct {
+	walDirectory string
+	uploader     *Uploader
+	// uploaderMutex prevents delta file manager replacement during uploads
+	uploaderMutex sync.RWMutex
+
+	mutex         sync.Mutex
+	inFlight      map[string]*walUpload
+	uploaded      map[string]bool
+	uploadedOrder []string
+
+	workers chan struct{}
+	running sync.WaitGroup
+	// stopping rejects new uploads, it is set under mutex, so that nothing is added to running after Stop waits for it
+	stopping       bool
+	uploadsToFlush int32
+}
+
+func NewWalPushDaemon(uploader *Uploader, walDirectory string) (*WalPushDaemon, error) {
+	err := uploader.prepareCrypter()
+	if err != nil {
+		return nil, err
+	}
+	return &WalPushDaemon{
+		walDirectory: filepath.Clean(walDirectory),
+		uploader:     uploader,
+		inFlight:     make(map[string]*walUpload),
+		uploaded:     make(map[string]bool),
+		workers:      make(chan struct{}, getMaxUploadConcurrency(16)),
+	}, nil
+}
+
+// TODO : unit tests
+// HandleDaemon is invoked to perform wal-g daemon
+func HandleDaemon(uploader *Uploader, walDirectory string) {
+	socketPath, ok := LookupConfigValue(DaemonSocketSetting)
+	if !ok || socketPath == "" {
+		tracelog.ErrorLogger.FatalError(NewDaemonSocketNotConfiguredError())
+	}
+	uploader.uploadingFolder = uploader.uploadingFolder.GetSubFolder(WalPath)
+	uploader.useCurrentZstdDictionary()
+	detectOfflinePostgresSizes(filepath.Dir(filepath.Clean(walDirectory)), walDirectory)
+	daemon, err := NewWalPushDaemon(uploader, ResolveSymlink(walDirectory))
+	if err != nil {
+		tracelog.ErrorLogger.FatalError(err)
+	}
+
+	// Socket left by previous daemon prevents listening
+	err = os.Remove(socketPath)
+	if err != nil && !os.IsNotExist(err) {
+		tracelog.ErrorLogger.FatalError(err)
+	}
+	listener, err := net.Listen("unix", socketPath)
+	if err != nil {
+		tracelog.ErrorLogger.FatalError(err)
+	}
+	// Only the owner of the daemon may ask it to upload files
+	err = os.Chmod(socketPath, 0600)
+	if err != nil {
+		tracelog.ErrorLogger.FatalError(err)
+	}
+	tracelog.InfoLogger.Printf("WAL push daemon is listening on %s\n", socketPath)
+
+	stop := make(chan struct{})
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		sig := <-signals
+		tracelog.InfoLogger.Printf("Received %v, stopping WAL push daemon\n", sig)
+		close(stop)
+		listener.Close()
+	}()
+	go daemon.watchArchiveStatus(stop)
+
+	err = daemon.Serve(listener)
+	// delta files are flushed and their data folder is unlocked before any exit
+	daemon.Stop()
+	select {
+	case <-stop:
+	default:
+		tracelog.ErrorLogger.FatalError(err)
+	}
+}
+
+// Serve answers client requests until listener is closed
+func (daemon *WalPushDaemon) Serve(listener net.Listener) error {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return err
+		}
+		go daemon.handleConnection(conn)
+	}
+}
+
+// Stop rejects new uploads, waits for running ones, flushes delta files and releases delta data folder
+func (daemon *WalPushDaemon) Stop() {
+	daemon.mutex.Lock()
+	daemon.stopping = true
+	daemon.mutex.Unlock()
+	daemon.running.Wait()
+	daemon.flushDeltaFiles()
+	daemon.uploaderMutex.Lock()
+	defer daemon.uploaderMutex.Unlock()
+	if daemon.uploader.deltaFileManager != nil {
+		daemon.uploader.deltaFileManager.unlockDataFolder()
+	}
+}
+
+func (daemon *WalPushDaemon) handleConnection(conn net.Conn) {
+	defer conn.Close()
+	request, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil {
+		tracelog.ErrorLogger.Println("Failed to read daemon request: ", err)
+		return
+	}
+	fields := strings.SplitN(strings.TrimSpace(request), " ", 2)
+	var response string
+	if len(fields) != 2 || fields[0] != daemonPushCommand {
+		response = fmt.Sprintf("%s unknown request '%s'", daemonErrorResponse, strings.TrimSpace(request))
+	} else if err = daemon.Push(fields[1]); err != nil {
+		response = fmt.Sprintf("%s %v", daemonErrorResponse, strings.Replace(err.Error(), "\n", " ", -1))
+	} else {
+		response = daemonOkResponse
+	}
+	_, err = fmt.Fprintln(conn, response)
+	if err != nil {
+		tracelog.ErrorLogger.Println("Failed to answer daemon request: ", err)
+	}
+}
+
+// Push uploads WAL file, unless it is already uploaded or being uploaded by daemon.
+// Only files, which postgres archives from WAL directory of daemon, are accepted.
+func (daemon *WalPushDaemon) Push(walFilePath string) error {
+	return daemon.push(walFilePath, false)
+}
+
+// push is called with isCounted by uploads of archive_status scan, which are already counted in running,
+// so they are completed by stopping daemon
+func (daemon *WalPushDaemon) push(walFilePath string, isCounted bool) error {
+	walFileName := filepath.Base(walFilePath)
+	if filepath.Dir(filepath.Clean(walFilePath)) != daemon.walDirectory || !isArchiveFilename(walFileName) {
+		return NewDaemonPushError(walFilePath, "daemon uploads only WAL files of "+daemon.walDirectory)
+	}
+	daemon.mutex.Lock()
+	if daemon.stopping && !isCounted {
+		daemon.mutex.Unlock()
+		return NewDaemonPushError(walFilePath, "daemon is stopping")
+	}
+	if daemon.uploaded[walFileName] {
+		daemon.mutex.Unlock()
+		tracelog.InfoLogger.Printf("WAL file '%s' is already uploaded by daemon\n", walFileName)
+		return nil
+	}
+	upload, isRunning := daemon.inFlight[walFileName]
+	if !isRunning {
+		upload = &walUpload{done: make(chan struct{})}
+		daemon.inFlight[walFileName] = upload
+		daemon.running.Add(1)
+	}
+	daemon.mutex.Unlock()
+
+	if isRunning {
+		<-upload.done
+		return upload.err
+	}
+	defer daemon.running.Done()
+
+	upload.err = daemon.upload(walFilePath)
+
+	daemon.mutex.Lock()
+	delete(daemon.inFlight, walFileName)
+	if upload.err == nil {
+		daemon.rememberUploaded(walFileName)
+	}
+	daemon.mutex.Unlock()
+	close(upload.done)
+	return upload.err
+}
+
+func (daemon *WalPushDaemon) upload(walFilePath string) error {
+	daemon.uploaderMutex.RLock()
+	defer daemon.uploaderMutex.RUnlock()
+	err := uploadWALFile(daemon.uploader.Clone(), walFilePath)
+	if err == nil {
+		atomic.AddInt32(&daemon.uploadsToFlush, 1)
+	}
+	return err
+}
+
+// rememberUploaded should be called under daemon mutex
+func (daemon *WalPushDaemon) rememberUploaded(walFileName string) {
+	daemon.uploaded[walFileName] = true
+	daemon.uploadedOrder = append(daemon.uploadedOrder, walFileName)
+	if len(daemon.uploadedOrder) > daemonRememberedUploads {
+		delete(daemon.uploaded, daemon.uploadedOrder[0])
+		daemon.uploadedOrder = daemon.uploadedOrder[1:]
+	}
+}
+
+// TODO : unit tests
+func (daemon *WalPushDaemon) watchArchiveStatus(stop chan struct{}) {
+	scanTicker := time.NewTicker(daemonScanInterval)
+	defer scanTicker.Stop()
+	flushTicker := time.NewTicker(daemonFlushInterval)
+	defer flushTicker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-scanTicker.C:
+			daemon.ScanArchiveStatus()
+		case <-flushTicker.C:
+			daemon.flushDeltaFiles()
+		}
+	}
+}
+
+// ScanArchiveStatus starts uploads of files marked ready in archive_status,
+// as many as there are free workers. Uploaded files are marked done.
+func (daemon *WalPushDaemon) ScanArchiveStatus() {
+	files, err := ioutil.ReadDir(filepath.Join(daemon.walDirectory, archiveStatus))
+	if err != nil {
+		tracelog.ErrorLogger.Print("Error of archive_status scan: ", err)
+		return
+	}
+	for _, file := range files {
+		if !strings.HasSuffix(file.Name(), readySuffix) {
+			continue
+		}
+		walFileName := strings.TrimSuffix(file.Name(), readySuffix)
+		daemon.mutex.Lock()
+		if daemon.stopping {
+			daemon.mutex.Unlock()
+			return
+		}
+		_, isRunning := daemon.inFlight[walFileName]
+		known := isRunning || daemon.uploaded[walFileName]
+		if !known {
+			daemon.running.Add(1)
+		}
+		daemon.mutex.Unlock()
+		if known {
+			continue
+		}
+		select {
+		case daemon.workers <- struct{}{}:
+		default:
+			daemon.running.Done()
+			return // No free workers, next scan will continue
+		}
+		go func() {
+			defer daemon.running.Done()
+			defer func() { <-daemon.workers }()
+			daemon.uploadReady(walFileName)
+		}()
+	}
+}
+
+func (daemon *WalPushDaemon) uploadReady(walFileName string) {
+	err := daemon.push(filepath.Join(daemon.walDirectory, walFileName), true)
+	if err != nil {
+		tracelog.ErrorLogger.Print("Error of background upload: ", err)
+		return
+	}
+	ready := filepath.Join(daemon.walDirectory, archiveStatus, walFileName+readySuffix)
+	// Postgres may have already archived the file through the client
+	err = os.Rename(ready, filepath.Join(daemon.walDirectory, archiveStatus, walFileName+done))
+	if err != nil && !os.IsNotExist(err) {
+		tracelog.ErrorLogger.Print("Error renaming .ready to .done: ", err)
+	}
+}
+
+// flushDeltaFiles uploads completed delta files and saves the rest to data folder.
+// Delta file manager can not be used after flush, so it is replaced with a new one.
+func (daemon *WalPushDaemon) flushDeltaFiles() {
+	daemon.uploaderMutex.Lock()
+	defer daemon.uploaderMutex.Unlock()
+	manager := daemon.uploader.deltaFileManager
+	if manager == nil || atomic.SwapInt32(&daemon.uploadsToFlush, 0) == 0 {
+		return
+	}
+	manager.FlushFiles(daemon.uploader.Clone())
+	daemon.uploader.deltaFileManager = NewDeltaFileManager(manager.dataFolder)
+}
+
+// TODO : unit tests
+// PushWalThroughDaemon asks daemon listening on the socket to upload WAL file and waits for the upload.
+// Error of connection to the daemon is returned as is, upload failure is DaemonPushError.
+func PushWalThroughDaemon(socketPath string, walFilePath string) error {
+	absolutePath, err := filepath.Abs(walFilePath)
+	if err != nil {
+		return err
+	}
+	conn, err := net.Dial("unix", socketPath)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	_, err = fmt.Fprintf(conn, "%s %s\n", daemonPushCommand, absolutePath)
+	if err != nil {
+		return err
+	}
+	response, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil {
+		return NewDaemonPushError(walFilePath, fmt.Sprintf("no answer from daemon: %v", err))
+	}
+	response = strings.TrimSpace(response)
+	if response != daemonOkResponse {
+		return NewDaemonPushError(walFilePath, strings.TrimSpace(strings.TrimPrefix(response, daemonErrorResponse)))
+	}
+	return nil
+}
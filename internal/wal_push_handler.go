@@ -2,14 +2,21 @@ package internal
 
 import (
 	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
 	"github.com/pkg/errors"
 	"github.com/x4m/wal-g/internal/tracelog"
+	"io"
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"strings"
 )
 
+// WalHashSuffix is appended to WAL file name to name object with SHA-256 of uncompressed WAL file
+const WalHashSuffix = ".sha256"
+
 type CantOverwriteWalFileError struct {
 	error
 }
@@ -26,11 +33,21 @@ func (err CantOverwriteWalFileError) Error() string {
 // HandleWALPush is invoked to perform wal-g wal-push
 func HandleWALPush(uploader *Uploader, walFilePath string) {
 	uploader.uploadingFolder = uploader.uploadingFolder.GetSubFolder(WalPath)
+	uploader.useCurrentZstdDictionary()
+	detectOfflinePostgresSizes(walDataDirectory(walFilePath), filepath.Dir(walFilePath))
+	err := uploader.prepareCrypter()
+	if err != nil {
+		tracelog.ErrorLogger.FatalError(err)
+	}
 	bgUploader := NewBgUploader(walFilePath, int32(getMaxUploadConcurrency(16)-1), uploader)
 	// Look for new WALs while doing main upload
 	bgUploader.Start()
-	err := uploadWALFile(uploader, walFilePath)
+	err = uploadWALFile(uploader, walFilePath)
 	if err != nil {
+		if uploader.deltaFileManager != nil {
+			bgUploader.Stop()
+			uploader.deltaFileManager.unlockDataFolder()
+		}
 		panic(err)
 	}
 
@@ -43,12 +60,20 @@ func HandleWALPush(uploader *Uploader, walFilePath string) {
 // TODO : unit tests
 // uploadWALFile from FS to the cloud
 func uploadWALFile(uploader *Uploader, walFilePath string) error {
+	var contentHash string
 	if uploader.preventWalOverwrite {
-		overwriteAttempt, err := checkWALOverwrite(uploader, walFilePath)
+		var err error
+		contentHash, err = hashWALFile(walFilePath)
+		if err != nil {
+			return errors.Wrapf(err, "upload: could not hash '%s'\n", walFilePath)
+		}
+		overwriteAttempt, archived, err := checkWALOverwrite(uploader, walFilePath, contentHash)
 		if err != nil {
 			return errors.Wrap(err, "Couldn't check whether there is an overwrite attempt due to inner error")
 		} else if overwriteAttempt {
 			return NewCantOverwriteWalFileError(walFilePath)
+		} else if archived {
+			return nil
 		}
 	}
 	walFile, err := os.Open(walFilePath)
@@ -56,33 +81,99 @@ func uploadWALFile(uploader *Uploader, walFilePath string) error {
 		return errors.Wrapf(err, "upload: could not open '%s'\n", walFilePath)
 	}
 	err = uploader.UploadWalFile(walFile)
-	return errors.Wrapf(err, "upload: could not upload '%s'\n", walFilePath)
+	if err != nil {
+		return errors.Wrapf(err, "upload: could not upload '%s'\n", walFilePath)
+	}
+	if contentHash != "" {
+		// Hash is stored after the segment, so existing hash means segment is completely uploaded
+		err = putWALHash(uploader.uploadingFolder, filepath.Base(walFilePath), contentHash)
+		return errors.Wrapf(err, "upload: could not upload hash of '%s'\n", walFilePath)
+	}
+	return nil
+}
+
+// hashWALFile computes hex encoded SHA-256 of uncompressed WAL file
+func hashWALFile(walFilePath string) (string, error) {
+	walFile, err := os.Open(walFilePath)
+	if err != nil {
+		return "", err
+	}
+	defer walFile.Close()
+	hash := sha256.New()
+	_, err = io.Copy(hash, walFile)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+func putWALHash(walFolder StorageFolder, walFileName string, contentHash string) error {
+	return walFolder.PutObject(walFileName+WalHashSuffix, strings.NewReader(contentHash))
+}
+
+// TODO : unit tests
+// fetchWALHash returns stored hash of archived WAL file, or false for files archived without hash
+func fetchWALHash(walFolder StorageFolder, walFileName string) (string, bool, error) {
+	reader, err := walFolder.ReadObject(walFileName + WalHashSuffix)
+	if _, ok := errors.Cause(err).(ObjectNotFoundError); ok {
+		return "", false, nil
+	}
+	if err != nil {
+		return "", false, err
+	}
+	defer reader.Close()
+	contentHash, err := ioutil.ReadAll(reader)
+	if err != nil {
+		return "", false, err
+	}
+	return strings.TrimSpace(string(contentHash)), true, nil
 }
 
 // TODO : unit tests
-func checkWALOverwrite(uploader *Uploader, walFilePath string) (overwriteAttempt bool, err error) {
-	walFileReader, err := downloadAndDecompressWALFile(uploader.uploadingFolder, filepath.Base(walFilePath)+"."+uploader.compressor.FileExtension())
+// checkWALOverwrite compares local WAL file with archived one. Stored hashes are compared when available,
+// files archived without hash are downloaded and compared byte by byte.
+// Identical archived file is not an overwrite attempt, such file need not be uploaded again.
+func checkWALOverwrite(uploader *Uploader, walFilePath string, localHash string) (overwriteAttempt bool, archived bool, err error) {
+	walFileName := filepath.Base(walFilePath)
+	archivedHash, hasHash, err := fetchWALHash(uploader.uploadingFolder, walFileName)
+	if err != nil {
+		return false, false, err
+	}
+	if hasHash {
+		if archivedHash != localHash {
+			return true, false, nil
+		}
+		tracelog.WarningLogger.Printf("WAL file '%s' already archived, archived content hash equals\n", walFilePath)
+		return false, true, nil
+	}
+
+	walFileReader, err := downloadAndDecompressWALFile(uploader.uploadingFolder, walFileName)
 	if err != nil {
 		if _, ok := err.(ArchiveNonExistenceError); ok {
 			err = nil
 		}
-		return false, err
+		return false, false, err
 	}
+	defer walFileReader.Close()
 
-	archived, err := ioutil.ReadAll(walFileReader)
+	archivedBytes, err := ioutil.ReadAll(walFileReader)
 	if err != nil {
-		return false, err
+		return false, false, err
 	}
 
 	localBytes, err := ioutil.ReadFile(walFilePath)
 	if err != nil {
-		return false, err
+		return false, false, err
 	}
 
-	if !bytes.Equal(archived, localBytes) {
-		return true, nil
-	} else {
-		tracelog.WarningLogger.Printf("WAL file '%s' already archived, archived content equals\n", walFilePath)
-		return false, nil
+	if !bytes.Equal(archivedBytes, localBytes) {
+		return true, false, nil
+	}
+	tracelog.WarningLogger.Printf("WAL file '%s' already archived, archived content equals\n", walFilePath)
+	// Next checks of the file will not download it
+	err = putWALHash(uploader.uploadingFolder, walFileName, localHash)
+	if err != nil {
+		tracelog.WarningLogger.Printf("Failed to store hash of archived WAL file '%s': %v\n", walFileName, err)
 	}
+	return false, true, nil
 }
@@ -0,0 +1,357 @@
+package internal
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/pkg/errors"
+	"github.com/x4m/wal-g/internal/tracelog"
+	"github.com/x4m/wal-g/internal/walparser"
+	"io"
+	"os"
+	"sort"
+	"strings"
+)
+
+const (
+	WalShowStatsFlag = "--stats"
+	WalShowJsonFlag  = "--json"
+
+	// walShowMaxSegments bounds range of wal-show, each segment is downloaded
+	walShowMaxSegments = 4096
+)
+
+var forkNames = []string{"main", "fsm", "vm", "init"}
+
+type InvalidWalShowRangeError struct {
+	error
+}
+
+func NewInvalidWalShowRangeError(segmentRange string, reason string) InvalidWalShowRangeError {
+	return InvalidWalShowRangeError{errors.Errorf("Invalid WAL segment range '%s': %s", segmentRange, reason)}
+}
+
+func (err InvalidWalShowRangeError) Error() string {
+	return fmt.Sprintf(tracelog.GetErrorFormatter(), err.error)
+}
+
+// WalShowCommandArguments are arguments of wal-show: segment or range of segments on one timeline
+type WalShowCommandArguments struct {
+	FirstSegment string
+	LastSegment  string
+	Stats        bool
+	Json         bool
+}
+
+// ParseWalShowArguments parses "segment" or "first_segment-last_segment" and flags in any order
+func ParseWalShowArguments(args []string) (WalShowCommandArguments, error) {
+	result := WalShowCommandArguments{}
+	segmentRange := ""
+	for _, arg := range args {
+		switch arg {
+		case WalShowStatsFlag:
+			result.Stats = true
+		case WalShowJsonFlag:
+			result.Json = true
+		default:
+			if segmentRange != "" {
+				return result, errors.Errorf("unexpected argument '%s'", arg)
+			}
+			segmentRange = arg
+		}
+	}
+	if segmentRange == "" {
+		return result, errors.New("WAL segment is not specified")
+	}
+	bounds := strings.SplitN(segmentRange, "-", 2)
+	result.FirstSegment, result.LastSegment = bounds[0], bounds[0]
+	if len(bounds) == 2 {
+		result.LastSegment = bounds[1]
+	}
+	firstTimeline, firstSegNo, err := ParseWALFilename(result.FirstSegment)
+	if err != nil {
+		return result, NewInvalidWalShowRangeError(segmentRange, err.Error())
+	}
+	lastTimeline, lastSegNo, err := ParseWALFilename(result.LastSegment)
+	if err != nil {
+		return result, NewInvalidWalShowRangeError(segmentRange, err.Error())
+	}
+	if firstTimeline != lastTimeline {
+		return result, NewInvalidWalShowRangeError(segmentRange, "segments are on different timelines")
+	}
+	if lastSegNo < firstSegNo {
+		return result, NewInvalidWalShowRangeError(segmentRange, "last segment precedes first one")
+	}
+	if lastSegNo-firstSegNo >= walShowMaxSegments {
+		return result, NewInvalidWalShowRangeError(segmentRange, fmt.Sprintf("more than %d segments", walShowMaxSegments))
+	}
+	return result, nil
+}
+
+// WalShowBlock is a block referenced by WAL record
+type WalShowBlock struct {
+	SpcNode  uint32
+	DBNode   uint32
+	RelNode  uint32
+	Fork     string
+	BlockNo  uint32
+	FpiBytes uint32 `json:",omitempty"`
+}
+
+// WalShowRecord describes WAL record like pg_waldump does
+type WalShowRecord struct {
+	Lsn               string
+	PrevLsn           string
+	ResourceManagerID uint8
+	ResourceManager   string
+	Info              uint8
+	Xid               uint32
+	TotalLength       uint32
+	// RecordLength is the length of record without full page images
+	RecordLength uint32
+	Blocks       []WalShowBlock `json:",omitempty"`
+}
+
+// WalShowResourceManagerStats sums records of one resource manager like pg_waldump --stats does
+type WalShowResourceManagerStats struct {
+	ResourceManagerID uint8 `json:"-"`
+	ResourceManager   string
+	Records           uint64
+	RecordBytes       uint64
+	FpiCount          uint64
+	FpiBytes          uint64
+}
+
+func (stats *WalShowResourceManagerStats) add(record *WalShowRecord) {
+	stats.Records++
+	stats.RecordBytes += uint64(record.RecordLength)
+	for _, block := range record.Blocks {
+		if block.FpiBytes > 0 {
+			stats.FpiCount++
+			stats.FpiBytes += uint64(block.FpiBytes)
+		}
+	}
+}
+
+// WalShowStats contains stats of resource managers ordered by id
+type WalShowStats struct {
+	ResourceManagers []WalShowResourceManagerStats
+	Total            WalShowResourceManagerStats
+}
+
+func NewWalShowStats() *WalShowStats {
+	return &WalShowStats{
+		ResourceManagers: make([]WalShowResourceManagerStats, 0),
+		Total:            WalShowResourceManagerStats{ResourceManager: "Total"},
+	}
+}
+
+func (stats *WalShowStats) Add(record *WalShowRecord) {
+	managers := stats.ResourceManagers
+	index := sort.Search(len(managers), func(i int) bool {
+		return managers[i].ResourceManagerID >= record.ResourceManagerID
+	})
+	if index == len(managers) || managers[index].ResourceManagerID != record.ResourceManagerID {
+		managers = append(managers, WalShowResourceManagerStats{})
+		copy(managers[index+1:], managers[index:])
+		managers[index] = WalShowResourceManagerStats{
+			ResourceManagerID: record.ResourceManagerID,
+			ResourceManager:   record.ResourceManager,
+		}
+		stats.ResourceManagers = managers
+	}
+	managers[index].add(record)
+	stats.Total.add(record)
+}
+
+func formatLsn(lsn uint64) string {
+	return fmt.Sprintf("%X/%08X", lsn>>32, uint32(lsn))
+}
+
+func resourceManagerName(id uint8) string {
+	if id < walparser.RmNextFreeID {
+		return walparser.ResourceManagerNames[id]
+	}
+	return fmt.Sprintf("%d", id)
+}
+
+func forkName(forkNum uint8) string {
+	if int(forkNum) < len(forkNames) {
+		return forkNames[forkNum]
+	}
+	return fmt.Sprintf("%d", forkNum)
+}
+
+// NewWalShowRecord describes parsed record
+func NewWalShowRecord(record *walparser.XLogRecord) WalShowRecord {
+	result := WalShowRecord{
+		Lsn:               formatLsn(uint64(record.Lsn)),
+		PrevLsn:           formatLsn(uint64(record.Header.PrevRecordPtr)),
+		ResourceManagerID: record.Header.ResourceManagerID,
+		ResourceManager:   resourceManagerName(record.Header.ResourceManagerID),
+		Info:              record.Header.Info,
+		Xid:               record.Header.XactID,
+		TotalLength:       record.Header.TotalRecordLength,
+		RecordLength:      record.Header.TotalRecordLength,
+	}
+	for _, block := range record.Blocks {
+		location := block.Header.BlockLocation
+		showBlock := WalShowBlock{
+			SpcNode: uint32(location.RelationFileNode.SpcNode),
+			DBNode:  uint32(location.RelationFileNode.DBNode),
+			RelNode: uint32(location.RelationFileNode.RelNode),
+			Fork:    forkName(block.Header.ForkNum()),
+			BlockNo: location.BlockNo,
+		}
+		if block.Header.HasImage() {
+			showBlock.FpiBytes = uint32(block.Header.ImageHeader.ImageLength)
+			result.RecordLength -= showBlock.FpiBytes
+		}
+		result.Blocks = append(result.Blocks, showBlock)
+	}
+	return result
+}
+
+func (record *WalShowRecord) WriteText(writer io.Writer) error {
+	var line bytes.Buffer
+	fmt.Fprintf(&line, "rmgr: %-17s len (rec/tot): %6d/%6d, tx: %10d, lsn: %s, prev %s, info: 0x%02X",
+		record.ResourceManager, record.RecordLength, record.TotalLength, record.Xid, record.Lsn, record.PrevLsn, record.Info)
+	for i, block := range record.Blocks {
+		fmt.Fprintf(&line, ", blkref #%d: rel %d/%d/%d", i, block.SpcNode, block.DBNode, block.RelNode)
+		if block.Fork != forkNames[0] {
+			fmt.Fprintf(&line, " fork %s", block.Fork)
+		}
+		fmt.Fprintf(&line, " blk %d", block.BlockNo)
+		if block.FpiBytes > 0 {
+			fmt.Fprintf(&line, " FPW %d", block.FpiBytes)
+		}
+	}
+	line.WriteString("\n")
+	_, err := writer.Write(line.Bytes())
+	return err
+}
+
+func percentOf(part uint64, total uint64) float64 {
+	if total == 0 {
+		return 0
+	}
+	return 100 * float64(part) / float64(total)
+}
+
+func (stats *WalShowStats) WriteText(writer io.Writer) error {
+	_, err := fmt.Fprintf(writer, "%-17s %20s %20s %20s %20s\n%-17s %20s %20s %20s %20s\n",
+		"Type", "N      (%)", "Record size      (%)", "FPI size      (%)", "Combined size      (%)",
+		"----", "-      ---", "-----------      ---", "--------      ---", "-------------      ---")
+	if err != nil {
+		return err
+	}
+	for i := range stats.ResourceManagers {
+		if err = stats.writeTextRow(writer, &stats.ResourceManagers[i]); err != nil {
+			return err
+		}
+	}
+	_, err = fmt.Fprintf(writer, "%-17s %20s %20s %20s %20s\n", "", "--------", "--------", "--------", "--------")
+	if err != nil {
+		return err
+	}
+	return stats.writeTextRow(writer, &stats.Total)
+}
+
+func (stats *WalShowStats) writeTextRow(writer io.Writer, managerStats *WalShowResourceManagerStats) error {
+	totalBytes := stats.Total.RecordBytes + stats.Total.FpiBytes
+	combined := managerStats.RecordBytes + managerStats.FpiBytes
+	_, err := fmt.Fprintf(writer, "%-17s %10d (%6.2f) %10d (%6.2f) %10d (%6.2f) %10d (%6.2f)\n", managerStats.ResourceManager,
+		managerStats.Records, percentOf(managerStats.Records, stats.Total.Records),
+		managerStats.RecordBytes, percentOf(managerStats.RecordBytes, totalBytes),
+		managerStats.FpiBytes, percentOf(managerStats.FpiBytes, totalBytes),
+		combined, percentOf(combined, totalBytes))
+	return err
+}
+
+// ReadWalShowRecords parses pages of WAL segment. Parser is carried across consecutive segments,
+// so records continued from the segment before the first one are skipped
+func ReadWalShowRecords(parser *walparser.WalParser, walFile io.Reader) ([]WalShowRecord, error) {
+	pageReader := walparser.NewWalPageReader(walFile)
+	records := make([]WalShowRecord, 0)
+	for {
+		data, err := pageReader.ReadPageData()
+		if err == io.EOF {
+			return records, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		_, pageRecords, err := parser.ParseRecordsFromPage(bytes.NewReader(data))
+		switch err.(type) {
+		case nil, walparser.PartialPageError, walparser.ZeroPageError:
+		default:
+			return nil, err
+		}
+		for i := range pageRecords {
+			records = append(records, NewWalShowRecord(&pageRecords[i]))
+		}
+	}
+}
+
+func readArchivedWalShowRecords(walFolder StorageFolder, walFileName string, parser *walparser.WalParser) ([]WalShowRecord, error) {
+	tracelog.DebugLogger.Printf("Reading %s\n", walFileName)
+	reader, err := downloadAndDecompressWALFile(walFolder, walFileName)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+	records, err := ReadWalShowRecords(parser, reader)
+	return records, errors.Wrapf(err, "failed to parse '%s'", walFileName)
+}
+
+// TODO : unit tests
+// HandleWalShow is invoked to perform wal-g wal-show
+func HandleWalShow(folder StorageFolder, arguments WalShowCommandArguments) {
+	timeline, firstSegNo, _ := ParseWALFilename(arguments.FirstSegment)
+	_, lastSegNo, _ := ParseWALFilename(arguments.LastSegment)
+	walFolder := folder.GetSubFolder(WalPath)
+	parser := walparser.NewWalParser()
+	stats := NewWalShowStats()
+	allRecords := make([]WalShowRecord, 0)
+
+	for logSegNo := firstSegNo; logSegNo <= lastSegNo; logSegNo++ {
+		records, err := readArchivedWalShowRecords(walFolder, formatWALFileName(timeline, logSegNo), parser)
+		if err != nil {
+			tracelog.ErrorLogger.FatalError(err)
+		}
+		switch {
+		case arguments.Stats:
+			for i := range records {
+				stats.Add(&records[i])
+			}
+		case arguments.Json:
+			allRecords = append(allRecords, records...)
+		default:
+			for i := 0; i < len(records) && err == nil; i++ {
+				err = records[i].WriteText(os.Stdout)
+			}
+		}
+		if err != nil {
+			tracelog.ErrorLogger.FatalError(err)
+		}
+	}
+
+	var err error
+	switch {
+	case arguments.Stats && arguments.Json:
+		err = writeIndentedJson(os.Stdout, stats)
+	case arguments.Stats:
+		err = stats.WriteText(os.Stdout)
+	case arguments.Json:
+		err = writeIndentedJson(os.Stdout, allRecords)
+	}
+	if err != nil {
+		tracelog.ErrorLogger.FatalError(err)
+	}
+}
+
+func writeIndentedJson(writer io.Writer, value interface{}) error {
+	encoder := json.NewEncoder(writer)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(value)
+}
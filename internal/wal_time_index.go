@@ -0,0 +1,133 @@
+package internal
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/pkg/errors"
+	"github.com/x4m/wal-g/internal/tracelog"
+	"github.com/x4m/wal-g/internal/walparser"
+	"io"
+	"io/ioutil"
+	"time"
+)
+
+// WalTimeIndexSuffix is appended to WAL file name to name object with WalTimeIndex of the WAL file
+const WalTimeIndexSuffix = ".time"
+
+// WalTimeIndex is the time range of transaction commits and aborts, which start in WAL file
+type WalTimeIndex struct {
+	FirstTime time.Time
+	LastTime  time.Time
+	FirstLsn  uint64
+	LastLsn   uint64
+	Commits   uint32
+	Aborts    uint32
+	// EndsWithPartialRecord tells that the last record starting in WAL file ends in the next one,
+	// such record is not indexed, but wal-find still parses it
+	EndsWithPartialRecord bool `json:",omitempty"`
+}
+
+func (index *WalTimeIndex) isEmpty() bool {
+	return index.Commits == 0 && index.Aborts == 0
+}
+
+func (index *WalTimeIndex) add(record *walparser.XLogRecord) {
+	xactTime, ok := record.GetXactTime()
+	if !ok {
+		return
+	}
+	if index.isEmpty() {
+		index.FirstTime, index.FirstLsn = xactTime, uint64(record.Lsn)
+	}
+	if xactTime.Before(index.FirstTime) {
+		index.FirstTime = xactTime
+	}
+	if xactTime.After(index.LastTime) {
+		index.LastTime = xactTime
+	}
+	index.LastLsn = uint64(record.Lsn)
+	if record.IsXactCommit() {
+		index.Commits++
+	} else {
+		index.Aborts++
+	}
+}
+
+// WalTimeIndexingReader passes WAL file through and collects times of transaction ends from its records.
+// Indexing stops on parsing error, data is still read correctly.
+type WalTimeIndexingReader struct {
+	reader    io.Reader
+	walParser *walparser.WalParser
+	pageData  []byte
+	Index     WalTimeIndex
+	Err       error
+}
+
+func NewWalTimeIndexingReader(walFileReader io.Reader) *WalTimeIndexingReader {
+	return &WalTimeIndexingReader{reader: walFileReader, walParser: walparser.NewWalParser()}
+}
+
+func (reader *WalTimeIndexingReader) Read(p []byte) (n int, err error) {
+	n, err = reader.reader.Read(p)
+	if reader.Err != nil {
+		return
+	}
+	reader.pageData = append(reader.pageData, p[:n]...)
+	pageSize := int(walparser.WalPageSize)
+	for reader.Err == nil && len(reader.pageData) >= pageSize {
+		reader.Err = reader.indexPage(reader.pageData[:pageSize])
+		reader.pageData = reader.pageData[pageSize:]
+	}
+	if len(reader.pageData) == 0 {
+		reader.pageData = nil
+	}
+	return
+}
+
+func (reader *WalTimeIndexingReader) indexPage(pageData []byte) error {
+	_, records, err := reader.walParser.ParseRecordsFromPage(bytes.NewReader(pageData))
+	switch err.(type) {
+	case nil, walparser.PartialPageError, walparser.ZeroPageError:
+	default:
+		return err
+	}
+	for i := range records {
+		reader.Index.add(&records[i])
+	}
+	reader.Index.EndsWithPartialRecord = len(reader.walParser.GetCurrentRecordData()) > 0 &&
+		reader.walParser.HasCurrentRecordBeginning()
+	return nil
+}
+
+// TODO : unit tests
+func uploadWalTimeIndex(walFolder StorageFolder, walFileName string, index *WalTimeIndex) error {
+	if index.isEmpty() && !index.EndsWithPartialRecord {
+		return nil
+	}
+	data, err := json.Marshal(index)
+	if err != nil {
+		return err
+	}
+	return walFolder.PutObject(walFileName+WalTimeIndexSuffix, bytes.NewReader(data))
+}
+
+// TODO : unit tests
+func fetchWalTimeIndex(walFolder StorageFolder, walFileName string) (*WalTimeIndex, error) {
+	reader, err := walFolder.ReadObject(walFileName + WalTimeIndexSuffix)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+	data, err := ioutil.ReadAll(reader)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to read time index of '%s'", walFileName)
+	}
+	index := &WalTimeIndex{}
+	err = json.Unmarshal(data, index)
+	return index, errors.Wrapf(err, "failed to unmarshal time index of '%s'", walFileName)
+}
+
+// logWalTimeIndexingError does not fail WAL upload, wal-find just does not search WAL file without index
+func logWalTimeIndexingError(walFileName string, err error) {
+	tracelog.WarningLogger.Printf("Failed to index transaction times of '%s': %v\n", walFileName, err)
+}
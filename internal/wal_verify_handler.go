@@ -0,0 +1,276 @@
+package internal
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/x4m/wal-g/internal/tracelog"
+	"io"
+	"os"
+	"path"
+	"sort"
+	"strings"
+)
+
+const (
+	WalVerifyJsonFlag = "--json"
+
+	// WalVerifyFailedExitCode is returned when some backup can not be restored up to the end of timeline.
+	// Errors preventing the check exit with code 1.
+	WalVerifyFailedExitCode = 2
+)
+
+// WalSegmentRange is a range of consecutive WAL segments
+type WalSegmentRange struct {
+	First string
+	Last  string
+}
+
+// TimelineVerifyResult tells if timeline is reachable from backup without gaps
+type TimelineVerifyResult struct {
+	Timeline        uint32
+	LastSegment     string
+	MissingSegments []WalSegmentRange `json:",omitempty"`
+}
+
+func (result *TimelineVerifyResult) Ok() bool {
+	return len(result.MissingSegments) == 0
+}
+
+type BackupWalVerifyResult struct {
+	BackupName   string
+	StartSegment string
+	Timelines    []TimelineVerifyResult
+}
+
+func (result *BackupWalVerifyResult) Ok() bool {
+	for _, timeline := range result.Timelines {
+		if !timeline.Ok() {
+			return false
+		}
+	}
+	return true
+}
+
+// WalVerifyReport describes WAL archive continuity for each backup
+type WalVerifyReport struct {
+	Timelines []uint32
+	// MissingHistories are timelines without .history file, their ancestors are unknown
+	MissingHistories []uint32 `json:",omitempty"`
+	Backups          []BackupWalVerifyResult
+	Ok               bool
+}
+
+// TODO : unit tests
+// HandleWalVerify is invoked to perform wal-g wal-verify
+func HandleWalVerify(folder StorageFolder, jsonOutput bool) {
+	walFolder := folder.GetSubFolder(WalPath)
+	walObjects, _, err := walFolder.ListFolder()
+	if err != nil {
+		tracelog.ErrorLogger.FatalError(err)
+	}
+
+	walFileNames := make([]string, 0, len(walObjects))
+	histories := make(map[uint32]TimelineHistory)
+	for _, walObject := range walObjects {
+		if strings.HasSuffix(walObject.GetName(), WalHashSuffix) || strings.HasSuffix(walObject.GetName(), WalTimeIndexSuffix) {
+			continue
+		}
+		name := trimWalObjectExtension(walObject.GetName())
+		if timeline, ok := parseHistoryFilename(name); ok {
+			history, err := fetchTimelineHistory(walFolder, timeline)
+			if err != nil {
+				tracelog.ErrorLogger.FatalError(err)
+			}
+			histories[timeline] = history
+			continue
+		}
+		walFileNames = append(walFileNames, name)
+	}
+
+	backups, _, err := getBackupsAndGarbage(folder)
+	if err != nil {
+		tracelog.ErrorLogger.FatalError(err)
+	}
+	if len(backups) > 0 {
+		// Segment size of the cluster is needed to find segments of timeline switch
+		sentinelDto, err := NewBackup(folder.GetSubFolder(BaseBackupPath), backups[0].BackupName).fetchSentinel()
+		if err != nil {
+			tracelog.ErrorLogger.FatalError(err)
+		}
+		err = sentinelDto.applyPostgresSizes()
+		if err != nil {
+			tracelog.ErrorLogger.FatalError(err)
+		}
+	}
+	backupNames := make([]string, 0, len(backups))
+	for _, backup := range backups {
+		backupNames = append(backupNames, backup.BackupName)
+	}
+
+	report := VerifyWalArchive(walFileNames, histories, backupNames)
+	if jsonOutput {
+		err = report.WriteJson(os.Stdout)
+	} else {
+		err = report.WriteText(os.Stdout)
+	}
+	if err != nil {
+		tracelog.ErrorLogger.FatalError(err)
+	}
+	if !report.Ok {
+		os.Exit(WalVerifyFailedExitCode)
+	}
+}
+
+// trimWalObjectExtension removes compression extension from WAL archive name
+func trimWalObjectExtension(objectName string) string {
+	return strings.TrimSuffix(objectName, path.Ext(objectName))
+}
+
+// VerifyWalArchive checks that for each backup all segments exist from its start
+// to the last archived segment of each timeline reachable from the backup.
+// Names which are not WAL segments are ignored.
+func VerifyWalArchive(walFileNames []string, histories map[uint32]TimelineHistory, backupNames []string) *WalVerifyReport {
+	archived := make(map[string]bool, len(walFileNames))
+	lastSegments := make(map[uint32]uint64)
+	allHistories := make(map[uint32]TimelineHistory, len(histories))
+	for timeline, history := range histories {
+		allHistories[timeline] = history
+	}
+	// History of an ancestor is a prefix of its descendant history
+	for _, history := range histories {
+		for i, record := range history {
+			if _, ok := allHistories[record.Timeline]; !ok {
+				allHistories[record.Timeline] = history[:i]
+			}
+		}
+	}
+
+	report := &WalVerifyReport{Ok: true}
+	for _, name := range walFileNames {
+		timeline, logSegNo, err := ParseWALFilename(name)
+		if err != nil {
+			continue
+		}
+		archived[name] = true
+		if last, ok := lastSegments[timeline]; !ok || logSegNo > last {
+			lastSegments[timeline] = logSegNo
+		}
+		if _, ok := allHistories[timeline]; !ok {
+			if timeline > 1 {
+				report.MissingHistories = append(report.MissingHistories, timeline)
+				report.Ok = false
+			}
+			allHistories[timeline] = TimelineHistory{}
+		}
+	}
+	sort.Slice(report.MissingHistories, func(i, j int) bool { return report.MissingHistories[i] < report.MissingHistories[j] })
+	report.Timelines = sortedTimelines(allHistories)
+
+	for _, backupName := range backupNames {
+		backupTimeline, startSegNo, err := ParseWALFilename(stripWalFileName(backupName))
+		if err != nil {
+			tracelog.WarningLogger.Printf("Unable to find start segment of backup %s: %v\n", backupName, err)
+			continue
+		}
+		backupResult := BackupWalVerifyResult{
+			BackupName:   backupName,
+			StartSegment: formatWALFileName(backupTimeline, startSegNo),
+			Timelines:    make([]TimelineVerifyResult, 0),
+		}
+		for _, timeline := range report.Timelines {
+			history := allHistories[timeline]
+			if timeline != backupTimeline {
+				switchLSN, isAncestor := history.SwitchLSN(backupTimeline)
+				if !isAncestor || switchLSN/WalSegmentSize < startSegNo {
+					continue
+				}
+			}
+			backupResult.Timelines = append(backupResult.Timelines,
+				verifyTimeline(timeline, history, startSegNo, lastSegments, archived))
+		}
+		if !backupResult.Ok() {
+			report.Ok = false
+		}
+		report.Backups = append(report.Backups, backupResult)
+	}
+	return report
+}
+
+// verifyTimeline finds segments missing to replay timeline from the start segment to its last archived segment
+func verifyTimeline(timeline uint32, history TimelineHistory, startSegNo uint64,
+	lastSegments map[uint32]uint64, archived map[string]bool) TimelineVerifyResult {
+	lastSegNo, ok := lastSegments[timeline]
+	if !ok {
+		// Nothing is archived on this timeline yet, it must be reachable up to the switch point
+		lastSegNo = startSegNo
+		if len(history) > 0 && history[len(history)-1].SwitchLSN/WalSegmentSize > startSegNo {
+			lastSegNo = history[len(history)-1].SwitchLSN/WalSegmentSize - 1
+		}
+	}
+
+	result := TimelineVerifyResult{Timeline: timeline, LastSegment: formatWALFileName(timeline, lastSegNo)}
+	inGap := false
+	for logSegNo := startSegNo; logSegNo <= lastSegNo; logSegNo++ {
+		name := formatWALFileName(history.TimelineForSegment(timeline, logSegNo), logSegNo)
+		if archived[name] {
+			inGap = false
+			continue
+		}
+		if inGap {
+			result.MissingSegments[len(result.MissingSegments)-1].Last = name
+		} else {
+			result.MissingSegments = append(result.MissingSegments, WalSegmentRange{name, name})
+			inGap = true
+		}
+	}
+	return result
+}
+
+func (report *WalVerifyReport) WriteJson(writer io.Writer) error {
+	encoder := json.NewEncoder(writer)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(report)
+}
+
+func (report *WalVerifyReport) WriteText(writer io.Writer) error {
+	timelines := make([]string, 0, len(report.Timelines))
+	for _, timeline := range report.Timelines {
+		timelines = append(timelines, fmt.Sprintf("%d", timeline))
+	}
+	if _, err := fmt.Fprintf(writer, "Timelines: %s\n", strings.Join(timelines, ", ")); err != nil {
+		return err
+	}
+	for _, timeline := range report.MissingHistories {
+		if _, err := fmt.Fprintf(writer, "Timeline %d: history file %s is missing\n", timeline, formatHistoryFileName(timeline)); err != nil {
+			return err
+		}
+	}
+	for _, backup := range report.Backups {
+		if _, err := fmt.Fprintf(writer, "Backup %s from %s:\n", backup.BackupName, backup.StartSegment); err != nil {
+			return err
+		}
+		for _, timeline := range backup.Timelines {
+			status := "OK"
+			if !timeline.Ok() {
+				ranges := make([]string, 0, len(timeline.MissingSegments))
+				for _, missing := range timeline.MissingSegments {
+					if missing.First == missing.Last {
+						ranges = append(ranges, missing.First)
+					} else {
+						ranges = append(ranges, missing.First+"-"+missing.Last)
+					}
+				}
+				status = "MISSING " + strings.Join(ranges, ", ")
+			}
+			if _, err := fmt.Fprintf(writer, "\ttimeline %d up to %s: %s\n", timeline.Timeline, timeline.LastSegment, status); err != nil {
+				return err
+			}
+		}
+	}
+	if report.Ok {
+		_, err := fmt.Fprintln(writer, "WAL archive is consistent")
+		return err
+	}
+	_, err := fmt.Fprintln(writer, "WAL archive has gaps")
+	return err
+}
@@ -0,0 +1,69 @@
+package walparser
+
+import (
+	"fmt"
+	"github.com/pkg/errors"
+	"github.com/x4m/wal-g/internal/tracelog"
+)
+
+/* Decompression of pglz format, used for compressed full page images, for clarification you can look at postgres code:
+ * src/common/pg_lzcompress.c
+ */
+
+type CorruptPglzDataError struct {
+	error
+}
+
+func NewCorruptPglzDataError(reason string) CorruptPglzDataError {
+	return CorruptPglzDataError{errors.Errorf("pglz compressed data is corrupt: %s", reason)}
+}
+
+func (err CorruptPglzDataError) Error() string {
+	return fmt.Sprintf(tracelog.GetErrorFormatter(), err.error)
+}
+
+// PglzDecompress decompresses source, which should be expanded exactly to rawSize bytes
+func PglzDecompress(source []byte, rawSize int) ([]byte, error) {
+	result := make([]byte, 0, rawSize)
+	sourcePosition := 0
+	for sourcePosition < len(source) && len(result) < rawSize {
+		control := source[sourcePosition]
+		sourcePosition++
+		for bit := 0; bit < 8 && sourcePosition < len(source) && len(result) < rawSize; bit++ {
+			if control&1 == 0 {
+				result = append(result, source[sourcePosition])
+				sourcePosition++
+				control >>= 1
+				continue
+			}
+			// tag is 2 or 3 bytes: 4 bits of length - 3, 12 bits of offset and optional extra length byte
+			if sourcePosition+2 > len(source) {
+				return nil, NewCorruptPglzDataError("tag is truncated")
+			}
+			length := int(source[sourcePosition]&0x0f) + 3
+			offset := int(source[sourcePosition]&0xf0)<<4 | int(source[sourcePosition+1])
+			sourcePosition += 2
+			if length == 18 {
+				if sourcePosition == len(source) {
+					return nil, NewCorruptPglzDataError("tag is truncated")
+				}
+				length += int(source[sourcePosition])
+				sourcePosition++
+			}
+			if offset == 0 || offset > len(result) {
+				return nil, NewCorruptPglzDataError(fmt.Sprintf("invalid back reference offset %d", offset))
+			}
+			length = minInt(length, rawSize-len(result))
+			// back reference may overlap the bytes it produces, so it is copied byte by byte
+			for i := 0; i < length; i++ {
+				result = append(result, result[len(result)-offset])
+			}
+			control >>= 1
+		}
+	}
+	if len(result) != rawSize || sourcePosition != len(source) {
+		return nil, NewCorruptPglzDataError(fmt.Sprintf("expanded to %d bytes of %d, used %d bytes of %d",
+			len(result), rawSize, sourcePosition, len(source)))
+	}
+	return result, nil
+}
@@ -0,0 +1,34 @@
+package walparser
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestPglzDecompress(t *testing.T) {
+	// literals 'a', 'b', then back reference of length 6 at offset 2 and long one of length 20 at offset 1
+	source := []byte{0x0c, 'a', 'b', 0x03, 0x02, 0x0f, 0x01, 0x02}
+	result, err := PglzDecompress(source, 28)
+	assert.NoError(t, err)
+	assert.Equal(t, append([]byte("abababab"), bytes.Repeat([]byte{'b'}, 20)...), result)
+}
+
+func TestPglzDecompress_Truncated(t *testing.T) {
+	source := []byte{0x0c, 'a', 'b', 0x03, 0x02, 0x0f, 0x01, 0x02}
+	_, err := PglzDecompress(source, 30)
+	assert.IsType(t, CorruptPglzDataError{}, err)
+
+	// back reference is cut to the expected size
+	result, err := PglzDecompress(source, 10)
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("ababababbb"), result)
+
+	_, err = PglzDecompress(source[:6], 28)
+	assert.IsType(t, CorruptPglzDataError{}, err)
+}
+
+func TestPglzDecompress_InvalidOffset(t *testing.T) {
+	_, err := PglzDecompress([]byte{0x02, 'a', 0x00, 0x02}, 4)
+	assert.IsType(t, CorruptPglzDataError{}, err)
+}
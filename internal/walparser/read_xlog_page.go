@@ -2,6 +2,7 @@ package walparser
 
 import (
 	"bytes"
+	"encoding/binary"
 	"fmt"
 	"github.com/pkg/errors"
 	"github.com/x4m/wal-g/internal/tracelog"
@@ -76,6 +77,27 @@ func readXLogLongPageHeaderData(reader io.Reader) error {
 	}, reader)
 }
 
+// ReadWalSizes reads segment size and page size of WAL from the long header,
+// which starts the first page of each WAL segment
+func ReadWalSizes(reader io.Reader) (segmentSize uint32, walPageSize uint32, err error) {
+	headerData := make([]byte, XLogLongPageHeaderSize)
+	_, err = io.ReadFull(reader, headerData)
+	if err != nil {
+		return 0, 0, errors.WithStack(err)
+	}
+	pageHeader, err := readXLogPageHeader(bytes.NewReader(headerData))
+	if err != nil {
+		return 0, 0, err
+	}
+	if !pageHeader.IsLong() {
+		return 0, 0, NewInvalidPageHeaderError()
+	}
+	// xlp_seg_size and xlp_xlog_blcksz follow MAXALIGNed standard header and xlp_sysid
+	segmentSize = binary.LittleEndian.Uint32(headerData[XLogLongPageHeaderSize-8:])
+	walPageSize = binary.LittleEndian.Uint32(headerData[XLogLongPageHeaderSize-4:])
+	return segmentSize, walPageSize, nil
+}
+
 // If header is long, then long header data is read from reader and thrown away
 func readXLogPageHeader(reader io.Reader) (*XLogPageHeader, error) {
 	pageHeader := XLogPageHeader{}
@@ -42,13 +42,14 @@ func readRelFileNode(reader io.Reader) (*RelFileNode, error) {
 	return &relFileNode, nil
 }
 
-func ParseXLogRecordFromBytes(data []byte) (*XLogRecord, error) {
+// ParseXLogRecordFromBytes parses record read from WAL page with given magic, see XLogRecordBlockImageHeader.PageMagic
+func ParseXLogRecordFromBytes(data []byte, pageMagic uint16) (*XLogRecord, error) {
 	reader := bytes.NewReader(data)
 	header, err := readXLogRecordHeader(reader)
 	if err != nil {
 		return nil, err
 	}
-	return readXLogRecordBody(header, reader)
+	return readXLogRecordBody(header, reader, pageMagic)
 }
 
 func readXLogRecordBlockDataAndImages(record *XLogRecord, reader io.Reader) error {
@@ -72,8 +73,8 @@ func readXLogRecordBlockDataAndImages(record *XLogRecord, reader io.Reader) erro
 	return nil
 }
 
-func readXLogRecordBlockImageHeader(reader io.Reader) (*XLogRecordBlockImageHeader, error) {
-	blockImageHeader := XLogRecordBlockImageHeader{}
+func readXLogRecordBlockImageHeader(reader io.Reader, pageMagic uint16) (*XLogRecordBlockImageHeader, error) {
+	blockImageHeader := XLogRecordBlockImageHeader{PageMagic: pageMagic}
 	err := parsingutil.ParseMultipleFieldsFromReader([]parsingutil.FieldToParse{
 		{Field: &blockImageHeader.ImageLength, Name: "imageLength"},
 		{Field: &blockImageHeader.HoleOffset, Name: "imageHoleOffset"},
@@ -82,7 +83,7 @@ func readXLogRecordBlockImageHeader(reader io.Reader) (*XLogRecordBlockImageHead
 	if err != nil {
 		return nil, err
 	}
-	if blockImageHeader.IsCompressed() {
+	if blockImageHeader.isCompressedByAnyMethod() {
 		if blockImageHeader.HasHole() {
 			err = parsingutil.NewFieldToParse(&blockImageHeader.HoleLength, "imageHoleLength").ParseFrom(reader)
 			if err != nil {
@@ -122,8 +123,8 @@ func readBlockLocation(blockHasSameRel bool, lastRelFileNode **RelFileNode, read
 	return
 }
 
-func readXLogRecordBlockHeader(lastRelFileNode *RelFileNode,
-	blockId uint8, maxReadBlockId *int, reader *ShrinkableReader) (*XLogRecordBlockHeader, error) {
+func readXLogRecordBlockHeader(lastRelFileNode **RelFileNode,
+	blockId uint8, maxReadBlockId *int, reader *ShrinkableReader, pageMagic uint16) (*XLogRecordBlockHeader, error) {
 	if blockId > XlrMaxBlockId {
 		return nil, NewInvalidRecordBlockIdError(blockId)
 	}
@@ -147,7 +148,7 @@ func readXLogRecordBlockHeader(lastRelFileNode *RelFileNode,
 	reader.Shrink(int(blockHeader.DataLength))
 
 	if blockHeader.HasImage() {
-		imageHeader, err := readXLogRecordBlockImageHeader(reader)
+		imageHeader, err := readXLogRecordBlockImageHeader(reader, pageMagic)
 		if err != nil {
 			return nil, err
 		}
@@ -158,7 +159,7 @@ func readXLogRecordBlockHeader(lastRelFileNode *RelFileNode,
 		}
 	}
 
-	blockLocation, err := readBlockLocation(blockHeader.HasSameRel(), &lastRelFileNode, reader)
+	blockLocation, err := readBlockLocation(blockHeader.HasSameRel(), lastRelFileNode, reader)
 	if err != nil {
 		return nil, err
 	}
@@ -166,7 +167,7 @@ func readXLogRecordBlockHeader(lastRelFileNode *RelFileNode,
 	return blockHeader, nil
 }
 
-func readXLogRecordBlockHeaderPart(record *XLogRecord, reader io.Reader) error {
+func readXLogRecordBlockHeaderPart(record *XLogRecord, reader io.Reader, pageMagic uint16) error {
 	var lastRelFileNode *RelFileNode = nil
 	maxReadBlockId := -1
 	headerReader := &ShrinkableReader{reader, int(record.Header.TotalRecordLength - XLogRecordHeaderSize)}
@@ -197,7 +198,7 @@ func readXLogRecordBlockHeaderPart(record *XLogRecord, reader io.Reader) error {
 				return err
 			}
 		default:
-			blockHeader, err := readXLogRecordBlockHeader(lastRelFileNode, blockId, &maxReadBlockId, headerReader)
+			blockHeader, err := readXLogRecordBlockHeader(&lastRelFileNode, blockId, &maxReadBlockId, headerReader, pageMagic)
 			if err != nil {
 				return err
 			}
@@ -213,11 +214,14 @@ func readXLogRecordMainData(mainDataLen uint32, reader io.Reader) ([]byte, error
 	return mainData, errors.WithStack(err)
 }
 
-func readXLogRecordBody(header *XLogRecordHeader, reader io.Reader) (*XLogRecord, error) {
+func readXLogRecordBody(header *XLogRecordHeader, reader io.Reader, pageMagic uint16) (*XLogRecord, error) {
 	record := NewXLogRecord(*header)
-	readXLogRecordBlockHeaderPart(record, reader)
+	err := readXLogRecordBlockHeaderPart(record, reader, pageMagic)
+	if err != nil {
+		return nil, err
+	}
 
-	err := readXLogRecordBlockDataAndImages(record, reader)
+	err = readXLogRecordBlockDataAndImages(record, reader)
 	if err != nil {
 		return nil, err
 	}
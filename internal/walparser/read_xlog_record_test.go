@@ -31,7 +31,7 @@ func TestReadXLogRecordBlockHeader(t *testing.T) {
 		0x00, 0x15, 0x40, 0x00, 0x00, 0xe4, 0x18, 0x00, 0x00,
 	}
 	reader := ShrinkableReader{bytes.NewReader(headerData), len(headerData) + 0x1cd4}
-	header, err := readXLogRecordBlockHeader(lastRelFileNode, 0, &maxReadBlockId, &reader)
+	header, err := readXLogRecordBlockHeader(&lastRelFileNode, 0, &maxReadBlockId, &reader, 0)
 	assert.NoError(t, err)
 	assert.Equal(t, header.BlockId, uint8(0))
 	assert.Equal(t, header.ForkFlags, uint8(0x10))
@@ -45,6 +45,15 @@ func TestReadXLogRecordBlockHeader(t *testing.T) {
 	assert.Equal(t, header.BlockLocation.RelationFileNode.RelNode, Oid(0x00004015))
 	assert.Equal(t, header.BlockLocation.BlockNo, uint32(0x000018e4))
 	AssertReaderIsEmpty(t, &reader)
+	assert.Equal(t, &header.BlockLocation.RelationFileNode, lastRelFileNode)
+
+	// Next block of the same relation refers to the previous block's relation
+	sameRelData := []byte{0xa0, 0x02, 0x00, 0x01, 0x00, 0x00, 0x00}
+	reader = ShrinkableReader{bytes.NewReader(sameRelData), len(sameRelData) + 2}
+	header, err = readXLogRecordBlockHeader(&lastRelFileNode, 1, &maxReadBlockId, &reader, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, header.BlockLocation.RelationFileNode.RelNode, Oid(0x00004015))
+	assert.Equal(t, header.BlockLocation.BlockNo, uint32(1))
 }
 
 func TestReadBlockLocation_WithDifferentRel(t *testing.T) {
@@ -68,7 +77,7 @@ func TestReadXLogRecordBlockImageHeader_NotCompressed(t *testing.T) {
 		0x42, 0x10, 0x30, 0x00, 0x05,
 	}
 	reader := bytes.NewReader(data)
-	header, err := readXLogRecordBlockImageHeader(reader)
+	header, err := readXLogRecordBlockImageHeader(reader, 0)
 	assert.NoError(t, err)
 	assert.Equal(t, header.ImageLength, uint16(0x1042))
 	assert.Equal(t, header.HoleOffset, uint16(0x0030))
@@ -82,19 +91,70 @@ func TestReadXLogRecordBlockImageHeader_Compressed(t *testing.T) {
 		0x42, 0x10, 0x30, 0x00, 0x07, 0x92, 0x00,
 	}
 	reader := bytes.NewReader(data)
-	header, err := readXLogRecordBlockImageHeader(reader)
+	header, err := readXLogRecordBlockImageHeader(reader, 0)
 	assert.NoError(t, err)
 	assert.Equal(t, header.ImageLength, uint16(0x1042))
 	assert.Equal(t, header.HoleOffset, uint16(0x0030))
 	assert.Equal(t, header.Info, uint8(0x07))
 	assert.Equal(t, header.HoleLength, uint16(0x0092))
+	assert.True(t, header.IsCompressed())
+	assert.True(t, header.ApplyImage())
+	AssertReaderIsEmpty(t, reader)
+}
+
+// The same info bits mean different things before and since postgres 15
+func TestReadXLogRecordBlockImageHeader_InfoLayouts(t *testing.T) {
+	data := []byte{
+		0x42, 0x10, 0x30, 0x00, 0x03, 0x92, 0x00,
+	}
+	reader := bytes.NewReader(data)
+	header, err := readXLogRecordBlockImageHeader(reader, 0xD106)
+	assert.NoError(t, err)
+	assert.True(t, header.HasHole())
+	assert.True(t, header.IsCompressed())
+	assert.False(t, header.ApplyImage())
+	assert.Equal(t, header.HoleLength, uint16(0x0092))
+	AssertReaderIsEmpty(t, reader)
+
+	reader = bytes.NewReader(data[:5])
+	header, err = readXLogRecordBlockImageHeader(reader, XLogPageMagicPg15)
+	assert.NoError(t, err)
+	assert.True(t, header.HasHole())
+	assert.False(t, header.IsCompressed())
+	assert.True(t, header.ApplyImage())
+	assert.Equal(t, header.HoleLength, BlockSize-header.ImageLength)
+	AssertReaderIsEmpty(t, reader)
+
+	data[4] = BkpImageHasHole | BkpImageApplyPg15 | BkpImageCompressPglz
+	reader = bytes.NewReader(data)
+	header, err = readXLogRecordBlockImageHeader(reader, XLogPageMagicPg15)
+	assert.NoError(t, err)
+	assert.True(t, header.IsCompressed())
+	assert.False(t, header.HasUnsupportedCompression())
+	assert.Equal(t, header.HoleLength, uint16(0x0092))
 	AssertReaderIsEmpty(t, reader)
+
+	data[4] = BkpImageHasHole | BkpImageApplyPg15 | BkpImageCompressLz4
+	reader = bytes.NewReader(data)
+	header, err = readXLogRecordBlockImageHeader(reader, XLogPageMagicPg15)
+	assert.NoError(t, err)
+	assert.False(t, header.IsCompressed())
+	assert.True(t, header.HasUnsupportedCompression())
+	assert.Equal(t, header.HoleLength, uint16(0x0092))
+	AssertReaderIsEmpty(t, reader)
+
+	// postgres 9.6 has no BKPIMAGE_APPLY, all its images are applied
+	data[4] = BkpImageHasHole
+	reader = bytes.NewReader(data[:5])
+	header, err = readXLogRecordBlockImageHeader(reader, 0xD093)
+	assert.NoError(t, err)
+	assert.True(t, header.ApplyImage())
 }
 
 func testReadXLogRecordBlockHeaderPartLogic(t *testing.T, data []byte, blockDataLen uint32) *XLogRecord {
 	reader := bytes.NewReader(data)
 	record := NewXLogRecord(XLogRecordHeader{TotalRecordLength: XLogRecordHeaderSize + uint32(len(data)) + blockDataLen})
-	err := readXLogRecordBlockHeaderPart(record, reader)
+	err := readXLogRecordBlockHeaderPart(record, reader, 0)
 	assert.NoError(t, err)
 	AssertReaderIsEmpty(t, reader)
 	return record
@@ -233,7 +293,7 @@ func TestReadXLogRecordBody(t *testing.T) {
 	expectedMainDataLen := uint32(0x04)
 	expectedImageLength := uint16(0x000a)
 	reader := bytes.NewReader(data)
-	record, err := readXLogRecordBody(&XLogRecordHeader{TotalRecordLength: uint32(int(XLogRecordHeaderSize) + len(data))}, reader)
+	record, err := readXLogRecordBody(&XLogRecordHeader{TotalRecordLength: uint32(int(XLogRecordHeaderSize) + len(data))}, reader, 0)
 	assert.NoError(t, err)
 	assert.Equal(t, record.Origin, expectedOrigin)
 	assert.Equal(t, record.MainDataLen, expectedMainDataLen)
@@ -30,3 +30,29 @@ const (
 
 	RmNextFreeID
 )
+
+// ResourceManagerNames are names of resource managers used by pg_waldump
+var ResourceManagerNames = [RmNextFreeID]string{
+	"XLOG",
+	"Transaction",
+	"Storage",
+	"CLOG",
+	"Database",
+	"Tablespace",
+	"MultiXact",
+	"RelMap",
+	"Standby",
+	"Heap2",
+	"Heap",
+	"Btree",
+	"Hash",
+	"Gin",
+	"Gist",
+	"Sequence",
+	"SPGist",
+	"BRIN",
+	"CommitTs",
+	"ReplicationOrigin",
+	"Generic",
+	"LogicalMessage",
+}
@@ -11,6 +11,13 @@ func minUint32(a uint32, b uint32) uint32 {
 	return b
 }
 
+func minInt(a int, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
 func concatByteSlices(a []byte, b []byte) []byte {
 	result := make([]byte, len(a)+len(b))
 	copy(result, a)
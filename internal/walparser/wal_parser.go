@@ -12,11 +12,27 @@ import (
 )
 
 const (
-	WalPageSize         uint16 = 8192
-	BlockSize           uint16 = 8192
+	DefaultWalPageSize  uint32 = 8192
+	DefaultBlockSize    uint16 = 8192
 	XLogRecordAlignment        = 8
 )
 
+// WalPageSize (XLOG_BLCKSZ) and BlockSize (BLCKSZ) are chosen when postgres is built, see SetPageSizes
+var (
+	WalPageSize = DefaultWalPageSize
+	BlockSize   = DefaultBlockSize
+)
+
+// SetPageSizes should be called before parsing, when cluster has non-default page sizes
+func SetPageSizes(walPageSize uint32, blockSize uint16) {
+	if WalPageSize != walPageSize {
+		WalPageSize = walPageSize
+	}
+	if BlockSize != blockSize {
+		BlockSize = blockSize
+	}
+}
+
 type ZeroPageError struct {
 	error
 }
@@ -56,10 +72,14 @@ func (err PartialPageError) Error() string {
 type WalParser struct {
 	currentRecordData         []byte
 	hasCurrentRecordBeginning bool
+	// currentRecordLsn is not saved with parser, records completed by loaded parser have zero Lsn
+	currentRecordLsn XLogRecordPtr
+	// pageMagic of the last parsed page defines layout of records, it is zero until the first page
+	pageMagic uint16
 }
 
 func NewWalParser() *WalParser {
-	return &WalParser{make([]byte, 0), false}
+	return &WalParser{make([]byte, 0), false, 0, 0}
 }
 
 func (parser *WalParser) setCurrentRecordData(data []byte) {
@@ -71,7 +91,8 @@ func (parser *WalParser) Invalidate() {
 	parser.setCurrentRecordData(nil)
 }
 
-// For now we suppose that no wal record crosses whole wal page.
+// A record may span any number of pages and WAL files: its data is accumulated in parser
+// until the page containing the end of the record.
 // If there is no currentRecordData (e. g. we look at the first record in the file), then we return
 // prevRecordTail and discard it in parser.
 func (parser *WalParser) ParseRecordsFromPage(reader io.Reader) (prevRecordTail []byte, pageRecords []XLogRecord, err error) {
@@ -81,13 +102,14 @@ func (parser *WalParser) ParseRecordsFromPage(reader io.Reader) (prevRecordTail
 		return nil, nil, pageParsingErr
 	}
 	if uint32(len(page.PrevRecordTrailingData)) < page.Header.RemainingDataLen {
-		// ok, it's not all
-		parser.currentRecordData = concatByteSlices(parser.currentRecordData, page.PrevRecordTrailingData)
+		// ok, it's not all, append grows data geometrically, so long records are accumulated in linear time
+		parser.currentRecordData = append(parser.currentRecordData, page.PrevRecordTrailingData...)
 		return nil, nil, pageParsingErr
 	}
 	currentRecordData := concatByteSlices(parser.currentRecordData, page.PrevRecordTrailingData)
 	if !parser.hasCurrentRecordBeginning {
 		parser.setCurrentRecordData(page.NextRecordHeadingData)
+		parser.currentRecordLsn = page.NextRecordLsn
 		return currentRecordData, page.Records, pageParsingErr
 	}
 	header, err := readXLogRecordHeader(bytes.NewReader(currentRecordData))
@@ -97,14 +119,16 @@ func (parser *WalParser) ParseRecordsFromPage(reader io.Reader) (prevRecordTail
 	if header.TotalRecordLength != uint32(len(currentRecordData)) {
 		return nil, nil, NewContinuationNotFoundError()
 	}
-	currentRecord, err := ParseXLogRecordFromBytes(currentRecordData)
+	currentRecord, err := ParseXLogRecordFromBytes(currentRecordData, parser.pageMagic)
 	if err != nil {
 		return nil, nil, err
 	}
+	currentRecord.Lsn = parser.currentRecordLsn
 	records := make([]XLogRecord, len(page.Records)+1)
 	records[0] = *currentRecord
 	copy(records[1:], page.Records)
 	parser.setCurrentRecordData(page.NextRecordHeadingData)
+	parser.currentRecordLsn = page.NextRecordLsn
 	return nil, records, pageParsingErr
 }
 
@@ -123,6 +147,7 @@ func (parser *WalParser) parsePage(reader io.Reader) (*XLogPage, error) {
 		}
 		return nil, err
 	}
+	parser.pageMagic = pageHeader.Magic
 	err = alignedReader.ReadToAlignment()
 	if err != nil {
 		return nil, err
@@ -137,7 +162,7 @@ func (parser *WalParser) parsePage(reader io.Reader) (*XLogPage, error) {
 	}
 	// if remainingData can be a part of WAL-switch record and we can check it
 	if parser.hasCurrentRecordBeginning {
-		record, err := ParseXLogRecordFromBytes(concatByteSlices(parser.currentRecordData, remainingData))
+		record, err := ParseXLogRecordFromBytes(concatByteSlices(parser.currentRecordData, remainingData), parser.pageMagic)
 		if err != nil {
 			return nil, err
 		}
@@ -151,19 +176,21 @@ func (parser *WalParser) parsePage(reader io.Reader) (*XLogPage, error) {
 		if err != nil {
 			return checkPartialPage(alignedReader, &XLogPage{Header: *pageHeader, PrevRecordTrailingData: remainingData, Records: pageRecords}, err)
 		}
+		recordLsn := pageHeader.PageAddress + XLogRecordPtr(alignedReader.alreadyRead-len(recordData))
 		if wholeRecord {
 			// The header was previously validated being zero, so now it doesn't need to. However we do this for code robustness.
-			record, err := ParseXLogRecordFromBytes(recordData)
+			record, err := ParseXLogRecordFromBytes(recordData, parser.pageMagic)
 			if err != nil {
 				return checkPartialPage(alignedReader, &XLogPage{Header: *pageHeader, PrevRecordTrailingData: remainingData, Records: pageRecords}, err)
 			}
+			record.Lsn = recordLsn
 			pageRecords = append(pageRecords, *record)
 			if record.isWALSwitch() {
 				return &XLogPage{Header: *pageHeader, PrevRecordTrailingData: remainingData, Records: pageRecords}, nil
 			}
 			continue
 		}
-		return &XLogPage{*pageHeader, remainingData, pageRecords, recordData}, nil
+		return &XLogPage{*pageHeader, remainingData, pageRecords, recordData, recordLsn}, nil
 	}
 }
 
@@ -198,6 +225,17 @@ func (parser *WalParser) GetCurrentRecordData() []byte {
 	return parser.currentRecordData
 }
 
+// PageMagic is the magic of the last page given to the parser
+func (parser *WalParser) PageMagic() uint16 {
+	return parser.pageMagic
+}
+
+// HasCurrentRecordBeginning is false while parser reads the tail of the record,
+// which started before the first page given to the parser
+func (parser *WalParser) HasCurrentRecordBeginning() bool {
+	return parser.hasCurrentRecordBeginning
+}
+
 func LoadWalParser(reader io.Reader) (*WalParser, error) {
 	var dataLen uint32
 	err := parsingutil.NewFieldToParse(&dataLen, "record data prefix len").ParseFrom(reader)
@@ -209,9 +247,9 @@ func LoadWalParser(reader io.Reader) (*WalParser, error) {
 	if err != nil {
 		return nil, errors.WithStack(err)
 	}
-	return &WalParser{data, len(data) > 0}, nil
+	return &WalParser{data, len(data) > 0, 0, 0}, nil
 }
 
 func LoadWalParserFromCurrentRecordHead(currentRecordHead []byte) *WalParser {
-	return &WalParser{currentRecordHead, true}
+	return &WalParser{currentRecordHead, true, 0, 0}
 }
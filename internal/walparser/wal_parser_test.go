@@ -3,6 +3,7 @@ package walparser
 import (
 	"bytes"
 	"github.com/stretchr/testify/assert"
+	"io"
 	"os"
 	"testing"
 )
@@ -15,6 +16,20 @@ const (
 	LongRecordTestPath   = "./testdata/long_record"
 )
 
+const (
+	// MultiPageRecordTestPath contains small record, full page images record spanning 5 pages and small record
+	MultiPageRecordTestPath = "./testdata/multi_page_record"
+	// SegmentSpanningRecordTestPath is a prefix of 2-page WAL files, full page images record
+	// starts in the first file, covers the second one and ends in the third file
+	SegmentSpanningRecordTestPath = "./testdata/segment_spanning_record_"
+	// XactRecordsTestPath contains commit, heap record, abort, commit prepared and abort prepared
+	// at 10, 20, 30 and 40 seconds after 2019-01-01 00:00:00 UTC
+	XactRecordsTestPath = "./testdata/xact_records"
+	// FullPageImagesTestPath contains heap record of block 1, image of block 1 with hole,
+	// compressed image of block 2 with hole and heap record of block 2, all of relation 1663/16384/16397
+	FullPageImagesTestPath = "./testdata/full_page_images"
+)
+
 func TestZeroPageParsing(t *testing.T) {
 	zeroPage := make([]byte, WalPageSize)
 	parser := NewWalParser()
@@ -67,6 +82,41 @@ func doLongRecordParsingTesting(t *testing.T, pageReader WalPageReader, parser W
 	assert.NotEmpty(t, records)
 }
 
+func doMultiPageRecordParsingTesting(t *testing.T, pageReader WalPageReader, parser WalParser) {
+	firstPage, err := pageReader.ReadPageData() // first page contains small record and a beginning of the long record
+	assert.NoError(t, err)
+	_, records, err := parser.ParseRecordsFromPage(bytes.NewReader(firstPage))
+	assert.NoError(t, err)
+	assert.Len(t, records, 1)
+	assert.Equal(t, XLogRecordPtr(0x3000028), records[0].Lsn)
+
+	for i := 0; i < 3; i++ { // following pages consist only of the long record
+		page, err := pageReader.ReadPageData()
+		assert.NoError(t, err)
+		discarded, records, err := parser.ParseRecordsFromPage(bytes.NewReader(page))
+		assert.NoError(t, err)
+		assert.Nil(t, discarded)
+		assert.Empty(t, records)
+		assert.True(t, parser.HasCurrentRecordBeginning())
+	}
+
+	lastPage, err := pageReader.ReadPageData() // last page contains long record tail and small record
+	assert.NoError(t, err)
+	discarded, records, err := parser.ParseRecordsFromPage(bytes.NewReader(lastPage))
+	assert.IsType(t, PartialPageError{}, err)
+	assert.Nil(t, discarded)
+	assert.Len(t, records, 2)
+	assert.Equal(t, XLogRecordPtr(0x3000060), records[0].Lsn)
+	assert.Equal(t, XLogRecordPtr(0x3008250), records[1].Lsn)
+	assert.Len(t, records[0].Blocks, 4)
+	assert.Equal(t, uint32(300), records[0].MainDataLen)
+	for i, block := range records[0].Blocks {
+		assert.Equal(t, *NewBlockLocation(1663, 16384, 16400, uint32(i)), block.Header.BlockLocation)
+		assert.Len(t, block.Image, int(BlockSize))
+	}
+	assert.Empty(t, parser.GetCurrentRecordData())
+}
+
 func parsingTestCase(t *testing.T, filename string, doTesting func(*testing.T, WalPageReader, WalParser)) {
 	walFile, err := os.Open(filename)
 	defer walFile.Close()
@@ -83,6 +133,57 @@ func TestParsing(t *testing.T) {
 	parsingTestCase(t, CutWALSwitchTestPath, doWalSwitchParsingTesting)
 	parsingTestCase(t, WalSwitchTestPath, doWalSwitchParsingTesting)
 	parsingTestCase(t, LongRecordTestPath, doLongRecordParsingTesting)
+	parsingTestCase(t, MultiPageRecordTestPath, doMultiPageRecordParsingTesting)
+}
+
+func parseWalFile(t *testing.T, parser *WalParser, filename string) (discarded []byte, records []XLogRecord) {
+	walFile, err := os.Open(filename)
+	assert.NoError(t, err)
+	defer walFile.Close()
+	pageReader := NewWalPageReader(walFile)
+	for {
+		page, err := pageReader.ReadPageData()
+		if err == io.EOF {
+			return
+		}
+		assert.NoError(t, err)
+		pageDiscarded, pageRecords, err := parser.ParseRecordsFromPage(bytes.NewReader(page))
+		if _, ok := err.(PartialPageError); !ok {
+			assert.NoError(t, err)
+		}
+		discarded = append(discarded, pageDiscarded...)
+		records = append(records, pageRecords...)
+	}
+}
+
+func TestParsingRecordSpanningWalFiles(t *testing.T) {
+	parser := NewWalParser()
+	_, records := parseWalFile(t, parser, SegmentSpanningRecordTestPath+"1")
+	assert.Len(t, records, 2)
+	head := parser.GetCurrentRecordData()
+	assert.NotEmpty(t, head)
+
+	// The second file is only a part of the record
+	_, records = parseWalFile(t, parser, SegmentSpanningRecordTestPath+"2")
+	assert.Empty(t, records)
+	_, records = parseWalFile(t, parser, SegmentSpanningRecordTestPath+"3")
+	assert.Len(t, records, 2)
+	assert.Len(t, records[0].Blocks, 4)
+	assert.Equal(t, uint32(1000), records[0].MainDataLen)
+	assert.Equal(t, *NewBlockLocation(1663, 16384, 16402, 3), records[0].Blocks[3].Header.BlockLocation)
+
+	// Parser, which started in the middle of the record, returns its tail from all files
+	parser = NewWalParser()
+	discarded, records := parseWalFile(t, parser, SegmentSpanningRecordTestPath+"2")
+	assert.Empty(t, discarded)
+	assert.Empty(t, records)
+	assert.False(t, parser.HasCurrentRecordBeginning())
+	middle := parser.GetCurrentRecordData()
+	parser = NewWalParser()
+	tail, _ := parseWalFile(t, parser, SegmentSpanningRecordTestPath+"3")
+	record, err := ParseXLogRecordFromBytes(concatByteSlices(concatByteSlices(head, middle), tail), 0)
+	assert.NoError(t, err)
+	assert.Len(t, record.Blocks, 4)
 }
 
 func TestSaveLoadWalParser(t *testing.T) {
@@ -0,0 +1,53 @@
+package walparser
+
+import (
+	"encoding/binary"
+	"time"
+)
+
+/* Transaction records, for clarification you can look at postgres code:
+ * src/include/access/xact.h
+ */
+
+const (
+	XLogXactOpMask         = 0x70
+	XLogXactCommit         = 0x00
+	XLogXactAbort          = 0x20
+	XLogXactCommitPrepared = 0x30
+	XLogXactAbortPrepared  = 0x40
+
+	// xactTimeSize is the size of TimestampTz xact_time, which starts main data of commit and abort records
+	xactTimeSize = 8
+)
+
+// postgresEpoch is the origin of TimestampTz
+var postgresEpoch = time.Date(2000, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+// TimestampTzToTime converts microseconds since postgres epoch to time
+func TimestampTzToTime(timestamp int64) time.Time {
+	return postgresEpoch.Add(time.Duration(timestamp) * time.Microsecond)
+}
+
+// IsXactCommit tells whether record commits transaction, including prepared one
+func (record *XLogRecord) IsXactCommit() bool {
+	opCode := record.xactOpCode()
+	return record.Header.ResourceManagerID == RmXactID && (opCode == XLogXactCommit || opCode == XLogXactCommitPrepared)
+}
+
+// IsXactAbort tells whether record aborts transaction, including prepared one
+func (record *XLogRecord) IsXactAbort() bool {
+	opCode := record.xactOpCode()
+	return record.Header.ResourceManagerID == RmXactID && (opCode == XLogXactAbort || opCode == XLogXactAbortPrepared)
+}
+
+func (record *XLogRecord) xactOpCode() uint8 {
+	return record.Header.Info & XLogXactOpMask
+}
+
+// GetXactTime returns time of commit or abort record, false is returned for other records
+func (record *XLogRecord) GetXactTime() (time.Time, bool) {
+	if !record.IsXactCommit() && !record.IsXactAbort() || len(record.MainData) < xactTimeSize {
+		return time.Time{}, false
+	}
+	return TimestampTzToTime(int64(binary.LittleEndian.Uint64(record.MainData))), true
+}
@@ -0,0 +1,41 @@
+package walparser
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestTimestampTzToTime(t *testing.T) {
+	assert.Equal(t, time.Date(2000, time.January, 1, 0, 0, 0, 0, time.UTC), TimestampTzToTime(0).UTC())
+	assert.Equal(t, time.Date(1999, time.December, 31, 23, 59, 59, 999999000, time.UTC), TimestampTzToTime(-1).UTC())
+}
+
+func TestGetXactTime(t *testing.T) {
+	_, records := parseWalFile(t, NewWalParser(), XactRecordsTestPath)
+	assert.Len(t, records, 5)
+	start := time.Date(2019, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+	xactTimes := make([]time.Time, 0)
+	for _, record := range records {
+		xactTime, ok := record.GetXactTime()
+		if ok {
+			xactTimes = append(xactTimes, xactTime.UTC())
+		}
+	}
+	assert.Equal(t, []time.Time{
+		start.Add(10 * time.Second),
+		start.Add(20 * time.Second),
+		start.Add(30 * time.Second),
+		start.Add(40 * time.Second),
+	}, xactTimes)
+
+	assert.True(t, records[0].IsXactCommit())
+	assert.False(t, records[1].IsXactCommit())
+	assert.False(t, records[1].IsXactAbort())
+	assert.True(t, records[2].IsXactAbort())
+	assert.True(t, records[3].IsXactCommit())
+	assert.True(t, records[4].IsXactAbort())
+	assert.Equal(t, XLogRecordPtr(0x5000028), records[0].Lsn)
+	assert.Equal(t, XLogRecordPtr(0x50000D8), records[4].Lsn)
+}
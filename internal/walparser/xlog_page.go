@@ -5,4 +5,5 @@ type XLogPage struct {
 	PrevRecordTrailingData []byte
 	Records                []XLogRecord
 	NextRecordHeadingData  []byte
+	NextRecordLsn          XLogRecordPtr
 }
@@ -11,6 +11,17 @@ const (
 	XlpBkpRemovable = 0x0004
 	/* All defined flag bits in xlp_info (used for validity checking of header) */
 	XlpAllFlags = 0x0007
+
+	// XLogLongPageHeaderSize is MAXALIGNed size of XLogLongPageHeaderData
+	XLogLongPageHeaderSize = 40
+)
+
+// XLOG_PAGE_MAGIC grows with every change of WAL format, so it tells the version of postgres
+const (
+	// XLogPageMagicPg10 is the magic of postgres 10, which introduced BKPIMAGE_APPLY
+	XLogPageMagicPg10 uint16 = 0xD097
+	// XLogPageMagicPg15 is the magic of postgres 15, which changed block image info bits
+	XLogPageMagicPg15 uint16 = 0xD110
 )
 
 /* This struct corresponds to postgres struct XLogPageHeaderData.
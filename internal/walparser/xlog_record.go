@@ -6,6 +6,8 @@ const (
 )
 
 type XLogRecord struct {
+	// Lsn is the position of the record in WAL, it is known only for records read by WalParser from WAL pages
+	Lsn         XLogRecordPtr
 	Header      XLogRecordHeader
 	MainDataLen uint32
 	Origin      uint16
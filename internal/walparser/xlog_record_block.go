@@ -5,3 +5,28 @@ type XLogRecordBlock struct {
 	Image  []byte
 	Data   []byte
 }
+
+// RestorePageImage makes the whole page from full page image of the block:
+// compressed image is decompressed and the hole is filled with zeros as postgres RestoreBlockImage does
+func (block *XLogRecordBlock) RestorePageImage() ([]byte, error) {
+	imageHeader := block.Header.ImageHeader
+	image := block.Image
+	if imageHeader.HasUnsupportedCompression() {
+		return nil, NewUnsupportedBlockImageCompressionError(imageHeader.Info)
+	}
+	if imageHeader.IsCompressed() {
+		var err error
+		image, err = PglzDecompress(image, int(BlockSize-imageHeader.HoleLength))
+		if err != nil {
+			return nil, err
+		}
+	}
+	if int(imageHeader.HoleOffset) > len(image) || len(image)+int(imageHeader.HoleLength) != int(BlockSize) {
+		return nil, NewInconsistentBlockImageHoleStateError(imageHeader.HoleOffset, imageHeader.HoleLength,
+			imageHeader.ImageLength, imageHeader.HasHole())
+	}
+	page := make([]byte, BlockSize)
+	copy(page, image[:imageHeader.HoleOffset])
+	copy(page[imageHeader.HoleOffset+imageHeader.HoleLength:], image[imageHeader.HoleOffset:])
+	return page, nil
+}
@@ -6,12 +6,21 @@ import (
 	"github.com/x4m/wal-g/internal/tracelog"
 )
 
+// Meaning of image info bits depends on the version of postgres, which wrote the WAL, see XLogPageMagicPg15
 const (
 	BkpImageHasHole      uint8 = 0x01
 	BkpImageIsCompressed uint8 = 0x02
 	BkpImageApply        uint8 = 0x04
 )
 
+// Image info bits of PostgreSQL 15 and later, only pglz compressed images can be restored
+const (
+	BkpImageApplyPg15    uint8 = 0x02
+	BkpImageCompressPglz uint8 = 0x04
+	BkpImageCompressLz4  uint8 = 0x08
+	BkpImageCompressZstd uint8 = 0x10
+)
+
 type InconsistentBlockImageHoleStateError struct {
 	error
 }
@@ -38,22 +47,56 @@ func (err InconsistentBlockImageLengthError) Error() string {
 	return fmt.Sprintf(tracelog.GetErrorFormatter(), err.error)
 }
 
+type UnsupportedBlockImageCompressionError struct {
+	error
+}
+
+func NewUnsupportedBlockImageCompressionError(info uint8) UnsupportedBlockImageCompressionError {
+	return UnsupportedBlockImageCompressionError{errors.Errorf("block image compression is not supported, image info: %#x", info)}
+}
+
+func (err UnsupportedBlockImageCompressionError) Error() string {
+	return fmt.Sprintf(tracelog.GetErrorFormatter(), err.error)
+}
+
 type XLogRecordBlockImageHeader struct {
 	ImageLength uint16
 	HoleOffset  uint16
 	HoleLength  uint16
 	Info        uint8
+	// PageMagic is the magic of WAL page the image was read from, zero is treated as magic of postgres 10-14
+	PageMagic uint16
+}
+
+func (imageHeader *XLogRecordBlockImageHeader) hasPg15Layout() bool {
+	return imageHeader.PageMagic >= XLogPageMagicPg15
 }
 
 func (imageHeader *XLogRecordBlockImageHeader) HasHole() bool {
 	return (imageHeader.Info & BkpImageHasHole) != 0
 }
 
+// IsCompressed tells whether image is compressed by pglz
 func (imageHeader *XLogRecordBlockImageHeader) IsCompressed() bool {
+	if imageHeader.hasPg15Layout() {
+		return (imageHeader.Info & BkpImageCompressPglz) != 0
+	}
 	return (imageHeader.Info & BkpImageIsCompressed) != 0
 }
 
+// HasUnsupportedCompression tells whether image is compressed by method other than pglz
+func (imageHeader *XLogRecordBlockImageHeader) HasUnsupportedCompression() bool {
+	return imageHeader.hasPg15Layout() && (imageHeader.Info&(BkpImageCompressLz4|BkpImageCompressZstd)) != 0
+}
+
+// ApplyImage tells whether image should be restored on replay, postgres before 10 restored every image
 func (imageHeader *XLogRecordBlockImageHeader) ApplyImage() bool {
+	if imageHeader.hasPg15Layout() {
+		return (imageHeader.Info & BkpImageApplyPg15) != 0
+	}
+	if imageHeader.PageMagic != 0 && imageHeader.PageMagic < XLogPageMagicPg10 {
+		return true
+	}
 	return (imageHeader.Info & BkpImageApply) != 0
 }
 
@@ -66,9 +109,15 @@ func (imageHeader *XLogRecordBlockImageHeader) checkHoleStateConsistency() error
 	return nil
 }
 
+// isCompressedByAnyMethod tells whether ImageLength is the length of compressed data, which is followed by HoleLength
+func (imageHeader *XLogRecordBlockImageHeader) isCompressedByAnyMethod() bool {
+	return imageHeader.IsCompressed() || imageHeader.HasUnsupportedCompression()
+}
+
 func (imageHeader *XLogRecordBlockImageHeader) checkLengthConsistency() error {
-	if (imageHeader.IsCompressed() && imageHeader.ImageLength == BlockSize) ||
-		(!imageHeader.HasHole() && !imageHeader.IsCompressed() && imageHeader.ImageLength != BlockSize) {
+	isCompressed := imageHeader.isCompressedByAnyMethod()
+	if (isCompressed && imageHeader.ImageLength == BlockSize) ||
+		(!imageHeader.HasHole() && !isCompressed && imageHeader.ImageLength != BlockSize) {
 		return NewInconsistentBlockImageLengthError(imageHeader.HasHole(), imageHeader.IsCompressed(), imageHeader.ImageLength)
 	}
 	return nil
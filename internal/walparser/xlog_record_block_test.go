@@ -0,0 +1,57 @@
+package walparser
+
+import (
+	"encoding/binary"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func checkRestoredPage(t *testing.T, page []byte, lsn uint32, fill byte, holeOffset int, holeLength int) {
+	assert.Len(t, page, int(BlockSize))
+	assert.Equal(t, lsn, binary.LittleEndian.Uint32(page[4:8]))
+	for i := 8; i < len(page); i++ {
+		expected := fill
+		if i >= holeOffset && i < holeOffset+holeLength {
+			expected = 0
+		}
+		if page[i] != expected {
+			assert.Failf(t, "unexpected page byte", "byte %d is %x, expected %x", i, page[i], expected)
+			return
+		}
+	}
+}
+
+func TestRestorePageImage(t *testing.T) {
+	_, records := parseWalFile(t, NewWalParser(), FullPageImagesTestPath)
+	assert.Len(t, records, 4)
+
+	holedBlock := records[1].Blocks[0]
+	assert.False(t, holedBlock.Header.ImageHeader.IsCompressed())
+	page, err := holedBlock.RestorePageImage()
+	assert.NoError(t, err)
+	checkRestoredPage(t, page, 0x7000100, 0x11, 200, 7000)
+
+	compressedBlock := records[2].Blocks[0]
+	assert.True(t, compressedBlock.Header.ImageHeader.IsCompressed())
+	page, err = compressedBlock.RestorePageImage()
+	assert.NoError(t, err)
+	checkRestoredPage(t, page, 0x7000200, 0x22, 300, 6000)
+	assert.Equal(t, XLogRecordPtr(0x7000540), records[2].Lsn)
+	assert.Equal(t, uint16(0xD098), compressedBlock.Header.ImageHeader.PageMagic)
+}
+
+func TestRestorePageImage_UnsupportedCompression(t *testing.T) {
+	_, records := parseWalFile(t, NewWalParser(), FullPageImagesTestPath)
+	block := records[2].Blocks[0]
+	block.Header.ImageHeader.Info = BkpImageCompressLz4 | BkpImageApplyPg15
+	block.Header.ImageHeader.PageMagic = XLogPageMagicPg15
+	_, err := block.RestorePageImage()
+	assert.IsType(t, UnsupportedBlockImageCompressionError{}, err)
+}
+
+func TestRestorePageImage_FullImage(t *testing.T) {
+	_, records := parseWalFile(t, NewWalParser(), MultiPageRecordTestPath)
+	page, err := records[1].Blocks[2].RestorePageImage()
+	assert.NoError(t, err)
+	assert.Equal(t, records[1].Blocks[2].Image, page)
+}
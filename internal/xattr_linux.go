@@ -0,0 +1,45 @@
+// +build linux
+
+package internal
+
+import (
+	"bytes"
+	"syscall"
+)
+
+func readXattrs(path string) (map[string]string, error) {
+	size, err := syscall.Listxattr(path, nil)
+	if err != nil || size == 0 {
+		if err == syscall.ENOTSUP {
+			return nil, nil
+		}
+		return nil, err
+	}
+	namesBuffer := make([]byte, size)
+	size, err = syscall.Listxattr(path, namesBuffer)
+	if err != nil {
+		return nil, err
+	}
+
+	xattrs := make(map[string]string)
+	for _, name := range bytes.Split(namesBuffer[:size], []byte{0}) {
+		if len(name) == 0 {
+			continue
+		}
+		valueSize, err := syscall.Getxattr(path, string(name), nil)
+		if err != nil {
+			return nil, err
+		}
+		value := make([]byte, valueSize)
+		valueSize, err = syscall.Getxattr(path, string(name), value)
+		if err != nil {
+			return nil, err
+		}
+		xattrs[string(name)] = string(value[:valueSize])
+	}
+	return xattrs, nil
+}
+
+func writeXattr(path string, name string, value string) error {
+	return syscall.Setxattr(path, name, []byte(value), 0)
+}
@@ -0,0 +1,13 @@
+// +build !linux
+
+package internal
+
+import "github.com/pkg/errors"
+
+func readXattrs(path string) (map[string]string, error) {
+	return nil, nil
+}
+
+func writeXattr(path string, name string, value string) error {
+	return errors.New("extended attributes are supported only on linux")
+}
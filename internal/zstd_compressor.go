@@ -4,10 +4,20 @@ import (
 	"io"
 )
 
-type ZstdCompressor struct{}
+const (
+	DefaultZstdCompressionLevel = 3
+	MinZstdCompressionLevel     = 1
+	MaxZstdCompressionLevel     = 20
+)
+
+type ZstdCompressor struct {
+	Level int
+	// Dictionary is trained by wal-dict-train, its ID is written to each frame
+	Dictionary []byte
+}
 
 func (compressor ZstdCompressor) NewWriter(writer io.Writer) ReaderFromWriteCloser {
-	return NewZstdReaderFromWriter(writer)
+	return NewZstdReaderFromWriter(writer, compressor.Level, compressor.Dictionary)
 }
 
 func (compressor ZstdCompressor) FileExtension() string {
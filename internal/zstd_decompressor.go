@@ -1,16 +1,33 @@
 package internal
 
 import (
+	"bufio"
 	"github.com/DataDog/zstd"
 	"github.com/pkg/errors"
 	"io"
 )
 
-type ZstdDecompressor struct{}
+type ZstdDecompressor struct {
+	// DictionaryFolder keeps dictionaries of frames, which are compressed with dictionary
+	DictionaryFolder StorageFolder
+}
 
 func (decompressor ZstdDecompressor) Decompress(dst io.Writer, src io.Reader) error {
-	zstdReader := zstd.NewReader(NewUntilEofReader(src))
-	_, err := FastCopy(dst, zstdReader)
+	source := bufio.NewReader(NewUntilEofReader(src))
+	// frame header is not longer than that up to dictionary ID
+	header, err := source.Peek(zstdFrameHeaderDictionaryIdEnd)
+	if err != nil && err != io.EOF {
+		return errors.Wrap(err, "DecompressZstd: zstd read failed")
+	}
+	var dictionary []byte
+	if dictionaryId := getZstdFrameDictionaryId(header); dictionaryId != 0 {
+		dictionary, err = loadZstdDictionary(decompressor.DictionaryFolder, dictionaryId)
+		if err != nil {
+			return errors.Wrap(err, "DecompressZstd: zstd dictionary load failed")
+		}
+	}
+	zstdReader := zstd.NewReaderDict(source, dictionary)
+	_, err = FastCopy(dst, zstdReader)
 	if err != nil {
 		return errors.Wrap(err, "DecompressZstd: zstd write failed")
 	}
@@ -0,0 +1,143 @@
+package internal
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"github.com/pkg/errors"
+	"github.com/x4m/wal-g/internal/tracelog"
+	"io/ioutil"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	// ZstdDictionaryPath is the subfolder of WAL folder, where dictionaries are kept by their IDs.
+	// Dictionaries are never deleted, so that WAL files compressed with rotated dictionaries stay readable.
+	ZstdDictionaryPath = "zstd_dictionaries/"
+	// ZstdCurrentDictionaryName is the object with ID of dictionary, which is used by wal-push
+	ZstdCurrentDictionaryName = "current"
+	ZstdDictionarySuffix      = ".dict"
+
+	zstdFrameMagic                 = 0xFD2FB528
+	zstdDictionaryMagic            = 0xEC30A437
+	zstdFrameHeaderDictionaryIdEnd = 10
+)
+
+type ZstdDictionaryNotFoundError struct {
+	error
+}
+
+func NewZstdDictionaryNotFoundError(id uint32) ZstdDictionaryNotFoundError {
+	return ZstdDictionaryNotFoundError{errors.Errorf("zstd dictionary %d is not found in storage", id)}
+}
+
+func (err ZstdDictionaryNotFoundError) Error() string {
+	return fmt.Sprintf(tracelog.GetErrorFormatter(), err.error)
+}
+
+// zstdDictionaryCache keeps loaded dictionaries, dictionary content never changes for its ID
+var zstdDictionaryCache = struct {
+	sync.Mutex
+	dictionaries map[uint32][]byte
+}{dictionaries: make(map[uint32][]byte)}
+
+func getZstdDictionaryName(id uint32) string {
+	return strconv.FormatUint(uint64(id), 10) + ZstdDictionarySuffix
+}
+
+// GetZstdDictionaryId returns ID, which is written in header of trained dictionary
+func GetZstdDictionaryId(dictionary []byte) (uint32, error) {
+	if len(dictionary) < 8 || binary.LittleEndian.Uint32(dictionary) != zstdDictionaryMagic {
+		return 0, errors.New("zstd dictionary has no header, only trained dictionaries are supported")
+	}
+	id := binary.LittleEndian.Uint32(dictionary[4:])
+	if id == 0 {
+		return 0, errors.New("zstd dictionary has no ID")
+	}
+	return id, nil
+}
+
+// getZstdFrameDictionaryId parses dictionary ID of frame header, it is zero for frames without dictionary
+func getZstdFrameDictionaryId(header []byte) uint32 {
+	if len(header) < 5 || binary.LittleEndian.Uint32(header) != zstdFrameMagic {
+		return 0
+	}
+	descriptor := header[4]
+	position := 5
+	// window descriptor is absent in single segment frames
+	if descriptor&0x20 == 0 {
+		position++
+	}
+	idSize := []int{0, 1, 2, 4}[descriptor&3]
+	if len(header) < position+idSize {
+		return 0
+	}
+	var id uint32
+	for i := idSize - 1; i >= 0; i-- {
+		id = id<<8 | uint32(header[position+i])
+	}
+	return id
+}
+
+// loadZstdDictionary reads dictionary from WAL folder
+func loadZstdDictionary(walFolder StorageFolder, id uint32) ([]byte, error) {
+	zstdDictionaryCache.Lock()
+	defer zstdDictionaryCache.Unlock()
+	if dictionary, ok := zstdDictionaryCache.dictionaries[id]; ok {
+		return dictionary, nil
+	}
+	if walFolder == nil {
+		return nil, NewZstdDictionaryNotFoundError(id)
+	}
+	dictionary, err := readZstdDictionaryObject(walFolder, getZstdDictionaryName(id))
+	if _, ok := errors.Cause(err).(ObjectNotFoundError); ok {
+		return nil, NewZstdDictionaryNotFoundError(id)
+	}
+	if err != nil {
+		return nil, err
+	}
+	zstdDictionaryCache.dictionaries[id] = dictionary
+	return dictionary, nil
+}
+
+func readZstdDictionaryObject(walFolder StorageFolder, name string) ([]byte, error) {
+	reader, err := walFolder.GetSubFolder(ZstdDictionaryPath).ReadObject(name)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+	return ioutil.ReadAll(reader)
+}
+
+// LoadCurrentZstdDictionary returns dictionary, which wal-push compresses with, or nil if there is none
+func LoadCurrentZstdDictionary(walFolder StorageFolder) ([]byte, error) {
+	idData, err := readZstdDictionaryObject(walFolder, ZstdCurrentDictionaryName)
+	if _, ok := errors.Cause(err).(ObjectNotFoundError); ok {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	id, err := strconv.ParseUint(strings.TrimSpace(string(idData)), 10, 32)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to parse ID of current zstd dictionary")
+	}
+	return loadZstdDictionary(walFolder, uint32(id))
+}
+
+// UploadZstdDictionary stores dictionary and makes it current, previous dictionaries are kept
+func UploadZstdDictionary(walFolder StorageFolder, dictionary []byte) (uint32, error) {
+	id, err := GetZstdDictionaryId(dictionary)
+	if err != nil {
+		return 0, err
+	}
+	dictionaryFolder := walFolder.GetSubFolder(ZstdDictionaryPath)
+	err = dictionaryFolder.PutObject(getZstdDictionaryName(id), bytes.NewReader(dictionary))
+	if err != nil {
+		return 0, err
+	}
+	err = dictionaryFolder.PutObject(ZstdCurrentDictionaryName, strings.NewReader(strconv.FormatUint(uint64(id), 10)))
+	return id, err
+}
@@ -0,0 +1,41 @@
+package internal
+
+/*
+#include <stddef.h>
+
+// zdict of zstd is compiled into github.com/DataDog/zstd, but it has no Go binding
+size_t ZDICT_trainFromBuffer(void* dictBuffer, size_t dictBufferCapacity,
+                             const void* samplesBuffer, const size_t* samplesSizes, unsigned nbSamples);
+unsigned ZDICT_isError(size_t errorCode);
+const char* ZDICT_getErrorName(size_t errorCode);
+*/
+import "C"
+
+import (
+	_ "github.com/DataDog/zstd"
+	"github.com/pkg/errors"
+	"unsafe"
+)
+
+// TrainZstdDictionary trains dictionary of size up to capacity on samples
+func TrainZstdDictionary(samples [][]byte, capacity int) ([]byte, error) {
+	sampleData := make([]byte, 0)
+	sampleSizes := make([]C.size_t, 0, len(samples))
+	for _, sample := range samples {
+		if len(sample) == 0 {
+			continue
+		}
+		sampleData = append(sampleData, sample...)
+		sampleSizes = append(sampleSizes, C.size_t(len(sample)))
+	}
+	if len(sampleSizes) == 0 {
+		return nil, errors.New("no samples to train zstd dictionary")
+	}
+	dictionary := make([]byte, capacity)
+	size := C.ZDICT_trainFromBuffer(unsafe.Pointer(&dictionary[0]), C.size_t(capacity),
+		unsafe.Pointer(&sampleData[0]), &sampleSizes[0], C.unsigned(len(sampleSizes)))
+	if C.ZDICT_isError(size) != 0 {
+		return nil, errors.Errorf("failed to train zstd dictionary: %s", C.GoString(C.ZDICT_getErrorName(size)))
+	}
+	return dictionary[:size], nil
+}
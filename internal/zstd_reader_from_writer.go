@@ -9,8 +9,9 @@ type ZstdReaderFromWriter struct {
 	zstd.Writer
 }
 
-func NewZstdReaderFromWriter(dst io.Writer) *ZstdReaderFromWriter {
-	zstdWriter := zstd.NewWriterLevel(dst, 3)
+// NewZstdReaderFromWriter compresses with dictionary, unless it is nil
+func NewZstdReaderFromWriter(dst io.Writer, level int, dictionary []byte) *ZstdReaderFromWriter {
+	zstdWriter := zstd.NewWriterLevelDict(dst, level, dictionary)
 	return &ZstdReaderFromWriter{Writer: *zstdWriter}
 }
 
@@ -29,3 +29,40 @@ func TestGetTarNames(t *testing.T) {
 	assert.NoError(t, err)
 	assert.ElementsMatch(t, []string{"1", "2", "3"}, tarNames)
 }
+
+func TestGetCheckedTarNames_NoManifest(t *testing.T) {
+	folder := createMockStorageFolder()
+	backup := internal.NewBackup(folder.GetSubFolder(internal.BaseBackupPath), "base_456")
+	tarNames, err := backup.GetCheckedTarNames(internal.BackupSentinelDto{})
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, []string{"1", "2", "3"}, tarNames)
+}
+
+func TestGetCheckedTarNames_Match(t *testing.T) {
+	folder := createMockStorageFolder()
+	backup := internal.NewBackup(folder.GetSubFolder(internal.BaseBackupPath), "base_456")
+	sentinelDto := internal.BackupSentinelDto{TarParts: internal.TarPartList{"1": {}, "2": {}, "3": {}}}
+	tarNames, err := backup.GetCheckedTarNames(sentinelDto)
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, []string{"1", "2", "3"}, tarNames)
+}
+
+func TestGetCheckedTarNames_MissingPart(t *testing.T) {
+	folder := createMockStorageFolder()
+	backup := internal.NewBackup(folder.GetSubFolder(internal.BaseBackupPath), "base_456")
+	sentinelDto := internal.BackupSentinelDto{TarParts: internal.TarPartList{"1": {}, "2": {}, "3": {}, "4": {}}}
+	_, err := backup.GetCheckedTarNames(sentinelDto)
+	assert.Error(t, err)
+	assert.IsType(t, internal.TarPartsMismatchError{}, err)
+	assert.Contains(t, err.Error(), "missing [4]")
+}
+
+func TestGetCheckedTarNames_UnlistedPart(t *testing.T) {
+	folder := createMockStorageFolder()
+	backup := internal.NewBackup(folder.GetSubFolder(internal.BaseBackupPath), "base_456")
+	sentinelDto := internal.BackupSentinelDto{TarParts: internal.TarPartList{"1": {}, "2": {}}}
+	_, err := backup.GetCheckedTarNames(sentinelDto)
+	assert.Error(t, err)
+	assert.IsType(t, internal.TarPartsMismatchError{}, err)
+	assert.Contains(t, err.Error(), "unlisted [3]")
+}
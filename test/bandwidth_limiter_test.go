@@ -44,3 +44,23 @@ func TestLimiter(t *testing.T) {
 		t.Errorf("Rate limiter did not work")
 	}
 }
+
+func TestDiskLimitWriter(t *testing.T) {
+	internal.DiskLimiter = rate.NewLimiter(rate.Limit(10000), int(1024))
+	defer func() {
+		internal.DiskLimiter = nil
+	}()
+	var buffer bytes.Buffer
+	start := time.Now()
+
+	writer := internal.NewDiskLimitWriter(&buffer)
+	// Write is larger than the burst, so it is waited in chunks
+	_, err := writer.Write(make([]byte, 2000))
+	assert.NoError(t, err)
+	end := time.Now()
+
+	assert.Equal(t, 2000, buffer.Len())
+	if end.Sub(start) < time.Millisecond*80 {
+		t.Errorf("Rate limiter did not work")
+	}
+}
@@ -2,6 +2,7 @@ package test
 
 import (
 	"bytes"
+	"fmt"
 	"github.com/stretchr/testify/assert"
 	"github.com/x4m/wal-g/internal"
 	"github.com/x4m/wal-g/internal/walparser"
@@ -237,3 +238,49 @@ func TestLoadDeltaMap_WalTail(t *testing.T) {
 	assert.Equal(t, []uint32{4, 9}, bundle.DeltaMap[BundleTestLocations[0].RelationFileNode].ToArray())
 	assert.Equal(t, []uint32{8}, bundle.DeltaMap[BundleTestLocations[1].RelationFileNode].ToArray())
 }
+
+// Timeline 1 is switched to timeline 2 in segment 0x85, which is read from timeline 2
+func setupFolderAndBundleWithTimelineSwitch(t *testing.T) (internal.StorageFolder, *internal.Bundle) {
+	storage := testtools.NewInMemoryStorage()
+	assert.NoError(t, putDeltaIntoStorage(storage, []walparser.BlockLocation{BundleTestLocations[0]}, "000000010000000000000070_delta"))
+	// delta of the switch group on the ancestor timeline contains changes, which are not in history of timeline 2
+	assert.NoError(t, putDeltaIntoStorage(storage, []walparser.BlockLocation{BundleTestLocations[2]}, "000000010000000000000080_delta"))
+	assert.NoError(t, putDeltaIntoStorage(storage, []walparser.BlockLocation{BundleTestLocations[1]}, "000000020000000000000090_delta"))
+	for logSegNo := 0x80; logSegNo < 0x90; logSegNo++ {
+		timeline := 1
+		if logSegNo >= 0x85 {
+			timeline = 2
+		}
+		walFilename := fmt.Sprintf("%08X%08X%08X", timeline, 0, logSegNo)
+		assert.NoError(t, putWalIntoStorage(storage, make([]byte, walparser.WalPageSize), walFilename))
+	}
+	assert.NoError(t, putWalIntoStorage(storage, []byte("1\t0/85000100\tno recovery target specified\n"), "00000002.history"))
+
+	incrementFromLsn := 0x73 * internal.WalSegmentSize
+	bundle := &internal.Bundle{Timeline: 2, IncrementFromLsn: &incrementFromLsn}
+	return testtools.NewInMemoryStorageFolder("in_memory/", storage).GetSubFolder(internal.WalPath), bundle
+}
+
+func TestLoadDeltaMap_TimelineSwitch(t *testing.T) {
+	folder, bundle := setupFolderAndBundleWithTimelineSwitch(t)
+	err := bundle.DownloadDeltaMap(folder, 0xA0*internal.WalSegmentSize+1)
+	assert.NoError(t, err)
+	assert.Len(t, bundle.DeltaMap, 2)
+	assert.Equal(t, []uint32{4}, bundle.DeltaMap[BundleTestLocations[0].RelationFileNode].ToArray())
+	assert.Equal(t, []uint32{8}, bundle.DeltaMap[BundleTestLocations[1].RelationFileNode].ToArray())
+}
+
+func TestLoadDeltaMap_TimelineSwitchInLastGroup(t *testing.T) {
+	folder, bundle := setupFolderAndBundleWithTimelineSwitch(t)
+	err := bundle.DownloadDeltaMap(folder, 0x8A*internal.WalSegmentSize+1)
+	assert.NoError(t, err)
+	assert.Len(t, bundle.DeltaMap, 1)
+	assert.Equal(t, []uint32{4}, bundle.DeltaMap[BundleTestLocations[0].RelationFileNode].ToArray())
+}
+
+func TestLoadDeltaMap_TimelineSwitchWithoutHistory(t *testing.T) {
+	folder, bundle := setupFolderAndBundleWithTimelineSwitch(t)
+	assert.NoError(t, folder.DeleteObjects([]string{"00000002.history.lz4"}))
+	err := bundle.DownloadDeltaMap(folder, 0xA0*internal.WalSegmentSize+1)
+	assert.Error(t, err)
+}
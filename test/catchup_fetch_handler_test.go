@@ -0,0 +1,109 @@
+package test
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"github.com/x4m/wal-g/internal"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func writeCatchupFile(t *testing.T, dbDataDirectory, name string, content []byte, mTime time.Time) {
+	filePath := filepath.Join(dbDataDirectory, name)
+	assert.NoError(t, os.MkdirAll(filepath.Dir(filePath), 0755))
+	assert.NoError(t, ioutil.WriteFile(filePath, content, 0600))
+	assert.NoError(t, os.Chtimes(filePath, mTime, mTime))
+}
+
+func TestCompareDataDirectoryWithBackup(t *testing.T) {
+	dbDataDirectory, err := ioutil.TempDir("", "catchup")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dbDataDirectory)
+
+	mTime := time.Date(2018, 10, 1, 12, 0, 0, 0, time.UTC)
+	writeCatchupFile(t, dbDataDirectory, "base/1/100", []byte("same"), mTime)
+	writeCatchupFile(t, dbDataDirectory, "base/1/200", []byte("other size"), mTime)
+	writeCatchupFile(t, dbDataDirectory, "base/1/300", []byte("time"), mTime.Add(time.Second))
+	writeCatchupFile(t, dbDataDirectory, "base/1/500", []byte("extra"), mTime)
+	writeCatchupFile(t, dbDataDirectory, "backup_label", []byte("label"), mTime)
+	writeCatchupFile(t, dbDataDirectory, "recovery.conf", []byte("standby_mode = on"), mTime)
+	writeCatchupFile(t, dbDataDirectory, "pg_wal/000000010000000000000001", []byte("wal"), mTime)
+
+	backupFiles := internal.BackupFileList{
+		"/base/1/100": {MTime: mTime, Size: 4},
+		"/base/1/200": {MTime: mTime, Size: 4},
+		"/base/1/300": {MTime: mTime, Size: 4},
+		"/base/1/400": {MTime: mTime, Size: 4},
+	}
+	comparison, err := internal.CompareDataDirectoryWithBackup(dbDataDirectory, backupFiles)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]bool{"/base/1/200": true, "/base/1/300": true, "/base/1/400": true}, comparison.FilesToRestore)
+	assert.Equal(t, []string{"base/1/500"}, comparison.FilesToDelete)
+}
+
+func TestFilterTarsToExtract(t *testing.T) {
+	tarsToExtract := []internal.ReaderMaker{
+		&BufferReaderMaker{&bytes.Buffer{}, "part_001.tar.lz4"},
+		&BufferReaderMaker{&bytes.Buffer{}, "part_002.tar.lz4"},
+		&BufferReaderMaker{&bytes.Buffer{}, "part_003.tar.lz4"},
+	}
+	backupFiles := internal.BackupFileList{
+		"/base/1/100": {TarPart: "part_001.tar.lz4"},
+		"/base/1/200": {TarPart: "part_002.tar.lz4"},
+		"/base/1/300": {IsSkipped: true},
+	}
+	filesToUnwrap := map[string]bool{"/base/1/100": true, "/base/1/300": true}
+
+	filtered := internal.FilterTarsToExtract(tarsToExtract, backupFiles, filesToUnwrap)
+	assert.Equal(t, []string{"part_001.tar.lz4", "part_003.tar.lz4"}, internal.ReaderMakersToFilePaths(filtered))
+}
+
+func TestCatchupTarInterpreter_OverwritesChangedPages(t *testing.T) {
+	dbDataDirectory, err := ioutil.TempDir("", "catchup")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dbDataDirectory)
+
+	mTime := time.Now()
+	local := bytes.Join([][]byte{makePage('A'), makePage('X'), makePage('A'), makePage('X')}, nil)
+	writeCatchupFile(t, dbDataDirectory, reverseUnpackPagedFile, local, mTime)
+
+	sentinel := internal.BackupSentinelDto{Files: internal.BackupFileList{reverseUnpackPagedFile: {}}}
+	filesToUnwrap := map[string]bool{reverseUnpackPagedFile: true}
+	tarInterpreter := internal.NewCatchupTarInterpreter(dbDataDirectory, sentinel, filesToUnwrap, internal.NewRestoredPagedFiles(), nil)
+	fromBackup := bytes.Join([][]byte{makePage('A'), makePage('B'), makePage('A')}, nil)
+	interpretFile(t, tarInterpreter, reverseUnpackPagedFile, fromBackup)
+
+	restored, err := ioutil.ReadFile(filepath.Join(dbDataDirectory, reverseUnpackPagedFile))
+	assert.NoError(t, err)
+	assert.Equal(t, fromBackup, restored)
+}
+
+func TestCatchupTarInterpreter_IncrementOverExistingFile(t *testing.T) {
+	dbDataDirectory, err := ioutil.TempDir("", "catchup")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dbDataDirectory)
+
+	pageSize := uint64(internal.DatabasePageSize)
+	local := bytes.Join([][]byte{makePage('X'), makePage('X'), makePage('X')}, nil)
+	writeCatchupFile(t, dbDataDirectory, reverseUnpackPagedFile, local, time.Now())
+
+	restoredFiles := internal.NewRestoredPagedFiles()
+	newestSentinel := makeIncrementalSentinel(internal.BackupFileList{reverseUnpackPagedFile: {IsIncremented: true}})
+	filesToUnwrap := map[string]bool{reverseUnpackPagedFile: true}
+	tarInterpreter := internal.NewCatchupTarInterpreter(dbDataDirectory, newestSentinel, filesToUnwrap, restoredFiles, nil)
+	interpretFile(t, tarInterpreter, reverseUnpackPagedFile,
+		makeIncrement(2*pageSize, map[uint32][]byte{1: makePage('B')}, []uint32{1}))
+
+	baseSentinel := internal.BackupSentinelDto{Files: internal.BackupFileList{reverseUnpackPagedFile: {}}}
+	baseFilesToUnwrap, err := internal.GetBaseFilesToUnwrap(newestSentinel.Files, filesToUnwrap)
+	assert.NoError(t, err)
+	tarInterpreter = internal.NewCatchupTarInterpreter(dbDataDirectory, baseSentinel, baseFilesToUnwrap, restoredFiles, nil)
+	interpretFile(t, tarInterpreter, reverseUnpackPagedFile, bytes.Join([][]byte{makePage('A'), makePage('A')}, nil))
+
+	restored, err := ioutil.ReadFile(filepath.Join(dbDataDirectory, reverseUnpackPagedFile))
+	assert.NoError(t, err)
+	assert.Equal(t, bytes.Join([][]byte{makePage('A'), makePage('B')}, nil), restored)
+}
@@ -2,6 +2,7 @@ package test
 
 import (
 	"bytes"
+	"fmt"
 	"github.com/stretchr/testify/assert"
 	"github.com/x4m/wal-g/internal"
 	"io"
@@ -74,3 +75,53 @@ func TestBigDataCompression(t *testing.T) {
 		testCompressor(compressor, testData, t)
 	}
 }
+
+func TestCompressionLevels(t *testing.T) {
+	const SmallDataSize = 16 << 10
+	randomReader := io.LimitReader(NewBiasedRandomReader(), SmallDataSize)
+	var testData bytes.Buffer
+	io.Copy(&testData, randomReader)
+	levels := map[string][]int{
+		internal.Lz4AlgorithmName:  {0, 9, internal.MaxLz4CompressionLevel},
+		internal.LzmaAlgorithmName: {0, 3, internal.MaxLzmaCompressionLevel},
+		internal.ZstdAlgorithmName: {internal.MinZstdCompressionLevel, 10, internal.MaxZstdCompressionLevel},
+	}
+	for method, methodLevels := range levels {
+		for _, level := range methodLevels {
+			compressor, err := internal.NewCompressor(method, level)
+			assert.NoError(t, err)
+			testCompressor(compressor, testData, t)
+		}
+	}
+}
+
+func TestNewCompressor_InvalidLevel(t *testing.T) {
+	_, err := internal.NewCompressor(internal.ZstdAlgorithmName, 0)
+	assert.IsType(t, internal.InvalidCompressionLevelError{}, err)
+	_, err = internal.NewCompressor(internal.LzmaAlgorithmName, internal.MaxLzmaCompressionLevel+1)
+	assert.IsType(t, internal.InvalidCompressionLevelError{}, err)
+	_, err = internal.NewCompressor(internal.Lz4AlgorithmName, -1)
+	assert.IsType(t, internal.InvalidCompressionLevelError{}, err)
+	_, err = internal.NewCompressor("brotli", 1)
+	assert.IsType(t, internal.UnknownCompressionMethodError{}, err)
+}
+
+func TestCompressionLevelChangesOutput(t *testing.T) {
+	var testData bytes.Buffer
+	for i := 0; testData.Len() < 1<<20; i++ {
+		fmt.Fprintf(&testData, "tuple %d of relation %d has value %d\n", i, i%7, i*i%1000)
+	}
+	compressedSize := func(method string, level int) int {
+		compressor, err := internal.NewCompressor(method, level)
+		assert.NoError(t, err)
+		var compressed bytes.Buffer
+		writer := compressor.NewWriter(&compressed)
+		_, err = writer.ReadFrom(bytes.NewReader(testData.Bytes()))
+		assert.NoError(t, err)
+		assert.NoError(t, writer.Close())
+		return compressed.Len()
+	}
+	assert.True(t, compressedSize(internal.ZstdAlgorithmName, 19) < compressedSize(internal.ZstdAlgorithmName, 1))
+	assert.True(t, compressedSize(internal.Lz4AlgorithmName, 9) < compressedSize(internal.Lz4AlgorithmName, 0))
+	assert.True(t, compressedSize(internal.LzmaAlgorithmName, 1) < compressedSize(internal.LzmaAlgorithmName, 0))
+}
@@ -51,7 +51,7 @@ func GetXLogRecordData() (walparser.XLogRecord, []byte) {
 	recordHeaderData.Write([]byte{0, 0})
 	recordHeaderData.Write(internal.ToBytes(&recordHeader.Crc32Hash))
 	recordData := concatByteSlices(recordHeaderData.Bytes(), data)
-	record, _ := walparser.ParseXLogRecordFromBytes(recordData)
+	record, _ := walparser.ParseXLogRecordFromBytes(recordData, 0)
 	return *record, recordData
 }
 
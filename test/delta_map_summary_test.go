@@ -0,0 +1,165 @@
+package test
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/RoaringBitmap/roaring"
+	"github.com/stretchr/testify/assert"
+	"github.com/x4m/wal-g/internal"
+	"github.com/x4m/wal-g/internal/walparser"
+	"github.com/x4m/wal-g/testtools"
+	"testing"
+)
+
+const DeltaSummaryFilename = "000000010000000400000000_delta_summary"
+
+func makeSummaryDeltaFile(t *testing.T, blockNo uint32) *internal.DeltaFile {
+	deltaFile, err := internal.NewDeltaFile(walparser.NewWalParser())
+	assert.NoError(t, err)
+	deltaFile.Locations = []walparser.BlockLocation{*walparser.NewBlockLocation(1663, 16384, 16397, blockNo)}
+	return deltaFile
+}
+
+func TestDeltaMapSummary_SaveLoad(t *testing.T) {
+	summary := internal.NewDeltaMapSummary()
+	summary.AddDeltaFile(0, &internal.DeltaFile{Locations: BundleTestLocations})
+	lastDeltaFile := makeSummaryDeltaFile(t, 100500)
+	lastDeltaFile.WalParser = walparser.LoadWalParserFromCurrentRecordHead([]byte{1, 2, 3})
+	summary.AddDeltaFile(63, lastDeltaFile)
+	assert.Equal(t, uint64(1|1<<63), summary.DeltaFiles)
+	assert.False(t, summary.IsComplete())
+
+	var data bytes.Buffer
+	assert.NoError(t, summary.Save(&data))
+	loadedSummary, err := internal.LoadDeltaMapSummary(&data)
+	assert.NoError(t, err)
+	assert.Equal(t, summary.DeltaFiles, loadedSummary.DeltaFiles)
+	assert.Equal(t, []byte{1, 2, 3}, loadedSummary.WalParser.GetCurrentRecordData())
+	assert.Len(t, loadedSummary.DeltaMap, 3)
+	assert.Equal(t, []uint32{4, 9}, loadedSummary.DeltaMap[BundleTestLocations[0].RelationFileNode].ToArray())
+	assert.Equal(t, []uint32{100500}, loadedSummary.DeltaMap[walparser.RelFileNode{SpcNode: 1663, DBNode: 16384, RelNode: 16397}].ToArray())
+}
+
+func TestGetDeltaSummaryFilenameFor(t *testing.T) {
+	summaryFilename, position, err := internal.GetDeltaSummaryFilenameFor("000000010000000400000000_delta")
+	assert.NoError(t, err)
+	assert.Equal(t, DeltaSummaryFilename, summaryFilename)
+	assert.Equal(t, 0, position)
+
+	summaryFilename, position, err = internal.GetDeltaSummaryFilenameFor("0000000100000007000000F0_delta")
+	assert.NoError(t, err)
+	assert.Equal(t, DeltaSummaryFilename, summaryFilename)
+	assert.Equal(t, 63, position)
+
+	summaryFilename, _, err = internal.GetDeltaSummaryFilenameFor("000000020000000600000010_delta")
+	assert.NoError(t, err)
+	assert.Equal(t, "000000020000000400000000_delta_summary", summaryFilename)
+}
+
+func TestFlushDeltaSummaries(t *testing.T) {
+	dataFolder := testtools.NewMockDataFolder()
+	storage := testtools.NewInMemoryStorage()
+	uploader := testtools.NewStoringMockUploader(storage, nil)
+
+	manager := internal.NewDeltaFileManager(dataFolder)
+	_, _, err := manager.DeltaSummaries.Load(DeltaSummaryFilename)
+	assert.NoError(t, err)
+	deltaFiles := make(map[string]*internal.DeltaFile)
+	for i := 0; i < 63; i++ {
+		deltaFiles[fmt.Sprintf("00000001%08X%08X_delta", 4+i/16, i%16*16)] = makeSummaryDeltaFile(t, uint32(i))
+	}
+	manager.FlushDeltaSummaries(uploader, deltaFiles)
+	_, ok := storage.Load("in_memory/" + DeltaSummaryFilename + ".mock")
+	assert.False(t, ok)
+
+	// the next wal-push continues with summary from data folder
+	manager = internal.NewDeltaFileManager(dataFolder)
+	_, _, err = manager.DeltaSummaries.Load(DeltaSummaryFilename)
+	assert.NoError(t, err)
+	manager.FlushDeltaSummaries(uploader, map[string]*internal.DeltaFile{
+		"0000000100000007000000F0_delta": makeSummaryDeltaFile(t, 63),
+	})
+	summaryData, ok := storage.Load("in_memory/" + DeltaSummaryFilename + ".mock")
+	assert.True(t, ok)
+	summary, err := internal.LoadDeltaMapSummary(&summaryData.Data)
+	assert.NoError(t, err)
+	assert.True(t, summary.IsComplete())
+	expected := roaring.New()
+	expected.AddRange(0, 64)
+	assert.Equal(t, expected.ToArray(), summary.DeltaMap[walparser.RelFileNode{SpcNode: 1663, DBNode: 16384, RelNode: 16397}].ToArray())
+}
+
+func TestFlushDeltaSummaries_NotLoadedSummary(t *testing.T) {
+	dataFolder := testtools.NewMockDataFolder()
+	manager := internal.NewDeltaFileManager(dataFolder)
+	manager.FlushDeltaSummaries(nil, map[string]*internal.DeltaFile{
+		"000000010000000400000000_delta": makeSummaryDeltaFile(t, 0),
+	})
+	assert.True(t, dataFolder.IsEmpty())
+}
+
+func TestFlushDeltaSummaries_CanceledDeltaFile(t *testing.T) {
+	dataFolder := testtools.NewMockDataFolder()
+	manager := internal.NewDeltaFileManager(dataFolder)
+	_, _, err := manager.DeltaSummaries.Load(DeltaSummaryFilename)
+	assert.NoError(t, err)
+	manager.CanceledDeltaFiles["000000010000000400000010_delta"] = true
+	manager.FlushDeltaSummaries(nil, map[string]*internal.DeltaFile{
+		"000000010000000400000000_delta": makeSummaryDeltaFile(t, 0),
+	})
+	assert.True(t, dataFolder.IsEmpty())
+}
+
+func TestFlushDeltaSummaries_OlderThanComplete(t *testing.T) {
+	dataFolder := testtools.NewMockDataFolder()
+	storage := testtools.NewInMemoryStorage()
+	uploader := testtools.NewStoringMockUploader(storage, nil)
+	nextSummaryFilename := "000000010000000800000000_delta_summary"
+
+	manager := internal.NewDeltaFileManager(dataFolder)
+	for _, summaryFilename := range []string{DeltaSummaryFilename, nextSummaryFilename} {
+		_, _, err := manager.DeltaSummaries.Load(summaryFilename)
+		assert.NoError(t, err)
+	}
+	deltaFiles := map[string]*internal.DeltaFile{
+		"000000010000000400000000_delta": makeSummaryDeltaFile(t, 0),
+	}
+	for i := 0; i < 64; i++ {
+		deltaFiles[fmt.Sprintf("00000001%08X%08X_delta", 8+i/16, i%16*16)] = makeSummaryDeltaFile(t, uint32(i))
+	}
+	manager.FlushDeltaSummaries(uploader, deltaFiles)
+	_, ok := storage.Load("in_memory/" + nextSummaryFilename + ".mock")
+	assert.True(t, ok)
+	assert.True(t, dataFolder.IsEmpty())
+}
+
+func TestLoadDeltaMap_DeltaSummary(t *testing.T) {
+	storage := testtools.NewInMemoryStorage()
+	summary := internal.NewDeltaMapSummary()
+	for i := 0; i < 64; i++ {
+		summary.AddDeltaFile(i, makeSummaryDeltaFile(t, uint32(i)))
+	}
+	var summaryData bytes.Buffer
+	assert.NoError(t, summary.Save(&summaryData))
+	putWalIntoStorage(storage, summaryData.Bytes(), "000000010000000000000000_delta_summary")
+	// delta files of the summary are not needed
+	assert.NoError(t, putDeltaIntoStorage(storage, BundleTestLocations, "000000010000000400000000_delta"))
+
+	folder := testtools.NewInMemoryStorageFolder("in_memory/", storage).GetSubFolder(internal.WalPath)
+	incrementFromLsn := uint64(0)
+	bundle := &internal.Bundle{Timeline: 1, IncrementFromLsn: &incrementFromLsn}
+	err := bundle.DownloadDeltaMap(folder, 0x410*internal.WalSegmentSize+1)
+	assert.NoError(t, err)
+	assert.Len(t, bundle.DeltaMap, 3)
+	assert.Equal(t, uint64(64), bundle.DeltaMap[walparser.RelFileNode{SpcNode: 1663, DBNode: 16384, RelNode: 16397}].GetCardinality())
+	assert.Equal(t, []uint32{4, 9}, bundle.DeltaMap[BundleTestLocations[0].RelationFileNode].ToArray())
+
+	// summary is used by wal-diff too
+	deltaMap, err := internal.DownloadDeltaMapBetween(folder, 1, 0, 0x410*internal.WalSegmentSize)
+	assert.NoError(t, err)
+	assert.Len(t, deltaMap, 3)
+
+	// delta files are used, if range does not cover the whole summary
+	err = bundle.DownloadDeltaMap(folder, 0x3FF*internal.WalSegmentSize+1)
+	assert.Error(t, err)
+}
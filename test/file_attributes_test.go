@@ -0,0 +1,94 @@
+package test
+
+import (
+	"archive/tar"
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"github.com/x4m/wal-g/internal"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func interpretHeader(t *testing.T, tarInterpreter internal.TarInterpreter, header *tar.Header, content []byte) {
+	header.Size = int64(len(content))
+	err := tarInterpreter.Interpret(bytes.NewReader(content), header)
+	assert.NoError(t, err)
+}
+
+func TestFileTarInterpreter_RestoresModTime(t *testing.T) {
+	dbDataDirectory, err := ioutil.TempDir("", "file_attributes")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dbDataDirectory)
+
+	mTime := time.Date(2018, 10, 1, 12, 0, 0, 0, time.UTC)
+	tarInterpreter := internal.NewFileTarInterpreter(dbDataDirectory, internal.BackupSentinelDto{},
+		map[string]bool{"base/1/100": true}, nil)
+	interpretHeader(t, tarInterpreter, &tar.Header{Name: "base/1/100", Typeflag: tar.TypeReg, Mode: 0600, ModTime: mTime}, []byte("data"))
+
+	info, err := os.Stat(filepath.Join(dbDataDirectory, "base/1/100"))
+	assert.NoError(t, err)
+	assert.True(t, mTime.Equal(info.ModTime()))
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+}
+
+func TestFileTarInterpreter_RestoresLinks(t *testing.T) {
+	dbDataDirectory, err := ioutil.TempDir("", "file_attributes")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dbDataDirectory)
+
+	tarInterpreter := internal.NewFileTarInterpreter(dbDataDirectory, internal.BackupSentinelDto{},
+		map[string]bool{"base/1/100": true}, nil)
+	interpretHeader(t, tarInterpreter, &tar.Header{Name: "base/1/100", Typeflag: tar.TypeReg, Mode: 0600}, []byte("data"))
+	interpretHeader(t, tarInterpreter, &tar.Header{Name: "base/1/hardlink", Typeflag: tar.TypeLink, Linkname: "base/1/100"}, nil)
+	interpretHeader(t, tarInterpreter, &tar.Header{Name: "pg_tblspc/16384", Typeflag: tar.TypeSymlink, Linkname: "/mnt/tablespace"}, nil)
+
+	target, err := os.Readlink(filepath.Join(dbDataDirectory, "pg_tblspc/16384"))
+	assert.NoError(t, err)
+	assert.Equal(t, "/mnt/tablespace", target)
+
+	original, err := os.Stat(filepath.Join(dbDataDirectory, "base/1/100"))
+	assert.NoError(t, err)
+	hardlink, err := os.Stat(filepath.Join(dbDataDirectory, "base/1/hardlink"))
+	assert.NoError(t, err)
+	assert.True(t, os.SameFile(original, hardlink))
+}
+
+func TestFileTarInterpreter_OwnerOverride(t *testing.T) {
+	dbDataDirectory, err := ioutil.TempDir("", "file_attributes")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dbDataDirectory)
+
+	// Chown to the current user is permitted without root privileges
+	owner := &internal.FileOwner{Uid: os.Getuid(), Gid: os.Getgid()}
+	tarInterpreter := internal.NewFileTarInterpreter(dbDataDirectory, internal.BackupSentinelDto{},
+		map[string]bool{"base/1/100": true}, owner)
+	header := &tar.Header{Name: "base/1/100", Typeflag: tar.TypeReg, Mode: 0600, Uid: 12345, Gid: 12345}
+	interpretHeader(t, tarInterpreter, header, []byte("data"))
+
+	info, err := os.Stat(filepath.Join(dbDataDirectory, "base/1/100"))
+	assert.NoError(t, err)
+	stat := info.Sys().(*syscall.Stat_t)
+	assert.Equal(t, uint32(owner.Uid), stat.Uid)
+	assert.Equal(t, uint32(owner.Gid), stat.Gid)
+}
+
+func TestLookupFileOwner_Empty(t *testing.T) {
+	owner, err := internal.LookupFileOwner("")
+	assert.NoError(t, err)
+	assert.Nil(t, owner)
+}
+
+func TestLookupFileOwner_ById(t *testing.T) {
+	owner, err := internal.LookupFileOwner("0")
+	assert.NoError(t, err)
+	assert.Equal(t, &internal.FileOwner{Uid: 0, Gid: 0}, owner)
+}
+
+func TestLookupFileOwner_Unknown(t *testing.T) {
+	_, err := internal.LookupFileOwner("no_such_user_for_wal_g")
+	assert.IsType(t, internal.UnknownOwnerError{}, err)
+}
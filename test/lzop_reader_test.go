@@ -0,0 +1,47 @@
+package test
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"github.com/x4m/wal-g/internal"
+	"github.com/x4m/wal-g/internal/walparser"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func decryptWaleWal(t *testing.T) []byte {
+	file, err := os.Open(waleWALfilename)
+	assert.NoError(t, err)
+	defer file.Close()
+	decrypted, err := createCrypter(waleGpgKey).Decrypt(file)
+	assert.NoError(t, err)
+	data, err := ioutil.ReadAll(decrypted)
+	assert.NoError(t, err)
+	return data
+}
+
+// WAL file archived by WAL-E is decompressed without lzo build tag
+func TestLzoDecompressor_WaleWal(t *testing.T) {
+	var decompressed bytes.Buffer
+	err := internal.LzoDecompressor{}.Decompress(&decompressed, bytes.NewReader(decryptWaleWal(t)))
+	assert.NoError(t, err)
+	assert.Equal(t, int(internal.WalSegmentSize), decompressed.Len())
+
+	segmentSize, pageSize, err := walparser.ReadWalSizes(&decompressed)
+	assert.NoError(t, err)
+	assert.Equal(t, uint32(internal.WalSegmentSize), segmentSize)
+	assert.Equal(t, uint32(walparser.WalPageSize), pageSize)
+}
+
+func TestLzoDecompressor_CorruptedData(t *testing.T) {
+	data := decryptWaleWal(t)
+	data[len(data)/2]++
+	err := internal.LzoDecompressor{}.Decompress(&bytes.Buffer{}, bytes.NewReader(data))
+	assert.Error(t, err)
+}
+
+func TestLzoDecompressor_InvalidMagic(t *testing.T) {
+	_, err := internal.NewLzopReader(bytes.NewReader([]byte("not an lzop file")))
+	assert.Error(t, err)
+}
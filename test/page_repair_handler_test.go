@@ -0,0 +1,219 @@
+package test
+
+import (
+	"archive/tar"
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"github.com/x4m/wal-g/internal"
+	"github.com/x4m/wal-g/internal/walparser"
+	"github.com/x4m/wal-g/testtools"
+	"io/ioutil"
+	"testing"
+)
+
+const (
+	pageRepairBackupName      = "base_000000010000000000000007"
+	pageRepairDeltaBackupName = "base_000000010000000000000007_D_000000010000000000000007"
+	pageRepairRelFile         = "/base/16384/16397"
+	pageRepairSkippedRelFile  = "/base/16384/16400"
+)
+
+var pageRepairLocation = walparser.BlockLocation{
+	RelationFileNode: walparser.RelFileNode{SpcNode: 1663, DBNode: 16384, RelNode: 16397},
+}
+
+// makeBackupPage makes page filled with fill, which has pd_lsn 0/6000000
+func makeBackupPage(fill byte) []byte {
+	page := makePage(fill)
+	binary.LittleEndian.PutUint32(page[0:4], 0)
+	binary.LittleEndian.PutUint32(page[4:8], 0x6000000)
+	return page
+}
+
+func makeTarPart(t *testing.T, files map[string][]byte) []byte {
+	var tarData bytes.Buffer
+	tarWriter := tar.NewWriter(&tarData)
+	for name, content := range files {
+		err := tarWriter.WriteHeader(&tar.Header{Name: name, Typeflag: tar.TypeReg, Size: int64(len(content)), Mode: 0600})
+		assert.NoError(t, err)
+		_, err = tarWriter.Write(content)
+		assert.NoError(t, err)
+	}
+	assert.NoError(t, tarWriter.Close())
+	return tarData.Bytes()
+}
+
+func putPageRepairBackup(t *testing.T, folder internal.StorageFolder, name string,
+	sentinel internal.BackupSentinelDto, tarPart []byte) {
+	baseBackupFolder := folder.GetSubFolder(internal.BaseBackupPath)
+	err := baseBackupFolder.GetSubFolder(name+internal.TarPartitionFolderName).PutObject("part_1.tar", bytes.NewReader(tarPart))
+	assert.NoError(t, err)
+	sentinelData, err := json.Marshal(sentinel)
+	assert.NoError(t, err)
+	assert.NoError(t, baseBackupFolder.PutObject(name+internal.SentinelSuffix, bytes.NewReader(sentinelData)))
+}
+
+// makePageRepairFolder makes backup of 4 pages of relation 1663/16384/16397 with start LSN 0/7000000
+// and WAL with full page images of blocks 1 and 2
+func makePageRepairFolder(t *testing.T) internal.StorageFolder {
+	folder := testtools.MakeDefaultInMemoryStorageFolder()
+	var relFile []byte
+	for i := 0; i < 4; i++ {
+		relFile = append(relFile, makeBackupPage(byte(i+1))...)
+	}
+	startLsn, finishLsn := uint64(0x7000000), uint64(0x7000028)
+	sentinel := internal.BackupSentinelDto{
+		BackupStartLSN:  &startLsn,
+		BackupFinishLSN: &finishLsn,
+		Files: internal.BackupFileList{
+			pageRepairRelFile:        {Size: int64(len(relFile)), TarPart: "part_1.tar"},
+			pageRepairSkippedRelFile: {Size: int64(len(relFile)), TarPart: "part_1.tar"},
+		},
+	}
+	tarPart := makeTarPart(t, map[string][]byte{pageRepairRelFile: relFile, pageRepairSkippedRelFile: relFile})
+	putPageRepairBackup(t, folder, pageRepairBackupName, sentinel, tarPart)
+
+	content, err := ioutil.ReadFile(walShowTestDataPath + "full_page_images")
+	assert.NoError(t, err)
+	putCompressedWalObject(t, folder.GetSubFolder(internal.WalPath), "000000010000000000000007", content)
+	return folder
+}
+
+func repairPage(t *testing.T, folder internal.StorageFolder, blockNo uint32, targetLsn uint64) *internal.PageRepairResult {
+	location := pageRepairLocation
+	location.BlockNo = blockNo
+	result, err := internal.RepairPage(folder, internal.PageRepairCommandArguments{Location: location, TargetLsn: targetLsn})
+	assert.NoError(t, err)
+	return result
+}
+
+func TestRepairPage_FromBackup(t *testing.T) {
+	result := repairPage(t, makePageRepairFolder(t), 0, 0x8000000)
+	assert.Equal(t, pageRepairBackupName, result.BackupName)
+	assert.Equal(t, uint32(1), result.Timeline)
+	assert.Equal(t, "backup", result.Source)
+	assert.Equal(t, "0/07000000", result.SourceLsn)
+	assert.Equal(t, "0/06000000", result.PageLsn)
+	assert.Equal(t, 0, result.RedoRecords)
+	assert.Equal(t, makeBackupPage(1), result.Page)
+}
+
+func TestRepairPage_FromFullPageImage(t *testing.T) {
+	result := repairPage(t, makePageRepairFolder(t), 1, 0x8000000)
+	assert.Equal(t, "full page image", result.Source)
+	assert.Equal(t, "0/07000060", result.SourceLsn)
+	assert.Equal(t, "0/07000100", result.PageLsn)
+	assert.Equal(t, 0, result.RedoRecords)
+	assert.Equal(t, []byte{0x11, 0, 0x11}, []byte{result.Page[199], result.Page[200], result.Page[7200]})
+}
+
+func TestRepairPage_RedoNeeded(t *testing.T) {
+	folder := makePageRepairFolder(t)
+	// heap record changes block 1 before its image
+	result := repairPage(t, folder, 1, 0x7000060)
+	assert.Equal(t, "backup", result.Source)
+	assert.Equal(t, 1, result.RedoRecords)
+	assert.Equal(t, "0/07000028", result.RedoFromLsn)
+	assert.Equal(t, makeBackupPage(2), result.Page)
+
+	// heap record changes block 2 after its compressed image
+	result = repairPage(t, folder, 2, 0x8000000)
+	assert.Equal(t, "full page image", result.Source)
+	assert.Equal(t, "0/07000540", result.SourceLsn)
+	assert.Equal(t, "0/07000200", result.PageLsn)
+	assert.Equal(t, 1, result.RedoRecords)
+	assert.Equal(t, "0/07000598", result.RedoFromLsn)
+
+	var output bytes.Buffer
+	assert.NoError(t, result.WriteText(&output))
+	assert.Contains(t, output.String(), "1 records from 0/07000598 up to 0/08000000")
+}
+
+func TestRepairPage_ImageNotApplied(t *testing.T) {
+	folder := makePageRepairFolder(t)
+	content, err := ioutil.ReadFile(walShowTestDataPath + "full_page_images")
+	assert.NoError(t, err)
+	// image of block 1 at 0/07000060 is made for wal_consistency_checking only
+	content[0x80] &^= walparser.BkpImageApply
+	putCompressedWalObject(t, folder.GetSubFolder(internal.WalPath), "000000010000000000000007", content)
+
+	result := repairPage(t, folder, 1, 0x8000000)
+	assert.Equal(t, "backup", result.Source)
+	assert.Equal(t, 2, result.RedoRecords)
+	assert.Equal(t, "0/07000028", result.RedoFromLsn)
+	assert.Equal(t, makeBackupPage(2), result.Page)
+}
+
+func TestRepairPage_Errors(t *testing.T) {
+	folder := makePageRepairFolder(t)
+	location := pageRepairLocation
+	location.BlockNo = 7
+	_, err := internal.RepairPage(folder, internal.PageRepairCommandArguments{Location: location, TargetLsn: 0x8000000})
+	assert.IsType(t, internal.BlockNotInBackupError{}, err)
+
+	_, err = internal.RepairPage(folder, internal.PageRepairCommandArguments{Location: pageRepairLocation, TargetLsn: 0x7000000})
+	assert.IsType(t, internal.NoBackupBeforeLsnError{}, err)
+
+	_, err = internal.RepairPage(folder, internal.PageRepairCommandArguments{Location: pageRepairLocation,
+		TargetLsn: 0x7000000, BackupName: pageRepairBackupName})
+	assert.Error(t, err)
+}
+
+func TestRepairPage_DeltaBackup(t *testing.T) {
+	folder := makePageRepairFolder(t)
+	pageSize := uint64(internal.DatabasePageSize)
+	increment := makeIncrement(4*pageSize, map[uint32][]byte{3: makeBackupPage(0x33)}, []uint32{3})
+	sentinel := makeIncrementalSentinel(internal.BackupFileList{
+		pageRepairRelFile:        {IsIncremented: true, Size: int64(len(increment)), TarPart: "part_1.tar"},
+		pageRepairSkippedRelFile: {IsSkipped: true},
+	})
+	startLsn, finishLsn, from := uint64(0x7000000), uint64(0x7000028), pageRepairBackupName
+	sentinel.BackupStartLSN, sentinel.BackupFinishLSN, sentinel.IncrementFrom = &startLsn, &finishLsn, &from
+	putPageRepairBackup(t, folder, pageRepairDeltaBackupName, sentinel,
+		makeTarPart(t, map[string][]byte{pageRepairRelFile: increment}))
+
+	location := pageRepairLocation
+	for blockNo, fill := range map[uint32]byte{3: 0x33, 0: 1} {
+		location.BlockNo = blockNo
+		result, err := internal.RepairPage(folder, internal.PageRepairCommandArguments{Location: location,
+			TargetLsn: 0x8000000, BackupName: pageRepairDeltaBackupName})
+		assert.NoError(t, err)
+		assert.Equal(t, pageRepairDeltaBackupName, result.BackupName)
+		assert.Equal(t, makeBackupPage(fill), result.Page)
+	}
+
+	location = walparser.BlockLocation{RelationFileNode: walparser.RelFileNode{SpcNode: 1663, DBNode: 16384, RelNode: 16400}, BlockNo: 2}
+	result, err := internal.RepairPage(folder, internal.PageRepairCommandArguments{Location: location,
+		TargetLsn: 0x8000000, BackupName: pageRepairDeltaBackupName})
+	assert.NoError(t, err)
+	assert.Equal(t, makeBackupPage(3), result.Page)
+}
+
+func TestParsePageRepairArguments(t *testing.T) {
+	arguments, err := internal.ParsePageRepairArguments([]string{internal.PageRepairRelFileNodeFlag, "1663/16384/16397",
+		internal.PageRepairBlockFlag, "5", internal.PageRepairTargetLsnFlag, "0/7000060"})
+	assert.NoError(t, err)
+	location := pageRepairLocation
+	location.BlockNo = 5
+	assert.Equal(t, internal.PageRepairCommandArguments{Location: location, TargetLsn: 0x7000060,
+		Output: "1663_16384_16397_5.page"}, arguments)
+
+	arguments, err = internal.ParsePageRepairArguments([]string{internal.PageRepairJsonFlag, internal.PageRepairBackupFlag, "LATEST",
+		internal.PageRepairOutputFlag, "/tmp/page", internal.PageRepairRelFileNodeFlag, "1663/16384/16397",
+		internal.PageRepairBlockFlag, "5", internal.PageRepairTargetLsnFlag, "0/7000060"})
+	assert.NoError(t, err)
+	assert.Equal(t, internal.PageRepairCommandArguments{Location: location, TargetLsn: 0x7000060,
+		BackupName: "LATEST", Output: "/tmp/page", Json: true}, arguments)
+
+	_, err = internal.ParsePageRepairArguments([]string{internal.PageRepairRelFileNodeFlag, "1663/16384",
+		internal.PageRepairBlockFlag, "5", internal.PageRepairTargetLsnFlag, "0/7000060"})
+	assert.Error(t, err)
+	_, err = internal.ParsePageRepairArguments([]string{internal.PageRepairRelFileNodeFlag, "1663/16384/16397",
+		internal.PageRepairTargetLsnFlag, "0/7000060"})
+	assert.Error(t, err)
+	_, err = internal.ParsePageRepairArguments([]string{internal.PageRepairRelFileNodeFlag, "1663/16384/16397",
+		internal.PageRepairBlockFlag})
+	assert.Error(t, err)
+}
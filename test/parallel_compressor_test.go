@@ -0,0 +1,73 @@
+package test
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"github.com/x4m/wal-g/internal"
+	"io"
+	"testing"
+)
+
+func setParallelCompressionBlockSize(blockSize int) func() {
+	oldBlockSize := internal.ParallelCompressionBlockSize
+	internal.ParallelCompressionBlockSize = blockSize
+	return func() {
+		internal.ParallelCompressionBlockSize = oldBlockSize
+	}
+}
+
+func TestParallelCompression(t *testing.T) {
+	defer setParallelCompressionBlockSize(64 << 10)()
+	for _, dataSize := range []int64{1000, 64 << 10, 1 << 20, 1<<20 + 1000} {
+		for _, method := range internal.CompressingAlgorithms {
+			var testData bytes.Buffer
+			_, err := testData.ReadFrom(io.LimitReader(NewBiasedRandomReader(), dataSize))
+			assert.NoError(t, err)
+			compressor := internal.NewParallelCompressor(internal.Compressors[method], 4)
+			testCompressor(compressor, testData, t)
+		}
+	}
+}
+
+func TestParallelCompression_Write(t *testing.T) {
+	defer setParallelCompressionBlockSize(1 << 10)()
+	data := make([]byte, 0, 100<<10)
+	for i := 0; len(data) < cap(data); i++ {
+		data = append(data, []byte("block parallel compression ")...)
+		data = append(data, byte(i))
+	}
+	for _, method := range internal.CompressingAlgorithms {
+		var compressed bytes.Buffer
+		writer := internal.NewParallelCompressor(internal.Compressors[method], 3).NewWriter(&compressed)
+		for i := 0; i < len(data); i += 777 {
+			_, err := writer.Write(data[i:min(i+777, len(data))])
+			assert.NoError(t, err)
+		}
+		assert.NoError(t, writer.Close())
+
+		var decompressed bytes.Buffer
+		err := FindDecompressor(internal.Compressors[method].FileExtension()).Decompress(&decompressed, &compressed)
+		assert.NoError(t, err)
+		assert.Equal(t, data, decompressed.Bytes())
+	}
+}
+
+// empty tar part is decompressible too
+func TestParallelCompression_Empty(t *testing.T) {
+	for _, method := range internal.CompressingAlgorithms {
+		var compressed bytes.Buffer
+		writer := internal.NewParallelCompressor(internal.Compressors[method], 2).NewWriter(&compressed)
+		assert.NoError(t, writer.Close())
+		assert.NotZero(t, compressed.Len())
+
+		var decompressed bytes.Buffer
+		err := FindDecompressor(internal.Compressors[method].FileExtension()).Decompress(&decompressed, &compressed)
+		assert.NoError(t, err)
+		assert.Zero(t, decompressed.Len())
+	}
+}
+
+func TestNewParallelCompressor_SingleGoroutine(t *testing.T) {
+	compressor := internal.NewParallelCompressor(internal.Compressors[internal.ZstdAlgorithmName], 1)
+	assert.Equal(t, internal.Compressors[internal.ZstdAlgorithmName], compressor)
+}
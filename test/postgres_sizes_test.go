@@ -0,0 +1,105 @@
+package test
+
+import (
+	"encoding/binary"
+	"github.com/stretchr/testify/assert"
+	"github.com/x4m/wal-g/internal"
+	"github.com/x4m/wal-g/internal/walparser"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func restoreDefaultPostgresSizes(t *testing.T) {
+	err := internal.SetPostgresSizes(internal.PostgresSizes{
+		WalSegmentSize: internal.DefaultWalSegmentSize,
+		WalPageSize:    walparser.DefaultWalPageSize,
+		BlockSize:      uint32(internal.DefaultDatabasePageSize),
+	})
+	assert.NoError(t, err)
+}
+
+func TestSetPostgresSizes_WalFileNames(t *testing.T) {
+	defer restoreDefaultPostgresSizes(t)
+	err := internal.SetPostgresSizes(internal.PostgresSizes{WalSegmentSize: 64 * 1024 * 1024})
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(64*1024*1024), internal.WalSegmentSize)
+
+	// There are 64 segments of 64MB in 4GB
+	nextName, err := internal.GetNextWalFilename("00000001000000000000003F")
+	assert.NoError(t, err)
+	assert.Equal(t, "000000010000000100000000", nextName)
+
+	_, err = internal.GetNextWalFilename("000000010000000000000040")
+	assert.Error(t, err)
+}
+
+func TestSetPostgresSizes_BlockSizes(t *testing.T) {
+	defer restoreDefaultPostgresSizes(t)
+	err := internal.SetPostgresSizes(internal.PostgresSizes{WalPageSize: 16384, BlockSize: 32768})
+	assert.NoError(t, err)
+	assert.Equal(t, uint16(32768), internal.DatabasePageSize)
+	assert.Equal(t, uint32(16384), walparser.WalPageSize)
+	assert.Equal(t, uint16(32768), walparser.BlockSize)
+	assert.Equal(t, internal.DefaultWalSegmentSize, internal.WalSegmentSize)
+}
+
+func TestSetPostgresSizes_RejectsInvalidSizes(t *testing.T) {
+	defer restoreDefaultPostgresSizes(t)
+	err := internal.SetPostgresSizes(internal.PostgresSizes{WalSegmentSize: 3 * 1024 * 1024})
+	assert.IsType(t, internal.InvalidPostgresSizeError{}, err)
+	err = internal.SetPostgresSizes(internal.PostgresSizes{WalSegmentSize: 2 * 1024 * 1024 * 1024})
+	assert.IsType(t, internal.InvalidPostgresSizeError{}, err)
+	err = internal.SetPostgresSizes(internal.PostgresSizes{BlockSize: 65536})
+	assert.IsType(t, internal.InvalidPostgresSizeError{}, err)
+	assert.Equal(t, internal.DefaultWalSegmentSize, internal.WalSegmentSize)
+	assert.Equal(t, internal.DefaultDatabasePageSize, internal.DatabasePageSize)
+}
+
+func TestReadPostgresSizesFromPgControl(t *testing.T) {
+	dataDirectory, err := ioutil.TempDir("", "pgdata")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dataDirectory)
+	assert.NoError(t, os.Mkdir(filepath.Join(dataDirectory, "global"), 0700))
+
+	// floatFormat is placed at some aligned offset, followed by blcksz, relseg_size, xlog_blcksz and xlog_seg_size
+	pgControl := make([]byte, 296)
+	binary.LittleEndian.PutUint64(pgControl[160:], math.Float64bits(1234567.0))
+	binary.LittleEndian.PutUint32(pgControl[168:], 16384)
+	binary.LittleEndian.PutUint32(pgControl[172:], 65536)
+	binary.LittleEndian.PutUint32(pgControl[176:], 4096)
+	binary.LittleEndian.PutUint32(pgControl[180:], 128*1024*1024)
+	err = ioutil.WriteFile(filepath.Join(dataDirectory, "global", internal.PgControl), pgControl, 0600)
+	assert.NoError(t, err)
+
+	sizes, err := internal.ReadPostgresSizesFromPgControl(dataDirectory)
+	assert.NoError(t, err)
+	assert.Equal(t, internal.PostgresSizes{WalSegmentSize: 128 * 1024 * 1024, WalPageSize: 4096, BlockSize: 16384}, sizes)
+
+	err = ioutil.WriteFile(filepath.Join(dataDirectory, "global", internal.PgControl), make([]byte, 296), 0600)
+	assert.NoError(t, err)
+	_, err = internal.ReadPostgresSizesFromPgControl(dataDirectory)
+	assert.IsType(t, internal.PgControlFormatError{}, err)
+}
+
+func TestReadPostgresSizesFromWalFile(t *testing.T) {
+	walDirectory, err := ioutil.TempDir("", "pg_wal")
+	assert.NoError(t, err)
+	defer os.RemoveAll(walDirectory)
+
+	header := make([]byte, walparser.XLogLongPageHeaderSize)
+	binary.LittleEndian.PutUint16(header[0:], 0xD101)
+	binary.LittleEndian.PutUint16(header[2:], walparser.XlpLongHeader)
+	binary.LittleEndian.PutUint32(header[4:], 1)
+	binary.LittleEndian.PutUint32(header[32:], 32*1024*1024)
+	binary.LittleEndian.PutUint32(header[36:], 16384)
+	walFilePath := filepath.Join(walDirectory, "000000010000000000000001")
+	err = ioutil.WriteFile(walFilePath, header, 0600)
+	assert.NoError(t, err)
+
+	sizes, err := internal.ReadPostgresSizesFromWalFile(walFilePath)
+	assert.NoError(t, err)
+	assert.Equal(t, internal.PostgresSizes{WalSegmentSize: 32 * 1024 * 1024, WalPageSize: 16384}, sizes)
+}
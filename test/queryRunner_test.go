@@ -28,6 +28,20 @@ func TestBuildStartBackup(t *testing.T) {
 	queryBuilder.Version = 100000
 	queryString, err = queryBuilder.BuildStartBackup()
 	assert.Equal(t, "SELECT case when pg_is_in_recovery() then '' else (pg_walfile_name_offset(lsn)).file_name end, lsn::text, pg_is_in_recovery() FROM pg_start_backup($1, true, false) lsn", queryString)
+
+	queryBuilder.Version = 140005
+	queryString, err = queryBuilder.BuildStartBackup()
+	assert.Equal(t, "SELECT case when pg_is_in_recovery() then '' else (pg_walfile_name_offset(lsn)).file_name end, lsn::text, pg_is_in_recovery() FROM pg_start_backup($1, true, false) lsn", queryString)
+
+	queryBuilder.Version = 150000
+	queryString, err = queryBuilder.BuildStartBackup()
+	assert.NoError(t, err)
+	assert.Equal(t, "SELECT case when pg_is_in_recovery() then '' else (pg_walfile_name_offset(lsn)).file_name end, lsn::text, pg_is_in_recovery() FROM pg_backup_start($1, true) lsn", queryString)
+
+	queryBuilder.Version = 170002
+	queryString, err = queryBuilder.BuildStartBackup()
+	assert.NoError(t, err)
+	assert.Equal(t, "SELECT case when pg_is_in_recovery() then '' else (pg_walfile_name_offset(lsn)).file_name end, lsn::text, pg_is_in_recovery() FROM pg_backup_start($1, true) lsn", queryString)
 }
 
 // Tests building stop backup query
@@ -51,4 +65,45 @@ func TestBuildStopBackup(t *testing.T) {
 	queryBuilder.Version = 100000
 	queryString, err = queryBuilder.BuildStopBackup()
 	assert.Equal(t, "SELECT labelfile, spcmapfile, lsn FROM pg_stop_backup(false)", queryString)
+
+	queryBuilder.Version = 150000
+	queryString, err = queryBuilder.BuildStopBackup()
+	assert.NoError(t, err)
+	assert.Equal(t, "SELECT labelfile, spcmapfile, lsn FROM pg_backup_stop()", queryString)
+
+	queryBuilder.Version = 160001
+	queryString, err = queryBuilder.BuildStopBackup()
+	assert.NoError(t, err)
+	assert.Equal(t, "SELECT labelfile, spcmapfile, lsn FROM pg_backup_stop()", queryString)
+}
+
+// Tests building query which keeps backup session alive
+func TestBuildDisableSessionTimeouts(t *testing.T) {
+	queryBuilder := &internal.PgQueryRunner{Version: 90600}
+	assert.Equal(t, "", queryBuilder.BuildDisableSessionTimeouts())
+
+	queryBuilder.Version = 130004
+	assert.Equal(t, "", queryBuilder.BuildDisableSessionTimeouts())
+
+	queryBuilder.Version = 140000
+	assert.Equal(t, "SET idle_session_timeout = 0", queryBuilder.BuildDisableSessionTimeouts())
+
+	queryBuilder.Version = 150000
+	assert.Equal(t, "SET idle_session_timeout = 0", queryBuilder.BuildDisableSessionTimeouts())
+}
+
+// Tests that recovery configuration files are not backed up
+func TestRecoveryFilesAreExcluded(t *testing.T) {
+	for _, filename := range []string{"recovery.conf", "recovery.signal", "standby.signal"} {
+		_, excluded := internal.ExcludedFilenames[filename]
+		assert.True(t, excluded, filename)
+	}
+}
+
+// Tests building query which reads names of relations for backup sentinel
+func TestBuildGetRelationNames(t *testing.T) {
+	queryBuilder := &internal.PgQueryRunner{Version: 90600}
+	assert.Equal(t, "SELECT pg_relation_filepath(c.oid), n.nspname || '.' || c.relname FROM pg_class c "+
+		"JOIN pg_namespace n ON n.oid = c.relnamespace WHERE pg_relation_filepath(c.oid) IS NOT NULL",
+		queryBuilder.BuildGetRelationNames())
 }
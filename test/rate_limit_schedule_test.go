@@ -0,0 +1,62 @@
+package test
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/x4m/wal-g/internal"
+	"golang.org/x/time/rate"
+	"testing"
+	"time"
+)
+
+func TestParseRateLimitSchedule(t *testing.T) {
+	windows, err := internal.ParseRateLimitSchedule("09:00-18:00=1048576, 22:30-06:00=2048")
+	assert.NoError(t, err)
+	assert.Equal(t, []internal.RateLimitWindow{
+		{From: 9 * time.Hour, To: 18 * time.Hour, Limit: 1048576},
+		{From: 22*time.Hour + 30*time.Minute, To: 6 * time.Hour, Limit: 2048},
+	}, windows)
+}
+
+func TestParseRateLimitSchedule_Invalid(t *testing.T) {
+	for _, schedule := range []string{"", "09:00-18:00", "09:00=100", "9am-18:00=100", "09:00-18:00=-1", "09:00-25:00=100"} {
+		_, err := internal.ParseRateLimitSchedule(schedule)
+		assert.IsType(t, internal.InvalidRateLimitScheduleError{}, err, schedule)
+	}
+}
+
+func TestRateLimitSchedule_LimitAt(t *testing.T) {
+	windows, err := internal.ParseRateLimitSchedule("09:00-18:00=1000,22:00-06:00=5000")
+	assert.NoError(t, err)
+	schedule := &internal.RateLimitSchedule{DefaultLimit: rate.Inf, Windows: windows}
+	at := func(hour, minute int) time.Time {
+		return time.Date(2018, 10, 1, hour, minute, 0, 0, time.Local)
+	}
+
+	assert.Equal(t, rate.Limit(1000), schedule.LimitAt(at(9, 0)))
+	assert.Equal(t, rate.Limit(1000), schedule.LimitAt(at(17, 59)))
+	assert.Equal(t, rate.Inf, schedule.LimitAt(at(18, 0)))
+	assert.Equal(t, rate.Limit(5000), schedule.LimitAt(at(23, 0)))
+	assert.Equal(t, rate.Limit(5000), schedule.LimitAt(at(3, 0)))
+	assert.Equal(t, rate.Inf, schedule.LimitAt(at(6, 0)))
+}
+
+func TestRateLimitSchedule_LimiterAt(t *testing.T) {
+	windows, err := internal.ParseRateLimitSchedule("09:00-18:00=1000,22:00-06:00=500000")
+	assert.NoError(t, err)
+	schedule := &internal.RateLimitSchedule{DefaultLimit: rate.Inf, Windows: windows}
+	at := func(hour, minute int) time.Time {
+		return time.Date(2018, 10, 1, hour, minute, 0, 0, time.Local)
+	}
+
+	dayLimiter := schedule.LimiterAt(at(9, 0))
+	assert.Equal(t, rate.Limit(1000), dayLimiter.Limit())
+	// burst follows the limit of active window, not the largest limit of schedule
+	assert.Equal(t, 1000+int(internal.DefaultDataBurstRateLimit), dayLimiter.Burst())
+	assert.True(t, dayLimiter == schedule.LimiterAt(at(12, 0)))
+
+	nightLimiter := schedule.LimiterAt(at(23, 0))
+	assert.Equal(t, rate.Limit(500000), nightLimiter.Limit())
+	assert.Equal(t, 500000+int(internal.DefaultDataBurstRateLimit), nightLimiter.Burst())
+
+	assert.Equal(t, rate.Inf, schedule.LimiterAt(at(7, 0)).Limit())
+}
@@ -0,0 +1,128 @@
+package test
+
+import (
+	"archive/tar"
+	"bytes"
+	"encoding/binary"
+	"github.com/stretchr/testify/assert"
+	"github.com/x4m/wal-g/internal"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+)
+
+const reverseUnpackPagedFile = "base/1/100"
+const reverseUnpackSkippedFile = "base/1/200"
+
+func makePage(fill byte) []byte {
+	return bytes.Repeat([]byte{fill}, int(internal.DatabasePageSize))
+}
+
+func makeIncrement(fileSize uint64, pages map[uint32][]byte, blockNumbers []uint32) []byte {
+	var increment bytes.Buffer
+	increment.Write([]byte{'w', 'i', '1', internal.SignatureMagicNumber})
+	binary.Write(&increment, binary.LittleEndian, fileSize)
+	binary.Write(&increment, binary.LittleEndian, uint32(len(blockNumbers)))
+	for _, blockNo := range blockNumbers {
+		binary.Write(&increment, binary.LittleEndian, blockNo)
+	}
+	for _, blockNo := range blockNumbers {
+		increment.Write(pages[blockNo])
+	}
+	return increment.Bytes()
+}
+
+func makeIncrementalSentinel(files internal.BackupFileList) internal.BackupSentinelDto {
+	lsn := uint64(1)
+	from := "base_000"
+	count := 1
+	return internal.BackupSentinelDto{
+		IncrementFrom:     &from,
+		IncrementFromLSN:  &lsn,
+		IncrementFullName: &from,
+		IncrementCount:    &count,
+		Files:             files,
+	}
+}
+
+func interpretFile(t *testing.T, tarInterpreter internal.TarInterpreter, name string, content []byte) {
+	header := &tar.Header{Name: name, Typeflag: tar.TypeReg, Size: int64(len(content)), Mode: 0600}
+	err := tarInterpreter.Interpret(bytes.NewReader(content), header)
+	assert.NoError(t, err)
+}
+
+func TestReverseDeltaUnpack(t *testing.T) {
+	dbDataDirectory, err := ioutil.TempDir("", "reverse_unpack")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dbDataDirectory)
+
+	pageSize := uint64(internal.DatabasePageSize)
+	incrementedFiles := internal.BackupFileList{
+		reverseUnpackPagedFile:   {IsIncremented: true},
+		reverseUnpackSkippedFile: {IsSkipped: true},
+	}
+	restoredFiles := internal.NewRestoredPagedFiles()
+
+	// the newest backup extends the file and changes blocks 1 and 3
+	newestSentinel := makeIncrementalSentinel(incrementedFiles)
+	newestFilesToUnwrap := internal.GetRestoredBackupFilesToUnwrap(newestSentinel)
+	tarInterpreter := internal.NewReverseDeltaTarInterpreter(dbDataDirectory, newestSentinel, newestFilesToUnwrap, restoredFiles, nil)
+	interpretFile(t, tarInterpreter, reverseUnpackPagedFile,
+		makeIncrement(4*pageSize, map[uint32][]byte{1: makePage('C'), 3: makePage('C')}, []uint32{1, 3}))
+	assert.Equal(t, uint64(2), restoredFiles.RestoredBlockCount(reverseUnpackPagedFile))
+
+	// intermediate backup changes blocks 1 and 2
+	middleSentinel := makeIncrementalSentinel(incrementedFiles)
+	middleFilesToUnwrap, err := internal.GetBaseFilesToUnwrap(newestSentinel.Files, newestFilesToUnwrap)
+	assert.NoError(t, err)
+	tarInterpreter = internal.NewReverseDeltaTarInterpreter(dbDataDirectory, middleSentinel, middleFilesToUnwrap, restoredFiles, nil)
+	interpretFile(t, tarInterpreter, reverseUnpackPagedFile,
+		makeIncrement(3*pageSize, map[uint32][]byte{1: makePage('B'), 2: makePage('B')}, []uint32{1, 2}))
+	assert.Equal(t, uint64(3), restoredFiles.RestoredBlockCount(reverseUnpackPagedFile))
+
+	// full base backup
+	baseSentinel := internal.BackupSentinelDto{Files: internal.BackupFileList{
+		reverseUnpackPagedFile:   {},
+		reverseUnpackSkippedFile: {},
+	}}
+	baseFilesToUnwrap, err := internal.GetBaseFilesToUnwrap(middleSentinel.Files, middleFilesToUnwrap)
+	assert.NoError(t, err)
+	tarInterpreter = internal.NewReverseDeltaTarInterpreter(dbDataDirectory, baseSentinel, baseFilesToUnwrap, restoredFiles, nil)
+	interpretFile(t, tarInterpreter, reverseUnpackPagedFile, bytes.Join([][]byte{makePage('A'), makePage('A'), makePage('A')}, nil))
+	interpretFile(t, tarInterpreter, reverseUnpackSkippedFile, makePage('S'))
+	assert.Equal(t, uint64(4), restoredFiles.RestoredBlockCount(reverseUnpackPagedFile))
+
+	restored, err := ioutil.ReadFile(path.Join(dbDataDirectory, reverseUnpackPagedFile))
+	assert.NoError(t, err)
+	expected := bytes.Join([][]byte{makePage('A'), makePage('C'), makePage('B'), makePage('C')}, nil)
+	assert.Equal(t, expected, restored)
+
+	skipped, err := ioutil.ReadFile(path.Join(dbDataDirectory, reverseUnpackSkippedFile))
+	assert.NoError(t, err)
+	assert.Equal(t, makePage('S'), skipped)
+}
+
+func TestReverseDeltaUnpack_TruncatesToNewestSize(t *testing.T) {
+	dbDataDirectory, err := ioutil.TempDir("", "reverse_unpack")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dbDataDirectory)
+
+	pageSize := uint64(internal.DatabasePageSize)
+	restoredFiles := internal.NewRestoredPagedFiles()
+	newestSentinel := makeIncrementalSentinel(internal.BackupFileList{reverseUnpackPagedFile: {IsIncremented: true}})
+	filesToUnwrap := internal.GetRestoredBackupFilesToUnwrap(newestSentinel)
+	tarInterpreter := internal.NewReverseDeltaTarInterpreter(dbDataDirectory, newestSentinel, filesToUnwrap, restoredFiles, nil)
+	interpretFile(t, tarInterpreter, reverseUnpackPagedFile,
+		makeIncrement(pageSize, map[uint32][]byte{0: makePage('B')}, []uint32{0}))
+
+	baseSentinel := internal.BackupSentinelDto{Files: internal.BackupFileList{reverseUnpackPagedFile: {}}}
+	baseFilesToUnwrap, err := internal.GetBaseFilesToUnwrap(newestSentinel.Files, filesToUnwrap)
+	assert.NoError(t, err)
+	tarInterpreter = internal.NewReverseDeltaTarInterpreter(dbDataDirectory, baseSentinel, baseFilesToUnwrap, restoredFiles, nil)
+	interpretFile(t, tarInterpreter, reverseUnpackPagedFile, bytes.Join([][]byte{makePage('A'), makePage('A')}, nil))
+
+	restored, err := ioutil.ReadFile(path.Join(dbDataDirectory, reverseUnpackPagedFile))
+	assert.NoError(t, err)
+	assert.Equal(t, makePage('B'), restored)
+}
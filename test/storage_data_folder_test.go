@@ -0,0 +1,165 @@
+package test
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"github.com/x4m/wal-g/internal"
+	"github.com/x4m/wal-g/internal/walparser"
+	"github.com/x4m/wal-g/testtools"
+	"io/ioutil"
+	"testing"
+	"time"
+)
+
+func init() {
+	internal.DataFolderLockSettleDelay = 0
+}
+
+func makeStorageDataFolder(storage *testtools.InMemoryStorage, holder string) *internal.StorageDataFolder {
+	folder := testtools.NewInMemoryStorageFolder("in_memory/", storage).GetSubFolder(internal.DeltaDataPath)
+	return internal.NewStorageDataFolder(folder, holder)
+}
+
+func TestStorageDataFolder_ReadWrite(t *testing.T) {
+	storage := testtools.NewInMemoryStorage()
+	dataFolder := makeStorageDataFolder(storage, "host-a")
+
+	_, err := dataFolder.OpenReadonlyFile("file")
+	assert.IsType(t, internal.NoSuchFileError{}, err)
+
+	file, err := dataFolder.OpenWriteOnlyFile("file")
+	assert.NoError(t, err)
+	_, err = file.Write([]byte{1, 2, 3})
+	assert.NoError(t, err)
+	assert.NoError(t, file.Close())
+
+	reader, err := dataFolder.OpenReadonlyFile("file")
+	assert.NoError(t, err)
+	data, err := ioutil.ReadAll(reader)
+	assert.NoError(t, err)
+	assert.Equal(t, []byte{1, 2, 3}, data)
+
+	assert.NoError(t, dataFolder.CleanFolder())
+	_, err = dataFolder.OpenReadonlyFile("file")
+	assert.IsType(t, internal.NoSuchFileError{}, err)
+	_, exists := storage.Load("in_memory/" + internal.DeltaDataPath + internal.DataFolderLockFilename)
+	assert.True(t, exists)
+
+	assert.NoError(t, dataFolder.Unlock())
+	_, exists = storage.Load("in_memory/" + internal.DeltaDataPath + internal.DataFolderLockFilename)
+	assert.False(t, exists)
+}
+
+func TestStorageDataFolder_Locked(t *testing.T) {
+	storage := testtools.NewInMemoryStorage()
+	dataFolder := makeStorageDataFolder(storage, "host-a")
+	_, err := dataFolder.OpenReadonlyFile("file")
+	assert.IsType(t, internal.NoSuchFileError{}, err)
+
+	otherDataFolder := makeStorageDataFolder(storage, "host-b")
+	_, err = otherDataFolder.OpenReadonlyFile("file")
+	assert.IsType(t, internal.DataFolderLockedError{}, err)
+	_, err = otherDataFolder.OpenWriteOnlyFile("file")
+	assert.IsType(t, internal.DataFolderLockedError{}, err)
+	assert.IsType(t, internal.DataFolderLockedError{}, otherDataFolder.CleanFolder())
+	// folder, which is not locked, does not remove lock of other host
+	assert.NoError(t, otherDataFolder.Unlock())
+
+	assert.NoError(t, dataFolder.Unlock())
+	_, err = otherDataFolder.OpenReadonlyFile("file")
+	assert.IsType(t, internal.NoSuchFileError{}, err)
+}
+
+func TestStorageDataFolder_ExpiredLock(t *testing.T) {
+	storage := testtools.NewInMemoryStorage()
+	lockData, err := json.Marshal(map[string]interface{}{
+		"holder":     "host-a",
+		"expires_at": time.Now().Add(-time.Minute),
+	})
+	assert.NoError(t, err)
+	storage.Store("in_memory/"+internal.DeltaDataPath+internal.DataFolderLockFilename, *bytes.NewBuffer(lockData))
+
+	dataFolder := makeStorageDataFolder(storage, "host-b")
+	_, err = dataFolder.OpenReadonlyFile("file")
+	assert.IsType(t, internal.NoSuchFileError{}, err)
+}
+
+// The next wal-push of the host takes over the lease left by the previous one without settle delay
+func TestStorageDataFolder_OwnLease(t *testing.T) {
+	storage := testtools.NewInMemoryStorage()
+	_, err := makeStorageDataFolder(storage, "host-a").OpenReadonlyFile("file")
+	assert.IsType(t, internal.NoSuchFileError{}, err)
+
+	internal.DataFolderLockSettleDelay = 5 * time.Second
+	defer func() { internal.DataFolderLockSettleDelay = 0 }()
+	start := time.Now()
+	_, err = makeStorageDataFolder(storage, "host-a").OpenReadonlyFile("file")
+	assert.IsType(t, internal.NoSuchFileError{}, err)
+	assert.True(t, time.Since(start) < time.Second)
+}
+
+// Archiving moves to another host in the middle of delta files, the next host completes them with stored state
+func TestStorageDataFolder_ResumeOnAnotherHost(t *testing.T) {
+	const spanningRecordTestPath = "../internal/walparser/testdata/segment_spanning_record_"
+	storage := testtools.NewInMemoryStorage()
+	uploader := testtools.NewStoringMockUploader(storage, nil)
+
+	firstDataFolder := makeStorageDataFolder(storage, "host-a")
+	manager := internal.NewDeltaFileManager(firstDataFolder)
+	recordWalFile(t, manager, spanningRecordTestPath+"1", "00000001000000000000006E")
+	recordWalFile(t, manager, spanningRecordTestPath+"2", "00000001000000000000006F")
+	partFile, err := manager.GetPartFile("000000010000000000000060_delta")
+	assert.NoError(t, err)
+	partFile.PreviousWalHead = make([]byte, 0)
+	for i := 0; i < 14; i++ {
+		partFile.WalTails[i] = make([]byte, 0)
+		partFile.WalHeads[i] = make([]byte, 0)
+	}
+	manager.FlushFiles(uploader)
+	assert.ElementsMatch(t, []walparser.BlockLocation{
+		*walparser.NewBlockLocation(internal.DefaultSpcNode, 16384, 16397, 3),
+		*walparser.NewBlockLocation(internal.DefaultSpcNode, 16384, 16401, 0),
+	}, loadUploadedDeltaLocations(t, storage, "000000010000000000000060_delta"))
+	// the lease is kept after flush until the first host stops archiving
+	_, err = makeStorageDataFolder(storage, "host-b").OpenReadonlyFile("file")
+	assert.IsType(t, internal.DataFolderLockedError{}, err)
+	assert.NoError(t, firstDataFolder.Unlock())
+
+	manager = internal.NewDeltaFileManager(makeStorageDataFolder(storage, "host-b"))
+	recordWalFile(t, manager, spanningRecordTestPath+"3", "000000010000000000000070")
+	nextPartFile, err := manager.GetPartFile("000000010000000000000070_delta")
+	assert.NoError(t, err)
+	// head of the record, which continues from previous delta, is recorded by the first host
+	assert.NotNil(t, nextPartFile.PreviousWalHead)
+	for i := 1; i < int(internal.WalFileInDelta); i++ {
+		nextPartFile.WalTails[i] = make([]byte, 0)
+		nextPartFile.WalHeads[i] = make([]byte, 0)
+	}
+	manager.FlushFiles(uploader)
+	assert.ElementsMatch(t, []walparser.BlockLocation{
+		*walparser.NewBlockLocation(internal.DefaultSpcNode, 16384, 16402, 0),
+		*walparser.NewBlockLocation(internal.DefaultSpcNode, 16384, 16402, 1),
+		*walparser.NewBlockLocation(internal.DefaultSpcNode, 16384, 16402, 2),
+		*walparser.NewBlockLocation(internal.DefaultSpcNode, 16384, 16402, 3),
+		*walparser.NewBlockLocation(internal.DefaultSpcNode, 16384, 16397, 4),
+	}, loadUploadedDeltaLocations(t, storage, "000000010000000000000070_delta"))
+	_, exists := storage.Load("in_memory/" + internal.DeltaDataPath + internal.DataFolderLockFilename)
+	assert.True(t, exists)
+}
+
+// Consecutive wal-pushes of the host keep the lease, so only the first one waits for settle delay
+func TestStorageDataFolder_LeaseKeptBetweenFlushes(t *testing.T) {
+	storage := testtools.NewInMemoryStorage()
+	uploader := testtools.NewStoringMockUploader(storage, nil)
+	internal.DataFolderLockSettleDelay = 300 * time.Millisecond
+	defer func() { internal.DataFolderLockSettleDelay = 0 }()
+
+	internal.NewDeltaFileManager(makeStorageDataFolder(storage, "host-a")).FlushFiles(uploader)
+	_, exists := storage.Load("in_memory/" + internal.DeltaDataPath + internal.DataFolderLockFilename)
+	assert.True(t, exists)
+
+	start := time.Now()
+	internal.NewDeltaFileManager(makeStorageDataFolder(storage, "host-a")).FlushFiles(uploader)
+	assert.True(t, time.Since(start) < internal.DataFolderLockSettleDelay)
+}
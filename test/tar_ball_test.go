@@ -117,3 +117,29 @@ func TestPackFileTo(t *testing.T) {
 	}
 	assert.Equal(t, []byte(mockData), interpreter.Out)
 }
+
+func TestStorageTarBallFinish_RecordsTarParts(t *testing.T) {
+	storage := testtools.NewInMemoryStorage()
+	uploader := testtools.NewStoringMockUploader(storage, nil)
+	maker := internal.NewStorageTarBallMaker("mockBackup", uploader)
+
+	tarBall := maker.Make(true)
+	tarBall.SetUp(MockDisarmedCrypter())
+	assert.NoError(t, tarBall.CloseTar())
+	tarBall.AwaitUploads()
+
+	lastTarBall := maker.Make(false)
+	lastTarBall.SetUp(MockDisarmedCrypter(), "backup_label.tar.mock")
+	assert.NoError(t, lastTarBall.CloseTar())
+
+	sentinelDto := &internal.BackupSentinelDto{}
+	err := lastTarBall.Finish(sentinelDto)
+	assert.NoError(t, err)
+	assert.Len(t, sentinelDto.TarParts, 2)
+	for name, description := range sentinelDto.TarParts {
+		data, exists := storage.Load("in_memory/mockBackup/tar_partitions/" + name)
+		assert.True(t, exists)
+		assert.Equal(t, int64(data.Data.Len()), description.Size)
+		assert.NotEmpty(t, description.MD5)
+	}
+}
@@ -0,0 +1,67 @@
+package test
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/hex"
+	"github.com/stretchr/testify/assert"
+	"github.com/x4m/wal-g/internal"
+	"io/ioutil"
+	"testing"
+)
+
+var tarPartContent = []byte("some tar part content")
+
+func tarPartDescription(content []byte) internal.TarPartDescription {
+	sum := md5.Sum(content)
+	return internal.TarPartDescription{Size: int64(len(content)), MD5: hex.EncodeToString(sum[:])}
+}
+
+func readCheckedTarPart(t *testing.T, description internal.TarPartDescription) error {
+	readerMaker := internal.NewTarPartCheckingReaderMaker(&BufferReaderMaker{bytes.NewBuffer(tarPartContent), "part_001.tar.lz4"}, description)
+	assert.Equal(t, "part_001.tar.lz4", readerMaker.Path())
+	reader, err := readerMaker.Reader()
+	assert.NoError(t, err)
+	_, err = ioutil.ReadAll(reader)
+	reader.Close()
+	return err
+}
+
+func TestTarPartCheckingReader_Valid(t *testing.T) {
+	err := readCheckedTarPart(t, tarPartDescription(tarPartContent))
+	assert.NoError(t, err)
+}
+
+func TestTarPartCheckingReader_WithoutMD5(t *testing.T) {
+	err := readCheckedTarPart(t, internal.TarPartDescription{Size: int64(len(tarPartContent))})
+	assert.NoError(t, err)
+}
+
+func TestTarPartCheckingReader_Truncated(t *testing.T) {
+	description := tarPartDescription(append(tarPartContent, 'x'))
+	err := readCheckedTarPart(t, description)
+	assert.IsType(t, internal.TarPartCorruptedError{}, err)
+}
+
+func TestTarPartCheckingReader_TooLong(t *testing.T) {
+	description := tarPartDescription(tarPartContent[:5])
+	err := readCheckedTarPart(t, description)
+	assert.IsType(t, internal.TarPartCorruptedError{}, err)
+}
+
+func TestTarPartCheckingReader_WrongMD5(t *testing.T) {
+	description := tarPartDescription(tarPartContent)
+	description.MD5 = "d41d8cd98f00b204e9800998ecf8427e"
+	err := readCheckedTarPart(t, description)
+	assert.IsType(t, internal.TarPartCorruptedError{}, err)
+}
+
+func TestTarPartCheckingReader_CloseVerifiesUnreadPart(t *testing.T) {
+	description := tarPartDescription(tarPartContent)
+	description.MD5 = "d41d8cd98f00b204e9800998ecf8427e"
+	readerMaker := internal.NewTarPartCheckingReaderMaker(&BufferReaderMaker{bytes.NewBuffer(tarPartContent), "part_001.tar.lz4"}, description)
+	reader, err := readerMaker.Reader()
+	assert.NoError(t, err)
+	err = reader.Close()
+	assert.IsType(t, internal.TarPartCorruptedError{}, err)
+}
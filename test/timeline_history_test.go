@@ -0,0 +1,131 @@
+package test
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"github.com/x4m/wal-g/internal"
+	"github.com/x4m/wal-g/testtools"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const testTimelineHistory = "1\t0/5000028\tno recovery target specified\n\n" +
+	"# comment line\n" +
+	"2\t0/9000000\tat restore point \"before_upgrade\"\n"
+
+func TestParseTimelineHistory(t *testing.T) {
+	history, err := internal.ParseTimelineHistory(strings.NewReader(testTimelineHistory))
+	assert.NoError(t, err)
+	assert.Equal(t, internal.TimelineHistory{
+		{Timeline: 1, SwitchLSN: 0x5000028, Reason: "no recovery target specified"},
+		{Timeline: 2, SwitchLSN: 0x9000000, Reason: "at restore point \"before_upgrade\""},
+	}, history)
+}
+
+func TestParseTimelineHistory_Invalid(t *testing.T) {
+	_, err := internal.ParseTimelineHistory(strings.NewReader("1 0/5000028\n"))
+	assert.IsType(t, internal.InvalidTimelineHistoryError{}, err)
+
+	_, err = internal.ParseTimelineHistory(strings.NewReader("1\tnot_lsn\treason\n"))
+	assert.IsType(t, internal.InvalidTimelineHistoryError{}, err)
+}
+
+func TestTimelineForSegment(t *testing.T) {
+	history, err := internal.ParseTimelineHistory(strings.NewReader(testTimelineHistory))
+	assert.NoError(t, err)
+
+	assert.Equal(t, uint32(1), history.TimelineForSegment(3, 4))
+	// segment with the switch point is read from the new timeline
+	assert.Equal(t, uint32(2), history.TimelineForSegment(3, 5))
+	assert.Equal(t, uint32(2), history.TimelineForSegment(3, 8))
+	assert.Equal(t, uint32(3), history.TimelineForSegment(3, 9))
+	assert.Equal(t, uint32(1), internal.TimelineHistory{}.TimelineForSegment(1, 9))
+}
+
+func TestValidateTimelineSwitch(t *testing.T) {
+	history, err := internal.ParseTimelineHistory(strings.NewReader(testTimelineHistory))
+	assert.NoError(t, err)
+
+	switches, err := internal.ValidateTimelineSwitch(history, 3, 0x9000100, 3)
+	assert.NoError(t, err)
+	assert.Empty(t, switches)
+
+	// replica was promoted twice during backup
+	switches, err = internal.ValidateTimelineSwitch(history, 1, 0x4000000, 3)
+	assert.NoError(t, err)
+	assert.Equal(t, history, switches)
+
+	switches, err = internal.ValidateTimelineSwitch(history, 2, 0x5000028, 3)
+	assert.NoError(t, err)
+	assert.Equal(t, history[1:], switches)
+
+	// replica replayed WAL of timeline 1 after the switch point, backup start is not in history of timeline 3
+	_, err = internal.ValidateTimelineSwitch(history, 1, 0x5000100, 3)
+	assert.IsType(t, internal.UnreachableBackupStartError{}, err)
+
+	_, err = internal.ValidateTimelineSwitch(history, 4, 0x4000000, 3)
+	assert.IsType(t, internal.UnreachableBackupStartError{}, err)
+}
+
+func putCompressedWalObject(t *testing.T, folder internal.StorageFolder, name string, content []byte) {
+	var compressed bytes.Buffer
+	writer := internal.Compressors[internal.Lz4AlgorithmName].NewWriter(&compressed)
+	_, err := writer.Write(content)
+	assert.NoError(t, err)
+	assert.NoError(t, writer.Close())
+	assert.NoError(t, folder.PutObject(name+"."+internal.Lz4FileExtension, &compressed))
+}
+
+func makeTimelineTestWalFolder(t *testing.T) internal.StorageFolder {
+	walFolder := testtools.MakeDefaultInMemoryStorageFolder().GetSubFolder(internal.WalPath)
+	putCompressedWalObject(t, walFolder, "00000002.history", []byte("1\t0/5000028\tno recovery target specified\n"))
+	// timeline 3 branched from timeline 1 before segment 4, so it does not continue through it
+	putCompressedWalObject(t, walFolder, "00000003.history", []byte("1\t0/3000000\tno recovery target specified\n"))
+	return walFolder
+}
+
+func TestGetNextWalFilenames_FollowsTimelineSwitch(t *testing.T) {
+	walFolder := makeTimelineTestWalFolder(t)
+
+	targetTimeline, history, err := internal.FindTargetTimeline(walFolder, "000000010000000000000003")
+	assert.NoError(t, err)
+	assert.Equal(t, uint32(2), targetTimeline)
+
+	names, err := internal.GetNextWalFilenames("000000010000000000000003", targetTimeline, history, 4)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{
+		"000000010000000000000004",
+		"000000020000000000000005",
+		"000000020000000000000006",
+		"000000020000000000000007",
+	}, names)
+}
+
+func TestFindTargetTimeline_NoNewerTimeline(t *testing.T) {
+	walFolder := makeTimelineTestWalFolder(t)
+
+	targetTimeline, history, err := internal.FindTargetTimeline(walFolder, "000000020000000000000009")
+	assert.NoError(t, err)
+	assert.Equal(t, uint32(2), targetTimeline)
+	assert.Empty(t, history)
+}
+
+func TestHandleWALFetch_FallsBackToAncestorTimeline(t *testing.T) {
+	folder := testtools.MakeDefaultInMemoryStorageFolder()
+	walFolder := folder.GetSubFolder(internal.WalPath)
+	putCompressedWalObject(t, walFolder, "00000002.history", []byte("1\t0/5000028\tno recovery target specified\n"))
+	putCompressedWalObject(t, walFolder, "000000010000000000000004", []byte("segment 4"))
+
+	dir, err := ioutil.TempDir("", "wal_fetch")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	location := filepath.Join(dir, "000000020000000000000004")
+
+	internal.HandleWALFetch(folder, "000000020000000000000004", location, false)
+	content, err := ioutil.ReadFile(location)
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("segment 4"), content)
+}
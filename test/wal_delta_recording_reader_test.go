@@ -130,3 +130,62 @@ func TestRead_RecordingFail(t *testing.T) {
 	assert.Equal(t, walData, actualData)
 	assert.True(t, dataFolder.IsEmpty())
 }
+
+func recordWalFile(t *testing.T, manager *internal.DeltaFileManager, walFilePath string, walFilename string) {
+	walFile, err := os.Open(walFilePath)
+	assert.NoError(t, err)
+	defer walFile.Close()
+	recordingReader, err := internal.NewWalDeltaRecordingReader(walFile, walFilename, manager)
+	assert.NoError(t, err)
+	_, err = ioutil.ReadAll(recordingReader)
+	assert.NoError(t, err)
+	assert.NoError(t, recordingReader.Close())
+}
+
+func loadUploadedDeltaLocations(t *testing.T, storage *testtools.InMemoryStorage, deltaFilename string) []walparser.BlockLocation {
+	deltaFileData, ok := storage.Load("in_memory/" + deltaFilename + ".mock")
+	assert.True(t, ok)
+	deltaFile, err := internal.LoadDeltaFile(&deltaFileData.Data)
+	assert.NoError(t, err)
+	return deltaFile.Locations
+}
+
+// Record starts in the WAL file before the last one of the delta, covers the last one and ends in the next delta
+func TestRead_RecordSpanningWalFiles(t *testing.T) {
+	const spanningRecordTestPath = "../internal/walparser/testdata/segment_spanning_record_"
+	manager := internal.NewDeltaFileManager(testtools.NewMockDataFolder())
+	recordWalFile(t, manager, spanningRecordTestPath+"1", "00000001000000000000006E")
+	recordWalFile(t, manager, spanningRecordTestPath+"2", "00000001000000000000006F")
+	recordWalFile(t, manager, spanningRecordTestPath+"3", "000000010000000000000070")
+
+	partFile, err := manager.GetPartFile("000000010000000000000060_delta")
+	assert.NoError(t, err)
+	assert.True(t, partFile.ContinuedWals[15])
+	partFile.PreviousWalHead = make([]byte, 0)
+	for i := 0; i < 14; i++ {
+		partFile.WalTails[i] = make([]byte, 0)
+		partFile.WalHeads[i] = make([]byte, 0)
+	}
+	nextPartFile, err := manager.GetPartFile("000000010000000000000070_delta")
+	assert.NoError(t, err)
+	assert.Nil(t, nextPartFile.PreviousWalHead)
+	for i := 1; i < int(internal.WalFileInDelta); i++ {
+		nextPartFile.WalTails[i] = make([]byte, 0)
+		nextPartFile.WalHeads[i] = make([]byte, 0)
+	}
+
+	storage := testtools.NewInMemoryStorage()
+	manager.FlushFiles(testtools.NewStoringMockUploader(storage, nil))
+
+	assert.ElementsMatch(t, []walparser.BlockLocation{
+		*walparser.NewBlockLocation(internal.DefaultSpcNode, 16384, 16397, 3),
+		*walparser.NewBlockLocation(internal.DefaultSpcNode, 16384, 16401, 0),
+	}, loadUploadedDeltaLocations(t, storage, "000000010000000000000060_delta"))
+	assert.ElementsMatch(t, []walparser.BlockLocation{
+		*walparser.NewBlockLocation(internal.DefaultSpcNode, 16384, 16402, 0),
+		*walparser.NewBlockLocation(internal.DefaultSpcNode, 16384, 16402, 1),
+		*walparser.NewBlockLocation(internal.DefaultSpcNode, 16384, 16402, 2),
+		*walparser.NewBlockLocation(internal.DefaultSpcNode, 16384, 16402, 3),
+		*walparser.NewBlockLocation(internal.DefaultSpcNode, 16384, 16397, 4),
+	}, loadUploadedDeltaLocations(t, storage, "000000010000000000000070_delta"))
+}
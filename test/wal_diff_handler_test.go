@@ -0,0 +1,121 @@
+package test
+
+import (
+	"bytes"
+	"github.com/RoaringBitmap/roaring"
+	"github.com/stretchr/testify/assert"
+	"github.com/x4m/wal-g/internal"
+	"github.com/x4m/wal-g/internal/walparser"
+	"github.com/x4m/wal-g/testtools"
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+func makeWalDiffTestFolder(t *testing.T) internal.StorageFolder {
+	walFolder := testtools.MakeDefaultInMemoryStorageFolder().GetSubFolder(internal.WalPath)
+	// Pages of the fixture start at 0/3000000, so it is the segment 3 of timeline 1
+	content, err := ioutil.ReadFile(walShowTestDataPath + "multi_page_record")
+	assert.NoError(t, err)
+	putCompressedWalObject(t, walFolder, "000000010000000000000003", content)
+	return walFolder
+}
+
+func TestDownloadDeltaMapBetween_FiltersRecordsByLsn(t *testing.T) {
+	walFolder := makeWalDiffTestFolder(t)
+	heapRel := walparser.RelFileNode{SpcNode: 1663, DBNode: 16384, RelNode: 16397}
+	fpiRel := walparser.RelFileNode{SpcNode: 1663, DBNode: 16384, RelNode: 16400}
+
+	deltaMap, err := internal.DownloadDeltaMapBetween(walFolder, 1, 0x3000000, 0x3000060)
+	assert.NoError(t, err)
+	assert.Len(t, deltaMap, 1)
+	assert.Equal(t, []uint32{1}, deltaMap[heapRel].ToArray())
+
+	deltaMap, err = internal.DownloadDeltaMapBetween(walFolder, 1, 0x3000060, 0x4000000)
+	assert.NoError(t, err)
+	assert.Len(t, deltaMap, 2)
+	assert.Equal(t, []uint32{2}, deltaMap[heapRel].ToArray())
+	assert.Equal(t, []uint32{0, 1, 2, 3}, deltaMap[fpiRel].ToArray())
+}
+
+func TestDownloadDeltaMapBetween_UsesDeltaFiles(t *testing.T) {
+	walFolder := makeWalDiffTestFolder(t)
+	deltaFile, err := internal.NewDeltaFile(walparser.NewWalParser())
+	assert.NoError(t, err)
+	location := *walparser.NewBlockLocation(1663, 16384, 20000, 5)
+	deltaFile.Locations = []walparser.BlockLocation{location}
+	var deltaFileData bytes.Buffer
+	assert.NoError(t, deltaFile.Save(&deltaFileData))
+	putCompressedWalObject(t, walFolder, "000000010000000000000000"+internal.DeltaFilenameSuffix, deltaFileData.Bytes())
+
+	// Range covers the whole group of WAL files, so WAL files are not needed
+	deltaMap, err := internal.DownloadDeltaMapBetween(walFolder, 1, 0, internal.WalFileInDelta*internal.WalSegmentSize)
+	assert.NoError(t, err)
+	assert.Len(t, deltaMap, 1)
+	assert.Equal(t, []uint32{5}, deltaMap[location.RelationFileNode].ToArray())
+
+	// WAL files of the group without delta file are read instead
+	_, err = internal.DownloadDeltaMapBetween(walFolder, 1, internal.WalFileInDelta*internal.WalSegmentSize,
+		2*internal.WalFileInDelta*internal.WalSegmentSize)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "000000010000000000000010")
+}
+
+func TestDownloadDeltaMapBetween_EmptyRange(t *testing.T) {
+	_, err := internal.DownloadDeltaMapBetween(makeWalDiffTestFolder(t), 1, 0x3000060, 0x3000060)
+	assert.IsType(t, internal.InvalidLsnRangeError{}, err)
+}
+
+func TestNewWalDiffReport(t *testing.T) {
+	deltaMap := internal.NewPagedFileDeltaMap()
+	small := walparser.RelFileNode{SpcNode: 1663, DBNode: 16384, RelNode: 16397}
+	big := walparser.RelFileNode{SpcNode: 1663, DBNode: 16384, RelNode: 16400}
+	renamed := walparser.RelFileNode{SpcNode: 1663, DBNode: 16384, RelNode: 16500}
+	deltaMap[small] = roaring.BitmapOf(7)
+	deltaMap[big] = roaring.BitmapOf(1, 2, 3)
+	deltaMap[renamed] = roaring.BitmapOf(1, 2)
+
+	fromNames := []internal.RelationName{
+		internal.NewRelationName(big, "public.old_big"),
+		internal.NewRelationName(renamed, "public.before"),
+	}
+	toNames := []internal.RelationName{internal.NewRelationName(renamed, "public.after")}
+	report := internal.NewWalDiffReport(deltaMap, 1, 0x3000000, 0x4000000, toNames, fromNames)
+
+	assert.Equal(t, "0/03000000", report.FromLsn)
+	assert.Equal(t, "0/04000000", report.ToLsn)
+	assert.Equal(t, uint64(6), report.ChangedBlocks)
+	assert.Equal(t, 6*uint64(internal.DatabasePageSize), report.ChangedBytes)
+	assert.Equal(t, []internal.RelationChanges{
+		{SpcNode: 1663, DBNode: 16384, RelNode: 16400, Name: "public.old_big", ChangedBlocks: 3, ChangedBytes: 3 * uint64(internal.DatabasePageSize)},
+		{SpcNode: 1663, DBNode: 16384, RelNode: 16500, Name: "public.after", ChangedBlocks: 2, ChangedBytes: 2 * uint64(internal.DatabasePageSize)},
+		{SpcNode: 1663, DBNode: 16384, RelNode: 16397, ChangedBlocks: 1, ChangedBytes: uint64(internal.DatabasePageSize)},
+	}, report.Relations)
+
+	var output bytes.Buffer
+	assert.NoError(t, report.WriteText(&output))
+	lines := strings.Split(strings.TrimSpace(output.String()), "\n")
+	assert.Len(t, lines, 6)
+	assert.Equal(t, "Changes on timeline 1 from 0/03000000 to 0/04000000:", lines[0])
+	assert.True(t, strings.HasPrefix(lines[2], "public.old_big (1663/16384/16400) "))
+	assert.True(t, strings.HasPrefix(lines[4], "1663/16384/16397 "))
+	assert.True(t, strings.HasPrefix(lines[5], "Total "))
+}
+
+func TestParseWalDiffArguments(t *testing.T) {
+	arguments, err := internal.ParseWalDiffArguments([]string{"base_000000010000000000000002", "LATEST"})
+	assert.NoError(t, err)
+	assert.Equal(t, internal.WalDiffCommandArguments{From: "base_000000010000000000000002", To: "LATEST"}, arguments)
+
+	arguments, err = internal.ParseWalDiffArguments([]string{internal.WalDiffJsonFlag, "0/3000028",
+		internal.WalDiffTimelineFlag, "2", "0/4000000"})
+	assert.NoError(t, err)
+	assert.Equal(t, internal.WalDiffCommandArguments{From: "0/3000028", To: "0/4000000", Timeline: 2, Json: true}, arguments)
+
+	_, err = internal.ParseWalDiffArguments([]string{"0/3000028"})
+	assert.Error(t, err)
+	_, err = internal.ParseWalDiffArguments([]string{"0/3000028", "0/4000000", internal.WalDiffTimelineFlag})
+	assert.Error(t, err)
+	_, err = internal.ParseWalDiffArguments([]string{"0/3000028", "0/4000000", internal.WalDiffTimelineFlag, "0"})
+	assert.Error(t, err)
+}
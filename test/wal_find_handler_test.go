@@ -0,0 +1,164 @@
+package test
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"github.com/x4m/wal-g/internal"
+	"github.com/x4m/wal-g/testtools"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"testing/iotest"
+	"time"
+)
+
+// xactRecordsStart is the time, from which transaction times of xact_records fixture are counted
+var xactRecordsStart = time.Date(2019, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+// pushWalFixture uploads WAL fixture of walparser as WAL file walFileName
+func pushWalFixture(t *testing.T, folder internal.StorageFolder, fixtureName, walFileName string) {
+	content, err := ioutil.ReadFile(walShowTestDataPath + fixtureName)
+	assert.NoError(t, err)
+	walDirectory, err := ioutil.TempDir("", "pg_wal")
+	assert.NoError(t, err)
+	defer os.RemoveAll(walDirectory)
+	walFilePath := filepath.Join(walDirectory, walFileName)
+	assert.NoError(t, ioutil.WriteFile(walFilePath, content, 0600))
+
+	uploader := internal.NewUploader(internal.Compressors[internal.Lz4AlgorithmName], folder, nil, false, false, true)
+	walFile, err := os.Open(walFilePath)
+	assert.NoError(t, err)
+	defer walFile.Close()
+	assert.NoError(t, uploader.UploadWalFile(walFile))
+}
+
+// pushXactRecords uploads xact_records fixture as the segment 5, which its pages belong to
+func pushXactRecords(t *testing.T, folder internal.StorageFolder, timeline string) {
+	pushWalFixture(t, folder, "xact_records", timeline+"0000000000000005")
+}
+
+func TestWalTimeIndexingReader(t *testing.T) {
+	content, err := ioutil.ReadFile(walShowTestDataPath + "xact_records")
+	assert.NoError(t, err)
+	reader := internal.NewWalTimeIndexingReader(iotest.HalfReader(bytes.NewReader(content)))
+	readContent, err := ioutil.ReadAll(reader)
+	assert.NoError(t, err)
+	assert.Equal(t, content, readContent)
+
+	assert.NoError(t, reader.Err)
+	assert.Equal(t, xactRecordsStart.Add(10*time.Second), reader.Index.FirstTime.UTC())
+	assert.Equal(t, xactRecordsStart.Add(40*time.Second), reader.Index.LastTime.UTC())
+	assert.Equal(t, uint64(0x5000028), reader.Index.FirstLsn)
+	assert.Equal(t, uint64(0x50000D8), reader.Index.LastLsn)
+	assert.Equal(t, uint32(2), reader.Index.Commits)
+	assert.Equal(t, uint32(2), reader.Index.Aborts)
+	assert.False(t, reader.Index.EndsWithPartialRecord)
+}
+
+func TestWalTimeIndexingReader_PartialRecord(t *testing.T) {
+	content, err := ioutil.ReadFile(walShowTestDataPath + "xact_spanning_record_1")
+	assert.NoError(t, err)
+	reader := internal.NewWalTimeIndexingReader(bytes.NewReader(content))
+	_, err = ioutil.ReadAll(reader)
+	assert.NoError(t, err)
+
+	assert.NoError(t, reader.Err)
+	assert.Equal(t, uint32(1), reader.Index.Commits)
+	assert.Equal(t, xactRecordsStart.Add(10*time.Second), reader.Index.LastTime.UTC())
+	assert.True(t, reader.Index.EndsWithPartialRecord)
+}
+
+func TestUploadWalFile_TimeIndex(t *testing.T) {
+	folder := testtools.MakeDefaultInMemoryStorageFolder()
+	pushXactRecords(t, folder, "00000001")
+	exists, err := folder.Exists("000000010000000000000005" + internal.WalTimeIndexSuffix)
+	assert.NoError(t, err)
+	assert.True(t, exists)
+
+	// WAL file without transaction ends has no index
+	pushWalFixture(t, folder, "multi_page_record", "000000010000000000000003")
+	exists, err = folder.Exists("000000010000000000000003" + internal.WalTimeIndexSuffix)
+	assert.NoError(t, err)
+	assert.False(t, exists)
+}
+
+func TestFindLastCommitBefore(t *testing.T) {
+	folder := testtools.MakeDefaultInMemoryStorageFolder()
+	pushXactRecords(t, folder, "00000001")
+
+	// Abort at 20 seconds is not a commit
+	result, err := internal.FindLastCommitBefore(folder, 0, xactRecordsStart.Add(25*time.Second))
+	assert.NoError(t, err)
+	assert.Equal(t, "000000010000000000000005", result.WalFileName)
+	assert.Equal(t, "0/05000028", result.Lsn)
+	assert.Equal(t, xactRecordsStart.Add(10*time.Second), result.CommitTime.UTC())
+
+	result, err = internal.FindLastCommitBefore(folder, 1, xactRecordsStart.Add(30*time.Second))
+	assert.NoError(t, err)
+	assert.Equal(t, "0/050000B0", result.Lsn)
+
+	_, err = internal.FindLastCommitBefore(folder, 0, xactRecordsStart.Add(5*time.Second))
+	assert.IsType(t, internal.NoCommitBeforeTimeError{}, err)
+}
+
+// xact_spanning_record fixtures have commits at 10, 20 and 30 seconds, the commit at 20 seconds
+// starts in the segment 5 and ends in the segment 6
+func TestFindLastCommitBefore_SegmentSpanningCommit(t *testing.T) {
+	folder := testtools.MakeDefaultInMemoryStorageFolder()
+	pushWalFixture(t, folder, "xact_spanning_record_1", "000000010000000000000005")
+
+	// the end of the commit is not archived yet
+	result, err := internal.FindLastCommitBefore(folder, 0, xactRecordsStart.Add(25*time.Second))
+	assert.NoError(t, err)
+	assert.Equal(t, "0/05000028", result.Lsn)
+
+	pushWalFixture(t, folder, "xact_spanning_record_2", "000000010000000000000006")
+	result, err = internal.FindLastCommitBefore(folder, 0, xactRecordsStart.Add(25*time.Second))
+	assert.NoError(t, err)
+	assert.Equal(t, "000000010000000000000005", result.WalFileName)
+	assert.Equal(t, "0/05000050", result.Lsn)
+	assert.Equal(t, xactRecordsStart.Add(20*time.Second), result.CommitTime.UTC())
+
+	result, err = internal.FindLastCommitBefore(folder, 0, xactRecordsStart.Add(35*time.Second))
+	assert.NoError(t, err)
+	assert.Equal(t, "000000010000000000000006", result.WalFileName)
+	assert.Equal(t, "0/060000B0", result.Lsn)
+}
+
+func TestFindLastCommitBefore_LatestTimeline(t *testing.T) {
+	folder := testtools.MakeDefaultInMemoryStorageFolder()
+	pushXactRecords(t, folder, "00000001")
+	pushXactRecords(t, folder, "00000002")
+
+	result, err := internal.FindLastCommitBefore(folder, 0, xactRecordsStart.Add(time.Minute))
+	assert.NoError(t, err)
+	assert.Equal(t, "000000020000000000000005", result.WalFileName)
+
+	_, err = internal.FindLastCommitBefore(folder, 3, xactRecordsStart.Add(time.Minute))
+	assert.IsType(t, internal.NoCommitBeforeTimeError{}, err)
+}
+
+func TestParseWalFindArguments(t *testing.T) {
+	arguments, err := internal.ParseWalFindArguments([]string{internal.WalFindTimeFlag, "2019-01-01 12:00:00+03"})
+	assert.NoError(t, err)
+	assert.Equal(t, xactRecordsStart.Add(9*time.Hour), arguments.Time.UTC())
+	assert.Equal(t, uint32(0), arguments.Timeline)
+
+	arguments, err = internal.ParseWalFindArguments([]string{internal.WalFindJsonFlag, internal.WalFindTimelineFlag, "2",
+		internal.WalFindTimeFlag, "2019-01-01T00:00:10.5Z"})
+	assert.NoError(t, err)
+	assert.Equal(t, xactRecordsStart.Add(10500*time.Millisecond), arguments.Time.UTC())
+	assert.Equal(t, internal.WalFindCommandArguments{Time: arguments.Time, Timeline: 2, Json: true}, arguments)
+
+	arguments, err = internal.ParseWalFindArguments([]string{internal.WalFindTimeFlag, "2019-01-01 00:00:10"})
+	assert.NoError(t, err)
+	assert.Equal(t, xactRecordsStart.Add(10*time.Second), arguments.Time)
+
+	_, err = internal.ParseWalFindArguments([]string{internal.WalFindTimelineFlag, "2"})
+	assert.Error(t, err)
+	_, err = internal.ParseWalFindArguments([]string{internal.WalFindTimeFlag, "yesterday"})
+	assert.Error(t, err)
+	_, err = internal.ParseWalFindArguments([]string{internal.WalFindTimeFlag})
+	assert.Error(t, err)
+}
@@ -48,6 +48,8 @@ func TestSaveLoadWalPartFile(t *testing.T) {
 	partFile.PreviousWalHead = []byte{1, 2, 3, 4, 5}
 	partFile.WalHeads[5] = []byte{6, 7, 7, 8, 9}
 	partFile.WalTails[10] = []byte{10, 11, 12, 13, 14}
+	partFile.ContinuedWals[10] = true
+	partFile.PageMagic = walparser.XLogPageMagicPg15
 
 	var partFileData bytes.Buffer
 	err := partFile.Save(&partFileData)
@@ -69,3 +71,31 @@ func TestCombineRecords(t *testing.T) {
 	assert.NoError(t, err)
 	assert.Equal(t, []walparser.XLogRecord{xLogRecord}, actualRecords)
 }
+
+func TestCombineRecords_ContinuedWal(t *testing.T) {
+	partFile := internal.NewWalPartFile()
+	xLogRecord, recordData := GetXLogRecordData()
+	partFile.WalHeads[1] = recordData[:16]
+	partFile.WalTails[2] = recordData[16:30]
+	partFile.WalHeads[2] = make([]byte, 0)
+	partFile.ContinuedWals[2] = true
+	partFile.WalTails[3] = recordData[30:]
+
+	actualRecords, err := partFile.CombineRecords()
+	assert.NoError(t, err)
+	assert.Equal(t, []walparser.XLogRecord{xLogRecord}, actualRecords)
+}
+
+func TestGetNextDeltaFileRecordHead_ContinuedWal(t *testing.T) {
+	partFile := internal.NewWalPartFile()
+	partFile.WalHeads[13] = []byte{1, 2, 3}
+	partFile.WalTails[14] = []byte{4, 5}
+	partFile.ContinuedWals[14] = true
+	partFile.WalTails[15] = []byte{6}
+	partFile.ContinuedWals[15] = true
+	assert.Equal(t, []byte{1, 2, 3, 4, 5, 6}, partFile.GetNextDeltaFileRecordHead())
+
+	partFile.ContinuedWals[15] = false
+	partFile.WalHeads[15] = []byte{7, 8}
+	assert.Equal(t, []byte{7, 8}, partFile.GetNextDeltaFileRecordHead())
+}
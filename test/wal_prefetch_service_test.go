@@ -0,0 +1,163 @@
+package test
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/x4m/wal-g/internal"
+	"github.com/x4m/wal-g/testtools"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+const prefetchCacheOrigin = "bucket/server/wal_005/"
+
+func addToPrefetchCache(t *testing.T, cache *internal.WalPrefetchCache, walFileName string, content string) {
+	err := ioutil.WriteFile(cache.DownloadPath(walFileName), []byte(content), 0600)
+	assert.NoError(t, err)
+	assert.NoError(t, cache.Add(walFileName))
+}
+
+func TestWalPrefetchCache_EvictsReplayedSegmentsFirst(t *testing.T) {
+	dir, err := ioutil.TempDir("", "prefetch_cache")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	cache, err := internal.NewWalPrefetchCache(dir, 30, prefetchCacheOrigin)
+	assert.NoError(t, err)
+
+	addToPrefetchCache(t, cache, "000000010000000000000001", "segment 01")
+	addToPrefetchCache(t, cache, "000000010000000000000002", "segment 02")
+	addToPrefetchCache(t, cache, "000000010000000000000005", "segment 05")
+	cache.MarkRequested("000000010000000000000002")
+
+	addToPrefetchCache(t, cache, "000000010000000000000003", "segment 03")
+	assert.False(t, cache.Contains("000000010000000000000001"))
+	assert.True(t, cache.Contains("000000010000000000000002"))
+
+	// Nothing is replayed now, so the farthest segment goes
+	addToPrefetchCache(t, cache, "000000010000000000000004", "segment 04")
+	assert.False(t, cache.Contains("000000010000000000000005"))
+	assert.True(t, cache.Contains("000000010000000000000002"))
+	assert.True(t, cache.Contains("000000010000000000000003"))
+	assert.True(t, cache.Contains("000000010000000000000004"))
+	assert.Equal(t, int64(30), cache.Size())
+}
+
+func TestWalPrefetchCache_PersistsBetweenRuns(t *testing.T) {
+	dir, err := ioutil.TempDir("", "prefetch_cache")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	cache, err := internal.NewWalPrefetchCache(dir, 100, prefetchCacheOrigin)
+	assert.NoError(t, err)
+	addToPrefetchCache(t, cache, "000000010000000000000001", "segment 01")
+	// Incomplete download is dropped on restart
+	err = ioutil.WriteFile(cache.DownloadPath("000000010000000000000002"), []byte("segm"), 0600)
+	assert.NoError(t, err)
+
+	cache, err = internal.NewWalPrefetchCache(dir, 100, prefetchCacheOrigin)
+	assert.NoError(t, err)
+	assert.True(t, cache.Contains("000000010000000000000001"))
+	assert.False(t, cache.Contains("000000010000000000000002"))
+	_, err = os.Stat(cache.DownloadPath("000000010000000000000002"))
+	assert.True(t, os.IsNotExist(err))
+
+	location := filepath.Join(dir, "RECOVERYXLOG")
+	copied, err := cache.CopyTo("000000010000000000000001", location)
+	assert.NoError(t, err)
+	assert.True(t, copied)
+	content, err := ioutil.ReadFile(location)
+	assert.NoError(t, err)
+	assert.Equal(t, "segment 01", string(content))
+}
+
+func TestWalPrefetchCache_ClearedForOtherOrigin(t *testing.T) {
+	dir, err := ioutil.TempDir("", "prefetch_cache")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	cache, err := internal.NewWalPrefetchCache(dir, 100, prefetchCacheOrigin)
+	assert.NoError(t, err)
+	addToPrefetchCache(t, cache, "000000010000000000000001", "segment 01")
+
+	cache, err = internal.NewWalPrefetchCache(dir, 100, "bucket/other_server/wal_005/")
+	assert.NoError(t, err)
+	assert.False(t, cache.Contains("000000010000000000000001"))
+	assert.Equal(t, int64(0), cache.Size())
+	_, err = os.Stat(filepath.Join(dir, "000000010000000000000001"))
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestAdaptiveLookahead(t *testing.T) {
+	lookahead := internal.NewAdaptiveLookahead(8, 100)
+	assert.Equal(t, 8, lookahead.Lookahead())
+
+	start := time.Now()
+	for i := 0; i <= 10; i++ {
+		lookahead.ObserveRequest(start.Add(time.Duration(i) * 100 * time.Millisecond))
+	}
+	lookahead.ObserveDownload(time.Second)
+	// 10 segments per second, each is downloaded in a second
+	assert.Equal(t, 20, lookahead.Lookahead())
+
+	lookahead = internal.NewAdaptiveLookahead(8, 100)
+	lookahead.ObserveRequest(start)
+	lookahead.ObserveRequest(start.Add(time.Minute))
+	lookahead.ObserveDownload(time.Second)
+	assert.Equal(t, 1, lookahead.Lookahead())
+
+	lookahead = internal.NewAdaptiveLookahead(8, 100)
+	lookahead.ObserveRequest(start)
+	lookahead.ObserveRequest(start.Add(time.Millisecond))
+	lookahead.ObserveDownload(time.Second)
+	assert.Equal(t, 100, lookahead.Lookahead())
+}
+
+func TestWalPrefetchService_FetchThroughSocket(t *testing.T) {
+	walFolder := testtools.MakeDefaultInMemoryStorageFolder().GetSubFolder(internal.WalPath)
+	putCompressedWalObject(t, walFolder, "000000010000000000000001", []byte("segment 1"))
+	putCompressedWalObject(t, walFolder, "000000010000000000000002", []byte("segment 2"))
+
+	dir, err := ioutil.TempDir("", "prefetch_service")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	cache, err := internal.NewWalPrefetchCache(filepath.Join(dir, "cache"), internal.DefaultPrefetchCacheSize, walFolder.GetPath())
+	assert.NoError(t, err)
+	service := internal.NewWalPrefetchService(walFolder, nil, cache, dir)
+
+	socketPath := filepath.Join(dir, "prefetch.sock")
+	listener, err := net.Listen("unix", socketPath)
+	assert.NoError(t, err)
+	go service.Serve(listener)
+	defer listener.Close()
+
+	location := filepath.Join(dir, "RECOVERYXLOG")
+	err = internal.FetchWalThroughPrefetchService(socketPath, "000000010000000000000001", location)
+	assert.NoError(t, err)
+	content, err := ioutil.ReadFile(location)
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("segment 1"), content)
+	assert.True(t, cache.Contains("000000010000000000000001"))
+
+	// Following segment is prefetched in background
+	for i := 0; i < 100 && !cache.Contains("000000010000000000000002"); i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.True(t, cache.Contains("000000010000000000000002"))
+
+	err = internal.FetchWalThroughPrefetchService(socketPath, "000000010000000000000003", filepath.Join(dir, "missing"))
+	assert.IsType(t, internal.ArchiveNonExistenceError{}, err)
+
+	// Files are written only into WAL directory and only for WAL file names
+	otherDir, err := ioutil.TempDir("", "prefetch_service_other")
+	assert.NoError(t, err)
+	defer os.RemoveAll(otherDir)
+	err = internal.FetchWalThroughPrefetchService(socketPath, "000000010000000000000001", filepath.Join(otherDir, "RECOVERYXLOG"))
+	assert.IsType(t, internal.PrefetchServiceError{}, err)
+	_, err = os.Stat(filepath.Join(otherDir, "RECOVERYXLOG"))
+	assert.True(t, os.IsNotExist(err))
+	err = internal.FetchWalThroughPrefetchService(socketPath, "../../000000010000000000000001", filepath.Join(dir, "RECOVERYHISTORY"))
+	assert.IsType(t, internal.PrefetchServiceError{}, err)
+	_, err = os.Stat(filepath.Join(dir, "RECOVERYHISTORY"))
+	assert.True(t, os.IsNotExist(err))
+}
@@ -0,0 +1,137 @@
+package test
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/x4m/wal-g/internal"
+	"github.com/x4m/wal-g/testtools"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+const daemonTestWalName = "000000010000000000000001"
+
+func setupDaemonWalDirectory(t *testing.T) string {
+	walDirectory, err := ioutil.TempDir("", "pg_wal")
+	assert.NoError(t, err)
+	err = os.Mkdir(filepath.Join(walDirectory, "archive_status"), 0700)
+	assert.NoError(t, err)
+	err = ioutil.WriteFile(filepath.Join(walDirectory, daemonTestWalName), []byte("wal content"), 0600)
+	assert.NoError(t, err)
+	return walDirectory
+}
+
+func newTestWalPushDaemon(t *testing.T, storage *testtools.InMemoryStorage, walDirectory string) *internal.WalPushDaemon {
+	// Test files are not real WAL segments, so delta recording is disabled
+	uploader := internal.NewUploader(&testtools.MockCompressor{}, testtools.NewInMemoryStorageFolder("in_memory/", storage), nil, false, true, false)
+	daemon, err := internal.NewWalPushDaemon(uploader, walDirectory)
+	assert.NoError(t, err)
+	return daemon
+}
+
+func TestWalPushDaemon_PushThroughSocket(t *testing.T) {
+	walDirectory := setupDaemonWalDirectory(t)
+	defer os.RemoveAll(walDirectory)
+	storage := testtools.NewInMemoryStorage()
+	daemon := newTestWalPushDaemon(t, storage, walDirectory)
+
+	socketPath := filepath.Join(walDirectory, "daemon.sock")
+	listener, err := net.Listen("unix", socketPath)
+	assert.NoError(t, err)
+	go daemon.Serve(listener)
+	defer listener.Close()
+
+	err = internal.PushWalThroughDaemon(socketPath, filepath.Join(walDirectory, daemonTestWalName))
+	assert.NoError(t, err)
+	_, exists := storage.Load("in_memory/" + daemonTestWalName + ".mock")
+	assert.True(t, exists)
+
+	// Repeated push of uploaded file is confirmed without upload
+	err = internal.PushWalThroughDaemon(socketPath, filepath.Join(walDirectory, daemonTestWalName))
+	assert.NoError(t, err)
+	daemon.Stop()
+}
+
+func TestWalPushDaemon_PushMissingFile(t *testing.T) {
+	walDirectory := setupDaemonWalDirectory(t)
+	defer os.RemoveAll(walDirectory)
+	daemon := newTestWalPushDaemon(t, testtools.NewInMemoryStorage(), walDirectory)
+
+	socketPath := filepath.Join(walDirectory, "daemon.sock")
+	listener, err := net.Listen("unix", socketPath)
+	assert.NoError(t, err)
+	go daemon.Serve(listener)
+	defer listener.Close()
+
+	err = internal.PushWalThroughDaemon(socketPath, filepath.Join(walDirectory, "000000010000000000000002"))
+	assert.IsType(t, internal.DaemonPushError{}, err)
+}
+
+func TestWalPushDaemon_NoDaemon(t *testing.T) {
+	err := internal.PushWalThroughDaemon(filepath.Join(os.TempDir(), "no_such_wal_g_daemon.sock"), daemonTestWalName)
+	assert.Error(t, err)
+	_, isPushError := err.(internal.DaemonPushError)
+	assert.False(t, isPushError)
+}
+
+func TestWalPushDaemon_ScanArchiveStatus(t *testing.T) {
+	walDirectory := setupDaemonWalDirectory(t)
+	defer os.RemoveAll(walDirectory)
+	err := ioutil.WriteFile(filepath.Join(walDirectory, "archive_status", daemonTestWalName+".ready"), nil, 0600)
+	assert.NoError(t, err)
+	storage := testtools.NewInMemoryStorage()
+	daemon := newTestWalPushDaemon(t, storage, walDirectory)
+
+	daemon.ScanArchiveStatus()
+	daemon.Stop()
+
+	_, exists := storage.Load("in_memory/" + daemonTestWalName + ".mock")
+	assert.True(t, exists)
+	_, err = os.Stat(filepath.Join(walDirectory, "archive_status", daemonTestWalName+".done"))
+	assert.NoError(t, err)
+	_, err = os.Stat(filepath.Join(walDirectory, "archive_status", daemonTestWalName+".ready"))
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestWalPushDaemon_RejectsForeignFiles(t *testing.T) {
+	walDirectory := setupDaemonWalDirectory(t)
+	defer os.RemoveAll(walDirectory)
+	otherDirectory := setupDaemonWalDirectory(t)
+	defer os.RemoveAll(otherDirectory)
+	err := ioutil.WriteFile(filepath.Join(walDirectory, "server.key"), []byte("secret"), 0600)
+	assert.NoError(t, err)
+	storage := testtools.NewInMemoryStorage()
+	daemon := newTestWalPushDaemon(t, storage, walDirectory)
+
+	err = daemon.Push(filepath.Join(walDirectory, "server.key"))
+	assert.IsType(t, internal.DaemonPushError{}, err)
+	// file of the same name elsewhere neither is uploaded nor marks the segment uploaded
+	err = daemon.Push(filepath.Join(otherDirectory, daemonTestWalName))
+	assert.IsType(t, internal.DaemonPushError{}, err)
+	err = daemon.Push(filepath.Join(walDirectory, "archive_status", "..", "..", filepath.Base(otherDirectory), daemonTestWalName))
+	assert.IsType(t, internal.DaemonPushError{}, err)
+	_, exists := storage.Load("in_memory/" + daemonTestWalName + ".mock")
+	assert.False(t, exists)
+
+	assert.NoError(t, daemon.Push(filepath.Join(walDirectory, daemonTestWalName)))
+	_, exists = storage.Load("in_memory/" + daemonTestWalName + ".mock")
+	assert.True(t, exists)
+}
+
+func TestWalPushDaemon_PushAfterStop(t *testing.T) {
+	walDirectory := setupDaemonWalDirectory(t)
+	defer os.RemoveAll(walDirectory)
+	err := ioutil.WriteFile(filepath.Join(walDirectory, "archive_status", daemonTestWalName+".ready"), nil, 0600)
+	assert.NoError(t, err)
+	storage := testtools.NewInMemoryStorage()
+	daemon := newTestWalPushDaemon(t, storage, walDirectory)
+	daemon.Stop()
+
+	err = daemon.Push(filepath.Join(walDirectory, daemonTestWalName))
+	assert.IsType(t, internal.DaemonPushError{}, err)
+	daemon.ScanArchiveStatus()
+	_, exists := storage.Load("in_memory/" + daemonTestWalName + ".mock")
+	assert.False(t, exists)
+}
@@ -0,0 +1,87 @@
+package test
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"github.com/stretchr/testify/assert"
+	"github.com/x4m/wal-g/internal"
+	"github.com/x4m/wal-g/testtools"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+const overwriteTestWalName = "000000010000000000000007"
+
+// pushWithOverwriteCheck uploads WAL file with fresh uploader, which does not remember previous uploads
+func pushWithOverwriteCheck(t *testing.T, folder internal.StorageFolder, walDirectory string, content string) error {
+	err := ioutil.WriteFile(filepath.Join(walDirectory, overwriteTestWalName), []byte(content), 0600)
+	assert.NoError(t, err)
+	uploader := internal.NewUploader(internal.Compressors[internal.Lz4AlgorithmName], folder, nil, false, true, false)
+	daemon, err := internal.NewWalPushDaemon(uploader, walDirectory)
+	assert.NoError(t, err)
+	return daemon.Push(filepath.Join(walDirectory, overwriteTestWalName))
+}
+
+func readStoredHash(t *testing.T, folder internal.StorageFolder) string {
+	reader, err := folder.ReadObject(overwriteTestWalName + internal.WalHashSuffix)
+	assert.NoError(t, err)
+	defer reader.Close()
+	storedHash, err := ioutil.ReadAll(reader)
+	assert.NoError(t, err)
+	return string(storedHash)
+}
+
+func sha256Hex(content string) string {
+	hash := sha256.Sum256([]byte(content))
+	return hex.EncodeToString(hash[:])
+}
+
+func TestWALOverwrite_HashIsStored(t *testing.T) {
+	walDirectory, err := ioutil.TempDir("", "pg_wal")
+	assert.NoError(t, err)
+	defer os.RemoveAll(walDirectory)
+	folder := testtools.MakeDefaultInMemoryStorageFolder()
+
+	err = pushWithOverwriteCheck(t, folder, walDirectory, "segment content")
+	assert.NoError(t, err)
+	assert.Equal(t, sha256Hex("segment content"), readStoredHash(t, folder))
+}
+
+func TestWALOverwrite_ComparesHashesOnly(t *testing.T) {
+	walDirectory, err := ioutil.TempDir("", "pg_wal")
+	assert.NoError(t, err)
+	defer os.RemoveAll(walDirectory)
+	folder := testtools.MakeDefaultInMemoryStorageFolder()
+
+	err = pushWithOverwriteCheck(t, folder, walDirectory, "segment content")
+	assert.NoError(t, err)
+	// Archived segment is not downloaded, when hash is stored
+	err = folder.PutObject(overwriteTestWalName+"."+internal.Lz4FileExtension, bytes.NewReader([]byte("not lz4")))
+	assert.NoError(t, err)
+
+	err = pushWithOverwriteCheck(t, folder, walDirectory, "segment content")
+	assert.NoError(t, err)
+	err = pushWithOverwriteCheck(t, folder, walDirectory, "other content")
+	assert.IsType(t, internal.CantOverwriteWalFileError{}, err)
+}
+
+func TestWALOverwrite_LegacyObjectWithoutHash(t *testing.T) {
+	walDirectory, err := ioutil.TempDir("", "pg_wal")
+	assert.NoError(t, err)
+	defer os.RemoveAll(walDirectory)
+	folder := testtools.MakeDefaultInMemoryStorageFolder()
+	putCompressedWalObject(t, folder, overwriteTestWalName, []byte("segment content"))
+
+	err = pushWithOverwriteCheck(t, folder, walDirectory, "other content")
+	assert.IsType(t, internal.CantOverwriteWalFileError{}, err)
+	exists, err := folder.Exists(overwriteTestWalName + internal.WalHashSuffix)
+	assert.NoError(t, err)
+	assert.False(t, exists)
+
+	err = pushWithOverwriteCheck(t, folder, walDirectory, "segment content")
+	assert.NoError(t, err)
+	assert.Equal(t, sha256Hex("segment content"), readStoredHash(t, folder))
+}
@@ -0,0 +1,140 @@
+package test
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"github.com/x4m/wal-g/internal"
+	"github.com/x4m/wal-g/internal/walparser"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+const walShowTestDataPath = "../internal/walparser/testdata/"
+
+func readWalShowTestRecords(t *testing.T, parser *walparser.WalParser, filename string) []internal.WalShowRecord {
+	walFile, err := os.Open(walShowTestDataPath + filename)
+	assert.NoError(t, err)
+	defer walFile.Close()
+	records, err := internal.ReadWalShowRecords(parser, walFile)
+	assert.NoError(t, err)
+	return records
+}
+
+func TestParseWalShowArguments(t *testing.T) {
+	arguments, err := internal.ParseWalShowArguments([]string{"000000010000000000000012"})
+	assert.NoError(t, err)
+	assert.Equal(t, internal.WalShowCommandArguments{
+		FirstSegment: "000000010000000000000012",
+		LastSegment:  "000000010000000000000012",
+	}, arguments)
+
+	arguments, err = internal.ParseWalShowArguments([]string{internal.WalShowStatsFlag,
+		"000000010000000000000012-000000010000000100000001", internal.WalShowJsonFlag})
+	assert.NoError(t, err)
+	assert.Equal(t, internal.WalShowCommandArguments{
+		FirstSegment: "000000010000000000000012",
+		LastSegment:  "000000010000000100000001",
+		Stats:        true,
+		Json:         true,
+	}, arguments)
+}
+
+func TestParseWalShowArguments_Invalid(t *testing.T) {
+	_, err := internal.ParseWalShowArguments([]string{internal.WalShowStatsFlag})
+	assert.Error(t, err)
+	_, err = internal.ParseWalShowArguments([]string{"000000010000000000000012", "000000010000000000000013"})
+	assert.Error(t, err)
+	_, err = internal.ParseWalShowArguments([]string{"000000010000000000000012-000000020000000000000013"})
+	assert.IsType(t, internal.InvalidWalShowRangeError{}, err)
+	_, err = internal.ParseWalShowArguments([]string{"000000010000000000000013-000000010000000000000012"})
+	assert.IsType(t, internal.InvalidWalShowRangeError{}, err)
+	_, err = internal.ParseWalShowArguments([]string{"000000010000000000000012-00000001"})
+	assert.IsType(t, internal.InvalidWalShowRangeError{}, err)
+}
+
+func TestReadWalShowRecords_MultiPageRecord(t *testing.T) {
+	records := readWalShowTestRecords(t, walparser.NewWalParser(), "multi_page_record")
+	assert.Len(t, records, 3)
+	assert.Equal(t, []string{"0/03000028", "0/03000060", "0/03008250"},
+		[]string{records[0].Lsn, records[1].Lsn, records[2].Lsn})
+
+	assert.Equal(t, "Heap", records[0].ResourceManager)
+	assert.Equal(t, uint32(1000), records[0].Xid)
+	assert.Equal(t, []internal.WalShowBlock{{SpcNode: 1663, DBNode: 16384, RelNode: 16397, Fork: "main", BlockNo: 1}},
+		records[0].Blocks)
+
+	fpiRecord := records[1]
+	assert.Equal(t, "XLOG", fpiRecord.ResourceManager)
+	assert.Equal(t, uint8(0xB0), fpiRecord.Info)
+	assert.Len(t, fpiRecord.Blocks, 4)
+	for i, block := range fpiRecord.Blocks {
+		assert.Equal(t, uint32(16400), block.RelNode)
+		assert.Equal(t, uint32(i), block.BlockNo)
+		assert.Equal(t, uint32(walparser.BlockSize), block.FpiBytes)
+	}
+	assert.Equal(t, fpiRecord.TotalLength-4*uint32(walparser.BlockSize), fpiRecord.RecordLength)
+}
+
+func TestReadWalShowRecords_RecordSpanningSegments(t *testing.T) {
+	parser := walparser.NewWalParser()
+	records := readWalShowTestRecords(t, parser, "segment_spanning_record_1")
+	assert.Len(t, records, 2)
+	assert.Empty(t, readWalShowTestRecords(t, parser, "segment_spanning_record_2"))
+	records = readWalShowTestRecords(t, parser, "segment_spanning_record_3")
+	assert.Len(t, records, 2)
+	assert.Equal(t, uint32(16402), records[0].Blocks[0].RelNode)
+	assert.True(t, strings.HasPrefix(records[0].Lsn, "0/6E00"))
+
+	// Record continued from the segment before the first one is skipped
+	records = readWalShowTestRecords(t, walparser.NewWalParser(), "segment_spanning_record_3")
+	assert.Len(t, records, 1)
+	assert.Equal(t, uint32(16397), records[0].Blocks[0].RelNode)
+}
+
+func TestWalShowRecord_WriteText(t *testing.T) {
+	records := readWalShowTestRecords(t, walparser.NewWalParser(), "multi_page_record")
+	var output bytes.Buffer
+	assert.NoError(t, records[1].WriteText(&output))
+	line := output.String()
+	assert.True(t, strings.HasPrefix(line, "rmgr: XLOG "))
+	assert.Contains(t, line, "lsn: 0/03000060, prev 0/01000000, info: 0xB0")
+	assert.Contains(t, line, "blkref #3: rel 1663/16384/16400 blk 3 FPW "+strconv.Itoa(int(walparser.BlockSize)))
+	assert.True(t, strings.HasSuffix(line, "\n"))
+	assert.Equal(t, 1, strings.Count(line, "\n"))
+}
+
+func TestWalShowStats(t *testing.T) {
+	stats := internal.NewWalShowStats()
+	for _, record := range readWalShowTestRecords(t, walparser.NewWalParser(), "multi_page_record") {
+		stats.Add(&record)
+	}
+
+	assert.Len(t, stats.ResourceManagers, 2)
+	xlogStats, heapStats := stats.ResourceManagers[0], stats.ResourceManagers[1]
+	assert.Equal(t, "XLOG", xlogStats.ResourceManager)
+	assert.Equal(t, uint64(1), xlogStats.Records)
+	assert.Equal(t, uint64(4), xlogStats.FpiCount)
+	assert.Equal(t, uint64(4*int(walparser.BlockSize)), xlogStats.FpiBytes)
+	assert.Equal(t, "Heap", heapStats.ResourceManager)
+	assert.Equal(t, uint64(2), heapStats.Records)
+	assert.Equal(t, uint64(0), heapStats.FpiBytes)
+	assert.Equal(t, uint64(3), stats.Total.Records)
+	assert.Equal(t, xlogStats.RecordBytes+heapStats.RecordBytes, stats.Total.RecordBytes)
+
+	var output bytes.Buffer
+	assert.NoError(t, stats.WriteText(&output))
+	lines := strings.Split(strings.TrimSpace(output.String()), "\n")
+	assert.Len(t, lines, 6)
+	assert.True(t, strings.HasPrefix(lines[2], "XLOG "))
+	assert.True(t, strings.HasPrefix(lines[5], "Total "))
+	assert.Contains(t, lines[5], "(100.00)")
+
+	encoded, err := json.Marshal(stats)
+	assert.NoError(t, err)
+	var decoded internal.WalShowStats
+	assert.NoError(t, json.Unmarshal(encoded, &decoded))
+	assert.Equal(t, stats.Total, decoded.Total)
+}
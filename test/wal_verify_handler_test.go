@@ -0,0 +1,85 @@
+package test
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"github.com/x4m/wal-g/internal"
+	"testing"
+)
+
+func walVerifyTestArchive() ([]string, map[uint32]internal.TimelineHistory) {
+	walFileNames := []string{
+		"000000010000000000000001",
+		"000000010000000000000002",
+		"000000010000000000000003",
+		"000000010000000000000004",
+		"000000010000000000000005",
+		"000000010000000000000006",
+		"000000020000000000000005",
+		"000000020000000000000006",
+		"000000020000000000000007",
+		"000000020000000000000008",
+		"000000010000000000000002.00000028.backup",
+	}
+	histories := map[uint32]internal.TimelineHistory{
+		2: {{Timeline: 1, SwitchLSN: 0x5000028}},
+	}
+	return walFileNames, histories
+}
+
+func TestVerifyWalArchive_Consistent(t *testing.T) {
+	walFileNames, histories := walVerifyTestArchive()
+	report := internal.VerifyWalArchive(walFileNames, histories, []string{"base_000000010000000000000002"})
+
+	assert.True(t, report.Ok)
+	assert.Equal(t, []uint32{1, 2}, report.Timelines)
+	assert.Equal(t, []internal.BackupWalVerifyResult{{
+		BackupName:   "base_000000010000000000000002",
+		StartSegment: "000000010000000000000002",
+		Timelines: []internal.TimelineVerifyResult{
+			{Timeline: 1, LastSegment: "000000010000000000000006"},
+			{Timeline: 2, LastSegment: "000000020000000000000008"},
+		},
+	}}, report.Backups)
+}
+
+func TestVerifyWalArchive_Gap(t *testing.T) {
+	walFileNames, histories := walVerifyTestArchive()
+	walFileNames = append(walFileNames[:2], walFileNames[4:]...) // drop segments 3 and 4 of timeline 1
+	report := internal.VerifyWalArchive(walFileNames, histories, []string{"base_000000010000000000000002"})
+
+	assert.False(t, report.Ok)
+	expectedGap := []internal.WalSegmentRange{{First: "000000010000000000000003", Last: "000000010000000000000004"}}
+	assert.Equal(t, expectedGap, report.Backups[0].Timelines[0].MissingSegments)
+	assert.Equal(t, expectedGap, report.Backups[0].Timelines[1].MissingSegments)
+}
+
+func TestVerifyWalArchive_TimelineBranchedBeforeBackup(t *testing.T) {
+	walFileNames, histories := walVerifyTestArchive()
+	report := internal.VerifyWalArchive(walFileNames, histories, []string{"base_000000010000000000000006_D_000000010000000000000002"})
+
+	assert.True(t, report.Ok)
+	assert.Equal(t, 1, len(report.Backups[0].Timelines))
+	assert.Equal(t, uint32(1), report.Backups[0].Timelines[0].Timeline)
+}
+
+func TestVerifyWalArchive_MissingHistory(t *testing.T) {
+	walFileNames, histories := walVerifyTestArchive()
+	walFileNames = append(walFileNames, "000000030000000000000009")
+	report := internal.VerifyWalArchive(walFileNames, histories, nil)
+
+	assert.False(t, report.Ok)
+	assert.Equal(t, []uint32{3}, report.MissingHistories)
+}
+
+func TestWalVerifyReport_WriteJson(t *testing.T) {
+	walFileNames, histories := walVerifyTestArchive()
+	report := internal.VerifyWalArchive(walFileNames, histories, []string{"base_000000010000000000000002"})
+
+	var buffer bytes.Buffer
+	assert.NoError(t, report.WriteJson(&buffer))
+	var decoded internal.WalVerifyReport
+	assert.NoError(t, json.Unmarshal(buffer.Bytes(), &decoded))
+	assert.Equal(t, *report, decoded)
+}
@@ -0,0 +1,121 @@
+package test
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/x4m/wal-g/internal"
+	"github.com/x4m/wal-g/internal/walparser"
+	"github.com/x4m/wal-g/testtools"
+	"math/rand"
+	"testing"
+)
+
+// makeDictionaryTestSegment makes WAL pages, which are similar within the cluster, but not within the page
+func makeDictionaryTestSegment(cluster int64, segmentNo int) []byte {
+	pageSize := int(walparser.WalPageSize)
+	common := make([]byte, pageSize*3/4)
+	rand.New(rand.NewSource(cluster)).Read(common)
+	random := rand.New(rand.NewSource(cluster*1000 + int64(segmentNo)))
+	segment := make([]byte, 0, 64*pageSize)
+	for pageNo := 0; pageNo < 64; pageNo++ {
+		segment = append(segment, common...)
+		page := make([]byte, pageSize-len(common))
+		random.Read(page)
+		segment = append(segment, page...)
+	}
+	return segment
+}
+
+func putZstdWalSegments(t *testing.T, walFolder internal.StorageFolder, cluster int64, firstSegmentNo int) {
+	for i := firstSegmentNo; i < firstSegmentNo+4; i++ {
+		var compressed bytes.Buffer
+		writer := internal.Compressors[internal.ZstdAlgorithmName].NewWriter(&compressed)
+		_, err := writer.Write(makeDictionaryTestSegment(cluster, i))
+		assert.NoError(t, err)
+		assert.NoError(t, writer.Close())
+		walFileName := fmt.Sprintf("0000000100000000000000%02X.%s", i, internal.ZstdFileExtension)
+		assert.NoError(t, walFolder.PutObject(walFileName, &compressed))
+	}
+}
+
+func compressWithDictionary(t *testing.T, data []byte, dictionary []byte) *bytes.Buffer {
+	var compressed bytes.Buffer
+	writer := internal.ZstdCompressor{Level: internal.DefaultZstdCompressionLevel, Dictionary: dictionary}.NewWriter(&compressed)
+	_, err := writer.Write(data)
+	assert.NoError(t, err)
+	assert.NoError(t, writer.Close())
+	return &compressed
+}
+
+func TestParseWalDictTrainArguments(t *testing.T) {
+	arguments, err := internal.ParseWalDictTrainArguments([]string{})
+	assert.NoError(t, err)
+	assert.Equal(t, internal.WalDictTrainCommandArguments{Segments: internal.DefaultWalDictTrainSegments, Size: internal.DefaultZstdDictionarySize}, arguments)
+
+	arguments, err = internal.ParseWalDictTrainArguments([]string{"--size", "4096", "--segments", "3"})
+	assert.NoError(t, err)
+	assert.Equal(t, internal.WalDictTrainCommandArguments{Segments: 3, Size: 4096}, arguments)
+
+	_, err = internal.ParseWalDictTrainArguments([]string{"--size", "0"})
+	assert.Error(t, err)
+	_, err = internal.ParseWalDictTrainArguments([]string{"--segments"})
+	assert.Error(t, err)
+}
+
+func TestTrainWalDictionary(t *testing.T) {
+	walFolder := testtools.MakeDefaultInMemoryStorageFolder().GetSubFolder(internal.WalPath)
+	dictionary, err := internal.LoadCurrentZstdDictionary(walFolder)
+	assert.NoError(t, err)
+	assert.Nil(t, dictionary)
+
+	putZstdWalSegments(t, walFolder, 1, 0x10)
+	arguments := internal.WalDictTrainCommandArguments{Segments: 4, Size: 16 << 10}
+	firstId, err := internal.TrainWalDictionary(walFolder, arguments)
+	assert.NoError(t, err)
+	firstDictionary, err := internal.LoadCurrentZstdDictionary(walFolder)
+	assert.NoError(t, err)
+	dictionaryId, err := internal.GetZstdDictionaryId(firstDictionary)
+	assert.NoError(t, err)
+	assert.Equal(t, firstId, dictionaryId)
+
+	segment := makeDictionaryTestSegment(1, 0x20)
+	// dictionary helps the most, when there is little data to find repetitions in
+	page := segment[:walparser.WalPageSize]
+	assert.True(t, compressWithDictionary(t, page, firstDictionary).Len() < compressWithDictionary(t, page, nil).Len())
+	firstCompressed := compressWithDictionary(t, segment, firstDictionary).Bytes()
+
+	// after rotation WAL compressed with the previous dictionary is readable
+	putZstdWalSegments(t, walFolder, 2, 0x30)
+	secondId, err := internal.TrainWalDictionary(walFolder, arguments)
+	assert.NoError(t, err)
+	assert.NotEqual(t, firstId, secondId)
+	secondDictionary, err := internal.LoadCurrentZstdDictionary(walFolder)
+	assert.NoError(t, err)
+	secondCompressed := compressWithDictionary(t, segment, secondDictionary).Bytes()
+
+	decompressor := internal.ZstdDecompressor{DictionaryFolder: walFolder}
+	for _, data := range [][]byte{firstCompressed, secondCompressed} {
+		var decompressed bytes.Buffer
+		assert.NoError(t, decompressor.Decompress(&decompressed, bytes.NewReader(data)))
+		assert.Equal(t, segment, decompressed.Bytes())
+	}
+}
+
+func TestZstdDecompressor_DictionaryNotFound(t *testing.T) {
+	samples := make([][]byte, 0)
+	for i := 0; i < 4; i++ {
+		segment := makeDictionaryTestSegment(3, i)
+		for j := 0; j < len(segment); j += int(walparser.WalPageSize) {
+			samples = append(samples, segment[j:j+int(walparser.WalPageSize)])
+		}
+	}
+	dictionary, err := internal.TrainZstdDictionary(samples, 16<<10)
+	assert.NoError(t, err)
+	compressed := compressWithDictionary(t, []byte("missing dictionary"), dictionary)
+
+	walFolder := testtools.MakeDefaultInMemoryStorageFolder().GetSubFolder(internal.WalPath)
+	err = internal.ZstdDecompressor{DictionaryFolder: walFolder}.Decompress(&bytes.Buffer{}, compressed)
+	assert.IsType(t, internal.ZstdDictionaryNotFoundError{}, errors.Cause(err))
+}
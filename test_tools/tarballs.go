@@ -79,6 +79,9 @@ func (fileTarBall *FileTarBall) AddSize(i int64)        { fileTarBall.size += i
 func (fileTarBall *FileTarBall) TarWriter() *tar.Writer { return fileTarBall.tarWriter }
 func (fileTarBall *FileTarBall) FileExtension() string  { return "lz4" }
 func (fileTarBall *FileTarBall) AwaitUploads()          {}
+func (fileTarBall *FileTarBall) Name() string {
+	return "part_" + fmt.Sprintf("%0.3d", fileTarBall.number) + ".tar.lz4"
+}
 
 // NOPTarBall mocks a tarball. Used for testing purposes.
 type NOPTarBall struct {
@@ -101,3 +104,4 @@ func (n *NOPTarBall) AddSize(i int64)        { n.size += i }
 func (n *NOPTarBall) TarWriter() *tar.Writer { return n.tarWriter }
 func (n *NOPTarBall) FileExtension() string  { return "lz4" }
 func (b *NOPTarBall) AwaitUploads()          {}
+func (n *NOPTarBall) Name() string           { return "" }
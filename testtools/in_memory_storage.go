@@ -35,6 +35,10 @@ func (storage *InMemoryStorage) Store(key string, value bytes.Buffer) {
 	storage.underlying.Store(key, TimeStampData(value))
 }
 
+func (storage *InMemoryStorage) Delete(key string) {
+	storage.underlying.Delete(key)
+}
+
 func (storage *InMemoryStorage) Range(callback func(key string, value TimeStampedData) bool) {
 	storage.underlying.Range(func(iKey, iValue interface{}) bool {
 		return callback(iKey.(string), iValue.(TimeStampedData))
@@ -75,7 +75,10 @@ func (folder *InMemoryStorageFolder) ListFolder() (objects []internal.StorageObj
 }
 
 func (folder *InMemoryStorageFolder) DeleteObjects(objectRelativePaths []string) error {
-	panic("implement me")
+	for _, objectRelativePath := range objectRelativePaths {
+		folder.Storage.Delete(folder.path + objectRelativePath)
+	}
+	return nil
 }
 
 func (folder *InMemoryStorageFolder) GetSubFolder(subFolderRelativePath string) internal.StorageFolder {
@@ -76,6 +76,9 @@ func (tarBall *FileTarBall) Size() int64            { return tarBall.size }
 func (tarBall *FileTarBall) AddSize(i int64)        { tarBall.size += i }
 func (tarBall *FileTarBall) TarWriter() *tar.Writer { return tarBall.tarWriter }
 func (tarBall *FileTarBall) AwaitUploads()          {}
+func (tarBall *FileTarBall) Name() string {
+	return "part_" + fmt.Sprintf("%0.3d", tarBall.number) + ".tar.lz4"
+}
 
 // BufferTarBall represents a tarball that is
 // written to buffer.
@@ -111,3 +114,5 @@ func (tarBall *BufferTarBall) TarWriter() *tar.Writer {
 }
 
 func (tarBall *BufferTarBall) AwaitUploads() {}
+
+func (tarBall *BufferTarBall) Name() string { return fmt.Sprintf("part_%0.3d.tar", tarBall.number) }
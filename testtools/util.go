@@ -20,6 +20,7 @@ func NewMockUploader(apiMultiErr, apiErr bool) *internal.Uploader {
 		nil,
 		false,
 		false,
+		false,
 	)
 }
 
@@ -30,6 +31,7 @@ func NewStoringMockUploader(storage *InMemoryStorage, deltaDataFolder internal.D
 		deltaDataFolder,
 		true,
 		true,
+		false,
 	)
 }
 
@@ -37,7 +39,7 @@ func NewLz4CompressingPipeWriter(input io.Reader) *internal.CompressingPipeWrite
 	return &internal.CompressingPipeWriter{
 		Input: input,
 		NewCompressingWriter: func(writer io.Writer) internal.ReaderFromWriteCloser {
-			return internal.NewLz4ReaderFromWriter(writer)
+			return internal.NewLz4ReaderFromWriter(writer, internal.DefaultLz4CompressionLevel)
 		},
 	}
 }